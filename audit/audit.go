@@ -0,0 +1,250 @@
+// Package audit records every command run to a Sink. Records are chained by
+// hash so that removing or altering a record can be detected with Verify.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// DefaultSensitiveArgs lists, per op, the positions of arguments that
+	// are secrets and must never be written to the audit log.
+	DefaultSensitiveArgs = map[string][]int{
+		"aws_auth":        {1},
+		"github_app_auth": {4},
+	}
+)
+
+type Record struct {
+	RunID       string `json:",omitempty"`
+	Time        time.Time
+	Op          string
+	Args        []string
+	Image       string
+	ImageID     string
+	ContainerID string
+	ExitCode    int
+	Duration    time.Duration
+	Error       string            `json:",omitempty"`
+	Initiator   map[string]string `json:",omitempty"`
+	// Transcript is kept for runs that record one, such as break glass
+	// sessions. TranscriptTruncated is set when the output limits of the
+	// run cut it short.
+	Transcript          []stdcopy.Chunk `json:",omitempty"`
+	TranscriptTruncated bool            `json:",omitempty"`
+	// TranscriptPart numbers, from 1, the records holding the parts of a
+	// transcript too long for one record, which are written while the
+	// session runs. The record of the finished run follows them with the
+	// last part, and TranscriptParts set to their number.
+	TranscriptPart  int `json:",omitempty"`
+	TranscriptParts int `json:",omitempty"`
+	PrevHash        string
+	Hash            string
+}
+
+// Sink stores audit records.
+type Sink interface {
+	Write(record *Record) error
+}
+
+// HashResumer is implemented by sinks that can report the hash of the last
+// record they hold, so that a new Auditor continues the existing chain.
+type HashResumer interface {
+	LastHash() (string, error)
+}
+
+type Auditor struct {
+	// Policy defaults to FailOpen. FailClosed needs a sink that implements
+	// HealthChecker, such as BufferedSink.
+	Policy Policy
+
+	sink          Sink
+	sensitiveArgs map[string][]int
+
+	mu       sync.Mutex
+	lastHash string
+}
+
+// New returns an Auditor writing to sink. sensitiveArgs defaults to
+// DefaultSensitiveArgs when nil.
+func New(sink Sink, sensitiveArgs map[string][]int) (*Auditor, error) {
+	if sensitiveArgs == nil {
+		sensitiveArgs = DefaultSensitiveArgs
+	}
+	a := &Auditor{sink: sink, sensitiveArgs: sensitiveArgs}
+	if resumer, ok := sink.(HashResumer); ok {
+		lastHash, err := resumer.LastHash()
+		if err != nil {
+			return nil, err
+		}
+		a.lastHash = lastHash
+	}
+	return a, nil
+}
+
+// Install registers the auditor so that every run is recorded.
+func (a *Auditor) Install() {
+	command.AddHooks(command.Hooks{
+		OnBeforeRun:  a.checkSink,
+		OnTranscript: a.recordTranscript,
+		OnFinished:   a.record,
+	})
+}
+
+func (a *Auditor) checkSink(rc *command.RunContext) error {
+	if a.Policy != FailClosed {
+		return nil
+	}
+	if checker, ok := a.sink.(HealthChecker); ok {
+		if err := checker.Healthy(); err != nil {
+			log.Errorf("refusing to run %s: audit sink unavailable: %s", rc.Op, err)
+			return ErrAuditUnavailable
+		}
+	}
+	return nil
+}
+
+func (a *Auditor) record(rc *command.RunContext) {
+	record := &Record{
+		RunID:       rc.RunID,
+		Time:        rc.Start,
+		Op:          rc.Op,
+		Args:        a.redact(rc.Op, rc.Args, rc.Options.Secrets),
+		Image:       rc.Image,
+		ImageID:     rc.ImageID,
+		ContainerID: rc.ContainerID,
+		ExitCode:    rc.ExitCode,
+		Duration:    rc.Duration,
+		Initiator:   rc.Options.Metadata,
+		Transcript:  rc.Transcript,
+	}
+	record.TranscriptTruncated, record.TranscriptParts = rc.TranscriptTruncated, rc.TranscriptParts
+	if rc.Err != nil {
+		record.Error = command.RedactSecrets(rc.Err.Error(), rc.Options.Secrets)
+	}
+	if err := a.Write(record); err != nil {
+		log.Errorf("error writing audit record for %s: %s", rc.Op, err)
+	}
+}
+
+// recordTranscript writes a part of the transcript of a session that is
+// still running, so that the session is recorded however long it gets.
+func (a *Auditor) recordTranscript(rc *command.RunContext, chunks []stdcopy.Chunk) {
+	record := &Record{
+		RunID:          rc.RunID,
+		Time:           rc.Start,
+		Op:             rc.Op,
+		Args:           a.redact(rc.Op, rc.Args, rc.Options.Secrets),
+		Initiator:      rc.Options.Metadata,
+		Transcript:     chunks,
+		TranscriptPart: rc.TranscriptParts + 1,
+	}
+	if err := a.Write(record); err != nil {
+		log.Errorf("error writing audit transcript of %s: %s", rc.Op, err)
+	}
+}
+
+// Write chains record to the previous one and writes it to the sink. A
+// BufferedSink chains the records itself as it stores them.
+func (a *Auditor) Write(record *Record) error {
+	if _, ok := a.sink.(*BufferedSink); ok {
+		return a.sink.Write(record)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	hash, err := chainRecord(record, a.lastHash)
+	if err != nil {
+		return err
+	}
+	if err := a.sink.Write(record); err != nil {
+		return err
+	}
+	a.lastHash = hash
+	return nil
+}
+
+func (a *Auditor) redact(op string, args []string, secrets []string) []string {
+	redactedArgs := RedactArgs(a.sensitiveArgs, op, args)
+	for i, arg := range redactedArgs {
+		redactedArgs[i] = command.RedactSecrets(arg, secrets)
+	}
+	return redactedArgs
+}
+
+// RedactArgs returns a copy of args with the positions listed for op in
+// sensitiveArgs replaced.
+func RedactArgs(sensitiveArgs map[string][]int, op string, args []string) []string {
+	redactedArgs := make([]string, len(args))
+	copy(redactedArgs, args)
+	for _, i := range sensitiveArgs[op] {
+		if i < len(redactedArgs) {
+			redactedArgs[i] = redacted
+		}
+	}
+	return redactedArgs
+}
+
+// chainRecord links record to the record hashed prevHash and sets its hash.
+func chainRecord(record *Record, prevHash string) (string, error) {
+	record.PrevHash = prevHash
+	hash, err := hashRecord(record)
+	if err != nil {
+		return "", err
+	}
+	record.Hash = hash
+	return hash, nil
+}
+
+func hashRecord(record *Record) (string, error) {
+	unhashed := *record
+	unhashed.Hash = ""
+	b, err := json.Marshal(unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify reads JSON lines records, as written by FileSink, and checks that
+// every record is intact and chained to the one before it.
+func Verify(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	prevHash := ""
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		var record Record
+		if err := json.Unmarshal(b, &record); err != nil {
+			return fmt.Errorf("audit record %d: %s", line, err)
+		}
+		if record.PrevHash != prevHash {
+			return fmt.Errorf("audit record %d: chain broken", line)
+		}
+		hash, err := hashRecord(&record)
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("audit record %d: hash mismatch", line)
+		}
+		prevHash = record.Hash
+	}
+}
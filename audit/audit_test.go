@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+var errSinkDown = errors.New("sink down")
+
+// memorySink keeps records as JSON lines, as FileSink writes them, and
+// fails the writes of the ops listed in failing.
+type memorySink struct {
+	mu      sync.Mutex
+	lines   []string
+	failing map[string]bool
+}
+
+func (s *memorySink) Write(record *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing[record.Op] {
+		return errSinkDown
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.lines = append(s.lines, string(b))
+	return nil
+}
+
+func (s *memorySink) verify(lines []string) error {
+	return Verify(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+}
+
+func writeRecords(t *testing.T, a *Auditor, ops ...string) {
+	for _, op := range ops {
+		if err := a.Write(&Record{Op: op, Args: []string{"arg"}}); err != nil {
+			t.Fatalf("writing %s: %s", op, err)
+		}
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	sink := &memorySink{}
+	a, err := New(sink, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, a, "first", "second", "third")
+	if err := sink.verify(sink.lines); err != nil {
+		t.Fatalf("intact chain failed to verify: %s", err)
+	}
+
+	tampered := append([]string{}, sink.lines...)
+	tampered[1] = strings.Replace(tampered[1], `"ExitCode":0`, `"ExitCode":1`, 1)
+	if err := sink.verify(tampered); err == nil || !strings.Contains(err.Error(), "record 2: hash mismatch") {
+		t.Errorf("tampered record: got error %v", err)
+	}
+
+	removed := []string{sink.lines[0], sink.lines[2]}
+	if err := sink.verify(removed); err == nil || !strings.Contains(err.Error(), "record 2: chain broken") {
+		t.Errorf("removed record: got error %v", err)
+	}
+}
+
+func TestFileSinkResumesChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libcmd-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	for _, ops := range [][]string{{"first", "second"}, {"third"}} {
+		sink, err := NewFileSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := New(sink, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeRecords(t, a, ops...)
+		sink.Close()
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(bytes.NewReader(b)); err != nil {
+		t.Errorf("resumed chain failed to verify: %s", err)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	sensitive := map[string][]int{"login": {1, 5}}
+	args := []string{"user", "password"}
+	got := RedactArgs(sensitive, "login", args)
+	if got[0] != "user" || got[1] != redacted {
+		t.Errorf("got %q", got)
+	}
+	if args[1] != "password" {
+		t.Errorf("redacting changed the args")
+	}
+	if got := RedactArgs(sensitive, "other", args); got[1] != "password" {
+		t.Errorf("redacted args of an op without sensitive args: %q", got)
+	}
+}
+
+func TestRecordUsesImageOfRun(t *testing.T) {
+	sink := &memorySink{}
+	a, err := New(sink, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.record(&command.RunContext{
+		Op:     "raw",
+		Config: command.CmdConfig{ContainerRepository: "libcmd", ContainerTag: "latest"},
+		Image:  "registry.example.com/tools@sha256:abc",
+	})
+	var record Record
+	if err := json.Unmarshal([]byte(sink.lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Image != "registry.example.com/tools@sha256:abc" {
+		t.Errorf("recorded image %q", record.Image)
+	}
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrSinkFull         = errors.New("audit sink buffer full")
+	ErrSinkClosed       = errors.New("audit sink closed")
+	ErrAuditUnavailable = errors.New("audit sink unavailable")
+)
+
+// Policy decides what happens to runs while the audit sink is failing.
+type Policy int
+
+const (
+	// FailOpen lets runs go ahead and drops the records that cannot be
+	// stored.
+	FailOpen Policy = iota
+	// FailClosed refuses new runs with ErrAuditUnavailable while the sink
+	// reports itself unhealthy.
+	FailClosed
+)
+
+// HealthChecker may be implemented by sinks that know whether records are
+// currently being stored.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// BufferedSink writes records to another sink in the background so that a
+// slow or unreachable sink does not hold up runs. When the buffer is full
+// records are dropped and counted.
+//
+// Records are chained as they are stored rather than as they are buffered,
+// so that those dropped or failing to store leave no gap in the chain.
+type BufferedSink struct {
+	sink    Sink
+	records chan *Record
+	done    chan bool
+
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu       sync.Mutex
+	dropped  int64
+	failed   int64
+	lastErr  error
+	resumed  bool
+	lastHash string
+}
+
+// NewBufferedSink buffers up to size records for sink.
+func NewBufferedSink(sink Sink, size int) *BufferedSink {
+	s := &BufferedSink{
+		sink:    sink,
+		records: make(chan *Record, size),
+		done:    make(chan bool),
+	}
+	go s.run()
+	return s
+}
+
+// Write buffers record, returning ErrSinkFull when the buffer is full and
+// ErrSinkClosed after Close.
+func (s *BufferedSink) Write(record *Record) error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+		return ErrSinkClosed
+	}
+	select {
+	case s.records <- record:
+		return nil
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+		return ErrSinkFull
+	}
+}
+
+func (s *BufferedSink) run() {
+	defer close(s.done)
+	for record := range s.records {
+		err := s.store(record)
+		s.mu.Lock()
+		s.lastErr = err
+		if err != nil {
+			s.failed++
+		}
+		s.mu.Unlock()
+		if err != nil {
+			log.Errorf("error writing audit record for %s: %s", record.Op, err)
+		}
+	}
+}
+
+// store chains record to the last one stored and writes it to the
+// underlying sink. The chain only moves on once the write succeeds.
+func (s *BufferedSink) store(record *Record) error {
+	if !s.resumed {
+		if resumer, ok := s.sink.(HashResumer); ok {
+			lastHash, err := resumer.LastHash()
+			if err != nil {
+				return err
+			}
+			s.mu.Lock()
+			s.lastHash = lastHash
+			s.mu.Unlock()
+		}
+		s.resumed = true
+	}
+	s.mu.Lock()
+	prevHash := s.lastHash
+	s.mu.Unlock()
+	hash, err := chainRecord(record, prevHash)
+	if err != nil {
+		return err
+	}
+	if err := s.sink.Write(record); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.lastHash = hash
+	s.mu.Unlock()
+	return nil
+}
+
+// LastHash returns the hash of the last record stored, passing through to
+// the underlying sink until a record has been.
+func (s *BufferedSink) LastHash() (string, error) {
+	s.mu.Lock()
+	resumed, lastHash := s.resumed, s.lastHash
+	s.mu.Unlock()
+	if resumed {
+		return lastHash, nil
+	}
+	if resumer, ok := s.sink.(HashResumer); ok {
+		return resumer.LastHash()
+	}
+	return "", nil
+}
+
+// Healthy returns ErrSinkFull while the buffer is full, or the error of the
+// last write to the underlying sink.
+func (s *BufferedSink) Healthy() error {
+	if len(s.records) == cap(s.records) {
+		return ErrSinkFull
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Dropped returns the number of records dropped because the buffer was
+// full or the sink closed, and Failed the number the underlying sink failed
+// to store.
+func (s *BufferedSink) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *BufferedSink) Failed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+// Close writes out the buffered records and stops. Records written after
+// Close are dropped.
+func (s *BufferedSink) Close() {
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.records)
+	}
+	s.closeMu.Unlock()
+	<-s.done
+}
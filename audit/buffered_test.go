@@ -0,0 +1,30 @@
+package audit
+
+import "testing"
+
+func TestBufferedSinkChainsStoredRecords(t *testing.T) {
+	underlying := &memorySink{failing: map[string]bool{"lost": true}}
+	sink := NewBufferedSink(underlying, 10)
+	a, err := New(sink, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, a, "first", "lost", "second", "third")
+	sink.Close()
+
+	if len(underlying.lines) != 3 {
+		t.Fatalf("stored %d records, want 3", len(underlying.lines))
+	}
+	if err := underlying.verify(underlying.lines); err != nil {
+		t.Errorf("chain with a failed write failed to verify: %s", err)
+	}
+	if sink.Failed() != 1 {
+		t.Errorf("got %d failed, want 1", sink.Failed())
+	}
+	if err := sink.Write(&Record{Op: "late"}); err != ErrSinkClosed {
+		t.Errorf("write after close: got error %v, want %v", err, ErrSinkClosed)
+	}
+	if sink.Dropped() != 1 {
+		t.Errorf("got %d dropped, want 1", sink.Dropped())
+	}
+}
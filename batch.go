@@ -0,0 +1,145 @@
+package libcmd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+var (
+	ErrCommandSkipped = errors.New("command skipped")
+)
+
+type CommandSpec struct {
+	Op   string
+	Args []string
+	// RunID is passed to the run as RunOptions.RunID. Defaults to a new
+	// ID, which Result.RunID reports.
+	RunID string
+	// Undo is run to compensate for this command when a later command in
+	// the same batch or workflow fails.
+	Undo *CommandSpec
+}
+
+type Result struct {
+	Spec   CommandSpec
+	RunID  string
+	Output []string
+	Err    error
+	// Summary categorizes the lines of both output streams by their log
+	// level prefix, so that it reports the warnings a successful command
+	// printed to stderr.
+	Summary  LogSummary
+	Duration time.Duration
+	// Truncated is set when the output was cut short by the output limits.
+	Truncated bool
+	// Undone is set when the Undo command of a successful command was run
+	// during rollback; UndoErr holds its error, if any.
+	Undone  bool
+	UndoErr error
+}
+
+type RunAllOptions struct {
+	// Concurrency is the maximum number of commands running at once. Zero
+	// or less runs every command concurrently.
+	Concurrency int
+	// FailFast stops launching new commands after the first failure. The
+	// results of commands that were never started have ErrCommandSkipped.
+	FailFast bool
+}
+
+// RunAll runs every spec and returns one Result per spec, in the same order
+// as specs. If any command fails, the Undo commands of the commands that
+// succeeded are run in reverse order of completion. Once ctx is done, the
+// commands running are canceled and those not yet started are skipped; the
+// Undo commands still run.
+func RunAll(ctx context.Context, specs []CommandSpec, opts RunAllOptions) []Result {
+	results := make([]Result, len(specs))
+	cancel, stop := cancelOnDone(ctx)
+	defer stop()
+	workers := opts.Concurrency
+	if workers <= 0 || workers > len(specs) {
+		workers = len(specs)
+	}
+
+	var (
+		mu        sync.Mutex
+		failed    bool
+		completed []*Result
+		wg        sync.WaitGroup
+	)
+	indexCh := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexCh {
+				spec := specs[i]
+				mu.Lock()
+				skip := (opts.FailFast && failed) || ctx.Err() != nil
+				mu.Unlock()
+				if skip {
+					results[i] = Result{Spec: spec, Err: ErrCommandSkipped}
+					continue
+				}
+				results[i] = runSpec(ctx, spec, cancel)
+				mu.Lock()
+				if results[i].Err != nil {
+					failed = true
+				} else {
+					completed = append(completed, &results[i])
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range specs {
+		indexCh <- i
+	}
+	close(indexCh)
+	wg.Wait()
+
+	if failed {
+		rollback(completed)
+	}
+	return results
+}
+
+// rollback runs the Undo commands of completed results, most recent first.
+func rollback(completed []*Result) {
+	for i := len(completed) - 1; i >= 0; i-- {
+		result := completed[i]
+		if result.Spec.Undo == nil {
+			continue
+		}
+		_, result.UndoErr = RunCommand(result.Spec.Undo.Op, result.Spec.Undo.Args...)
+		result.Undone = true
+	}
+}
+
+// runSpec runs spec in the trace of ctx. It is canceled when cancel is
+// closed.
+func runSpec(ctx context.Context, spec CommandSpec, cancel <-chan bool) Result {
+	start := time.Now()
+	runID := spec.RunID
+	if runID == "" {
+		var err error
+		if runID, err = command.NewID(); err != nil {
+			return Result{Spec: spec, Err: err}
+		}
+	}
+	streams := &outputStreams{}
+	output, err := RunCommandWithOptions(spec.Op, command.RunOptions{RunID: runID, Cancel: cancel, Context: ctx, OnOutput: streams.write}, spec.Args...)
+	return Result{
+		Spec:      spec,
+		RunID:     runID,
+		Output:    output,
+		Err:       err,
+		Duration:  time.Since(start),
+		Summary:   streams.summary(output),
+		Truncated: command.OutputTruncated(output),
+	}
+}
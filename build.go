@@ -0,0 +1,34 @@
+package libcmd
+
+import (
+	"sync"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+var (
+	imageBuildMu sync.RWMutex
+	imageBuild   *command.ImageBuild
+)
+
+// WithImageBuild makes InitCmdContainer build the command image, tagged
+// ContainerRepository:ContainerTag, from the Dockerfile in contextDir
+// instead of pulling it. dockerfile is relative to contextDir and may be
+// empty. Call it before InitCmdContainer.
+func WithImageBuild(contextDir, dockerfile string, buildArgs map[string]string) {
+	SetImageBuild(&command.ImageBuild{ContextDir: contextDir, Dockerfile: dockerfile, BuildArgs: buildArgs})
+}
+
+// SetImageBuild is WithImageBuild with every build option. Setting nil goes
+// back to pulling the image.
+func SetImageBuild(build *command.ImageBuild) {
+	imageBuildMu.Lock()
+	imageBuild = build
+	imageBuildMu.Unlock()
+}
+
+func currentImageBuild() *command.ImageBuild {
+	imageBuildMu.RLock()
+	defer imageBuildMu.RUnlock()
+	return imageBuild
+}
@@ -0,0 +1,123 @@
+package libcmd
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+var (
+	resultCacheMu sync.Mutex
+	resultCache   = map[string]*cachedResult{}
+)
+
+type cachedResult struct {
+	op      string
+	args    []string
+	output  []string
+	expires time.Time
+}
+
+// resultCacheKey identifies a result by the op, args and image of the run and
+// the options that change what the command sees.
+func resultCacheKey(op string, args []string, imageID string, opts command.RunOptions) string {
+	b, _ := json.Marshal(struct {
+		Op          string
+		ImageID     string
+		Args        []string
+		Env         []string
+		User        string
+		WorkingDir  string
+		Entrypoint  []string
+		MountParams map[string]string
+	}{op, imageID, args, opts.Env, opts.User, opts.WorkingDir, opts.Entrypoint, opts.MountParams})
+	return string(b)
+}
+
+// cacheable reports whether the result of a run with opts can be cached.
+// Inputs, secret files and volumes hold content the key cannot cover,
+// templates can render differently on every run, and artifacts are copied
+// out by the run itself.
+func cacheable(opts command.RunOptions) bool {
+	return len(opts.Inputs) == 0 && len(opts.SecretFiles) == 0 && len(opts.Volumes) == 0 &&
+		!opts.ExpandTemplates && opts.Artifacts == nil
+}
+
+// commandImageID returns the ID of the command image, so that cached results
+// are not returned once the image has been replaced. Commands that do not
+// need the image are still cached when it cannot be inspected.
+func commandImageID() string {
+	_, imageID, err := ResolveImage()
+	if err != nil {
+		return ""
+	}
+	return imageID
+}
+
+func runCached(op string, args []string, opts command.RunOptions, imageID string, fn func() ([]string, error)) ([]string, error) {
+	if !cacheable(opts) {
+		return fn()
+	}
+	if imageID == "" {
+		imageID = commandImageID()
+	}
+	key := resultCacheKey(op, args, imageID, opts)
+
+	resultCacheMu.Lock()
+	cached, exists := resultCache[key]
+	if exists && time.Now().Before(cached.expires) {
+		resultCacheMu.Unlock()
+		return copyOutput(cached.output), nil
+	}
+	delete(resultCache, key)
+	resultCacheMu.Unlock()
+
+	output, err := fn()
+	if err != nil {
+		return output, err
+	}
+
+	resultCacheMu.Lock()
+	resultCache[key] = &cachedResult{
+		op:      op,
+		args:    args,
+		output:  copyOutput(output),
+		expires: time.Now().Add(opts.CacheTTL),
+	}
+	resultCacheMu.Unlock()
+	return output, nil
+}
+
+// InvalidateCache removes the cached results of op run with args, for any
+// image. With no args, every cached result of op is removed.
+func InvalidateCache(op string, args ...string) {
+	resultCacheMu.Lock()
+	defer resultCacheMu.Unlock()
+	for key, cached := range resultCache {
+		if cached.op != op {
+			continue
+		}
+		if len(args) == 0 || strings.Join(cached.args, "\x00") == strings.Join(args, "\x00") {
+			delete(resultCache, key)
+		}
+	}
+}
+
+// ClearCache removes every cached result.
+func ClearCache() {
+	resultCacheMu.Lock()
+	resultCache = map[string]*cachedResult{}
+	resultCacheMu.Unlock()
+}
+
+func copyOutput(output []string) []string {
+	if output == nil {
+		return nil
+	}
+	c := make([]string, len(output))
+	copy(c, output)
+	return c
+}
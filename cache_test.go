@@ -0,0 +1,107 @@
+package libcmd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+func TestResultCacheKey(t *testing.T) {
+	base := command.RunOptions{Env: []string{"A=1"}, User: "1000", MountParams: map[string]string{"src": "/data"}}
+	key := resultCacheKey("ls", []string{"/"}, "sha256:1", base)
+
+	same := command.RunOptions{Env: []string{"A=1"}, User: "1000", MountParams: map[string]string{"src": "/data"}, CacheTTL: time.Hour}
+	if got := resultCacheKey("ls", []string{"/"}, "sha256:1", same); got != key {
+		t.Errorf("equal options gave different keys %s and %s", key, got)
+	}
+
+	tests := []struct {
+		name    string
+		op      string
+		args    []string
+		imageID string
+		opts    command.RunOptions
+	}{
+		{"op", "cat", []string{"/"}, "sha256:1", base},
+		{"args", "ls", []string{"/tmp"}, "sha256:1", base},
+		{"image", "ls", []string{"/"}, "sha256:2", base},
+		{"env", "ls", []string{"/"}, "sha256:1", command.RunOptions{Env: []string{"A=2"}, User: "1000", MountParams: base.MountParams}},
+		{"user", "ls", []string{"/"}, "sha256:1", command.RunOptions{Env: base.Env, User: "0", MountParams: base.MountParams}},
+		{"working dir", "ls", []string{"/"}, "sha256:1", command.RunOptions{Env: base.Env, User: "1000", MountParams: base.MountParams, WorkingDir: "/srv"}},
+		{"mount params", "ls", []string{"/"}, "sha256:1", command.RunOptions{Env: base.Env, User: "1000", MountParams: map[string]string{"src": "/other"}}},
+	}
+	for _, test := range tests {
+		if got := resultCacheKey(test.op, test.args, test.imageID, test.opts); got == key {
+			t.Errorf("changing the %s did not change the key", test.name)
+		}
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	tests := []struct {
+		opts command.RunOptions
+		want bool
+	}{
+		{command.RunOptions{Env: []string{"A=1"}}, true},
+		{command.RunOptions{Inputs: []command.Input{{Path: "/data", HostPath: "/tmp"}}}, false},
+		{command.RunOptions{SecretFiles: map[string]string{"/run/token": "x"}}, false},
+		{command.RunOptions{Volumes: []command.VolumeMount{{Name: "data", Target: "/data"}}}, false},
+		{command.RunOptions{ExpandTemplates: true}, false},
+		{command.RunOptions{Artifacts: &command.ArtifactOptions{Paths: []string{"/out"}}}, false},
+	}
+	for i, test := range tests {
+		if got := cacheable(test.opts); got != test.want {
+			t.Errorf("%d: got cacheable %t, want %t", i, got, test.want)
+		}
+	}
+}
+
+func TestRunCached(t *testing.T) {
+	ClearCache()
+	defer ClearCache()
+	calls := 0
+	fn := func() ([]string, error) {
+		calls++
+		return []string{"result"}, nil
+	}
+	opts := command.RunOptions{CacheTTL: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		output, err := runCached("ls", []string{"/"}, opts, "sha256:1", fn)
+		if err != nil || len(output) != 1 || output[0] != "result" {
+			t.Fatalf("got %q, %v", output, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("ran %d times, want 1", calls)
+	}
+
+	runCached("ls", []string{"/"}, command.RunOptions{CacheTTL: time.Hour, User: "0"}, "sha256:1", fn)
+	if calls != 2 {
+		t.Errorf("options with another user returned the cached result")
+	}
+
+	InvalidateCache("ls", "/")
+	runCached("ls", []string{"/"}, opts, "sha256:1", fn)
+	if calls != 3 {
+		t.Errorf("invalidated result was returned")
+	}
+}
+
+func TestRunCachedDoesNotCacheErrors(t *testing.T) {
+	ClearCache()
+	defer ClearCache()
+	calls := 0
+	fn := func() ([]string, error) {
+		calls++
+		return nil, errors.New("failed")
+	}
+	opts := command.RunOptions{CacheTTL: time.Hour}
+	runCached("ls", nil, opts, "sha256:1", fn)
+	runCached("ls", nil, opts, "sha256:1", fn)
+	if calls != 2 {
+		t.Errorf("ran %d times, want 2", calls)
+	}
+}
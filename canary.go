@@ -0,0 +1,142 @@
+package libcmd
+
+import (
+	"strings"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+// maxCanaryDiffLines bounds the output compared line by line.
+const maxCanaryDiffLines = 2000
+
+// CanaryOptions runs a command on a candidate command image alongside the
+// current one.
+type CanaryOptions struct {
+	// Image is the candidate image, as a tag or an image ID.
+	Image string
+	// CompareOutput compares the output as well as the outcome.
+	CompareOutput bool
+	// OnReport receives the comparison of every canary run. Runs that
+	// diverge are also logged.
+	OnReport func(report CanaryReport)
+}
+
+// CanaryReport compares a run on the current image with the same run on the
+// candidate image.
+type CanaryReport struct {
+	Op             string
+	Args           []string
+	CandidateImage string
+	Err            error
+	CandidateErr   error
+	// Diverged is set when the runs did not both succeed or both fail, or
+	// when CompareOutput is set and their output differs.
+	Diverged bool
+	// Diff lists the output lines only the current run printed, prefixed
+	// with "-", and those only the candidate printed, prefixed with "+".
+	Diff []string
+}
+
+// RunCanary runs op as RunCommandWithOptions does and returns its result.
+// The same command runs concurrently on canary.Image and is compared with
+// it once both finish, without affecting the result. Both runs take
+// effect, so only use it for commands that are safe to run twice.
+func RunCanary(op string, opts command.RunOptions, canary CanaryOptions, args ...string) ([]string, error) {
+	candidateOpts := opts
+	candidateOpts.ImageID = canary.Image
+	candidateOpts.RunID = ""
+	candidateOpts.IdempotencyKey = ""
+	candidateOpts.CacheTTL = 0
+	candidateOpts.OnOutput = nil
+
+	type result struct {
+		output []string
+		err    error
+	}
+	candidateCh := make(chan result, 1)
+	go func() {
+		output, err := runCommand(op, candidateOpts, args...)
+		candidateCh <- result{output, err}
+	}()
+
+	output, err := RunCommandWithOptions(op, opts, args...)
+	go func() {
+		candidate := <-candidateCh
+		report := CanaryReport{
+			Op:             op,
+			Args:           args,
+			CandidateImage: canary.Image,
+			Err:            err,
+			CandidateErr:   candidate.err,
+			Diverged:       (err == nil) != (candidate.err == nil),
+		}
+		if canary.CompareOutput {
+			report.Diff = diffLines(splitOutput(output), splitOutput(candidate.output))
+			report.Diverged = report.Diverged || len(report.Diff) > 0
+		}
+		if report.Diverged {
+			log.Errorf("canary run of %s on %s diverged: %v / %v, %d lines differ", op, canary.Image, err, candidate.err, len(report.Diff))
+		}
+		if canary.OnReport != nil {
+			canary.OnReport(report)
+		}
+	}()
+	return output, err
+}
+
+func splitOutput(output []string) []string {
+	lines := []string{}
+	for _, o := range output {
+		lines = append(lines, strings.Split(o, "\n")...)
+	}
+	return lines
+}
+
+// diffLines returns the lines removed from a and added in b, using the
+// longest common subsequence.
+func diffLines(a, b []string) []string {
+	if len(a) > maxCanaryDiffLines || len(b) > maxCanaryDiffLines {
+		if strings.Join(a, "\n") == strings.Join(b, "\n") {
+			return nil
+		}
+		return []string{"- (output too long to compare)", "+ (output too long to compare)"}
+	}
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+ "+b[j])
+	}
+	return diff
+}
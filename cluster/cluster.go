@@ -0,0 +1,207 @@
+// Package cluster spreads command runs across several docker daemons.
+//
+//	c, err := cluster.New(libcmd.Config(), []cluster.Endpoint{
+//		{Endpoint: "tcp://10.0.0.1:2375", Labels: map[string]string{"zone": "a"}},
+//		{Endpoint: "tcp://10.0.0.2:2375", Labels: map[string]string{"zone": "b"}},
+//	}, cluster.Options{Strategy: cluster.LeastLoaded})
+package cluster
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrNoHealthyHost = errors.New("no healthy docker host")
+)
+
+type Strategy int
+
+const (
+	// RoundRobin takes the hosts in turn.
+	RoundRobin Strategy = iota
+	// LeastLoaded picks the host with the fewest runs in flight.
+	LeastLoaded
+)
+
+// Endpoint is a docker daemon. Labels are matched by the selector of
+// RunOn.
+type Endpoint struct {
+	Endpoint string
+	Labels   map[string]string
+}
+
+type Options struct {
+	Strategy Strategy
+	// HealthInterval is how often each daemon is pinged. Hosts that fail
+	// are skipped until they respond again. Defaults to 10 seconds.
+	HealthInterval time.Duration
+}
+
+// HostStatus describes a host of the cluster.
+type HostStatus struct {
+	Endpoint Endpoint
+	Healthy  bool
+	InFlight int
+}
+
+type host struct {
+	endpoint Endpoint
+	config   command.CmdConfig
+	client   *docker.Client
+	monitor  *command.HealthMonitor
+	inFlight int
+
+	pullMu sync.Mutex
+	pulled bool
+}
+
+type Cluster struct {
+	opts  Options
+	hosts []*host
+
+	mu   sync.Mutex
+	next int
+}
+
+// New configures a host for every endpoint from base and pulls the command
+// image on each. Hosts whose pull fails pull again when they are next
+// picked.
+func New(base command.CmdConfig, endpoints []Endpoint, opts Options) (*Cluster, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("at least one endpoint is required")
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = 10 * time.Second
+	}
+	c := &Cluster{opts: opts}
+	for _, endpoint := range endpoints {
+		config := base
+		config.DockerEndpoint = endpoint.Endpoint
+		client, err := command.NewDockerClient(config)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		h := &host{endpoint: endpoint, config: config, client: client}
+		h.monitor = command.StartHealthMonitor(config, opts.HealthInterval, nil)
+		h.ensurePulled()
+		c.hosts = append(c.hosts, h)
+	}
+	return c, nil
+}
+
+// ensurePulled pulls the command image unless an earlier pull succeeded.
+func (h *host) ensurePulled() error {
+	h.pullMu.Lock()
+	defer h.pullMu.Unlock()
+	if h.pulled {
+		return nil
+	}
+	if err := command.PullPlatformImage(h.config, h.client, h.config.ContainerRepository, h.config.ContainerTag, h.config.Platform); err != nil {
+		log.Errorf("error pulling command image on %s: %s", h.endpoint.Endpoint, err)
+		return err
+	}
+	h.pulled = true
+	return nil
+}
+
+func (h *host) healthy() bool {
+	healthy, _ := h.monitor.Healthy()
+	return healthy
+}
+
+// Run runs op on a host picked by the strategy.
+func (c *Cluster) Run(op string, opts command.RunOptions, args ...string) ([]string, error) {
+	return c.RunOn(nil, op, opts, args...)
+}
+
+// RunOn runs op on a host whose labels include every label in selector.
+func (c *Cluster) RunOn(selector map[string]string, op string, opts command.RunOptions, args ...string) ([]string, error) {
+	h, err := c.pick(selector)
+	if err != nil {
+		return nil, err
+	}
+	defer c.done(h)
+	log.Debugf("running %s on %s", op, h.endpoint.Endpoint)
+	return command.Run(op, h.config, h.client, opts, args...)
+}
+
+func (c *Cluster) pick(selector map[string]string) (*host, error) {
+	h := c.choose(selector)
+	if h == nil {
+		return nil, ErrNoHealthyHost
+	}
+	if err := h.ensurePulled(); err != nil {
+		c.done(h)
+		return nil, err
+	}
+	return h, nil
+}
+
+func (c *Cluster) choose(selector map[string]string) *host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	candidates := []*host{}
+	for _, h := range c.hosts {
+		if h.healthy() && matches(h.endpoint.Labels, selector) {
+			candidates = append(candidates, h)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var chosen *host
+	switch c.opts.Strategy {
+	case LeastLoaded:
+		for _, h := range candidates {
+			if chosen == nil || h.inFlight < chosen.inFlight {
+				chosen = h
+			}
+		}
+	default:
+		chosen = candidates[c.next%len(candidates)]
+		c.next++
+	}
+	chosen.inFlight++
+	return chosen
+}
+
+func (c *Cluster) done(h *host) {
+	c.mu.Lock()
+	h.inFlight--
+	c.mu.Unlock()
+}
+
+func matches(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cluster) Hosts() []HostStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statuses := []HostStatus{}
+	for _, h := range c.hosts {
+		statuses = append(statuses, HostStatus{Endpoint: h.endpoint, Healthy: h.healthy(), InFlight: h.inFlight})
+	}
+	return statuses
+}
+
+// Close stops the health checks.
+func (c *Cluster) Close() {
+	for _, h := range c.hosts {
+		h.monitor.Stop()
+	}
+}
@@ -0,0 +1,206 @@
+package command
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// tarDir streams the files under dir as a tar archive with paths relative
+// to dir. Paths for which skip returns true are left out, with everything
+// under them.
+func tarDir(dir string, skip func(rel string) bool) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTarDir(writer, dir, skip))
+	}()
+	return reader
+}
+
+func writeTarDir(w io.Writer, dir string, skip func(rel string) bool) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if skip != nil && skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// dockerignore returns a skip function for the patterns of the
+// .dockerignore file in dir. Patterns are matched with filepath.Match
+// against each path, and a matching directory is left out whole. "!"
+// exceptions are not supported.
+func dockerignore(dir string) (func(rel string) bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		patterns = append(patterns, strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/"))
+	}
+	return func(rel string) bool {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, rel); matched {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// readContainerFiles returns the contents of the regular files directly
+// under dir in the container for which match returns true, by name, with
+// the archive API. A dir that does not exist has no files.
+func readContainerFiles(endpoint, containerID, dir string, match func(name string) bool) (map[string][]byte, error) {
+	dir = path.Clean(dir)
+	resp, closeFn, err := sendRequest("GET", endpoint,
+		fmt.Sprintf("/containers/%s/archive?%s", containerID, url.Values{"path": {dir}}.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string][]byte{}, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("docker returned status %d reading %s", resp.StatusCode, dir)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(resp.Body)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(header.Name, "/")
+		if header.Typeflag != tar.TypeReg || path.Dir(name) != path.Base(dir) || !match(path.Base(name)) {
+			continue
+		}
+		if files[path.Base(name)], err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// extractTar writes the directories and regular files of the tar stream
+// under dir. Entries that would land outside dir, with absolute paths or
+// paths climbing out with "..", are an ErrUnsafeArtifact, and links
+// and special files are skipped so that a command cannot write through
+// them.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%s: %s", ErrUnsafeArtifact, header.Name)
+		}
+		if name == "." {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyTarEntries appends the entries of the tar stream to tw.
+func copyTarEntries(tw *tar.Writer, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
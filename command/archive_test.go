@@ -0,0 +1,95 @@
+package command
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0644, Linkname: entry.linkname}
+		if entry.typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.body))
+		} else if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("writing header %s: %s", entry.name, err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatalf("writing %s: %s", entry.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar: %s", err)
+	}
+	return buf
+}
+
+func TestExtractTarWritesEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libcmd-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := buildTar(t, []tarEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "reports/", typeflag: tar.TypeDir},
+		{name: "reports/summary.txt", typeflag: tar.TypeReg, body: "all good\n"},
+		{name: "nested/deep/file", typeflag: tar.TypeReg, body: "x"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+	})
+	if err := extractTar(archive, dir); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "reports", "summary.txt"))
+	if err != nil || string(data) != "all good\n" {
+		t.Errorf("got summary %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "nested", "deep", "file")); err != nil {
+		t.Errorf("nested file not extracted: %s", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "link")); !os.IsNotExist(err) {
+		t.Errorf("symlink was extracted: %v", err)
+	}
+}
+
+func TestExtractTarRefusesUnsafeEntries(t *testing.T) {
+	tests := []string{
+		"../escape",
+		"reports/../../escape",
+		"..",
+		"/etc/escape",
+	}
+	for _, name := range tests {
+		dir, err := ioutil.TempDir("", "libcmd-extract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := buildTar(t, []tarEntry{{name: name, typeflag: tar.TypeReg, body: "x"}})
+		err = extractTar(archive, filepath.Join(dir, "artifacts"))
+		if err == nil || !strings.HasPrefix(err.Error(), ErrUnsafeArtifact.Error()) {
+			t.Errorf("extracting %q: got error %v, want %v", name, err, ErrUnsafeArtifact)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+			t.Errorf("extracting %q wrote outside the directory", name)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,40 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidArg = errors.New("invalid command argument")
+)
+
+// CheckArgs returns ErrInvalidArg if any of args could subvert the script
+// of op: NUL bytes, which cannot be passed to a process, newlines and other
+// control characters, which scripts that eval or log their arguments
+// mishandle, and invalid UTF-8. Tabs are allowed. When the command sets
+// CommandDef.ArgPattern every arg must also match it as a whole.
+func CheckArgs(op string, args []string) error {
+	def, _ := lookupCommand(op)
+	for i, arg := range args {
+		if !utf8.ValidString(arg) {
+			return fmt.Errorf("%s: arg %d is not valid UTF-8", ErrInvalidArg, i)
+		}
+		for _, r := range arg {
+			if r != '\t' && unicode.IsControl(r) {
+				return fmt.Errorf("%s: arg %d contains control character %U", ErrInvalidArg, i, r)
+			}
+		}
+		if def != nil && def.ArgPattern != "" && !argPattern(def.ArgPattern).MatchString(arg) {
+			return fmt.Errorf("%s: arg %d does not match %s", ErrInvalidArg, i, def.ArgPattern)
+		}
+	}
+	return nil
+}
+
+func argPattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^(?:" + pattern + ")$")
+}
@@ -0,0 +1,109 @@
+package command
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrArtifactNotFound = errors.New("artifact not found in command container")
+	ErrUnsafeArtifact   = errors.New("artifact entry outside the host directory")
+)
+
+// ArtifactOptions copies files the command writes, such as reports or
+// backups, out of the container once it exits and before it is removed.
+type ArtifactOptions struct {
+	// Paths are absolute paths in the container, files or directories.
+	// Each is copied as a tar entry named after its last element.
+	Paths []string
+	// HostDir, when set, is the host directory the artifacts are
+	// extracted to. Only directories and regular files are extracted.
+	HostDir string
+	// Writer, when set, receives the artifacts as one tar stream.
+	Writer io.Writer
+	// Optional paths that do not exist are skipped. Otherwise they fail
+	// the run with ErrArtifactNotFound.
+	Optional bool
+}
+
+// copyArtifacts copies the artifacts out of the container with the archive
+// API.
+func copyArtifacts(config CmdConfig, containerID string, artifacts *ArtifactOptions) error {
+	if artifacts.HostDir != "" {
+		if err := os.MkdirAll(artifacts.HostDir, 0755); err != nil {
+			return err
+		}
+	}
+	var tw *tar.Writer
+	if artifacts.Writer != nil {
+		tw = tar.NewWriter(artifacts.Writer)
+	}
+	for _, p := range artifacts.Paths {
+		if err := copyArtifact(config, containerID, p, artifacts, tw); err != nil {
+			log.Errorf(" -> error copying artifact %s from container %s: %s", p, containerID, err)
+			return err
+		}
+	}
+	if tw != nil {
+		return tw.Close()
+	}
+	return nil
+}
+
+func copyArtifact(config CmdConfig, containerID, p string, artifacts *ArtifactOptions, tw *tar.Writer) error {
+	log.Debugf("copying artifact %s from container %s", p, containerID)
+	resp, closeFn, err := sendRequest("GET", config.DockerEndpoint,
+		fmt.Sprintf("/containers/%s/archive?%s", containerID, url.Values{"path": {p}}.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	if resp.StatusCode == 404 {
+		if artifacts.Optional {
+			log.Debugf(" -> artifact %s not found, skipping", p)
+			return nil
+		}
+		return ErrArtifactNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("docker returned status %d", resp.StatusCode)
+	}
+
+	// The body is read once, so it is split between the destinations.
+	var body io.Reader = resp.Body
+	var pipeWriter *io.PipeWriter
+	errCh := make(chan error, 1)
+	if artifacts.HostDir != "" && tw != nil {
+		var pipeReader *io.PipeReader
+		pipeReader, pipeWriter = io.Pipe()
+		body = io.TeeReader(resp.Body, pipeWriter)
+		go func() {
+			err := copyTarEntries(tw, pipeReader)
+			io.Copy(ioutil.Discard, pipeReader)
+			errCh <- err
+		}()
+	}
+	switch {
+	case artifacts.HostDir != "":
+		err = extractTar(body, artifacts.HostDir)
+	case tw != nil:
+		err = copyTarEntries(tw, body)
+	}
+	if pipeWriter != nil {
+		pipeWriter.CloseWithError(err)
+		if teeErr := <-errCh; err == nil {
+			err = teeErr
+		}
+	}
+	if err == nil {
+		log.Debugf(" -> artifact %s copied from container %s", p, containerID)
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// BreakGlassOp is the op under which break glass sessions pass through the
+// hooks, and so are recorded by the audit and history stores.
+const BreakGlassOp = "break_glass_shell"
+
+var (
+	ErrBreakGlassReasonRequired = errors.New("a reason is required to break glass")
+	ErrBreakGlassNotApproved    = errors.New("break glass session was not approved")
+)
+
+// BreakGlassRequest describes an operator shell session.
+type BreakGlassRequest struct {
+	Operator string
+	Reason   string
+	// Binds are host paths mounted into the shell container, as
+	// "host:container[:ro]".
+	Binds []string
+	// Shell defaults to /bin/bash.
+	Shell []string
+}
+
+// BreakGlassApprover decides whether a session may start. Returning an error
+// refuses it with that error.
+type BreakGlassApprover func(req BreakGlassRequest) error
+
+var (
+	breakGlassMu       sync.RWMutex
+	breakGlassApprover BreakGlassApprover
+)
+
+// SetBreakGlassApprover registers the approval hook. Until one is set every
+// session is refused.
+func SetBreakGlassApprover(fn BreakGlassApprover) {
+	breakGlassMu.Lock()
+	breakGlassApprover = fn
+	breakGlassMu.Unlock()
+}
+
+func approveBreakGlass(req BreakGlassRequest) error {
+	breakGlassMu.RLock()
+	approve := breakGlassApprover
+	breakGlassMu.RUnlock()
+	if approve == nil {
+		return ErrBreakGlassNotApproved
+	}
+	return approve(req)
+}
+
+// BreakGlassShell runs an interactive shell in the command image with a TTY
+// attached to stdin and stdout. The session runs without the default
+// security profile and with req.Binds mounted. The operator is the caller
+// the policy registered with SetPolicy sees, for the op BreakGlassOp.
+// Keystrokes and output are kept in the run transcript, and the operator
+// and reason in its metadata, so that the audit store records the whole
+// session. The transcript is never truncated: once it outgrows the output
+// limits it is handed to the OnTranscript hooks in parts.
+func BreakGlassShell(config CmdConfig, client *docker.Client, req BreakGlassRequest, stdin io.Reader, stdout io.Writer) error {
+	if err := CheckDockerBackend(config, client); err != nil {
+		return err
+	}
+	if req.Reason == "" {
+		return ErrBreakGlassReasonRequired
+	}
+	if !beginRun() {
+		return ErrShuttingDown
+	}
+	defer endRun()
+	shell := req.Shell
+	if len(shell) == 0 {
+		shell = []string{"/bin/bash"}
+	}
+
+	runID, err := NewID()
+	if err != nil {
+		return err
+	}
+	opts := RunOptions{
+		RunID:            runID,
+		Caller:           req.Operator,
+		RecordTranscript: true,
+		Metadata: map[string]string{
+			"break-glass": "true",
+			"operator":    req.Operator,
+			"reason":      req.Reason,
+		},
+	}
+	rc := newRunContext(BreakGlassOp, []string{req.Operator, req.Reason}, config, opts)
+	rc.uncappedTranscript = true
+	_, err = observeRun(BreakGlassOp, func() ([]string, error) {
+		if err := runHooks(rc, beforeRunHook); err != nil {
+			return nil, err
+		}
+		if err := Authorize(opts.Caller, BreakGlassOp, rc.Args); err != nil {
+			return nil, err
+		}
+		if err := approveBreakGlass(req); err != nil {
+			log.Errorf("break glass session for %s refused: %s", req.Operator, err)
+			return nil, err
+		}
+		log.Infof("break glass session approved for %s: %s", req.Operator, req.Reason)
+		return nil, runBreakGlassShell(rc, client, shell, req.Binds, stdin, stdout)
+	})
+	rc.Err = err
+	rc.Duration = time.Since(rc.Start)
+	runFinishedHooks(rc)
+	return err
+}
+
+func runBreakGlassShell(rc *RunContext, client *docker.Client, shell, binds []string, stdin io.Reader, stdout io.Writer) error {
+	rc.HostConfig.Binds = append(rc.HostConfig.Binds, binds...)
+	createOpts := ContainerSpec{
+		Op:    BreakGlassOp,
+		RunID: rc.RunID,
+		Config: &docker.Config{
+			Image:        fmt.Sprintf("%s:%s", rc.Config.ContainerRepository, rc.Config.ContainerTag),
+			Cmd:          shell,
+			Env:          containerEnv(rc.Config, nil),
+			User:         "root",
+			Tty:          true,
+			OpenStdin:    true,
+			StdinOnce:    true,
+			AttachStdin:  true,
+			AttachStdout: true,
+		},
+		HostConfig: rc.HostConfig,
+		Network:    networkOptions(nil, rc.Options),
+	}
+	return runTTY(rc, client, createOpts, nil, stdin, stdout, nil)
+}
+
+// transcriptRecorder numbers the keystrokes and output of an interactive
+// session in the order they happened.
+type transcriptRecorder struct {
+	mu     sync.Mutex
+	seq    uint64
+	record func(stdcopy.Chunk)
+}
+
+func (t *transcriptRecorder) writer(stream stdcopy.Stream) io.Writer {
+	return transcriptWriter{t, stream}
+}
+
+type transcriptWriter struct {
+	t      *transcriptRecorder
+	stream stdcopy.Stream
+}
+
+func (w transcriptWriter) Write(p []byte) (int, error) {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	w.t.seq++
+	data := make([]byte, len(p))
+	copy(data, p)
+	w.t.record(stdcopy.Chunk{Seq: w.t.seq, Stream: w.stream, Data: data})
+	return len(p), nil
+}
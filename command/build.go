@@ -0,0 +1,103 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ImageBuild builds the command image from a Dockerfile rather than pulling
+// it, for CI and for forks that change the command scripts. The daemon's
+// layer cache is used unless NoCache is set.
+type ImageBuild struct {
+	ContextDir string
+	// Dockerfile is relative to ContextDir. Defaults to "Dockerfile".
+	Dockerfile string
+	BuildArgs  map[string]string
+	// CacheFrom lists images whose layers may be reused, such as the last
+	// published command image.
+	CacheFrom []string
+	NoCache   bool
+	// Pull pulls newer versions of the base images.
+	Pull bool
+}
+
+// BuildImage builds repository:tag from build. Files matched by the
+// .dockerignore of the context are not sent to the daemon.
+func BuildImage(config CmdConfig, build ImageBuild, repository, tag string) error {
+	image := fmt.Sprintf("%s:%s", repository, tag)
+	q := url.Values{"t": {image}, "rm": {"1"}}
+	if build.Dockerfile != "" {
+		q.Set("dockerfile", build.Dockerfile)
+	}
+	if len(build.BuildArgs) > 0 {
+		b, _ := json.Marshal(build.BuildArgs)
+		q.Set("buildargs", string(b))
+	}
+	if len(build.CacheFrom) > 0 {
+		b, _ := json.Marshal(build.CacheFrom)
+		q.Set("cachefrom", string(b))
+	}
+	if build.NoCache {
+		q.Set("nocache", "1")
+	}
+	if build.Pull {
+		q.Set("pull", "1")
+	}
+	skip, err := dockerignore(build.ContextDir)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("building image %s from %s", image, build.ContextDir)
+	context := tarDir(build.ContextDir, skip)
+	defer context.Close()
+	if err := streamBuild(config.DockerEndpoint, "/build?"+q.Encode(), context); err != nil {
+		log.Errorf(" -> error building image %s: %s", image, err)
+		return err
+	}
+	log.Debugf(" -> building image %s complete", image)
+	return nil
+}
+
+// streamBuild sends the build context and logs the build output. A failed
+// build reports its error in the output stream.
+func streamBuild(endpoint, path string, context io.Reader) error {
+	resp, closeFn, err := sendTypedRequest("POST", endpoint, path, "application/x-tar", context)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	scanner := bufio.NewScanner(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		var message string
+		for scanner.Scan() {
+			message += scanner.Text()
+		}
+		return &docker.Error{Status: resp.StatusCode, Message: message}
+	}
+	for scanner.Scan() {
+		var progress struct {
+			Stream string
+			Error  string
+		}
+		if json.Unmarshal(scanner.Bytes(), &progress) != nil {
+			continue
+		}
+		if progress.Error != "" {
+			return errors.New(progress.Error)
+		}
+		if line := strings.TrimSpace(progress.Stream); line != "" {
+			log.Debugf(" -> %s", line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,64 @@
+package command
+
+// Capabilities lists the features the configured backend supports, so that
+// callers can check for one before relying on it rather than failing at run
+// time.
+type Capabilities struct {
+	// Stdin can be streamed into a command, as Pipe and sessions do.
+	Stdin bool
+	// TTY can be attached for interactive use, as BreakGlassShell does.
+	TTY bool
+	// Artifacts can be copied out of the command container after it exits.
+	// See RunOptions.Artifacts.
+	Artifacts bool
+	// ExecPool reuses running containers instead of creating one per run.
+	ExecPool bool
+	// ResourceLimits such as ulimits and pid limits apply to each run.
+	ResourceLimits bool
+	// Devices and GPUs can be passed through with RunOptions.Devices.
+	Devices bool
+}
+
+// BackendCapabilities returns the capabilities of the backend selected by
+// config, as its runtime reports them. The local backend, which runs
+// processes rather than containers, has none of them.
+func BackendCapabilities(config CmdConfig) Capabilities {
+	if config.Backend == BackendLocal {
+		return Capabilities{}
+	}
+	rt, err := NewRuntime(config, nil)
+	if err != nil {
+		return Capabilities{}
+	}
+	return rt.Capabilities()
+}
+
+// Capabilities are those of a container per run, exec in ExecContainer when
+// it is set, or the named pipe transport, which has neither. Windows
+// containers have no ulimits.
+func (r *dockerRuntime) Capabilities() Capabilities {
+	windows := r.config.ContainerOS == OSWindows
+	if isNamedPipe(r.config.DockerEndpoint) {
+		// Exec and attach hijack the connection, which the named pipe
+		// transport cannot do. See NewDockerClient.
+		return Capabilities{
+			Artifacts:      true,
+			ResourceLimits: !windows,
+			Devices:        true,
+		}
+	}
+	if r.config.ExecContainer != "" {
+		return Capabilities{
+			Stdin:     true,
+			Artifacts: true,
+			ExecPool:  true,
+		}
+	}
+	return Capabilities{
+		Stdin:          true,
+		TTY:            true,
+		Artifacts:      true,
+		ResourceLimits: !windows,
+		Devices:        true,
+	}
+}
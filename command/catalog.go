@@ -0,0 +1,130 @@
+package command
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// listScriptsCmd prints the names of the files directly under $1.
+const listScriptsCmd = `cd "$1" 2>/dev/null || exit 0; for f in *; do [ -f "$f" ] && echo "$f"; done; true`
+
+// ListCommands returns the ops that have a script in the command image, or
+// in the scripts injected with SetScripts or CmdConfig.ScriptsDir, sorted by
+// name. A script is listed under the op of the registered command whose
+// Script it is, or else under its name without its extension when that is
+// one of Interpreters. Other files are skipped, as are commands that run a
+// Binary. The image is inspected with a short-lived container, or with exec
+// in ExecContainer.
+func ListCommands(config CmdConfig, client *docker.Client) ([]string, error) {
+	var files []string
+	var err error
+	switch {
+	case injectedScripts(config) != nil:
+		files, err = scriptFiles(injectedScripts(config))
+	case config.Backend == BackendLocal:
+		files, err = scriptFiles(os.DirFS(config.CommandsDir))
+	case config.Backend != "" && config.Backend != BackendDocker:
+		return nil, ErrNotSupportedByRuntime
+	case config.ContainerOS == OSWindows:
+		return nil, ErrNotSupportedOnWindows
+	default:
+		files, err = imageScriptFiles(config, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return catalogOps(files), nil
+}
+
+func scriptFiles(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, nil
+}
+
+func imageScriptFiles(config CmdConfig, client *docker.Client) ([]string, error) {
+	log.Debugf("listing command scripts in %s", config.CommandsDir)
+	cmdParts := []string{"bash", "-c", listScriptsCmd, "libcmd-catalog", config.CommandsDir}
+	var stdout string
+	if config.ExecContainer != "" {
+		output, err := runExec(&dockerRuntime{config: config, client: client}, config.ExecContainer, cmdParts, DefaultOutputLimits)
+		if err != nil {
+			log.Errorf(" -> error listing command scripts: %s", err)
+			return nil, err
+		}
+		stdout = output[0]
+	} else {
+		opts := ContainerSpec{
+			Op: "catalog",
+			Config: &docker.Config{
+				Image: fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag),
+				Cmd:   cmdParts,
+				User:  config.User,
+			},
+			HostConfig: newHostConfig(config),
+		}
+		if err := applyPrivileged(opts.HostConfig, "catalog", nil); err != nil {
+			return nil, err
+		}
+		var err error
+		if stdout, err = runCheckContainer(client, config, opts); err != nil {
+			log.Errorf(" -> error listing command scripts: %s", err)
+			return nil, err
+		}
+	}
+	files := []string{}
+	for _, line := range strings.Split(stdout, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	log.Debugf(" -> %d command scripts found", len(files))
+	return files, nil
+}
+
+// catalogOps maps script files to the ops that run them.
+func catalogOps(files []string) []string {
+	scriptOps := map[string]string{}
+	registryMu.RLock()
+	for op, def := range registry {
+		if def.Script != "" {
+			scriptOps[def.Script] = op
+		}
+	}
+	registryMu.RUnlock()
+
+	seen := map[string]bool{}
+	ops := []string{}
+	for _, file := range files {
+		op, exists := scriptOps[file]
+		if !exists {
+			ext := path.Ext(file)
+			if _, known := Interpreters[strings.ToLower(ext)]; !known {
+				continue
+			}
+			op = strings.TrimSuffix(file, ext)
+		}
+		if op != "" && !seen[op] {
+			seen[op] = true
+			ops = append(ops, op)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
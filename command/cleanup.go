@@ -0,0 +1,107 @@
+package command
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrContainerNotRemoved = errors.New("container still exists after removal")
+)
+
+var (
+	// CleanupRetries is the number of times the reaper retries removing a
+	// container that removeContainer left behind.
+	CleanupRetries = 10
+	// CleanupRetryInterval is the wait between those attempts.
+	CleanupRetryInterval = 30 * time.Second
+)
+
+// LeakMetrics may be implemented by Metrics to receive the number of
+// resources that could not be removed, by kind, whenever it changes.
+type LeakMetrics interface {
+	LeakedResources(kind string, count int)
+}
+
+var (
+	leaksMu sync.Mutex
+	leaks   = map[string]map[string]bool{}
+)
+
+// LeakedResources returns, by kind, the IDs of resources that were not
+// removed after a run and are still being retried.
+func LeakedResources() map[string][]string {
+	leaksMu.Lock()
+	defer leaksMu.Unlock()
+	resources := map[string][]string{}
+	for kind, ids := range leaks {
+		for id := range ids {
+			resources[kind] = append(resources[kind], id)
+		}
+		sort.Strings(resources[kind])
+	}
+	return resources
+}
+
+func setLeaked(kind, id string, leaked bool) {
+	leaksMu.Lock()
+	if leaks[kind] == nil {
+		leaks[kind] = map[string]bool{}
+	}
+	if leaked {
+		leaks[kind][id] = true
+	} else {
+		delete(leaks[kind], id)
+	}
+	count := len(leaks[kind])
+	leaksMu.Unlock()
+
+	if m, ok := currentMetrics().(LeakMetrics); ok {
+		m.LeakedResources(kind, count)
+	}
+}
+
+// verifyRemoved checks that the daemon no longer knows the container, since
+// a removal can report success while the container lingers in the
+// "removal in progress" state.
+func verifyRemoved(client *docker.Client, containerID string) error {
+	_, err := client.InspectContainer(containerID)
+	if _, gone := err.(*docker.NoSuchContainer); gone {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrContainerNotRemoved
+}
+
+// reapLater retries removing the container in the background, counting it
+// as leaked until it is gone.
+func reapLater(client *docker.Client, containerID string, opts docker.RemoveContainerOptions) {
+	if verifyRemoved(client, containerID) == nil {
+		return
+	}
+	setLeaked("container", containerID, true)
+	go func() {
+		for attempt := 0; attempt < CleanupRetries; attempt++ {
+			time.Sleep(CleanupRetryInterval)
+			prepareRemove(client, containerID)
+			err := client.RemoveContainer(opts)
+			if _, gone := err.(*docker.NoSuchContainer); gone || err == nil {
+				if verifyRemoved(client, containerID) == nil {
+					log.Debugf("reaped container %s", containerID)
+					setLeaked("container", containerID, false)
+					trackContainer(containerID, false)
+					return
+				}
+			}
+		}
+		log.Errorf("giving up removing container %s; it has leaked", containerID)
+	}()
+}
@@ -1,12 +1,17 @@
 package command
 
 import (
+	"context"
 	"errors"
+	"time"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
 )
 
 var (
 	ErrCommandNotFound = errors.New("command not found")
 	ErrCommandResponse = errors.New("error running command")
+	ErrCommandCanceled = errors.New("command canceled")
 )
 
 type CmdConfig struct {
@@ -14,4 +19,195 @@ type CmdConfig struct {
 	DockerEndpoint      string
 	ContainerRepository string
 	ContainerTag        string
+	ExecContainer       string
+	DetachDir           string
+	// User is the user, as "uid[:gid]" or a name known to the image, that
+	// command containers run as. Empty runs as the image's user.
+	User string
+	// UsernsMode is passed to command containers. Set it to "host" when
+	// the daemon remaps user namespaces but commands need host IDs, for
+	// example to write to bind mounted host directories. "host" is only
+	// honored after SetAllowPrivileged(true).
+	UsernsMode string
+	// DNS, DNSSearch and ExtraHosts are comma separated. ExtraHosts
+	// entries are "host:ip", as for docker run --add-host.
+	DNS        string
+	DNSSearch  string
+	ExtraHosts string
+	// HTTPProxy, HTTPSProxy and NoProxy are passed to command containers
+	// in both the upper and lower case proxy environment variables.
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	// Owner labels every container created, so that Reap can find those
+	// left behind by a crash. Defaults to the host name.
+	Owner string
+	// ScriptsDir is a host directory whose scripts are copied into each
+	// command container at CommandsDir before it starts, in place of those
+	// in the image. See SetScripts.
+	ScriptsDir string
+	// TLSCertPath is a directory with the ca.pem, cert.pem and key.pem
+	// used to connect to DockerEndpoint over TLS, as for DOCKER_CERT_PATH.
+	// TLSVerify set to "true" checks the daemon's certificate against
+	// ca.pem.
+	TLSCertPath string
+	TLSVerify   string
+	// ContainerOS is "linux" or OSWindows, the OS of the command image.
+	// Windows command scripts are <CommandsDir>\<op>.ps1 and are run with
+	// powershell, and linux-only options fail with
+	// ErrNotSupportedOnWindows. Sessions, pipelines and detached runs need
+	// linux images.
+	ContainerOS string
+	// Platform, such as "linux/arm64", selects the variant of the command
+	// image that is pulled and run. Empty uses the daemon's platform.
+	Platform string
+	// OCIRuntime is the container runtime of commands that do not set one.
+	// Empty uses the daemon's default.
+	OCIRuntime string
+	// Manifests set to "true" validates the args of runs against the
+	// <op>.json manifests in the command image. Manifests registered with
+	// the command, injected or on the local backend are always used. See
+	// Describe.
+	Manifests string
+	// PullPolicy is PullMissing, PullAlways or PullNever, and decides
+	// when the images of RunOptions.Image are pulled. Defaults to
+	// PullMissing.
+	PullPolicy string
+	// Backend is BackendDocker, BackendLocal or the name of a runtime
+	// registered with RegisterRuntime. Defaults to BackendDocker.
+	Backend string
+}
+
+// RunOptions holds settings that apply to a single run.
+type RunOptions struct {
+	// Cancel kills the command container when closed. Go commands and
+	// commands run with exec are not interrupted.
+	Cancel <-chan bool
+	// Context is the context of the caller, whose span the spans of the
+	// run are children of. See SetTracer. It does not cancel the run.
+	Context context.Context
+	// Security overrides the security profile of the command for the run.
+	Security *SecurityProfile
+	// ReadOnly overrides the read-only root filesystem setting of the
+	// command for the run.
+	ReadOnly *ReadOnlyOptions
+	// User overrides CmdConfig.User for the run.
+	User string
+	// ImageID, when set, runs the container command in this image instead
+	// of ContainerRepository:ContainerTag, so that a run submitted earlier
+	// uses the image that was current at submission.
+	ImageID string
+	// Image runs the container command in this image, as repository:tag
+	// or repository@digest, instead of ContainerRepository:ContainerTag.
+	// It is pulled according to CmdConfig.PullPolicy. ImageID takes
+	// precedence.
+	Image string
+	// IdempotencyKey deduplicates runs in libcmd.RunCommandWithOptions. A
+	// run whose key matches one in flight or recently completed for the
+	// same Caller returns that run's result instead of running the command
+	// again. Reusing a key for another op, args or image returns
+	// libcmd.ErrIdempotencyKeyReused.
+	IdempotencyKey string
+	// CacheTTL, when set, caches a successful result in
+	// libcmd.RunCommandWithOptions. Runs of the same op with the same args,
+	// Env, User, WorkingDir, Entrypoint and MountParams against the same
+	// command image return the cached output until it expires or is removed
+	// with libcmd.InvalidateCache. Runs with Inputs, SecretFiles, Volumes,
+	// Artifacts or ExpandTemplates are never cached. Only use it for
+	// commands without side effects.
+	CacheTTL time.Duration
+	// OutputLimits caps the stdout and stderr held for the run. Defaults to
+	// DefaultOutputLimits. See OutputTruncated.
+	OutputLimits *OutputLimits
+	// MountParams fills in the mount templates registered for the command.
+	// See RegisterCommand.
+	MountParams map[string]string
+	// Secrets are values, usually passed in args, that are masked in log
+	// messages, audit and history records, and the output of failed runs.
+	Secrets []string
+	// Env sets KEY=VALUE environment variables in the command container.
+	// Values may be secret references; see RegisterSecretResolver.
+	Env []string
+	// SecretFiles are written, by name, to files in SecretsDir inside the
+	// command container instead of being passed in args or env, which
+	// docker inspect and process listings expose. Their values are also
+	// treated as Secrets.
+	SecretFiles map[string]string
+	// OnOutput receives the output of the command, with sequence numbers
+	// that order stdout and stderr relative to each other. Container
+	// commands deliver it once the container has exited, as it is read
+	// back from the runtime's logs, while local commands and break glass
+	// sessions deliver it as it is written. Commands run with exec and
+	// scripts do not report output.
+	OnOutput func(chunk stdcopy.Chunk)
+	// RecordTranscript keeps the sequenced output in
+	// RunContext.Transcript, where hooks and the history store see it.
+	RecordTranscript bool
+	// Caller identifies who the run is for, such as a tenant or user, to
+	// the policy registered with SetPolicy.
+	Caller string
+	// Metadata describes the initiator of the run, such as a user or
+	// request ID. It is passed to hooks and recorded by the audit package.
+	Metadata map[string]string
+	// Network overrides the network of the command for the run, for
+	// example to attach it to a user-defined network with static IPv4 and
+	// IPv6 addresses.
+	Network *NetworkOptions
+	// Devices passes host devices or GPUs through to the command
+	// container. The runtime they need is checked before the container is
+	// created. They are only honored after SetAllowPrivileged(true).
+	Devices *DeviceOptions
+	// Privileged runs the command privileged or in host namespaces. It is
+	// only honored after SetAllowPrivileged(true).
+	Privileged *PrivilegedOptions
+	// Ulimits and PidsLimit override those of the security profile.
+	// Ulimits replace profile limits of the same name.
+	Ulimits   []Ulimit
+	PidsLimit int64
+	// OCIRuntime runs the container with this runtime, such as
+	// RuntimeGVisor or RuntimeKata, instead of CmdConfig.OCIRuntime. Runs
+	// of a command pinned to another runtime fail with ErrRuntimePinned.
+	// The runtime is checked before the container is created.
+	OCIRuntime string
+	// ExpandTemplates renders args and Env values as text/templates with
+	// TemplateData when the run starts, so that a command defined ahead of
+	// time can take {{.RunID}}, {{.Now}} or {{.Values.name}} from
+	// TemplateValues. Idempotency keys and the result cache see the args
+	// before they are rendered.
+	ExpandTemplates bool
+	TemplateValues  map[string]string
+	// WorkingDir runs the command from this directory, such as a mounted
+	// workspace, instead of that of the image or of ReadOnlyOptions. On
+	// the local backend it is a host directory.
+	WorkingDir string
+	// Entrypoint replaces the entrypoint of the command image, for images
+	// whose entrypoint gets in the way of running scripts. []string{""}
+	// clears it. The local backend ignores it.
+	//
+	// Runs in ExecContainer fail with ErrNotSupportedByRuntime when it or
+	// any other option that shapes the command container is set: secret
+	// files, env, user, image, network, security, devices and the like.
+	Entrypoint []string
+	// Volumes attaches volumes created with CreateVolume to the command
+	// container.
+	Volumes []VolumeMount
+	// Inputs are copied into the command container before the command
+	// starts. Archive readers are consumed by the run.
+	Inputs []Input
+	// Artifacts are copied out of the command container after it exits,
+	// whether it succeeded or not, and before it is removed. See
+	// libcmd.RunWithArtifacts.
+	Artifacts *ArtifactOptions
+	// CollectStats collects the resource usage of the command container
+	// while it runs into RunContext.Stats and Result.Stats. Commands run
+	// with exec or on other runtimes have none.
+	CollectStats bool
+	// RunID identifies the run in logs, container labels, hooks and
+	// records, for example an upstream request ID. It must pass CheckID.
+	// Defaults to an ID from NewID.
+	RunID string
+
+	runContext *RunContext
+	// signals are requests from Execution.Signal.
+	signals <-chan signalRequest
 }
@@ -1,86 +1,331 @@
 package command
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net"
-	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"strings"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
-	"github.com/fsouza/go-dockerclient"
-)
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
 
-var (
-	availableCommands = []string{
-		"cert",
-		"random",
-		"raw",
-	}
+	"github.com/fsouza/go-dockerclient"
 )
 
 type containerCmd struct {
 	op           string
 	config       CmdConfig
 	dockerClient *docker.Client
+	def          *CommandDef
 }
 
 func NewContainerCmd(op string, config CmdConfig, dockerClient *docker.Client) (*containerCmd, error) {
-	exists := false
-	for _, o := range availableCommands {
-		if o == op {
-			exists = true
-			break
-		}
-	}
+	def, exists := lookupCommand(op)
 	if !exists {
 		return nil, ErrCommandNotFound
 	}
-	cmd := containerCmd{op, config, dockerClient}
+	cmd := containerCmd{op, config, dockerClient, def}
 	return &cmd, nil
 }
 
 func (c *containerCmd) Run(args ...string) ([]string, error) {
-	cmdParts := []string{"bash", fmt.Sprintf("%s/%s.sh", c.config.CommandsDir, c.op)}
-	cmdParts = append(cmdParts, args...)
-	container, err := createContainer(c.dockerClient, c.config.ContainerRepository, c.config.ContainerTag, cmdParts)
+	return c.RunWithOptions(RunOptions{}, args...)
+}
+
+func (c *containerCmd) RunWithOptions(opts RunOptions, args ...string) ([]string, error) {
+	rc := opts.runContext
+	if rc == nil {
+		rc = newRunContext(c.op, args, c.config, opts)
+	}
+	if err := CheckArgs(c.op, args); err != nil {
+		return nil, err
+	}
+	manifest, err := commandManifest(c.config, c.dockerClient, c.def)
 	if err != nil {
 		return nil, err
 	}
-	defer removeContainer(c.dockerClient, container.ID)
+	if manifest != nil {
+		if args, err = manifest.Validate(args); err != nil {
+			return nil, err
+		}
+		rc.Args = args
+	}
+	if c.config.Backend == BackendLocal {
+		if err := checkLocalOptions(c.config, opts); err != nil {
+			return nil, err
+		}
+		return runLocal(rc, c.config, scriptCmdParts(c.config, c.op, args), opts)
+	}
+	rt, err := NewRuntime(c.config, c.dockerClient)
+	if err != nil {
+		return nil, err
+	}
+	onDocker := isDockerRuntime(rt)
+	scripts := injectedScripts(c.config)
+	if !onDocker && (len(c.def.Requires) > 0 || len(opts.SecretFiles) > 0 || scripts != nil || opts.Artifacts != nil || len(opts.Inputs) > 0) {
+		return nil, ErrNotSupportedByRuntime
+	}
+	if err := checkInputs(opts.Inputs); err != nil {
+		return nil, err
+	}
+	if c.config.ExecContainer != "" {
+		if err := checkExecOptions(opts); err != nil {
+			return nil, err
+		}
+	}
+	windows := c.config.ContainerOS == OSWindows
+	if windows {
+		if err := checkWindows(c.def, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	image := opts.ImageID
+	if image == "" && opts.Image != "" {
+		if err := rc.phase("libcmd.pull", func() error {
+			return EnsureImage(c.config, rt, c.dockerClient, opts.Image)
+		}); err != nil {
+			return nil, err
+		}
+		image = opts.Image
+	}
+	if image == "" {
+		image = fmt.Sprintf("%s:%s", c.config.ContainerRepository, c.config.ContainerTag)
+	}
+	if onDocker {
+		missing, err := checkDependencies(c, image, containerUser(c.config, opts))
+		if err != nil {
+			return nil, err
+		}
+		if len(missing) > 0 {
+			return []string{"missing dependencies: " + strings.Join(missing, ", ")}, ErrMissingDependency
+		}
+	}
+
+	cmdParts := scriptCmdParts(c.config, c.op, args)
+	if c.config.ExecContainer != "" {
+		if scripts != nil {
+			if err := injectScripts(c.config, c.config.ExecContainer, scripts); err != nil {
+				return nil, err
+			}
+		}
+		if len(opts.Inputs) > 0 {
+			if err := stageInputs(c.config, c.config.ExecContainer, containerUser(c.config, opts), opts.Inputs); err != nil {
+				return nil, err
+			}
+		}
+		defer rejectSignals(opts.signals)()
+		output, err := runExecContext(rc, rt, c.config.ExecContainer, cmdParts, opts.outputLimits())
+		if (err == nil || err == ErrCommandResponse) && opts.Artifacts != nil {
+			if err := copyArtifacts(c.config, c.config.ExecContainer, opts.Artifacts); err != nil {
+				return nil, err
+			}
+		}
+		return output, err
+	}
+	if onDocker && !windows {
+		if output, err := runWarmUps(c, image); err != nil {
+			return output, err
+		}
+	}
 
-	if err := startContainer(c.dockerClient, container.ID); err != nil {
+	if err := checkSecretFiles(opts.SecretFiles); err != nil {
 		return nil, err
 	}
+	if len(opts.SecretFiles) > 0 {
+		cmdParts = secretFilesCmdParts(cmdParts)
+		rc.HostConfig.Tmpfs[SecretsDir] = secretsTmpfsOptions + tmpfsOwnerOptions(containerUser(c.config, opts))
+	}
+	workingDir := ""
+	if readOnly := readOnlyOptions(c.def, opts); readOnly != nil {
+		if scripts != nil {
+			return nil, ErrInjectReadOnly
+		}
+		if len(opts.Inputs) > 0 {
+			return nil, ErrInputReadOnly
+		}
+		applyReadOnly(rc.HostConfig, c.config, opts, readOnly)
+		workingDir = readOnly.WorkDir
+	}
+	if opts.WorkingDir != "" {
+		workingDir = opts.WorkingDir
+	}
 
-	stopCh := make(chan bool)
-	eventCh, err := getContainerEventCh(c.dockerClient, container.ID, stopCh)
+	// Windows containers have no capabilities, seccomp or ulimits.
+	if !windows {
+		if err := applySecurity(rc.HostConfig, c.op, securityProfile(c.def, opts)); err != nil {
+			return nil, err
+		}
+		applyRunLimits(rc.HostConfig, opts)
+	}
+	if err := applyPrivileged(rc.HostConfig, c.op, privilegedOptions(c.def, opts)); err != nil {
+		return nil, err
+	}
+
+	binds, err := c.def.binds(opts.MountParams)
 	if err != nil {
 		return nil, err
 	}
+	rc.HostConfig.Binds = append(rc.HostConfig.Binds, binds...)
+	if len(opts.Volumes) > 0 {
+		volumes, err := volumeBinds(c.config, opts.Volumes)
+		if err != nil {
+			return nil, err
+		}
+		rc.HostConfig.Binds = append(rc.HostConfig.Binds, volumes...)
+	}
 
-	for {
-		event := <-eventCh
-		if event.Status == "die" {
-			close(stopCh)
-			break
+	if err := applyOCIRuntime(rc.HostConfig, c.config, c.def, opts); err != nil {
+		return nil, err
+	}
+	if opts.Devices != nil {
+		if err := CheckDevices(c.config, *opts.Devices); err != nil {
+			return nil, err
+		}
+		if err := applyDevices(rc.HostConfig, c.op, opts.Devices); err != nil {
+			return nil, err
 		}
 	}
 
-	exitCode, err := getContainerExitCode(c.dockerClient, container.ID)
+	network, dnsName, err := withRunDNSName(networkOptions(c.def, opts), rc.RunID)
 	if err != nil {
 		return nil, err
 	}
+	rc.DNSName = dnsName
+	if network.Name != "" {
+		if err := CheckNetwork(c.config, *network); err != nil {
+			return nil, err
+		}
+	}
+	createOpts := ContainerSpec{
+		Op:    c.op,
+		RunID: rc.RunID,
+		Config: &docker.Config{
+			Image:      image,
+			Cmd:        cmdParts,
+			Env:        containerEnv(c.config, opts.Env),
+			User:       containerUser(c.config, opts),
+			WorkingDir: workingDir,
+			Entrypoint: opts.Entrypoint,
+		},
+		HostConfig: rc.HostConfig,
+		Network:    network,
+	}
+	rc.Image = image
+	var containerID string
+	if err := rc.phase("libcmd.create", func() error {
+		containerID, err = rt.Create(createOpts)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	defer rc.phase("libcmd.remove", func() error { return rt.Remove(containerID) })
 
-	stdout, stderr, err := getContainerLogs(c.config.DockerEndpoint, container.ID)
-	if err != nil {
+	rc.ContainerID = containerID
+	if err := runHooks(rc, containerCreatedHook); err != nil {
 		return nil, err
 	}
+	if scripts != nil {
+		if err := injectScripts(c.config, containerID, scripts); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Inputs) > 0 {
+		if err := stageInputs(c.config, containerID, containerUser(c.config, opts), opts.Inputs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rc.phase("libcmd.start", func() error { return rt.Start(containerID) }); err != nil {
+		return nil, err
+	}
+
+	if len(opts.SecretFiles) > 0 {
+		if err := writeSecretFiles(c.dockerClient, containerID, opts.SecretFiles); err != nil {
+			rt.Kill(containerID)
+			return nil, err
+		}
+	}
+
+	if err := runHooks(rc, startedHook); err != nil {
+		rt.Kill(containerID)
+		return nil, err
+	}
+
+	// Look up the image while the command runs rather than after it exits.
+	imageIDCh := make(chan string, 1)
+	go func() {
+		if !onDocker {
+			imageIDCh <- ""
+			return
+		}
+		cntr, err := inspectContainer(c.dockerClient, containerID)
+		if err != nil {
+			imageIDCh <- ""
+			return
+		}
+		imageIDCh <- cntr.Image
+	}()
+
+	// The wait response carries the exit code, so there is no need to
+	// watch events or inspect the container once it has exited.
+	waitCh := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := rt.Wait(containerID)
+		waitCh <- waitResult{exitCode, err}
+	}()
+
+	var stopStats func() *ResourceStats
+	if opts.CollectStats && onDocker {
+		stopStats = collectStats(c.config.DockerEndpoint, containerID)
+	}
+
+	canceled := false
+	cancelCh := opts.Cancel
+	var result waitResult
+	rc.phase("libcmd.wait", func() error {
+		for waiting := true; waiting; {
+			select {
+			case result = <-waitCh:
+				waiting = false
+			case <-cancelCh:
+				canceled = true
+				cancelCh = nil
+				rt.Kill(containerID)
+			case req := <-opts.signals:
+				req.errCh <- signalRuntime(rt, containerID, req.sig)
+			}
+		}
+		return result.err
+	})
+	rc.ImageID = <-imageIDCh
+	if stopStats != nil {
+		rc.Stats = stopStats()
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	exitCode := result.exitCode
+	rc.ExitCode = exitCode
+
+	var logs Output
+	if err := rc.phase("libcmd.logs", func() error {
+		logs, err = rt.Logs(containerID, opts.outputLimits(), chunkRecorder(rc, opts))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	rc.captureStreams(logs)
+	stdout, stderr := logs.Stdout, logs.Stderr
+	if opts.Artifacts != nil && !canceled {
+		if err := copyArtifacts(c.config, containerID, opts.Artifacts); err != nil {
+			return nil, err
+		}
+	}
+
+	if canceled {
+		return []string{strings.TrimSpace(stderr)}, ErrCommandCanceled
+	}
 
 	if exitCode == 0 {
 		return []string{strings.TrimSpace(stdout)}, nil
@@ -89,51 +334,138 @@ func (c *containerCmd) Run(args ...string) ([]string, error) {
 	return []string{strings.TrimSpace(stderr)}, ErrCommandResponse
 }
 
+func isContainerCommand(op string) bool {
+	_, exists := lookupCommand(op)
+	return exists
+}
+
+func scriptCmdParts(config CmdConfig, op string, args []string) []string {
+	if def, _ := lookupCommand(op); def != nil && def.Binary != "" {
+		return append([]string{def.Binary}, args...)
+	}
+	if config.ContainerOS == OSWindows {
+		return windowsScriptCmdParts(config, op, args)
+	}
+	interpreter, script := commandScript(config, op)
+	cmdParts := append(append([]string{}, interpreter...), script)
+	return append(cmdParts, args...)
+}
+
 func PullImage(client *docker.Client, repository, tag string) error {
+	image := fmt.Sprintf("%s:%s", repository, tag)
 	reader, writer := io.Pipe()
-	go func(reader io.Reader) {
-		scanner := bufio.NewScanner(reader)
-		for scanner.Scan() {
-			line := scanner.Text()
-			log.Debugf(" -> %s", line)
-		}
-	}(reader)
+	progressErrCh := make(chan error, 1)
+	go func() {
+		progressErrCh <- decodePullProgress(image, reader)
+	}()
 	opts := docker.PullImageOptions{
-		Repository:   repository,
-		Tag:          tag,
-		OutputStream: writer,
+		Repository:    repository,
+		Tag:           tag,
+		OutputStream:  writer,
+		RawJSONStream: true,
+	}
+	log.Debugf("pulling image %s", image)
+	start := time.Now()
+	err := client.PullImage(opts, docker.AuthConfiguration{})
+	writer.Close()
+	if progressErr := <-progressErrCh; err == nil {
+		err = progressErr
 	}
-	log.Debugf("pulling image %s:%s", repository, tag)
-	if err := client.PullImage(opts, docker.AuthConfiguration{}); err != nil {
+	currentMetrics().PullFinished(image, time.Since(start), err)
+	if err != nil {
+		log.Errorf(" -> error pulling image %s: %s", image, err)
 		return err
 	}
 	log.Debugf(" -> pulling image %s:%s complete", repository, tag)
 	return nil
 }
 
-func createContainer(client *docker.Client, repository, tag string, cmdParts []string) (*docker.Container, error) {
-	log.Debugf("creating container %s:%s", repository, tag)
-	config := &docker.Config{
-		Image: fmt.Sprintf("%s:%s", repository, tag),
-		Cmd:   cmdParts,
+func createContainer(config CmdConfig, cmdParts []string) (*docker.Container, error) {
+	opts := ContainerSpec{
+		Config: &docker.Config{
+			Image: fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag),
+			Cmd:   cmdParts,
+			Env:   containerEnv(config, nil),
+			User:  config.User,
+		},
+		HostConfig: newHostConfig(config),
+		Network:    networkOptions(nil, RunOptions{}),
+	}
+	applyNetwork(opts.HostConfig, opts.Network)
+	if err := applySecurity(opts.HostConfig, "container", DefaultSecurityProfile); err != nil {
+		return nil, err
 	}
-	opts := docker.CreateContainerOptions{
-		Config: config,
+	if err := applyPrivileged(opts.HostConfig, "container", nil); err != nil {
+		return nil, err
+	}
+	if err := applyOCIRuntime(opts.HostConfig, config, nil, RunOptions{}); err != nil {
+		return nil, err
+	}
+	return createContainerFromOptions(config, opts)
+}
+
+func containerUser(config CmdConfig, opts RunOptions) string {
+	if opts.User != "" {
+		return opts.User
+	}
+	return config.User
+}
+
+// ContainerSpec describes a container to create.
+type ContainerSpec struct {
+	// Op and RunID label the container, along with CmdConfig.Owner.
+	Op         string
+	RunID      string
+	Name       string
+	Config     *docker.Config
+	HostConfig *HostConfig
+	Network    *NetworkOptions
+}
+
+type containerCreateBody struct {
+	*docker.Config
+	HostConfig       *HostConfig       `json:",omitempty"`
+	NetworkingConfig *networkingConfig `json:",omitempty"`
+}
+
+// createContainerFromOptions creates the container with a direct API request
+// because the vendored client cannot send a networking config.
+func createContainerFromOptions(config CmdConfig, opts ContainerSpec) (*docker.Container, error) {
+	log.Debugf("creating container %s", opts.Config.Image)
+	path := "/containers/create" + createQuery(config, opts.Name)
+	body := containerCreateBody{Config: ownerLabeled(config, opts), HostConfig: opts.HostConfig}
+	if opts.Network != nil && opts.Network.Name != "" {
+		body.NetworkingConfig = opts.Network.networkingConfig()
 	}
-	container, err := client.CreateContainer(opts)
+	start := time.Now()
+	id, err := postCreate(config.DockerEndpoint, path, body)
+	currentMetrics().ContainerCreated(time.Since(start), err)
 	if err != nil {
-		log.Errorf(" -> error creating container %s:%s: %s", repository, tag, err)
+		log.Errorf(" -> error creating container %s: %s", opts.Config.Image, err)
 		return nil, err
 	}
-	log.Debugf(" -> container %s:%s with id %s created", repository, tag, container.ID)
+	log.Debugf(" -> container %s with id %s created", opts.Config.Image, id)
+	trackContainer(id, true)
+	touchImage(opts.Config.Image)
+	container := &docker.Container{
+		ID:     id,
+		Name:   opts.Name,
+		Config: opts.Config,
+	}
+	if opts.HostConfig != nil {
+		container.HostConfig = &opts.HostConfig.HostConfig
+	}
 	return container, nil
-
 }
 
 func startContainer(client *docker.Client, containerID string) error {
 	log.Debugf("starting container %s", containerID)
-	hostConfig := &docker.HostConfig{}
-	if err := client.StartContainer(containerID, hostConfig); err != nil {
+	// The host config is set when the container is created. Sending one
+	// here would replace it on older daemons.
+	start := time.Now()
+	err := postStart(client, containerID)
+	currentMetrics().ContainerStarted(time.Since(start), err)
+	if err != nil {
 		log.Errorf(" -> error starting container %s: %s", containerID, err)
 		return err
 	}
@@ -141,6 +473,19 @@ func startContainer(client *docker.Client, containerID string) error {
 	return nil
 }
 
+func killContainer(client *docker.Client, containerID string) error {
+	log.Debugf("killing container %s", containerID)
+	opts := docker.KillContainerOptions{
+		ID: containerID,
+	}
+	if err := client.KillContainer(opts); err != nil {
+		log.Errorf(" -> error killing container %s: %s", containerID, err)
+		return err
+	}
+	log.Debugf(" -> container %s killed", containerID)
+	return nil
+}
+
 func removeContainer(client *docker.Client, containerID string) error {
 	log.Debugf("removing container %s", containerID)
 	opts := docker.RemoveContainerOptions{
@@ -148,103 +493,80 @@ func removeContainer(client *docker.Client, containerID string) error {
 		RemoveVolumes: false,
 		Force:         true,
 	}
-	if err := client.RemoveContainer(opts); err != nil {
+	prepareRemove(client, containerID)
+	err := client.RemoveContainer(opts)
+	if err == nil {
+		err = verifyRemoved(client, containerID)
+	}
+	if err != nil {
 		log.Errorf(" -> error removing container %s: %s", containerID, err)
+		reapLater(client, containerID, opts)
 		return err
 	}
 	log.Debugf(" -> container %s removed", containerID)
+	trackContainer(containerID, false)
 	return nil
 }
 
-func getContainerEventCh(client *docker.Client, containerID string, stopCh chan bool) (<-chan *docker.APIEvents, error) {
-	eventCh := make(chan *docker.APIEvents)
-
-	listener := make(chan *docker.APIEvents)
-	log.Debugf("adding container %s event listener", containerID)
-	if err := client.AddEventListener(listener); err != nil {
-		log.Errorf(" -> error adding container %s event listener: %s", containerID, err)
-		return nil, err
-	}
-	log.Debugf(" -> container %s event listener added successfully", containerID)
+type waitResult struct {
+	exitCode int
+	err      error
+}
 
-	go func() {
-		for {
-			select {
-			case event := <-listener:
-				if event.ID == containerID {
-					eventCh <- event
-				}
-				continue
-			case <-stopCh:
-				return
-			}
+// waitContainer waits for the container to exit. A wait cut short by a
+// daemon restart is resumed once the daemon is back; the container keeps
+// its exit code.
+func waitContainer(client *docker.Client, containerID string) (int, error) {
+	log.Debugf("waiting for container %s", containerID)
+	exitCode, err := client.WaitContainer(containerID)
+	for attempt := 0; isConnectionError(err) && attempt < DaemonRetries; attempt++ {
+		log.Errorf(" -> lost connection waiting for container %s, retrying: %s", containerID, err)
+		if err = waitForDaemon(client.Ping); err == nil {
+			exitCode, err = client.WaitContainer(containerID)
 		}
-	}()
-
-	return eventCh, nil
+	}
+	if err != nil {
+		log.Errorf(" -> error waiting for container %s: %s", containerID, err)
+		return -1, err
+	}
+	log.Debugf(" -> container %s exited with code %d", containerID, exitCode)
+	return exitCode, nil
 }
 
-func getContainerExitCode(client *docker.Client, containerID string) (int, error) {
+func inspectContainer(client *docker.Client, containerID string) (*docker.Container, error) {
 	log.Debugf("inspecting container %s", containerID)
 	cntr, err := client.InspectContainer(containerID)
 	if err != nil {
 		log.Errorf(" -> error inspecting container %s: %s", containerID, err)
-		return -1, err
+		return nil, err
 	}
 	log.Debugf(" -> container %s inspect success", containerID)
-	return cntr.State.ExitCode, nil
+	return cntr, nil
 }
 
-func getContainerLogs(endpoint, containerID string) (string, string, error) {
+func getContainerLogs(endpoint, containerID string, limits OutputLimits, onChunk func(stdcopy.Chunk)) (Output, error) {
 	log.Debugf("getting container %s logs", containerID)
-	stdout, stderr, _, err := makeRequest("GET", endpoint, fmt.Sprintf("/containers/%s/logs?follow=0&stderr=1&stdout=1", containerID))
+	stdout := newLimitedBuffer(limits.MaxStdout, limits.Strategy)
+	stderr := newLimitedBuffer(limits.MaxStderr, limits.Strategy)
+	_, err := makeRequest("GET", endpoint, fmt.Sprintf("/containers/%s/logs?follow=0&stderr=1&stdout=1", containerID), stdout, stderr, onChunk)
 	if err != nil {
 		log.Errorf(" -> error making container %s logs request: %s", containerID, err)
-		return "", "", err
+		return Output{}, err
 	}
 	log.Debugf(" -> container %s logs request complete", containerID)
-	return string(stdout), string(stderr), nil
+	return limitedOutput(stdout, stderr), nil
 }
 
-func makeRequest(method, endpoint, path string) ([]byte, []byte, int, error) {
-	req, err := http.NewRequest(method, path, nil)
-	if err != nil {
-		return nil, nil, -1, err
-	}
-	req.Header.Set("User-Agent", "go-dockerclient")
-	var resp *http.Response
-	u, err := url.Parse(endpoint)
+// makeRequest streams the multiplexed stdout and stderr of the response
+// into the given writers, passing each chunk to onChunk if it is not nil.
+func makeRequest(method, endpoint, path string, stdout, stderr io.Writer, onChunk func(stdcopy.Chunk)) (int, error) {
+	resp, closeFn, err := sendRequest(method, endpoint, path, nil)
 	if err != nil {
-		return nil, nil, -1, docker.ErrInvalidEndpoint
-	}
-	protocol := u.Scheme
-	address := u.Path
-	if protocol == "unix" {
-		dial, err := net.Dial(protocol, address)
-		if err != nil {
-			return nil, nil, -1, err
-		}
-		defer dial.Close()
-		clientconn := httputil.NewClientConn(dial, nil)
-		resp, err = clientconn.Do(req)
-		if err != nil {
-			return nil, nil, -1, err
-		}
-		defer clientconn.Close()
-	} else {
-		resp, err = http.DefaultClient.Do(req)
-	}
-	if err != nil {
-		if strings.Contains(err.Error(), "connection refused") {
-			return nil, nil, -1, docker.ErrConnectionRefused
-		}
-		return nil, nil, -1, err
+		return -1, err
 	}
-	var stdoutBuffer, stderrBuffer bytes.Buffer
-	if _, err := stdCopy(&stdoutBuffer, &stderrBuffer, resp.Body); err != nil {
-		return nil, nil, -1, err
+	defer closeFn()
+	if _, err := stdcopy.StdCopySequenced(stdout, stderr, resp.Body, onChunk); err != nil {
+		return -1, err
 	}
-	bErr, _ := ioutil.ReadAll(&stderrBuffer)
-	bOut, err := ioutil.ReadAll(&stdoutBuffer)
-	return bOut, bErr, resp.StatusCode, err
+	return resp.StatusCode, nil
 }
@@ -0,0 +1,175 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrMissingDependency = errors.New("missing command dependency")
+
+	versionPattern = regexp.MustCompile(`[0-9]+(\.[0-9]+)*`)
+
+	dependencyChecksMu sync.Mutex
+	dependencyChecks   = map[string][]string{}
+)
+
+// Dependency is a binary or file a command needs inside the command image.
+type Dependency struct {
+	// Binary is looked up on the PATH.
+	Binary string
+	// MinVersion, if set, is compared with the first version number
+	// printed by running Binary with VersionArg.
+	MinVersion string
+	// VersionArg defaults to "--version".
+	VersionArg string
+	// File is an absolute path that must exist.
+	File string
+}
+
+func (d Dependency) String() string {
+	if d.File != "" {
+		return d.File
+	}
+	if d.MinVersion != "" {
+		return fmt.Sprintf("%s >= %s", d.Binary, d.MinVersion)
+	}
+	return d.Binary
+}
+
+func (d Dependency) script() string {
+	if d.File != "" {
+		return fmt.Sprintf("if [ -e %s ]; then echo found; else echo missing; fi", shellQuote(d.File))
+	}
+	versionArg := d.VersionArg
+	if versionArg == "" {
+		versionArg = "--version"
+	}
+	binary := shellQuote(d.Binary)
+	return fmt.Sprintf("if command -v %s >/dev/null 2>&1; then echo \"found $(%s %s 2>&1 | head -n 1)\"; else echo missing; fi",
+		binary, binary, shellQuote(versionArg))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// checkDependencies verifies the dependencies of the command in image once
+// per image ID and returns a description of the ones that are missing.
+func checkDependencies(c *containerCmd, image string, user string) ([]string, error) {
+	if len(c.def.Requires) == 0 {
+		return nil, nil
+	}
+	info, err := c.dockerClient.InspectImage(image)
+	if err != nil {
+		return nil, err
+	}
+	key := info.ID + "\x00" + c.op
+	dependencyChecksMu.Lock()
+	missing, checked := dependencyChecks[key]
+	dependencyChecksMu.Unlock()
+	if checked {
+		return missing, nil
+	}
+
+	log.Debugf("checking dependencies of %s in image %s", c.op, info.ID)
+	scripts := make([]string, len(c.def.Requires))
+	for i, dep := range c.def.Requires {
+		scripts[i] = dep.script()
+	}
+	cmdParts := []string{"bash", "-c", strings.Join(scripts, "\n")}
+
+	var stdout string
+	if c.config.ExecContainer != "" {
+		output, err := runExec(&dockerRuntime{config: c.config, client: c.dockerClient}, c.config.ExecContainer, cmdParts, DefaultOutputLimits)
+		if err != nil {
+			return nil, err
+		}
+		stdout = output[0]
+	} else {
+		opts := ContainerSpec{
+			Op:         c.op,
+			Config:     &docker.Config{Image: info.ID, Cmd: cmdParts, User: user},
+			HostConfig: newHostConfig(c.config),
+		}
+		if err := applyPrivileged(opts.HostConfig, c.op, nil); err != nil {
+			return nil, err
+		}
+		if err := applyOCIRuntime(opts.HostConfig, c.config, c.def, RunOptions{}); err != nil {
+			return nil, err
+		}
+		if stdout, err = runCheckContainer(c.dockerClient, c.config, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	missing = []string{}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	for i, dep := range c.def.Requires {
+		line := ""
+		if i < len(lines) {
+			line = strings.TrimSpace(lines[i])
+		}
+		if !strings.HasPrefix(line, "found") {
+			missing = append(missing, dep.String())
+			continue
+		}
+		if dep.MinVersion != "" {
+			version := versionPattern.FindString(strings.TrimPrefix(line, "found"))
+			if version == "" || compareVersions(version, dep.MinVersion) < 0 {
+				missing = append(missing, fmt.Sprintf("%s (found %q)", dep, version))
+			}
+		}
+	}
+	log.Debugf(" -> dependencies of %s checked, %d missing", c.op, len(missing))
+
+	dependencyChecksMu.Lock()
+	dependencyChecks[key] = missing
+	dependencyChecksMu.Unlock()
+	return missing, nil
+}
+
+func runCheckContainer(client *docker.Client, config CmdConfig, opts ContainerSpec) (string, error) {
+	container, err := createContainerFromOptions(config, opts)
+	if err != nil {
+		return "", err
+	}
+	defer removeContainer(client, container.ID)
+	if err := startContainer(client, container.ID); err != nil {
+		return "", err
+	}
+	if _, err := waitContainer(client, container.ID); err != nil {
+		return "", err
+	}
+	logs, err := getContainerLogs(config.DockerEndpoint, container.ID, DefaultOutputLimits, nil)
+	return logs.Stdout, err
+}
+
+// compareVersions compares dotted version numbers numerically.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,233 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const detachedMountPath = "/libcmd-detached"
+
+var (
+	ErrDetachedNotFound = errors.New("detached run not found")
+	ErrDetachedRunning  = errors.New("detached run still running")
+
+	// The script may write its own exit status to $LIBCMD_MARKER_FILE, for
+	// example right before it reboots the host. Otherwise the wrapper
+	// records the status when the script exits.
+	detachedWrapper = `"$@"; code=$?; [ -f "$LIBCMD_MARKER_FILE" ] || echo $code > "$LIBCMD_MARKER_FILE"`
+)
+
+type detachedRecord struct {
+	RunID       string
+	ContainerID string
+	Op          string
+	Args        []string
+	StartedAt   time.Time
+}
+
+// StartDetached starts a container command that is expected to outlive the
+// calling process or the docker daemon, for instance because it reboots the
+// host. The container is not removed when it exits; the result is collected
+// later with RecoverDetached. DetachDir must be a host path visible to both
+// this process and the docker daemon. The policy registered with SetPolicy
+// sees the run as one for an anonymous caller.
+func StartDetached(config CmdConfig, dockerClient *docker.Client, runID, op string, args ...string) error {
+	if err := CheckDockerBackend(config, dockerClient); err != nil {
+		return err
+	}
+	if !isContainerCommand(op) {
+		return ErrCommandNotFound
+	}
+	if err := CheckID(runID); err != nil {
+		return err
+	}
+	if err := CheckArgs(op, args); err != nil {
+		return err
+	}
+	if err := CheckPolicy(config, RunOptions{RunID: runID}, op, args); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DetachDir, 0700); err != nil {
+		return err
+	}
+	if err := chownForUser(config.DetachDir, config.User); err != nil {
+		return err
+	}
+
+	cmdParts := append([]string{"bash", "-c", detachedWrapper, "libcmd-detached"}, scriptCmdParts(config, op, args)...)
+	opts := ContainerSpec{
+		Op:    op,
+		RunID: runID,
+		Name:  "libcmd-detached-" + runID,
+		Config: &docker.Config{
+			Image:  fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag),
+			Cmd:    cmdParts,
+			Env:    containerEnv(config, []string{"LIBCMD_MARKER_FILE=" + detachedMountPath + "/" + runID + ".exit"}),
+			User:   config.User,
+			Labels: map[string]string{detachedLabel: "true"},
+		},
+		HostConfig: newHostConfig(config),
+	}
+	opts.HostConfig.Binds = []string{config.DetachDir + ":" + detachedMountPath}
+	def, _ := lookupCommand(op)
+	network := networkOptions(def, RunOptions{})
+	applyNetwork(opts.HostConfig, network)
+	opts.Network = network
+	if err := applySecurity(opts.HostConfig, op, securityProfile(def, RunOptions{})); err != nil {
+		return err
+	}
+	if err := applyPrivileged(opts.HostConfig, op, privilegedOptions(def, RunOptions{})); err != nil {
+		return err
+	}
+	if err := applyOCIRuntime(opts.HostConfig, config, def, RunOptions{}); err != nil {
+		return err
+	}
+	container, err := createContainerFromOptions(config, opts)
+	if err != nil {
+		return err
+	}
+	// The container outlives this process, so Shutdown must not remove it.
+	trackContainer(container.ID, false)
+
+	record := detachedRecord{
+		RunID:       runID,
+		ContainerID: container.ID,
+		Op:          op,
+		Args:        args,
+		StartedAt:   time.Now(),
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		removeContainer(dockerClient, container.ID)
+		return err
+	}
+	if err := ioutil.WriteFile(detachedPath(config, runID, ".json"), b, 0600); err != nil {
+		removeContainer(dockerClient, container.ID)
+		return err
+	}
+
+	return startContainer(dockerClient, container.ID)
+}
+
+// RecoverDetached reconstructs the result of a detached run from its exit
+// marker and the container logs, then removes the container. It returns
+// ErrDetachedRunning while the container is running, and ErrInvalidID for
+// run IDs that StartDetached would have refused. The recovered run passes
+// through the OnFinished hooks.
+func RecoverDetached(config CmdConfig, dockerClient *docker.Client, runID string) ([]string, error) {
+	if err := CheckDockerBackend(config, dockerClient); err != nil {
+		return nil, err
+	}
+	if err := CheckID(runID); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(detachedPath(config, runID, ".json"))
+	if os.IsNotExist(err) {
+		return nil, ErrDetachedNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var record detachedRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, err
+	}
+
+	// The marker is written just before the script exits, so the container
+	// may still be running, and its logs incomplete, when it is found.
+	cntr, err := dockerClient.InspectContainer(record.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	if cntr.State.Running {
+		return nil, ErrDetachedRunning
+	}
+
+	// Without a marker the script was interrupted before it exited, so fall
+	// back to the exit status docker recorded for the container.
+	exitCode := cntr.State.ExitCode
+	marker, err := ioutil.ReadFile(detachedPath(config, runID, ".exit"))
+	if err == nil {
+		exitCode, err = strconv.Atoi(strings.TrimSpace(string(marker)))
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	logs, err := getContainerLogs(config.DockerEndpoint, record.ContainerID, DefaultOutputLimits, nil)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := logs.Stdout, logs.Stderr
+
+	removeContainer(dockerClient, record.ContainerID)
+	os.Remove(detachedPath(config, runID, ".exit"))
+	os.Remove(detachedPath(config, runID, ".json"))
+
+	output, err := []string{strings.TrimSpace(stdout)}, error(nil)
+	if exitCode != 0 {
+		output, err = []string{strings.TrimSpace(stderr)}, ErrCommandResponse
+	}
+	recordDetached(config, record, cntr, exitCode, logs, output, err)
+	return output, err
+}
+
+// recordDetached passes the recovered run through the OnFinished hooks, so
+// that detached runs are audited once their result is known.
+func recordDetached(config CmdConfig, record detachedRecord, cntr *docker.Container, exitCode int, logs Output, output []string, err error) {
+	rc := newRunContext(record.Op, record.Args, config, RunOptions{RunID: record.RunID})
+	rc.Start = record.StartedAt
+	rc.ContainerID, rc.ImageID, rc.ExitCode = record.ContainerID, cntr.Image, exitCode
+	if cntr.Config != nil {
+		rc.Image = cntr.Config.Image
+	}
+	rc.captureStreams(logs)
+	rc.Output, rc.Err = output, err
+	if !cntr.State.FinishedAt.IsZero() {
+		rc.Duration = cntr.State.FinishedAt.Sub(record.StartedAt)
+	}
+	runFinishedHooks(rc)
+}
+
+// ListDetached returns the run IDs of detached runs that have not been
+// recovered yet.
+func ListDetached(config CmdConfig) ([]string, error) {
+	files, err := ioutil.ReadDir(config.DetachDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	runIDs := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			runIDs = append(runIDs, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return runIDs, nil
+}
+
+func detachedPath(config CmdConfig, runID, ext string) string {
+	return filepath.Join(config.DetachDir, runID+ext)
+}
+
+// chownForUser gives a numeric "uid[:gid]" user ownership of dir so that
+// the command can write its exit marker. Users given by name cannot be
+// resolved on the host, and the directory must be made writable for them.
+func chownForUser(dir, user string) error {
+	uid, gid, ok := numericUser(user)
+	if !ok {
+		return nil
+	}
+	return os.Chown(dir, uid, gid)
+}
@@ -0,0 +1,123 @@
+package command
+
+import (
+	"errors"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrRuntimeNotFound = errors.New("container runtime not found on docker daemon")
+)
+
+// DeviceRequest asks the daemon for devices from a driver, such as NVIDIA
+// GPUs. It requires a daemon with API version 1.40 or later.
+type DeviceRequest struct {
+	Driver string `json:",omitempty"`
+	// Count of -1 requests every device. DeviceIDs selects devices
+	// instead.
+	Count        int        `json:",omitempty"`
+	DeviceIDs    []string   `json:",omitempty"`
+	Capabilities [][]string `json:",omitempty"`
+	Options      map[string]string
+}
+
+// GPURequest requests count NVIDIA GPUs, or all of them when count is -1.
+func GPURequest(count int) DeviceRequest {
+	return DeviceRequest{
+		Driver:       "nvidia",
+		Count:        count,
+		Capabilities: [][]string{{"gpu"}},
+		Options:      map[string]string{},
+	}
+}
+
+// DeviceOptions passes host devices through to the command container.
+type DeviceOptions struct {
+	// Devices are host device nodes mapped into the container.
+	Devices []docker.Device
+	// Requests are devices allocated by a driver. Requests with the nvidia
+	// driver require the nvidia runtime on the daemon.
+	Requests []DeviceRequest
+	// Runtime runs the container with a runtime other than the daemon's
+	// default, such as "nvidia".
+	Runtime string
+}
+
+// requiredRuntimes returns the runtimes that must be registered on the
+// daemon for the devices to be available.
+func (d *DeviceOptions) requiredRuntimes() []string {
+	var runtimes []string
+	if d.Runtime != "" {
+		runtimes = append(runtimes, d.Runtime)
+	}
+	for _, request := range d.Requests {
+		if request.Driver == "nvidia" && d.Runtime != "nvidia" {
+			runtimes = append(runtimes, "nvidia")
+			break
+		}
+	}
+	return runtimes
+}
+
+// CheckDevices returns ErrRuntimeNotFound if a runtime required by devices
+// is not registered on the daemon.
+func CheckDevices(config CmdConfig, devices DeviceOptions) error {
+	return CheckRuntimes(config, devices.requiredRuntimes())
+}
+
+// CheckRuntimes returns ErrRuntimeNotFound if any of the OCI runtimes is not
+// registered on the daemon.
+func CheckRuntimes(config CmdConfig, required []string) error {
+	if len(required) == 0 {
+		return nil
+	}
+	log.Debugf("checking docker runtimes %v", required)
+	var info struct {
+		Runtimes map[string]interface{}
+	}
+	if _, err := doJSON("GET", config.DockerEndpoint, "/info", nil, &info); err != nil {
+		log.Errorf(" -> error getting docker info: %s", err)
+		return err
+	}
+	for _, runtime := range required {
+		if _, exists := info.Runtimes[runtime]; !exists {
+			log.Errorf(" -> docker runtime %s not found", runtime)
+			return ErrRuntimeNotFound
+		}
+	}
+	log.Debugf(" -> docker runtimes found")
+	return nil
+}
+
+// requested describes the devices for checkPrivileged.
+func (d *DeviceOptions) requested() []string {
+	var requested []string
+	for _, device := range d.Devices {
+		requested = append(requested, "device "+device.PathOnHost)
+	}
+	for _, request := range d.Requests {
+		requested = append(requested, "device request "+request.Driver)
+	}
+	if d.Runtime != "" {
+		requested = append(requested, "runtime "+d.Runtime)
+	}
+	return requested
+}
+
+func applyDevices(hostConfig *HostConfig, op string, devices *DeviceOptions) error {
+	if devices == nil {
+		return nil
+	}
+	if err := checkPrivileged(op, devices.requested()); err != nil {
+		return err
+	}
+	hostConfig.Devices = append(hostConfig.Devices, devices.Devices...)
+	hostConfig.DeviceRequests = append(hostConfig.DeviceRequests, devices.Requests...)
+	if devices.Runtime != "" {
+		hostConfig.Runtime = devices.Runtime
+	}
+	return nil
+}
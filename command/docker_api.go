@@ -0,0 +1,116 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// sendRequest sends a request straight to the docker API, for endpoints and
+// fields the vendored client does not support. The returned function must be
+// called once the response body has been read.
+func sendRequest(method, endpoint, path string, body io.Reader) (*http.Response, func(), error) {
+	return sendTypedRequest(method, endpoint, path, "application/json", body)
+}
+
+// sendTypedRequest is sendRequest with a body of another content type,
+// such as a tar archive.
+func sendTypedRequest(method, endpoint, path, contentType string, body io.Reader) (*http.Response, func(), error) {
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", "go-dockerclient")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, nil, docker.ErrInvalidEndpoint
+	}
+	protocol := u.Scheme
+	address := u.Path
+	if protocol == "unix" || protocol == "npipe" {
+		var dial net.Conn
+		if protocol == "npipe" {
+			dial, err = dialPipe(pipePath(u))
+		} else {
+			dial, err = net.Dial(protocol, address)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		clientconn := httputil.NewClientConn(dial, nil)
+		resp, err := clientconn.Do(req)
+		if err != nil {
+			clientconn.Close()
+			dial.Close()
+			return nil, nil, err
+		}
+		return resp, func() {
+			resp.Body.Close()
+			clientconn.Close()
+			dial.Close()
+		}, nil
+	}
+
+	if protocol == "tcp" {
+		protocol = "http"
+	}
+	httpClient := http.DefaultClient
+	if client := tlsHTTPClient(endpoint); client != nil {
+		protocol, httpClient = "https", client
+	}
+	req.URL, err = url.Parse(protocol + "://" + u.Host + path)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "connection refused") {
+			return nil, nil, docker.ErrConnectionRefused
+		}
+		return nil, nil, err
+	}
+	return resp, func() { resp.Body.Close() }, nil
+}
+
+// doJSON sends in, if not nil, as the JSON request body and decodes the
+// response into out, if not nil. Responses with an error status are returned
+// as a *docker.Error.
+func doJSON(method, endpoint, path string, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return -1, err
+		}
+		body = bytes.NewReader(b)
+	}
+	resp, closeFn, err := sendRequest(method, endpoint, path, body)
+	if err != nil {
+		return -1, err
+	}
+	defer closeFn()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return resp.StatusCode, &docker.Error{Status: resp.StatusCode, Message: string(b)}
+	}
+	if out != nil && len(b) > 0 {
+		if err := json.Unmarshal(b, out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,157 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// DockerEnvironment is where the docker daemon is found when no endpoint
+// is configured, as the docker CLI would find it.
+type DockerEnvironment struct {
+	Endpoint string
+	// TLSCertPath is a directory with ca.pem, cert.pem and key.pem. Empty
+	// connects without TLS.
+	TLSCertPath string
+	TLSVerify   bool
+	// Source describes where Endpoint came from, such as "DOCKER_HOST" or
+	// "context desktop-linux".
+	Source string
+}
+
+// ResolveDockerEnvironment looks for the daemon in, in order: DOCKER_HOST
+// with DOCKER_TLS_VERIFY and DOCKER_CERT_PATH; the docker CLI context named
+// by DOCKER_CONTEXT or the CLI config; the docker socket; the Docker Desktop
+// user sockets; the Podman sockets. On windows it defaults to the docker
+// engine named pipe.
+func ResolveDockerEnvironment() DockerEnvironment {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		env := DockerEnvironment{Endpoint: host, Source: "DOCKER_HOST"}
+		if os.Getenv("DOCKER_TLS_VERIFY") != "" {
+			env.TLSCertPath = os.Getenv("DOCKER_CERT_PATH")
+			if env.TLSCertPath == "" {
+				env.TLSCertPath = dockerConfigDir()
+			}
+			env.TLSVerify = true
+		}
+		return env
+	}
+	if env, ok := contextEnvironment(); ok {
+		return env
+	}
+	if runtime.GOOS == "windows" {
+		return DockerEnvironment{Endpoint: "npipe:////./pipe/docker_engine", Source: "default"}
+	}
+	if _, err := os.Stat("/var/run/docker.sock"); err == nil {
+		return DockerEnvironment{Endpoint: "unix:///var/run/docker.sock", Source: "default"}
+	}
+	for _, socket := range []string{
+		// Docker Desktop on macOS, then on linux.
+		filepath.Join(homeDir(), ".docker", "run", "docker.sock"),
+		filepath.Join(homeDir(), ".docker", "desktop", "docker.sock"),
+	} {
+		if _, err := os.Stat(socket); err == nil {
+			return DockerEnvironment{Endpoint: "unix://" + socket, Source: "docker desktop"}
+		}
+	}
+	if podman := PodmanSocket(); podman != "" {
+		return DockerEnvironment{Endpoint: podman, Source: "podman"}
+	}
+	return DockerEnvironment{Endpoint: "unix:///var/run/docker.sock", Source: "default"}
+}
+
+// contextEnvironment reads the current docker CLI context. The default
+// context has no metadata and falls through to the sockets.
+func contextEnvironment() (DockerEnvironment, bool) {
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		var cliConfig struct {
+			CurrentContext string `json:"currentContext"`
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+		if err != nil || json.Unmarshal(b, &cliConfig) != nil {
+			return DockerEnvironment{}, false
+		}
+		name = cliConfig.CurrentContext
+	}
+	if name == "" || name == "default" {
+		return DockerEnvironment{}, false
+	}
+
+	// The CLI stores each context under the hex SHA-256 of its name.
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dockerConfigDir(), "contexts", "meta", id, "meta.json"))
+	if err != nil || json.Unmarshal(b, &meta) != nil {
+		return DockerEnvironment{}, false
+	}
+	endpoint, exists := meta.Endpoints["docker"]
+	if !exists || endpoint.Host == "" {
+		return DockerEnvironment{}, false
+	}
+	env := DockerEnvironment{Endpoint: endpoint.Host, Source: "context " + name}
+	tlsDir := filepath.Join(dockerConfigDir(), "contexts", "tls", id, "docker")
+	if _, err := os.Stat(filepath.Join(tlsDir, "cert.pem")); err == nil {
+		env.TLSCertPath = tlsDir
+		env.TLSVerify = !endpoint.SkipTLSVerify
+	}
+	return env, true
+}
+
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	return filepath.Join(homeDir(), ".docker")
+}
+
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	return os.Getenv("USERPROFILE")
+}
+
+var (
+	tlsClientsMu sync.RWMutex
+	tlsClients   = map[string]*http.Client{}
+)
+
+// newTLSClient connects with the certificates in config.TLSCertPath, and
+// registers them for direct API requests to the endpoint. Without
+// config.TLSVerify the daemon's certificate is not checked.
+func newTLSClient(config CmdConfig) (*docker.Client, error) {
+	ca := filepath.Join(config.TLSCertPath, "ca.pem")
+	if config.TLSVerify != "true" {
+		ca = ""
+	}
+	client, err := docker.NewTLSClient(config.DockerEndpoint,
+		filepath.Join(config.TLSCertPath, "cert.pem"), filepath.Join(config.TLSCertPath, "key.pem"), ca)
+	if err != nil {
+		return nil, err
+	}
+	tlsClientsMu.Lock()
+	tlsClients[config.DockerEndpoint] = client.HTTPClient
+	tlsClientsMu.Unlock()
+	return client, nil
+}
+
+func tlsHTTPClient(endpoint string) *http.Client {
+	tlsClientsMu.RLock()
+	defer tlsClientsMu.RUnlock()
+	return tlsClients[endpoint]
+}
@@ -0,0 +1,115 @@
+package command
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type fakeContainer struct {
+	Id      string
+	Created int64
+	State   string
+	Labels  map[string]string
+}
+
+// fakeDocker serves the parts of the docker API that Reap, EnsureImage,
+// PruneImages and script injection use, from its containers and images,
+// which map references to sizes.
+type fakeDocker struct {
+	mu         sync.Mutex
+	server     *httptest.Server
+	containers []fakeContainer
+	images     map[string]int64
+	pulled     []string
+	// archives are the containers files were copied into.
+	archives []string
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+/`)
+
+func newFakeDocker(t *testing.T) (*fakeDocker, CmdConfig, *docker.Client) {
+	d := &fakeDocker{images: map[string]int64{}}
+	d.server = httptest.NewServer(http.HandlerFunc(d.serve))
+	endpoint := "tcp://" + d.server.Listener.Addr().String()
+	client, err := docker.NewClient(endpoint)
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	config := CmdConfig{
+		DockerEndpoint:      endpoint,
+		ContainerRepository: "libcmd-test",
+		ContainerTag:        "latest",
+		Owner:               "libcmd-test",
+	}
+	return d, config, client
+}
+
+func (d *fakeDocker) Close() {
+	d.server.Close()
+}
+
+func (d *fakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := apiVersionPrefix.ReplaceAllString(r.URL.Path, "/")
+	switch {
+	case path == "/version":
+		json.NewEncoder(w).Encode(map[string]string{"ApiVersion": "1.24"})
+	case r.Method == "GET" && path == "/containers/json":
+		json.NewEncoder(w).Encode(d.containers)
+	case r.Method == "PUT" && strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/archive"):
+		d.archives = append(d.archives, strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/archive"))
+	case strings.HasPrefix(path, "/containers/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+		i := d.container(id)
+		if i < 0 {
+			http.Error(w, "no such container", http.StatusNotFound)
+		} else if r.Method == "DELETE" {
+			d.containers = append(d.containers[:i], d.containers[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			json.NewEncoder(w).Encode(map[string]interface{}{"Id": id, "State": map[string]bool{"Running": d.containers[i].State == "running"}})
+		}
+	case r.Method == "POST" && path == "/images/create":
+		ref := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+		d.images[ref] = 1
+		d.pulled = append(d.pulled, ref)
+		json.NewEncoder(w).Encode(map[string]string{"status": "Downloaded newer image for " + ref})
+	case strings.HasPrefix(path, "/images/"):
+		ref := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+		size, exists := d.images[ref]
+		if !exists {
+			http.Error(w, "no such image", http.StatusNotFound)
+		} else if r.Method == "DELETE" {
+			delete(d.images, ref)
+			json.NewEncoder(w).Encode([]map[string]string{{"Untagged": ref}})
+		} else {
+			json.NewEncoder(w).Encode(map[string]interface{}{"Id": "sha256:" + ref, "Size": size})
+		}
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (d *fakeDocker) container(id string) int {
+	for i, c := range d.containers {
+		if c.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *fakeDocker) hasImage(ref string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, exists := d.images[ref]
+	return exists
+}
@@ -0,0 +1,143 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// checkExecOptions returns ErrNotSupportedByRuntime for the options of a run
+// that need a container created for the run, since a run with exec shares
+// a container that is already running.
+func checkExecOptions(opts RunOptions) error {
+	if len(opts.SecretFiles) > 0 || len(opts.Env) > 0 || opts.User != "" || opts.Image != "" || opts.ImageID != "" ||
+		opts.Network != nil || opts.ReadOnly != nil || opts.Security != nil || opts.Devices != nil ||
+		opts.Privileged != nil || opts.OCIRuntime != "" || len(opts.Ulimits) > 0 || opts.PidsLimit != 0 ||
+		len(opts.MountParams) > 0 || len(opts.Volumes) > 0 || opts.WorkingDir != "" || opts.Entrypoint != nil {
+		return ErrNotSupportedByRuntime
+	}
+	return nil
+}
+
+// runExec runs the command inside an already running container rather than
+// creating a new container for each run.
+func runExec(rt Runtime, containerID string, cmdParts []string, limits OutputLimits) ([]string, error) {
+	return runExecContext(nil, rt, containerID, cmdParts, limits)
+}
+
+// runExecContext is runExec for a run, whose exit code and streams are kept
+// in rc.
+func runExecContext(rc *RunContext, rt Runtime, containerID string, cmdParts []string, limits OutputLimits) ([]string, error) {
+	output, exitCode, err := rt.Exec(containerID, cmdParts, limits)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := output.Stdout, output.Stderr
+	if rc != nil {
+		rc.ExitCode = exitCode
+		rc.captureStreams(output)
+	}
+
+	if exitCode == 0 {
+		return []string{strings.TrimSpace(stdout)}, nil
+	}
+
+	return []string{strings.TrimSpace(stderr)}, ErrCommandResponse
+}
+
+func CheckExecContainer(client *docker.Client, containerID string) error {
+	log.Debugf("inspecting exec container %s", containerID)
+	cntr, err := client.InspectContainer(containerID)
+	if err != nil {
+		log.Errorf(" -> error inspecting exec container %s: %s", containerID, err)
+		return err
+	}
+	if !cntr.State.Running {
+		log.Errorf(" -> exec container %s is not running", containerID)
+		return fmt.Errorf("exec container %s is not running", containerID)
+	}
+	log.Debugf(" -> exec container %s is running", containerID)
+	return nil
+}
+
+func createExec(client *docker.Client, containerID string, cmdParts []string) (*docker.Exec, error) {
+	log.Debugf("creating exec in container %s", containerID)
+	opts := docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmdParts,
+		Container:    containerID,
+	}
+	exec, err := client.CreateExec(opts)
+	if err != nil {
+		log.Errorf(" -> error creating exec in container %s: %s", containerID, err)
+		return nil, err
+	}
+	log.Debugf(" -> exec %s created in container %s", exec.ID, containerID)
+	return exec, nil
+}
+
+func startExec(client *docker.Client, execID string, limits OutputLimits) (Output, error) {
+	log.Debugf("starting exec %s", execID)
+	stdoutBuffer := newLimitedBuffer(limits.MaxStdout, limits.Strategy)
+	stderrBuffer := newLimitedBuffer(limits.MaxStderr, limits.Strategy)
+	opts := docker.StartExecOptions{
+		OutputStream: stdoutBuffer,
+		ErrorStream:  stderrBuffer,
+	}
+	if err := client.StartExec(execID, opts); err != nil {
+		log.Errorf(" -> error starting exec %s: %s", execID, err)
+		return Output{}, err
+	}
+	log.Debugf(" -> exec %s complete", execID)
+	return limitedOutput(stdoutBuffer, stderrBuffer), nil
+}
+
+func getExecExitCode(client *docker.Client, execID string) (int, error) {
+	log.Debugf("inspecting exec %s", execID)
+	exec, err := client.InspectExec(execID)
+	if err != nil {
+		log.Errorf(" -> error inspecting exec %s: %s", execID, err)
+		return -1, err
+	}
+	log.Debugf(" -> exec %s inspect success", execID)
+	return exec.ExitCode, nil
+}
+
+// runExecWithInput runs cmdParts in the container with input as its stdin
+// and fails unless it exits zero.
+func runExecWithInput(client *docker.Client, containerID string, cmdParts []string, input io.Reader) error {
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		AttachStdin:  input != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmdParts,
+		Container:    containerID,
+	})
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	opts := docker.StartExecOptions{
+		InputStream:  input,
+		OutputStream: ioutil.Discard,
+		ErrorStream:  &stderr,
+	}
+	if err := client.StartExec(exec.ID, opts); err != nil {
+		return err
+	}
+	exitCode, err := getExecExitCode(client, exec.ID)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exec exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package command
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrPoolDrained = errors.New("exec pool drained")
+)
+
+// ExecPool keeps warm command containers and runs each command with exec in
+// one of them, so that runs skip container creation. A container serves one
+// run at a time.
+type ExecPool struct {
+	config       CmdConfig
+	dockerClient *docker.Client
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	size       int
+	idle       []*pooledContainer
+	busy       int
+	generation int
+	drained    bool
+	hits       int64
+	misses     int64
+	recycled   int64
+}
+
+type pooledContainer struct {
+	session    *Session
+	created    time.Time
+	generation int
+}
+
+// ExecPoolStats describes the pool. Hits are runs that found a warm
+// container, misses runs that had to create one.
+type ExecPoolStats struct {
+	Size     int
+	Idle     int
+	Busy     int
+	Hits     int64
+	Misses   int64
+	Recycled int64
+	// IdleAges are the ages of the idle containers, oldest first.
+	IdleAges []time.Duration
+}
+
+// NewExecPool starts size warm containers.
+func NewExecPool(config CmdConfig, dockerClient *docker.Client, size int) (*ExecPool, error) {
+	if err := CheckDockerBackend(config, dockerClient); err != nil {
+		return nil, err
+	}
+	p := &ExecPool{config: config, dockerClient: dockerClient}
+	p.cond = sync.NewCond(&p.mu)
+	if err := p.Resize(size); err != nil {
+		p.Drain()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *ExecPool) Run(op string, args ...string) ([]string, error) {
+	return p.RunWithOptions(RunOptions{}, op, args...)
+}
+
+// RunWithOptions runs op in a warm container, as Session.RunWithOptions
+// does.
+func (p *ExecPool) RunWithOptions(opts RunOptions, op string, args ...string) ([]string, error) {
+	if !isContainerCommand(op) {
+		return nil, ErrCommandNotFound
+	}
+	pc, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	output, err := pc.session.RunWithOptions(opts, op, args...)
+	p.release(pc)
+	return output, err
+}
+
+func (p *ExecPool) acquire() (*pooledContainer, error) {
+	p.mu.Lock()
+	if p.drained {
+		p.mu.Unlock()
+		return nil, ErrPoolDrained
+	}
+	p.busy++
+	if n := len(p.idle); n > 0 {
+		pc := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.hits++
+		p.mu.Unlock()
+		return pc, nil
+	}
+	p.misses++
+	generation := p.generation
+	p.mu.Unlock()
+
+	pc, err := p.newContainer(generation)
+	if err != nil {
+		p.mu.Lock()
+		p.busy--
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		return nil, err
+	}
+	return pc, nil
+}
+
+func (p *ExecPool) release(pc *pooledContainer) {
+	p.mu.Lock()
+	p.busy--
+	keep := p.putIdle(pc)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	if !keep {
+		pc.session.Close()
+	}
+}
+
+// putIdle adds pc to the idle containers unless the pool is full, drained,
+// or has been recycled since pc was created. It must be called with p.mu
+// held.
+func (p *ExecPool) putIdle(pc *pooledContainer) bool {
+	if p.drained || pc.generation != p.generation || len(p.idle)+p.busy >= p.size {
+		return false
+	}
+	p.idle = append(p.idle, pc)
+	return true
+}
+
+func (p *ExecPool) newContainer(generation int) (*pooledContainer, error) {
+	session, err := NewSession(p.config, p.dockerClient, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledContainer{session: session, created: time.Now(), generation: generation}, nil
+}
+
+// Resize sets the number of warm containers, starting or removing idle
+// containers to match. Containers in use are removed when their run
+// finishes if the pool is over size.
+func (p *ExecPool) Resize(size int) error {
+	p.mu.Lock()
+	if p.drained {
+		p.mu.Unlock()
+		return ErrPoolDrained
+	}
+	p.size = size
+	var extra []*pooledContainer
+	for len(p.idle)+p.busy > p.size && len(p.idle) > 0 {
+		extra = append(extra, p.idle[0])
+		p.idle = p.idle[1:]
+	}
+	missing := p.size - len(p.idle) - p.busy
+	generation := p.generation
+	p.mu.Unlock()
+
+	for _, pc := range extra {
+		pc.session.Close()
+	}
+	log.Debugf("resizing exec pool to %d, starting %d containers", size, missing)
+	for i := 0; i < missing; i++ {
+		pc, err := p.newContainer(generation)
+		if err != nil {
+			log.Errorf(" -> error starting exec pool container: %s", err)
+			return err
+		}
+		p.mu.Lock()
+		keep := p.putIdle(pc)
+		p.mu.Unlock()
+		if !keep {
+			pc.session.Close()
+		}
+	}
+	return nil
+}
+
+// RecycleAll replaces the idle containers with fresh ones, for example
+// after the command image was updated. Containers in use are removed when
+// their run finishes.
+func (p *ExecPool) RecycleAll() error {
+	p.mu.Lock()
+	if p.drained {
+		p.mu.Unlock()
+		return ErrPoolDrained
+	}
+	p.generation++
+	old := p.idle
+	p.idle = nil
+	p.recycled += int64(len(old))
+	size := p.size
+	p.mu.Unlock()
+
+	for _, pc := range old {
+		pc.session.Close()
+	}
+	return p.Resize(size)
+}
+
+// Drain stops the pool from taking new runs, waits for the runs in flight
+// and removes every container. It is safe to call more than once.
+func (p *ExecPool) Drain() {
+	p.mu.Lock()
+	p.drained = true
+	for p.busy > 0 {
+		p.cond.Wait()
+	}
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, pc := range idle {
+		pc.session.Close()
+	}
+}
+
+func (p *ExecPool) Stats() ExecPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := ExecPoolStats{
+		Size:     p.size,
+		Idle:     len(p.idle),
+		Busy:     p.busy,
+		Hits:     p.hits,
+		Misses:   p.misses,
+		Recycled: p.recycled,
+	}
+	now := time.Now()
+	for _, pc := range p.idle {
+		stats.IdleAges = append(stats.IdleAges, now.Sub(pc.created))
+	}
+	return stats
+}
@@ -0,0 +1,132 @@
+package command
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrExecutionFinished = errors.New("execution has finished")
+	ErrSignalUnsupported = errors.New("signal not supported")
+)
+
+// SignalRuntime is implemented by runtimes that can deliver signals other
+// than SIGKILL to a running container.
+type SignalRuntime interface {
+	Signal(id string, sig os.Signal) error
+}
+
+// Execution is a run started with Start.
+type Execution struct {
+	RunID string
+
+	signals chan signalRequest
+	done    chan struct{}
+	output  []string
+	err     error
+}
+
+type signalRequest struct {
+	sig   os.Signal
+	errCh chan error
+}
+
+// Start runs op as Run does without waiting for it to finish.
+func Start(op string, config CmdConfig, dockerClient *docker.Client, opts RunOptions, args ...string) *Execution {
+	e := &Execution{
+		signals: make(chan signalRequest),
+		done:    make(chan struct{}),
+	}
+	if opts.RunID == "" {
+		var err error
+		if opts.RunID, err = NewID(); err != nil {
+			e.err = err
+			close(e.done)
+			return e
+		}
+	}
+	e.RunID = opts.RunID
+	opts.signals = e.signals
+	go func() {
+		defer close(e.done)
+		e.output, e.err = Run(op, config, dockerClient, opts, args...)
+	}()
+	return e
+}
+
+// Signal forwards sig, such as syscall.SIGHUP for scripts that reload on
+// it, to the main process of the command. It waits until the command is
+// running and returns ErrExecutionFinished if it exits first. Go commands
+// and commands run with exec cannot be signaled and return
+// ErrSignalUnsupported.
+func (e *Execution) Signal(sig os.Signal) error {
+	req := signalRequest{sig, make(chan error, 1)}
+	select {
+	case e.signals <- req:
+		return <-req.errCh
+	case <-e.done:
+		return ErrExecutionFinished
+	}
+}
+
+// Done is closed when the run has finished.
+func (e *Execution) Done() <-chan struct{} {
+	return e.done
+}
+
+// Wait waits for the run to finish and returns what Run would have.
+func (e *Execution) Wait() ([]string, error) {
+	<-e.done
+	return e.output, e.err
+}
+
+func (r *dockerRuntime) Signal(id string, sig os.Signal) error {
+	return signalContainer(r.client, id, sig)
+}
+
+func signalContainer(client *docker.Client, containerID string, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return ErrSignalUnsupported
+	}
+	log.Debugf("sending signal %s to container %s", sig, containerID)
+	if err := client.KillContainer(docker.KillContainerOptions{ID: containerID, Signal: docker.Signal(s)}); err != nil {
+		log.Errorf(" -> error sending signal %s to container %s: %s", sig, containerID, err)
+		return err
+	}
+	log.Debugf(" -> signal %s sent to container %s", sig, containerID)
+	return nil
+}
+
+// rejectSignals answers signal requests with ErrSignalUnsupported, for
+// runs that have no process to signal, until the returned function is
+// called.
+func rejectSignals(signals <-chan signalRequest) func() {
+	stop := make(chan struct{})
+	if signals != nil {
+		go func() {
+			for {
+				select {
+				case req := <-signals:
+					req.errCh <- ErrSignalUnsupported
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	return func() { close(stop) }
+}
+
+// signalRuntime delivers sig to the container with rt, if it can.
+func signalRuntime(rt Runtime, containerID string, sig os.Signal) error {
+	if signaler, ok := rt.(SignalRuntime); ok {
+		return signaler.Signal(containerID, sig)
+	}
+	return ErrSignalUnsupported
+}
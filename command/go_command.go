@@ -47,9 +47,15 @@ type goCmd struct {
 	fn           goCommandFunc
 	config       CmdConfig
 	dockerClient *docker.Client
+	opts         RunOptions
 }
 
 func (c *goCmd) Run(args ...string) ([]string, error) {
+	return c.RunWithOptions(RunOptions{}, args...)
+}
+
+func (c *goCmd) RunWithOptions(opts RunOptions, args ...string) ([]string, error) {
+	c.opts = opts
 	return c.fn(c, args...)
 }
 
@@ -58,7 +64,7 @@ func NewGoCmd(op string, config CmdConfig, dockerClient *docker.Client) (*goCmd,
 	if !exists {
 		return nil, ErrCommandNotFound
 	}
-	return &goCmd{fn, config, dockerClient}, nil
+	return &goCmd{fn: fn, config: config, dockerClient: dockerClient}, nil
 }
 
 func certCommand(c *goCmd, args ...string) ([]string, error) {
@@ -66,7 +72,7 @@ func certCommand(c *goCmd, args ...string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	result, err := cmd.Run(args...)
+	result, err := cmd.RunWithOptions(c.opts, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -268,7 +274,7 @@ func resolveHostCommand(c *goCmd, args ...string) ([]string, error) {
 }
 
 func tcpPortAccept(c *goCmd, args ...string) ([]string, error) {
-	_, err := net.Dial("tcp", fmt.Sprintf("%s:%s", args[0], args[1]))
+	_, err := net.Dial("tcp", net.JoinHostPort(args[0], args[1]))
 	if err != nil {
 		return []string{strconv.FormatBool(false)}, nil
 	}
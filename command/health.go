@@ -0,0 +1,133 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// DaemonReconnectTimeout is how long a run waits for the docker daemon
+	// to come back after losing its connection before the run fails.
+	DaemonReconnectTimeout = 30 * time.Second
+)
+
+// Ping checks that the docker daemon at config.DockerEndpoint responds.
+func Ping(config CmdConfig) error {
+	resp, closeFn, err := sendRequest("GET", config.DockerEndpoint, "/_ping", nil)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	if resp.StatusCode != 200 {
+		return &docker.Error{Status: resp.StatusCode, Message: fmt.Sprintf("ping returned %d", resp.StatusCode)}
+	}
+	return nil
+}
+
+// isConnectionError reports whether err means the daemon could not be
+// reached or dropped the connection, as it does while restarting.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == docker.ErrConnectionRefused || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "broken pipe")
+}
+
+// waitForDaemon polls ping until it succeeds or DaemonReconnectTimeout
+// passes.
+func waitForDaemon(ping func() error) error {
+	deadline := time.Now().Add(DaemonReconnectTimeout)
+	for {
+		err := ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(DaemonRetryBackoff)
+	}
+}
+
+// HealthMonitor pings the docker daemon periodically and calls OnReconnect
+// when it responds again after failing, such as after a daemon restart.
+type HealthMonitor struct {
+	config      CmdConfig
+	onReconnect func()
+
+	mu      sync.Mutex
+	healthy bool
+	lastErr error
+	stopCh  chan bool
+	once    sync.Once
+}
+
+// StartHealthMonitor pings the daemon every interval until Stop is called.
+// onReconnect may be nil.
+func StartHealthMonitor(config CmdConfig, interval time.Duration, onReconnect func()) *HealthMonitor {
+	m := &HealthMonitor{
+		config:      config,
+		onReconnect: onReconnect,
+		healthy:     true,
+		stopCh:      make(chan bool),
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.check()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *HealthMonitor) check() {
+	err := Ping(m.config)
+	m.mu.Lock()
+	wasHealthy := m.healthy
+	m.healthy = err == nil
+	m.lastErr = err
+	m.mu.Unlock()
+
+	if err != nil && wasHealthy {
+		log.Errorf("docker daemon is not responding: %s", err)
+	} else if err == nil && !wasHealthy {
+		log.Infof("docker daemon is responding again")
+		if m.onReconnect != nil {
+			m.onReconnect()
+		}
+	}
+}
+
+// Healthy returns whether the last ping succeeded, and its error if not.
+func (m *HealthMonitor) Healthy() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.healthy, m.lastErr
+}
+
+func (m *HealthMonitor) Stop() {
+	m.once.Do(func() { close(m.stopCh) })
+}
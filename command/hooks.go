@@ -0,0 +1,171 @@
+package command
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+// RunContext describes a run as it passes through the lifecycle hooks.
+// Hooks may modify Args and HostConfig in OnBeforeRun, and use Values to
+// pass data between phases.
+type RunContext struct {
+	RunID      string
+	Op         string
+	Args       []string
+	Config     CmdConfig
+	Options    RunOptions
+	HostConfig *HostConfig
+	Start      time.Time
+
+	// Set once the container exists. Image is the image it was created
+	// from, as repository:tag, repository@digest or an image ID. Go
+	// commands and local runs, which use no container, leave these empty,
+	// as do runs in an exec container that libcmd did not create.
+	ContainerID string
+	Image       string
+	ImageID     string
+	ExitCode    int
+	// DNSName is the per-run DNS name of the container, when its network
+	// options set DNSDomain.
+	DNSName string
+
+	// Set when the run has finished. Truncated is set when the output
+	// limits dropped any of stdout or stderr.
+	Output    []string
+	Truncated bool
+	// Stats is the resource usage of the container when
+	// RunOptions.CollectStats is set.
+	Stats *ResourceStats
+	// Stdout and Stderr are the streams of the command as they were read,
+	// before the output is trimmed. Go commands leave them empty.
+	Stdout string
+	Stderr string
+	// Transcript holds stdout and stderr in the order they were written
+	// when RunOptions.RecordTranscript is set. TranscriptTruncated is set
+	// when it stopped growing at the output limits of the run.
+	Transcript          []stdcopy.Chunk
+	TranscriptTruncated bool
+	// TranscriptParts counts the parts of the transcript handed to the
+	// OnTranscript hooks before Transcript, for sessions that are never
+	// truncated.
+	TranscriptParts int
+	Err             error
+	Duration        time.Duration
+
+	Values map[string]interface{}
+
+	captured bool
+	// flushTranscript records the end of the transcript held back while
+	// its secrets were masked.
+	flushTranscript func()
+	// uncappedTranscript spills the transcript to the OnTranscript hooks
+	// instead of truncating it.
+	uncappedTranscript bool
+	// traceCtx holds span, the root span of the run. See Tracer.
+	traceCtx context.Context
+	span     Span
+}
+
+func (rc *RunContext) captureStreams(output Output) {
+	rc.Stdout, rc.Stderr, rc.captured = output.Stdout, output.Stderr, true
+	rc.Truncated = output.Truncated()
+}
+
+// Hooks are called at each phase of a run. Any of the functions may be
+// nil. Returning an error from OnBeforeRun, OnContainerCreated or OnStarted
+// aborts the run with that error.
+//
+// OnTranscript receives the transcript of a session that must be recorded
+// in full, such as a break glass shell, in parts as it outgrows the output
+// limits. The last part is in RunContext.Transcript when the run finishes.
+// When no hook takes the parts, the whole transcript is kept in memory.
+type Hooks struct {
+	OnBeforeRun        func(rc *RunContext) error
+	OnContainerCreated func(rc *RunContext) error
+	OnStarted          func(rc *RunContext) error
+	OnTranscript       func(rc *RunContext, chunks []stdcopy.Chunk)
+	OnFinished         func(rc *RunContext)
+	OnError            func(rc *RunContext)
+}
+
+var (
+	hooksMu sync.RWMutex
+	hooks   []Hooks
+)
+
+// AddHooks registers h. Hooks are called in the order they were added.
+func AddHooks(h Hooks) {
+	hooksMu.Lock()
+	hooks = append(hooks, h)
+	hooksMu.Unlock()
+}
+
+func registeredHooks() []Hooks {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return hooks
+}
+
+func newRunContext(op string, args []string, config CmdConfig, opts RunOptions) *RunContext {
+	hostConfig := newHostConfig(config)
+	def, _ := lookupCommand(op)
+	applyNetwork(hostConfig, networkOptions(def, opts))
+	return &RunContext{
+		RunID:      opts.RunID,
+		Op:         op,
+		Args:       args,
+		Config:     config,
+		Options:    opts,
+		HostConfig: hostConfig,
+		Start:      time.Now(),
+		Values:     map[string]interface{}{},
+		traceCtx:   context.Background(),
+		span:       noopSpan{},
+	}
+}
+
+func runHooks(rc *RunContext, phase func(h Hooks) func(rc *RunContext) error) error {
+	for _, h := range registeredHooks() {
+		if fn := phase(h); fn != nil {
+			if err := fn(rc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func beforeRunHook(h Hooks) func(rc *RunContext) error        { return h.OnBeforeRun }
+func containerCreatedHook(h Hooks) func(rc *RunContext) error { return h.OnContainerCreated }
+func startedHook(h Hooks) func(rc *RunContext) error          { return h.OnStarted }
+
+// spillTranscript hands rc.Transcript to the OnTranscript hooks and
+// reports whether any took it.
+func spillTranscript(rc *RunContext) bool {
+	spilled := false
+	for _, h := range registeredHooks() {
+		if h.OnTranscript != nil {
+			h.OnTranscript(rc, rc.Transcript)
+			spilled = true
+		}
+	}
+	if spilled {
+		rc.TranscriptParts++
+		rc.Transcript = nil
+	}
+	return spilled
+}
+
+func runFinishedHooks(rc *RunContext) {
+	for _, h := range registeredHooks() {
+		if rc.Err != nil && h.OnError != nil {
+			h.OnError(rc)
+		}
+		if h.OnFinished != nil {
+			h.OnFinished(rc)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// HostConfig is the host configuration of a command container. It adds the
+// fields that the vendored docker.HostConfig predates.
+type HostConfig struct {
+	docker.HostConfig
+	// Tmpfs maps container paths to tmpfs mount options.
+	Tmpfs map[string]string `json:",omitempty"`
+	// UsernsMode set to "host" opts the container out of the daemon's
+	// user namespace remapping. It is only honored after
+	// SetAllowPrivileged(true).
+	UsernsMode string `json:",omitempty"`
+	// DeviceRequests and Runtime select devices and the runtime that
+	// provides them. See DeviceOptions.
+	DeviceRequests []DeviceRequest `json:",omitempty"`
+	Runtime        string          `json:",omitempty"`
+	Ulimits        []Ulimit        `json:",omitempty"`
+	PidsLimit      int64           `json:",omitempty"`
+}
+
+func newHostConfig(config CmdConfig) *HostConfig {
+	hostConfig := &HostConfig{
+		Tmpfs:      map[string]string{},
+		UsernsMode: config.UsernsMode,
+	}
+	hostConfig.DNS = splitList(config.DNS)
+	hostConfig.DNSSearch = splitList(config.DNSSearch)
+	hostConfig.ExtraHosts = splitList(config.ExtraHosts)
+	return hostConfig
+}
+
+// containerEnv returns the proxy settings of config followed by env, so
+// that env can override them.
+func containerEnv(config CmdConfig, env []string) []string {
+	containerEnv := []string{}
+	for _, proxy := range []struct{ name, value string }{
+		{"HTTP_PROXY", config.HTTPProxy},
+		{"HTTPS_PROXY", config.HTTPSProxy},
+		{"NO_PROXY", config.NoProxy},
+	} {
+		if proxy.value != "" {
+			containerEnv = append(containerEnv,
+				proxy.name+"="+proxy.value,
+				strings.ToLower(proxy.name)+"="+proxy.value)
+		}
+	}
+	return append(containerEnv, env...)
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
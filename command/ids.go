@@ -0,0 +1,67 @@
+package command
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sync"
+)
+
+var (
+	ErrInvalidID = errors.New("invalid id")
+
+	validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,127}$`)
+)
+
+// IDGenerator returns a new unique ID, such as a ULID or a snowflake. IDs
+// end up in container labels and file names, so they must pass CheckID.
+type IDGenerator func() (string, error)
+
+var (
+	idGeneratorMu sync.RWMutex
+	idGenerator   IDGenerator = randomID
+)
+
+// SetIDGenerator replaces the generator of run and job IDs. nil restores
+// the default of 32 random hex characters.
+func SetIDGenerator(gen IDGenerator) {
+	if gen == nil {
+		gen = randomID
+	}
+	idGeneratorMu.Lock()
+	idGenerator = gen
+	idGeneratorMu.Unlock()
+}
+
+// NewID returns an ID from the configured generator.
+func NewID() (string, error) {
+	idGeneratorMu.RLock()
+	gen := idGenerator
+	idGeneratorMu.RUnlock()
+	id, err := gen()
+	if err != nil {
+		return "", err
+	}
+	if err := CheckID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// CheckID returns ErrInvalidID unless id is 1 to 128 letters, digits, '_',
+// '.', ':' or '-', starting with a letter or digit.
+func CheckID(id string) error {
+	if !validID.MatchString(id) {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,217 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+// ImageGCOptions are the settings of PruneImages. Only images pulled by
+// libcmd are considered; images that were present already are never
+// removed.
+type ImageGCOptions struct {
+	// MaxUnused removes images that no container has been created from for
+	// longer than this. Zero disables it.
+	MaxUnused time.Duration
+	// MaxBytes removes the least recently used images until the images
+	// pulled by libcmd, less those excluded, take up no more than this.
+	// Zero disables it.
+	MaxBytes int64
+	// Exclude lists references never removed. The command image of the
+	// config is always excluded.
+	Exclude []string
+	// StateFile, when set, keeps the pulled images and when they were last
+	// used across restarts. Images pulled before a restart are otherwise
+	// forgotten and never removed.
+	StateFile string
+}
+
+// pulledImage is an image reference, repository:tag or repository@digest,
+// pulled by libcmd.
+type pulledImage struct {
+	Ref      string    `json:"ref"`
+	PulledAt time.Time `json:"pulled_at"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+var (
+	pulledImagesMu sync.Mutex
+	pulledImages   = map[string]*pulledImage{}
+)
+
+// trackPulledImage records that libcmd pulled image.
+func trackPulledImage(image string) {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	now := time.Now()
+	if pulled, exists := pulledImages[image]; exists {
+		pulled.LastUsed = now
+		return
+	}
+	pulledImages[image] = &pulledImage{Ref: image, PulledAt: now, LastUsed: now}
+}
+
+// touchImage records a use of image if libcmd pulled it.
+func touchImage(image string) {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	if pulled, exists := pulledImages[image]; exists {
+		pulled.LastUsed = time.Now()
+	}
+}
+
+func untrackImage(image string) {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	delete(pulledImages, image)
+}
+
+func pulledImageList() []pulledImage {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	images := make([]pulledImage, 0, len(pulledImages))
+	for _, pulled := range pulledImages {
+		images = append(images, *pulled)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].LastUsed.Before(images[j].LastUsed) })
+	return images
+}
+
+// loadImageState merges the images recorded in stateFile into those
+// tracked by this process, keeping the later use of each.
+func loadImageState(stateFile string) error {
+	b, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var images []pulledImage
+	if err := json.Unmarshal(b, &images); err != nil {
+		return fmt.Errorf("image state %s: %s", stateFile, err)
+	}
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	for i := range images {
+		if pulled, exists := pulledImages[images[i].Ref]; exists {
+			if images[i].LastUsed.After(pulled.LastUsed) {
+				pulled.LastUsed = images[i].LastUsed
+			}
+			continue
+		}
+		pulledImages[images[i].Ref] = &images[i]
+	}
+	return nil
+}
+
+func saveImageState(stateFile string) error {
+	b, err := json.Marshal(pulledImageList())
+	if err != nil {
+		return err
+	}
+	tmp := stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, stateFile)
+}
+
+// PruneImages removes images pulled by libcmd that have gone unused for
+// opts.MaxUnused, then the least recently used ones while they take up
+// more than opts.MaxBytes. Images that a container still uses are kept by
+// the daemon and skipped. PruneImages returns the references it removed.
+func PruneImages(config CmdConfig, opts ImageGCOptions) ([]string, error) {
+	if config.Backend != "" && config.Backend != BackendDocker {
+		return nil, ErrNotSupportedByRuntime
+	}
+	if opts.StateFile != "" {
+		if err := loadImageState(opts.StateFile); err != nil {
+			log.Errorf("error loading image state: %s", err)
+			return nil, err
+		}
+	}
+	excluded := map[string]bool{fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag): true}
+	for _, ref := range opts.Exclude {
+		excluded[ref] = true
+	}
+
+	log.Debugf("pruning images")
+	sizes := map[string]int64{}
+	var total int64
+	candidates := []pulledImage{}
+	for _, pulled := range pulledImageList() {
+		var info struct {
+			Size int64
+		}
+		if status, err := doJSON("GET", config.DockerEndpoint, "/images/"+pulled.Ref+"/json", nil, &info); err != nil {
+			if status == 404 {
+				// Removed outside of libcmd.
+				untrackImage(pulled.Ref)
+				continue
+			}
+			log.Errorf(" -> error inspecting image %s: %s", pulled.Ref, err)
+			return nil, err
+		}
+		// Excluded images are never removed, so they do not count
+		// towards MaxBytes either.
+		if !excluded[pulled.Ref] {
+			sizes[pulled.Ref] = info.Size
+			total += info.Size
+			candidates = append(candidates, pulled)
+		}
+	}
+
+	removed := []string{}
+	for _, pulled := range candidates {
+		unused := opts.MaxUnused > 0 && time.Since(pulled.LastUsed) > opts.MaxUnused
+		overLimit := opts.MaxBytes > 0 && total > opts.MaxBytes
+		if !unused && !overLimit {
+			continue
+		}
+		log.Debugf(" -> removing image %s, last used %s", pulled.Ref, pulled.LastUsed.Format(time.RFC3339))
+		q := url.Values{"noprune": {"false"}}
+		if _, err := doJSON("DELETE", config.DockerEndpoint, "/images/"+pulled.Ref+"?"+q.Encode(), nil, nil); err != nil {
+			log.Errorf(" -> error removing image %s: %s", pulled.Ref, err)
+			continue
+		}
+		untrackImage(pulled.Ref)
+		total -= sizes[pulled.Ref]
+		removed = append(removed, pulled.Ref)
+	}
+	log.Debugf(" -> removed %d images", len(removed))
+
+	if opts.StateFile != "" {
+		if err := saveImageState(opts.StateFile); err != nil {
+			log.Errorf("error saving image state: %s", err)
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// StartImageGC runs PruneImages every interval until the returned function
+// is called.
+func StartImageGC(config CmdConfig, opts ImageGCOptions, interval time.Duration) func() {
+	stopCh := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				PruneImages(config, opts)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stopCh) }) }
+}
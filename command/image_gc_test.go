@@ -0,0 +1,99 @@
+package command
+
+import (
+	"testing"
+	"time"
+)
+
+func resetPulledImages() {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	pulledImages = map[string]*pulledImage{}
+}
+
+func isTracked(ref string) bool {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	_, tracked := pulledImages[ref]
+	return tracked
+}
+
+func TestEnsureImageTracksOnlyPulledImages(t *testing.T) {
+	resetPulledImages()
+	defer resetPulledImages()
+	d, config, client := newFakeDocker(t)
+	defer d.Close()
+	d.images["present:1"] = 10
+	rt := &dockerRuntime{config, client}
+
+	if err := EnsureImage(config, rt, client, "present:1"); err != nil {
+		t.Fatalf("ensuring present image: %s", err)
+	}
+	if err := EnsureImage(config, rt, client, "absent:1"); err != nil {
+		t.Fatalf("ensuring absent image: %s", err)
+	}
+	if len(d.pulled) != 1 || d.pulled[0] != "absent:1" {
+		t.Errorf("pulled %q, want only absent:1", d.pulled)
+	}
+	if isTracked("present:1") {
+		t.Errorf("present image tracked as pulled")
+	}
+	if !isTracked("absent:1") {
+		t.Errorf("pulled image not tracked")
+	}
+
+	config.PullPolicy = PullNever
+	if err := EnsureImage(config, rt, client, "missing:1"); err != ErrImageNotPresent {
+		t.Errorf("got error %v, want %v", err, ErrImageNotPresent)
+	}
+}
+
+func TestPruneImagesRemovesLeastRecentlyUsed(t *testing.T) {
+	resetPulledImages()
+	defer resetPulledImages()
+	d, config, _ := newFakeDocker(t)
+	defer d.Close()
+
+	now := time.Now()
+	for i, ref := range []string{"oldest:1", "older:1", "excluded:1", "newest:1", "libcmd-test:latest"} {
+		d.images[ref] = 100
+		pulledImages[ref] = &pulledImage{Ref: ref, PulledAt: now, LastUsed: now.Add(time.Duration(i-5) * time.Minute)}
+	}
+	pulledImages["gone:1"] = &pulledImage{Ref: "gone:1", LastUsed: now.Add(-time.Hour)}
+
+	removed, err := PruneImages(config, ImageGCOptions{MaxBytes: 150, Exclude: []string{"excluded:1"}})
+	if err != nil {
+		t.Fatalf("prune failed: %s", err)
+	}
+	if len(removed) != 2 || removed[0] != "oldest:1" || removed[1] != "older:1" {
+		t.Errorf("removed %q, want oldest:1 and older:1", removed)
+	}
+	for _, ref := range []string{"excluded:1", "newest:1", "libcmd-test:latest"} {
+		if !d.hasImage(ref) {
+			t.Errorf("%s was removed", ref)
+		}
+	}
+	if isTracked("gone:1") || isTracked("oldest:1") {
+		t.Errorf("removed images still tracked")
+	}
+}
+
+func TestPruneImagesRemovesUnused(t *testing.T) {
+	resetPulledImages()
+	defer resetPulledImages()
+	d, config, _ := newFakeDocker(t)
+	defer d.Close()
+
+	d.images["stale:1"] = 100
+	d.images["fresh:1"] = 100
+	pulledImages["stale:1"] = &pulledImage{Ref: "stale:1", LastUsed: time.Now().Add(-2 * time.Hour)}
+	pulledImages["fresh:1"] = &pulledImage{Ref: "fresh:1", LastUsed: time.Now()}
+
+	removed, err := PruneImages(config, ImageGCOptions{MaxUnused: time.Hour})
+	if err != nil {
+		t.Fatalf("prune failed: %s", err)
+	}
+	if len(removed) != 1 || removed[0] != "stale:1" {
+		t.Errorf("removed %q, want stale:1", removed)
+	}
+}
@@ -0,0 +1,110 @@
+package command
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrInjectReadOnly = errors.New("injected scripts cannot be copied into a read-only root filesystem")
+)
+
+var (
+	scriptsMu sync.RWMutex
+	scriptsFS fs.FS
+)
+
+// SetScripts injects the command scripts from fsys, such as an embed.FS,
+// into every command container at CommandsDir before it starts, so that
+// changing a script does not need a new image. It takes precedence over
+// CmdConfig.ScriptsDir. nil goes back to the scripts in the image.
+func SetScripts(fsys fs.FS) {
+	scriptsMu.Lock()
+	scriptsFS = fsys
+	scriptsMu.Unlock()
+}
+
+// injectedScripts returns the scripts to copy into command containers, or
+// nil to use those in the image.
+func injectedScripts(config CmdConfig) fs.FS {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+	if scriptsFS != nil {
+		return scriptsFS
+	}
+	if config.ScriptsDir != "" {
+		return os.DirFS(config.ScriptsDir)
+	}
+	return nil
+}
+
+// injectScripts copies the files of scripts into the container under
+// config.CommandsDir with the archive API. The path of each entry includes
+// CommandsDir so that it need not exist in the image.
+func injectScripts(config CmdConfig, containerID string, scripts fs.FS) error {
+	log.Debugf("injecting command scripts into container %s", containerID)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTarFS(writer, scripts, strings.Trim(config.CommandsDir, "/")))
+	}()
+	defer reader.Close()
+	resp, closeFn, err := sendTypedRequest("PUT", config.DockerEndpoint,
+		fmt.Sprintf("/containers/%s/archive?path=/", containerID), "application/x-tar", reader)
+	if err != nil {
+		log.Errorf(" -> error injecting command scripts into container %s: %s", containerID, err)
+		return err
+	}
+	defer closeFn()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("docker returned status %d copying command scripts", resp.StatusCode)
+		log.Errorf(" -> error injecting command scripts into container %s: %s", containerID, err)
+		return err
+	}
+	log.Debugf(" -> command scripts injected into container %s", containerID)
+	return nil
+}
+
+// writeTarFS writes the files of fsys as a tar archive under prefix. Files
+// are world readable, as the command user may not be root.
+func writeTarFS(w io.Writer, fsys fs.FS, prefix string) error {
+	tw := tar.NewWriter(w)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: path.Join(prefix, name), Mode: 0755}
+		if d.IsDir() {
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			return tw.WriteHeader(header)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		header.Typeflag = tar.TypeReg
+		header.Mode = 0644
+		header.Size = int64(len(b))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,216 @@
+package command
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrInvalidInput  = errors.New("invalid input")
+	ErrInputReadOnly = errors.New("inputs cannot be copied into a read-only root filesystem")
+)
+
+// Input is a payload copied into the command container at Path before the
+// command starts, for data too large for args or stdin. Exactly one of
+// HostPath, FS and Archive is set.
+type Input struct {
+	// Path is the absolute directory in the container the input is copied
+	// into. It is created if the image does not have it.
+	Path string
+	// HostPath is a host file, copied as Path/<name>, or a directory,
+	// whose contents are copied under Path.
+	HostPath string
+	// FS has its files copied under Path.
+	FS fs.FS
+	// Archive is a tar stream extracted under Path.
+	Archive io.Reader
+}
+
+func (in Input) check() error {
+	sources := 0
+	for _, set := range []bool{in.HostPath != "", in.FS != nil, in.Archive != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("%s: exactly one of HostPath, FS and Archive must be set", ErrInvalidInput)
+	}
+	if !path.IsAbs(in.Path) {
+		return fmt.Errorf("%s: path %q is not absolute", ErrInvalidInput, in.Path)
+	}
+	return nil
+}
+
+func checkInputs(inputs []Input) error {
+	for _, in := range inputs {
+		if err := in.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageInputs copies the inputs into the created container with the
+// archive API, owned by user when it is numeric so that a command that
+// does not run as root can modify them.
+func stageInputs(config CmdConfig, containerID, user string, inputs []Input) error {
+	log.Debugf("staging %d inputs into container %s", len(inputs), containerID)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeInputsTar(writer, inputs, user))
+	}()
+	defer reader.Close()
+	resp, closeFn, err := sendTypedRequest("PUT", config.DockerEndpoint,
+		fmt.Sprintf("/containers/%s/archive?path=/", containerID), "application/x-tar", reader)
+	if err != nil {
+		log.Errorf(" -> error staging inputs into container %s: %s", containerID, err)
+		return err
+	}
+	defer closeFn()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("docker returned status %d copying inputs", resp.StatusCode)
+		log.Errorf(" -> error staging inputs into container %s: %s", containerID, err)
+		return err
+	}
+	log.Debugf(" -> inputs staged into container %s", containerID)
+	return nil
+}
+
+func writeInputsTar(w io.Writer, inputs []Input, user string) error {
+	uid, gid, _ := numericUser(user)
+	if gid < 0 {
+		gid = uid
+	}
+	tw := &ownedTarWriter{tar.NewWriter(w), uid, gid}
+	for _, in := range inputs {
+		prefix := strings.Trim(path.Clean(in.Path), "/")
+		if err := tw.WriteHeader(&tar.Header{Name: prefix + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+			return err
+		}
+		var err error
+		switch {
+		case in.FS != nil:
+			err = writeInputFS(tw, in.FS, prefix)
+		case in.Archive != nil:
+			err = writeInputArchive(tw, in.Archive, prefix)
+		default:
+			err = writeInputHostPath(tw, in.HostPath, prefix)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ownedTarWriter sets the owner of every entry when uid is not negative.
+type ownedTarWriter struct {
+	*tar.Writer
+	uid, gid int
+}
+
+func (tw *ownedTarWriter) WriteHeader(header *tar.Header) error {
+	if tw.uid >= 0 {
+		header.Uid, header.Gid = tw.uid, tw.gid
+		header.Uname, header.Gname = "", ""
+	}
+	return tw.Writer.WriteHeader(header)
+}
+
+func writeInputFS(tw *ownedTarWriter, fsys fs.FS, prefix string) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		if d.IsDir() {
+			return tw.WriteHeader(&tar.Header{Name: path.Join(prefix, name) + "/", Typeflag: tar.TypeDir, Mode: 0755})
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return writeInputFile(tw, path.Join(prefix, name), info, f)
+	})
+}
+
+func writeInputHostPath(tw *ownedTarWriter, hostPath, prefix string) error {
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return writeInputFS(tw, os.DirFS(hostPath), prefix)
+	}
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeInputFile(tw, path.Join(prefix, filepath.Base(hostPath)), info, f)
+}
+
+func writeInputFile(tw *ownedTarWriter, name string, info fs.FileInfo, r io.Reader) error {
+	header := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     int64(info.Mode().Perm() | 0644),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, info.Size())
+	return err
+}
+
+// writeInputArchive copies the directories and regular files of the tar
+// stream under prefix. Entries that would land outside prefix are an error.
+func writeInputArchive(tw *ownedTarWriter, r io.Reader, prefix string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") || strings.Contains(name, "/../") {
+			return fmt.Errorf("%s: archive entry %q is outside the input path", ErrInvalidInput, header.Name)
+		}
+		name = path.Join(prefix, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := tw.WriteHeader(&tar.Header{Name: name + "/", Typeflag: tar.TypeDir, Mode: header.Mode | 0755}); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			copied := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: header.Mode | 0644, Size: header.Size, ModTime: header.ModTime}
+			if err := tw.WriteHeader(copied); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
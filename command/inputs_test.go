@@ -0,0 +1,117 @@
+package command
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type writtenEntry struct {
+	typeflag byte
+	uid, gid int
+	body     string
+}
+
+func readTarEntries(t *testing.T, r io.Reader) map[string]writtenEntry {
+	entries := map[string]writtenEntry{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		} else if err != nil {
+			t.Fatalf("reading tar: %s", err)
+		}
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %s", header.Name, err)
+		}
+		entries[header.Name] = writtenEntry{header.Typeflag, header.Uid, header.Gid, string(body)}
+	}
+}
+
+func TestWriteInputsTarPlacesEntriesUnderPath(t *testing.T) {
+	archive := buildTar(t, []tarEntry{
+		{name: "./conf/", typeflag: tar.TypeDir},
+		{name: "./conf/app.yaml", typeflag: tar.TypeReg, body: "replicas: 2\n"},
+		{name: "skipped", typeflag: tar.TypeSymlink, linkname: "/etc/shadow"},
+	})
+	fsys := fstest.MapFS{"seed.sql": {Data: []byte("select 1;"), Mode: 0600}}
+	inputs := []Input{
+		{Path: "/data/archive", Archive: archive},
+		{Path: "/data/fs/", FS: fsys},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeInputsTar(buf, inputs, "1000:2000"); err != nil {
+		t.Fatalf("writing inputs failed: %s", err)
+	}
+	entries := readTarEntries(t, buf)
+
+	if entry, ok := entries["data/archive/conf/app.yaml"]; !ok || entry.body != "replicas: 2\n" {
+		t.Errorf("archive file not placed under its path: %v", entries)
+	}
+	if entry, ok := entries["data/fs/seed.sql"]; !ok || entry.body != "select 1;" {
+		t.Errorf("fs file not placed under its path: %v", entries)
+	}
+	if _, ok := entries["data/archive/skipped"]; ok {
+		t.Errorf("symlink was copied")
+	}
+	for name, entry := range entries {
+		if entry.uid != 1000 || entry.gid != 2000 {
+			t.Errorf("%s owned by %d:%d, want 1000:2000", name, entry.uid, entry.gid)
+		}
+	}
+}
+
+func TestWriteInputsTarKeepsOwnerForNamedUser(t *testing.T) {
+	inputs := []Input{{Path: "/data", FS: fstest.MapFS{"a": {Data: []byte("a")}}}}
+	buf := &bytes.Buffer{}
+	if err := writeInputsTar(buf, inputs, "nobody"); err != nil {
+		t.Fatalf("writing inputs failed: %s", err)
+	}
+	for name, entry := range readTarEntries(t, buf) {
+		if entry.uid != 0 || entry.gid != 0 {
+			t.Errorf("%s owned by %d:%d, want 0:0", name, entry.uid, entry.gid)
+		}
+	}
+}
+
+func TestWriteInputArchiveRefusesEntriesOutsidePath(t *testing.T) {
+	tests := []string{
+		"../escape",
+		"conf/../../escape",
+		"..",
+		"/etc/escape",
+	}
+	for _, name := range tests {
+		archive := buildTar(t, []tarEntry{{name: name, typeflag: tar.TypeReg, body: "x"}})
+		err := writeInputsTar(ioutil.Discard, []Input{{Path: "/data", Archive: archive}}, "")
+		if err == nil || !strings.HasPrefix(err.Error(), ErrInvalidInput.Error()) {
+			t.Errorf("writing %q: got error %v, want %v", name, err, ErrInvalidInput)
+		}
+	}
+}
+
+func TestCheckInputs(t *testing.T) {
+	tests := []struct {
+		in    Input
+		valid bool
+	}{
+		{Input{Path: "/data", HostPath: "/tmp/x"}, true},
+		{Input{Path: "/data", FS: fstest.MapFS{}}, true},
+		{Input{Path: "data", HostPath: "/tmp/x"}, false},
+		{Input{Path: "/data"}, false},
+		{Input{Path: "/data", HostPath: "/tmp/x", FS: fstest.MapFS{}}, false},
+	}
+	for i, test := range tests {
+		err := checkInputs([]Input{test.in})
+		if (err == nil) != test.valid {
+			t.Errorf("%d: got error %v, want valid %t", i, err, test.valid)
+		}
+	}
+}
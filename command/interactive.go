@@ -0,0 +1,272 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrNotSupportedInteractive = errors.New("not supported by interactive runs")
+)
+
+// TerminalSize is the size of the caller's terminal, in characters.
+type TerminalSize struct {
+	Height int
+	Width  int
+}
+
+// RunInteractive runs op with a TTY, streaming stdin to it and its output to
+// stdout, for REPL-like commands such as debug shells or psql. Each size
+// received from sizes resizes the TTY; send the size of the caller's
+// terminal first and again whenever it changes. sizes may be nil. The run
+// passes through the hooks and policy like any other and returns
+// ErrCommandResponse if the command exits non-zero, or ErrCommandCanceled
+// when opts.Cancel is closed. Interactive runs create a container even when
+// ExecContainer is set, and do not support dependencies or secret files.
+func RunInteractive(config CmdConfig, client *docker.Client, opts RunOptions, op string, stdin io.Reader, stdout io.Writer, sizes <-chan TerminalSize, args ...string) error {
+	if err := CheckDockerBackend(config, client); err != nil {
+		return err
+	}
+	if config.ContainerOS == OSWindows {
+		return ErrNotSupportedOnWindows
+	}
+	def, exists := lookupCommand(op)
+	if !exists {
+		return ErrCommandNotFound
+	}
+	if len(def.Requires) > 0 || len(opts.SecretFiles) > 0 {
+		return ErrNotSupportedInteractive
+	}
+	if err := CheckArgs(op, args); err != nil {
+		return err
+	}
+	if !beginRun() {
+		return ErrShuttingDown
+	}
+	defer endRun()
+	var err error
+	if opts.RunID == "" {
+		if opts.RunID, err = NewID(); err != nil {
+			return err
+		}
+	} else if err := CheckID(opts.RunID); err != nil {
+		return err
+	}
+	opts, releaseSecrets, err := resolveSecretRefs(opts)
+	if err != nil {
+		return err
+	}
+	defer releaseSecrets()
+	if len(opts.Secrets) > 0 {
+		defer log.AddSecrets(opts.Secrets...)()
+	}
+	log.Debugf("run %s: %s (interactive)", opts.RunID, op)
+
+	rc := newRunContext(op, args, config, opts)
+	_, err = observeRun(op, func() ([]string, error) {
+		if err := runHooks(rc, beforeRunHook); err != nil {
+			return nil, err
+		}
+		if err := Authorize(opts.Caller, op, rc.Args); err != nil {
+			return nil, err
+		}
+		return nil, runInteractive(rc, client, def, stdin, stdout, sizes)
+	})
+	rc.Err = err
+	rc.Duration = time.Since(rc.Start)
+	runFinishedHooks(rc)
+	return err
+}
+
+func runInteractive(rc *RunContext, client *docker.Client, def *CommandDef, stdin io.Reader, stdout io.Writer, sizes <-chan TerminalSize) error {
+	config, opts := rc.Config, rc.Options
+	scripts := injectedScripts(config)
+	workingDir := ""
+	if readOnly := readOnlyOptions(def, opts); readOnly != nil {
+		if scripts != nil {
+			return ErrInjectReadOnly
+		}
+		applyReadOnly(rc.HostConfig, config, opts, readOnly)
+		workingDir = readOnly.WorkDir
+	}
+	if opts.WorkingDir != "" {
+		workingDir = opts.WorkingDir
+	}
+	if err := applySecurity(rc.HostConfig, rc.Op, securityProfile(def, opts)); err != nil {
+		return err
+	}
+	applyRunLimits(rc.HostConfig, opts)
+	if err := applyPrivileged(rc.HostConfig, rc.Op, privilegedOptions(def, opts)); err != nil {
+		return err
+	}
+	binds, err := def.binds(opts.MountParams)
+	if err != nil {
+		return err
+	}
+	rc.HostConfig.Binds = append(rc.HostConfig.Binds, binds...)
+	if len(opts.Volumes) > 0 {
+		volumes, err := volumeBinds(config, opts.Volumes)
+		if err != nil {
+			return err
+		}
+		rc.HostConfig.Binds = append(rc.HostConfig.Binds, volumes...)
+	}
+	if err := applyOCIRuntime(rc.HostConfig, config, def, opts); err != nil {
+		return err
+	}
+	if opts.Devices != nil {
+		if err := CheckDevices(config, *opts.Devices); err != nil {
+			return err
+		}
+		if err := applyDevices(rc.HostConfig, rc.Op, opts.Devices); err != nil {
+			return err
+		}
+	}
+
+	image := opts.ImageID
+	if image == "" && opts.Image != "" {
+		if err := EnsureImage(config, &dockerRuntime{config: config, client: client}, client, opts.Image); err != nil {
+			return err
+		}
+		image = opts.Image
+	}
+	if image == "" {
+		image = fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag)
+	}
+	createOpts := ContainerSpec{
+		Op:    rc.Op,
+		RunID: rc.RunID,
+		Config: &docker.Config{
+			Image:        image,
+			Cmd:          scriptCmdParts(config, rc.Op, rc.Args),
+			Env:          containerEnv(config, opts.Env),
+			User:         containerUser(config, opts),
+			WorkingDir:   workingDir,
+			Entrypoint:   opts.Entrypoint,
+			Tty:          true,
+			OpenStdin:    true,
+			StdinOnce:    true,
+			AttachStdin:  true,
+			AttachStdout: true,
+		},
+		HostConfig: rc.HostConfig,
+		Network:    networkOptions(def, opts),
+	}
+	return runTTY(rc, client, createOpts, scripts, stdin, stdout, sizes)
+}
+
+// runTTY creates a container with a TTY from spec, injects scripts if they
+// are not nil, attaches stdin and stdout to it and waits for it to exit.
+// Keystrokes and output are recorded in the transcript of the run.
+func runTTY(rc *RunContext, client *docker.Client, spec ContainerSpec, scripts fs.FS, stdin io.Reader, stdout io.Writer, sizes <-chan TerminalSize) error {
+	rc.Image = spec.Config.Image
+	container, err := createContainerFromOptions(rc.Config, spec)
+	if err != nil {
+		return err
+	}
+	defer removeContainer(client, container.ID)
+
+	rc.ContainerID = container.ID
+	if err := runHooks(rc, containerCreatedHook); err != nil {
+		return err
+	}
+	if scripts != nil {
+		if err := injectScripts(rc.Config, container.ID, scripts); err != nil {
+			return err
+		}
+	}
+
+	transcript := &transcriptRecorder{record: chunkRecorder(rc, rc.Options)}
+	success := make(chan struct{})
+	attachErrCh := make(chan error, 1)
+	go func() {
+		attachErrCh <- client.AttachToContainer(docker.AttachToContainerOptions{
+			Container:    container.ID,
+			InputStream:  io.TeeReader(stdin, transcript.writer(stdcopy.Stdin)),
+			OutputStream: io.MultiWriter(stdout, transcript.writer(stdcopy.Stdout)),
+			Stdin:        true,
+			Stdout:       true,
+			Stream:       true,
+			RawTerminal:  true,
+			Success:      success,
+		})
+	}()
+	select {
+	case <-success:
+		success <- struct{}{}
+	case err := <-attachErrCh:
+		log.Errorf(" -> error attaching to container %s: %s", container.ID, err)
+		return err
+	}
+
+	if err := startContainer(client, container.ID); err != nil {
+		return err
+	}
+	if err := runHooks(rc, startedHook); err != nil {
+		killContainer(client, container.ID)
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	if sizes != nil {
+		go resizeTTY(client, container.ID, sizes, done)
+	}
+
+	waitCh := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := waitContainer(client, container.ID)
+		waitCh <- waitResult{exitCode, err}
+	}()
+	canceled := false
+	cancelCh := rc.Options.Cancel
+	var result waitResult
+	for waiting := true; waiting; {
+		select {
+		case result = <-waitCh:
+			waiting = false
+		case <-cancelCh:
+			canceled = true
+			cancelCh = nil
+			killContainer(client, container.ID)
+		}
+	}
+	if result.err != nil {
+		return result.err
+	}
+	rc.ExitCode = result.exitCode
+	if err := <-attachErrCh; err != nil {
+		log.Errorf(" -> error reading interactive session %s: %s", container.ID, err)
+	}
+	if canceled {
+		return ErrCommandCanceled
+	}
+	if result.exitCode != 0 {
+		return ErrCommandResponse
+	}
+	return nil
+}
+
+func resizeTTY(client *docker.Client, containerID string, sizes <-chan TerminalSize, done <-chan struct{}) {
+	for {
+		select {
+		case size, ok := <-sizes:
+			if !ok {
+				return
+			}
+			if err := client.ResizeContainerTTY(containerID, size.Height, size.Width); err != nil {
+				log.Errorf("error resizing tty of container %s: %s", containerID, err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
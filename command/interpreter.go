@@ -0,0 +1,44 @@
+package command
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+var (
+	// Interpreters run a command script by its extension when the command
+	// does not set CommandDef.Interpreter. Scripts with other extensions
+	// are run with bash.
+	Interpreters = map[string][]string{
+		".sh":   {"bash"},
+		".bash": {"bash"},
+		".py":   {"python3"},
+		".js":   {"node"},
+		".mjs":  {"node"},
+		".ps1":  {"pwsh", "-NoProfile", "-NonInteractive", "-File"},
+	}
+)
+
+// Interpreter returns the interpreter of script by its extension.
+func Interpreter(script string) []string {
+	if interpreter, exists := Interpreters[strings.ToLower(path.Ext(script))]; exists {
+		return interpreter
+	}
+	return []string{"bash"}
+}
+
+// commandScript returns the script of op under CommandsDir, <op>.sh unless
+// the command sets CommandDef.Script, and the interpreter that runs it.
+func commandScript(config CmdConfig, op string) ([]string, string) {
+	def, _ := lookupCommand(op)
+	script := op + ".sh"
+	if def != nil && def.Script != "" {
+		script = def.Script
+	}
+	interpreter := Interpreter(script)
+	if def != nil && len(def.Interpreter) > 0 {
+		interpreter = def.Interpreter
+	}
+	return interpreter, fmt.Sprintf("%s/%s", config.CommandsDir, script)
+}
@@ -0,0 +1,37 @@
+package command
+
+// Ulimit is a resource limit set with setrlimit in the command container,
+// such as "nofile" or "nproc".
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// mergeUlimits returns base with the limits in overrides replacing those of
+// the same name.
+func mergeUlimits(base, overrides []Ulimit) []Ulimit {
+	merged := []Ulimit{}
+	for _, ulimit := range base {
+		overridden := false
+		for _, override := range overrides {
+			if override.Name == ulimit.Name {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, ulimit)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+// applyRunLimits sets the limits given for the run over those of the
+// security profile.
+func applyRunLimits(hostConfig *HostConfig, opts RunOptions) {
+	hostConfig.Ulimits = mergeUlimits(hostConfig.Ulimits, opts.Ulimits)
+	if opts.PidsLimit != 0 {
+		hostConfig.PidsLimit = opts.PidsLimit
+	}
+}
@@ -0,0 +1,117 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+const (
+	// BackendDocker runs container commands in containers on the docker
+	// daemon at DockerEndpoint. It is the default.
+	BackendDocker = "docker"
+	// BackendLocal runs the <CommandsDir>/<op>.sh scripts directly on this
+	// host, for development and tests where no container runtime is
+	// available. Container settings such as binds, security profiles and
+	// resource limits do not apply, and runs that set them fail with
+	// ErrNotSupportedByRuntime.
+	BackendLocal = "local"
+)
+
+// checkLocalOptions returns ErrNotSupportedByRuntime for the options of a
+// run that need a container, rather than run the script without them.
+func checkLocalOptions(config CmdConfig, opts RunOptions) error {
+	if injectedScripts(config) != nil || len(opts.SecretFiles) > 0 || opts.Artifacts != nil ||
+		len(opts.Inputs) > 0 || len(opts.Volumes) > 0 || opts.Image != "" || opts.ImageID != "" ||
+		opts.User != "" || opts.Network != nil || opts.Security != nil || opts.ReadOnly != nil ||
+		opts.Devices != nil || opts.Privileged != nil || opts.OCIRuntime != "" ||
+		len(opts.Ulimits) > 0 || opts.PidsLimit != 0 {
+		return ErrNotSupportedByRuntime
+	}
+	return nil
+}
+
+// runLocal runs the script as a child process with the environment of this
+// process and the command env. Output is captured as for a container.
+// Cancellation and signals reach the process group of the script, so that
+// the commands it starts do not outlive it.
+func runLocal(rc *RunContext, config CmdConfig, cmdParts []string, opts RunOptions) ([]string, error) {
+	limits := opts.outputLimits()
+	stdoutBuffer := newLimitedBuffer(limits.MaxStdout, limits.Strategy)
+	stderrBuffer := newLimitedBuffer(limits.MaxStderr, limits.Strategy)
+	var stdout, stderr io.Writer = stdoutBuffer, stderrBuffer
+	if record := chunkRecorder(rc, opts); record != nil {
+		transcript := &transcriptRecorder{record: record}
+		stdout = io.MultiWriter(stdoutBuffer, transcript.writer(stdcopy.Stdout))
+		stderr = io.MultiWriter(stderrBuffer, transcript.writer(stdcopy.Stderr))
+	}
+
+	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
+	cmd.Env = append(os.Environ(), containerEnv(config, opts.Env)...)
+	cmd.Stdin = bytes.NewReader(nil)
+	cmd.Dir = opts.WorkingDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	log.Debugf("starting local process for %s", rc.Op)
+	if err := cmd.Start(); err != nil {
+		log.Errorf(" -> error starting local process: %s", err)
+		return nil, err
+	}
+	if err := runHooks(rc, startedHook); err != nil {
+		signalProcessGroup(cmd, os.Kill)
+		cmd.Wait()
+		return nil, err
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+	canceled := false
+	cancelCh := opts.Cancel
+	var err error
+	for waiting := true; waiting; {
+		select {
+		case err = <-waitCh:
+			waiting = false
+		case <-cancelCh:
+			canceled = true
+			cancelCh = nil
+			signalProcessGroup(cmd, os.Kill)
+		case req := <-opts.signals:
+			req.errCh <- signalProcessGroup(cmd, req.sig)
+		}
+	}
+
+	exitCode := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			log.Errorf(" -> error waiting for local process: %s", err)
+			return nil, err
+		}
+		exitCode = -1
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			exitCode = status.ExitStatus()
+		}
+	}
+	rc.ExitCode = exitCode
+	rc.captureStreams(limitedOutput(stdoutBuffer, stderrBuffer))
+	log.Debugf(" -> local process exited with code %d", exitCode)
+
+	if canceled {
+		return []string{strings.TrimSpace(stderrBuffer.String())}, ErrCommandCanceled
+	}
+	if exitCode == 0 {
+		return []string{strings.TrimSpace(stdoutBuffer.String())}, nil
+	}
+	return []string{strings.TrimSpace(stderrBuffer.String())}, ErrCommandResponse
+}
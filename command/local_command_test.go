@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLocalCancelKillsProcessGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libcmd-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "child.pid")
+	script := "sleep 30 &\necho $! > " + pidFile + "\nwait\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "raw.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := CmdConfig{CommandsDir: dir, Backend: BackendLocal}
+	cancel := make(chan bool)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := Run("raw", config, nil, RunOptions{Cancel: cancel})
+		errCh <- err
+	}()
+
+	var pid int
+	for deadline := time.Now().Add(5 * time.Second); pid == 0; {
+		if b, err := ioutil.ReadFile(pidFile); err == nil {
+			pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("script did not start its child")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(cancel)
+	select {
+	case err := <-errCh:
+		if err != ErrCommandCanceled {
+			t.Fatalf("got error %v, want %v", err, ErrCommandCanceled)
+		}
+	case <-time.After(5 * time.Second):
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Fatal("canceled run waited for the child of the script")
+	}
+	for deadline := time.Now().Add(5 * time.Second); syscall.Kill(pid, 0) == nil; {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatal("child of the script outlived the canceled run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,15 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows, where a process has no group to
+// signal.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup signals the process of cmd. Windows can only kill it.
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Signal(sig)
+}
@@ -0,0 +1,259 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Arg types of a manifest.
+const (
+	ArgString = "string"
+	ArgInt    = "int"
+	ArgFloat  = "float"
+	ArgBool   = "bool"
+)
+
+var (
+	ErrManifestNotFound = errors.New("command manifest not found")
+	ErrInvalidManifest  = errors.New("invalid command manifest")
+
+	imageManifestsMu sync.Mutex
+	imageManifests   = map[string]map[string]*Manifest{}
+)
+
+// Manifest describes a command and the positional args it takes. Manifests
+// are registered with CommandDef.Manifest or kept as <op>.json beside the
+// scripts in CommandsDir, such as
+//
+//	{"description": "Prints random bytes", "args": [
+//	  {"name": "bytes", "type": "int", "default": "16"}]}
+type Manifest struct {
+	Description string    `json:"description"`
+	Args        []ArgSpec `json:"args"`
+}
+
+// ArgSpec is one positional arg. Required args must precede optional ones,
+// and an optional arg that is not passed takes its Default when it has one.
+type ArgSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Type is ArgString, ArgInt, ArgFloat or ArgBool. Defaults to
+	// ArgString.
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	Default  string `json:"default"`
+	// Enum, when set, lists the only values the arg may take.
+	Enum []string `json:"enum"`
+}
+
+func (m *Manifest) check() error {
+	optional := false
+	for _, arg := range m.Args {
+		switch arg.Type {
+		case "", ArgString, ArgInt, ArgFloat, ArgBool:
+		default:
+			return fmt.Errorf("%s: arg %s has unknown type %s", ErrInvalidManifest, arg.Name, arg.Type)
+		}
+		if arg.Required && optional {
+			return fmt.Errorf("%s: required arg %s follows an optional arg", ErrInvalidManifest, arg.Name)
+		}
+		optional = optional || !arg.Required
+		if arg.Default != "" {
+			if err := arg.check(arg.Default); err != nil {
+				return fmt.Errorf("%s: default of arg %s: %s", ErrInvalidManifest, arg.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate returns ErrInvalidArg if args do not match the manifest, and
+// otherwise args with the defaults of the optional args that were left out.
+func (m *Manifest) Validate(args []string) ([]string, error) {
+	if len(args) > len(m.Args) {
+		return nil, fmt.Errorf("%s: %d args given, at most %d accepted", ErrInvalidArg, len(args), len(m.Args))
+	}
+	validated := append([]string{}, args...)
+	for i, spec := range m.Args {
+		if i >= len(args) {
+			if spec.Required {
+				return nil, fmt.Errorf("%s: %s is required", ErrInvalidArg, spec.Name)
+			}
+			if spec.Default == "" {
+				break
+			}
+			validated = append(validated, spec.Default)
+			continue
+		}
+		if err := spec.check(args[i]); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", ErrInvalidArg, spec.Name, err)
+		}
+	}
+	return validated, nil
+}
+
+func (spec ArgSpec) check(value string) error {
+	var err error
+	switch spec.Type {
+	case ArgInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case ArgFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case ArgBool:
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a %s", value, spec.Type)
+	}
+	if len(spec.Enum) == 0 {
+		return nil
+	}
+	for _, allowed := range spec.Enum {
+		if value == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", value, strings.Join(spec.Enum, ", "))
+}
+
+// Describe returns the manifest of op: the one registered with the command,
+// or else <op>.json from the scripts injected with SetScripts or
+// CmdConfig.ScriptsDir, the CommandsDir of the local backend, or the
+// command image. Manifests in an image are read once per image ID, without
+// starting a container. It returns ErrManifestNotFound if op has none.
+func Describe(config CmdConfig, client *docker.Client, op string) (*Manifest, error) {
+	if def, exists := lookupCommand(op); exists && def.Manifest != nil {
+		return def.Manifest, nil
+	}
+	var manifests map[string]*Manifest
+	var err error
+	switch {
+	case injectedScripts(config) != nil:
+		manifests, err = fsManifests(injectedScripts(config), op)
+	case config.Backend == BackendLocal:
+		manifests, err = fsManifests(os.DirFS(config.CommandsDir), op)
+	case config.Backend != "" && config.Backend != BackendDocker:
+		return nil, ErrManifestNotFound
+	default:
+		manifests, err = loadImageManifests(config, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if manifest, exists := manifests[op]; exists {
+		return manifest, nil
+	}
+	return nil, ErrManifestNotFound
+}
+
+// commandManifest returns the manifest the args of a run are validated
+// against, or nil. Manifests are only read from the image when
+// CmdConfig.Manifests is "true".
+func commandManifest(config CmdConfig, client *docker.Client, def *CommandDef) (*Manifest, error) {
+	if def.Manifest == nil && config.Manifests != "true" && injectedScripts(config) == nil && config.Backend != BackendLocal {
+		return nil, nil
+	}
+	manifest, err := Describe(config, client, def.Op)
+	if err == ErrManifestNotFound {
+		return nil, nil
+	}
+	return manifest, err
+}
+
+func fsManifests(fsys fs.FS, op string) (map[string]*Manifest, error) {
+	b, err := fs.ReadFile(fsys, op+".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	manifest, err := parseManifest(op, b)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]*Manifest{op: manifest}, nil
+}
+
+func loadImageManifests(config CmdConfig, client *docker.Client) (map[string]*Manifest, error) {
+	image := fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag)
+	containerID := config.ExecContainer
+	var key string
+	if containerID != "" {
+		container, err := client.InspectContainer(containerID)
+		if err != nil {
+			return nil, err
+		}
+		key = container.Image
+	} else {
+		info, err := client.InspectImage(image)
+		if err != nil {
+			return nil, err
+		}
+		key = info.ID
+	}
+	imageManifestsMu.Lock()
+	manifests, loaded := imageManifests[key]
+	imageManifestsMu.Unlock()
+	if loaded {
+		return manifests, nil
+	}
+
+	log.Debugf("reading command manifests from %s", config.CommandsDir)
+	if containerID == "" {
+		// The archive API needs a container, but it need not be started.
+		container, err := createContainerFromOptions(config, ContainerSpec{
+			Op:         "manifests",
+			Config:     &docker.Config{Image: key, Cmd: []string{"true"}},
+			HostConfig: newHostConfig(config),
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer removeContainer(client, container.ID)
+		containerID = container.ID
+	}
+	files, err := readContainerFiles(config.DockerEndpoint, containerID, config.CommandsDir, func(name string) bool {
+		return strings.HasSuffix(name, ".json")
+	})
+	if err != nil {
+		log.Errorf(" -> error reading command manifests: %s", err)
+		return nil, err
+	}
+	manifests = map[string]*Manifest{}
+	for name, b := range files {
+		op := strings.TrimSuffix(name, ".json")
+		manifest, err := parseManifest(op, b)
+		if err != nil {
+			log.Errorf(" -> error reading command manifests: %s", err)
+			return nil, err
+		}
+		manifests[op] = manifest
+	}
+	log.Debugf(" -> %d command manifests read", len(manifests))
+
+	imageManifestsMu.Lock()
+	imageManifests[key] = manifests
+	imageManifestsMu.Unlock()
+	return manifests, nil
+}
+
+func parseManifest(op string, b []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %s: %s", ErrInvalidManifest, op, err)
+	}
+	if err := manifest.check(); err != nil {
+		return nil, fmt.Errorf("%s: %s", op, err)
+	}
+	return &manifest, nil
+}
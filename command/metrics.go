@@ -0,0 +1,55 @@
+package command
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements of command execution. See the metrics
+// package for an implementation that can be scraped by Prometheus.
+type Metrics interface {
+	RunStarted(op string)
+	RunFinished(op string, duration time.Duration, err error)
+	PullFinished(image string, duration time.Duration, err error)
+	ContainerCreated(duration time.Duration, err error)
+	ContainerStarted(duration time.Duration, err error)
+}
+
+var (
+	metricsMu sync.RWMutex
+	metrics   Metrics = noopMetrics{}
+)
+
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metricsMu.Lock()
+	metrics = m
+	metricsMu.Unlock()
+}
+
+func currentMetrics() Metrics {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	return metrics
+}
+
+// observeRun reports the run of op, performed by fn, to the configured
+// Metrics.
+func observeRun(op string, fn func() ([]string, error)) ([]string, error) {
+	m := currentMetrics()
+	m.RunStarted(op)
+	start := time.Now()
+	output, err := fn()
+	m.RunFinished(op, time.Since(start), err)
+	return output, err
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) RunStarted(op string)                                         {}
+func (noopMetrics) RunFinished(op string, duration time.Duration, err error)     {}
+func (noopMetrics) PullFinished(image string, duration time.Duration, err error) {}
+func (noopMetrics) ContainerCreated(duration time.Duration, err error)           {}
+func (noopMetrics) ContainerStarted(duration time.Duration, err error)           {}
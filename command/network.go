@@ -0,0 +1,277 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrNetworkNotFound  = errors.New("network not found")
+	ErrNetworkNoIPv6    = errors.New("network does not have IPv6 enabled")
+	ErrNetworkNoIPv4    = errors.New("network does not have an IPv4 subnet")
+	ErrDNSNameNoNetwork = errors.New("per-run DNS names require a user-defined network")
+)
+
+// NetworkOptions selects the network of the command container. Name
+// attaches it to an existing user-defined network, where Aliases let other
+// containers reach it by name and static addresses may be set. Static
+// addresses require the network to have been created with a subnet of the
+// matching family. Without a Name, Mode is "none", "bridge" or "host". The
+// host network is only honored after SetAllowPrivileged(true).
+type NetworkOptions struct {
+	Mode        string
+	Name        string
+	Aliases     []string
+	IPv4Address string
+	IPv6Address string
+	// DNSDomain, when set, registers the container on the Name network as
+	// cmd-<run id>.<DNSDomain> for the duration of the run. See
+	// RunDNSName.
+	DNSDomain string
+}
+
+var (
+	// DefaultNetwork applies to commands that neither register network
+	// options nor are given them in RunOptions. Commands that need the
+	// network must ask for it.
+	DefaultNetwork = NetworkOptions{Mode: "none"}
+)
+
+// networkOptions picks the network of the run, then of the command, then
+// the default.
+func networkOptions(def *CommandDef, opts RunOptions) *NetworkOptions {
+	if opts.Network != nil {
+		return opts.Network
+	}
+	if def != nil && def.Network != nil {
+		return def.Network
+	}
+	network := DefaultNetwork
+	return &network
+}
+
+// NetworkSpec describes a network created by EnsureNetwork. Subnets may be
+// IPv4 or IPv6 CIDRs. Setting IPv6Only creates a network with no IPv4
+// addressing, for hosts that have no IPv4 connectivity; it requires a
+// daemon that supports disabling IPv4 on a network.
+type NetworkSpec struct {
+	Name       string
+	Driver     string
+	EnableIPv6 bool
+	IPv6Only   bool
+	Subnets    []string
+}
+
+type ipamConfig struct {
+	Subnet  string `json:",omitempty"`
+	Gateway string `json:",omitempty"`
+}
+
+type networkResource struct {
+	Name       string
+	Driver     string `json:",omitempty"`
+	EnableIPv4 *bool  `json:",omitempty"`
+	EnableIPv6 bool
+	IPAM       struct {
+		Config []ipamConfig
+	}
+}
+
+type networkingConfig struct {
+	EndpointsConfig map[string]*endpointSettings
+}
+
+type endpointSettings struct {
+	IPAMConfig *endpointIPAMConfig `json:",omitempty"`
+	Aliases    []string            `json:",omitempty"`
+}
+
+type endpointIPAMConfig struct {
+	IPv4Address string `json:",omitempty"`
+	IPv6Address string `json:",omitempty"`
+}
+
+// EnsureNetwork creates the network described by spec unless a network by
+// that name already exists, in which case it is checked for the requested
+// address families.
+func EnsureNetwork(config CmdConfig, spec NetworkSpec) error {
+	network, err := inspectNetwork(config.DockerEndpoint, spec.Name)
+	if err == nil {
+		if (spec.EnableIPv6 || spec.IPv6Only) && !network.EnableIPv6 {
+			return ErrNetworkNoIPv6
+		}
+		return nil
+	} else if err != ErrNetworkNotFound {
+		return err
+	}
+
+	create := networkResource{
+		Name:       spec.Name,
+		Driver:     spec.Driver,
+		EnableIPv6: spec.EnableIPv6 || spec.IPv6Only,
+	}
+	if spec.IPv6Only {
+		enableIPv4 := false
+		create.EnableIPv4 = &enableIPv4
+	}
+	for _, subnet := range spec.Subnets {
+		ip, _, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return err
+		}
+		if ip.To4() == nil && !create.EnableIPv6 {
+			return fmt.Errorf("IPv6 subnet %s requires EnableIPv6", subnet)
+		}
+		if ip.To4() != nil && spec.IPv6Only {
+			return fmt.Errorf("IPv4 subnet %s on an IPv6 only network", subnet)
+		}
+		create.IPAM.Config = append(create.IPAM.Config, ipamConfig{Subnet: subnet})
+	}
+
+	log.Debugf("creating network %s", spec.Name)
+	if _, err := doJSON("POST", config.DockerEndpoint, "/networks/create", create, nil); err != nil {
+		log.Errorf(" -> error creating network %s: %s", spec.Name, err)
+		return err
+	}
+	log.Debugf(" -> network %s created", spec.Name)
+	return nil
+}
+
+// CheckNetwork validates opts against the network it names, so that
+// misconfigured addressing fails before a container is created. Requesting
+// an IPv4 address on an IPv6 only network, or an IPv6 address on a network
+// without IPv6, is an error.
+func CheckNetwork(config CmdConfig, opts NetworkOptions) error {
+	network, err := inspectNetwork(config.DockerEndpoint, opts.Name)
+	if err != nil {
+		return err
+	}
+
+	var subnets4, subnets6 []*net.IPNet
+	for _, c := range network.IPAM.Config {
+		_, subnet, err := net.ParseCIDR(c.Subnet)
+		if err != nil {
+			continue
+		}
+		if subnet.IP.To4() != nil {
+			subnets4 = append(subnets4, subnet)
+		} else {
+			subnets6 = append(subnets6, subnet)
+		}
+	}
+	ipv4Disabled := network.EnableIPv4 != nil && !*network.EnableIPv4
+
+	if opts.IPv4Address != "" {
+		if ipv4Disabled {
+			return ErrNetworkNoIPv4
+		}
+		if err := checkStaticAddress(opts.IPv4Address, true, subnets4); err != nil {
+			return err
+		}
+	}
+	if opts.IPv6Address != "" {
+		if !network.EnableIPv6 {
+			return ErrNetworkNoIPv6
+		}
+		if err := checkStaticAddress(opts.IPv6Address, false, subnets6); err != nil {
+			return err
+		}
+	}
+	if ipv4Disabled && !network.EnableIPv6 {
+		return fmt.Errorf("network %s has no address family enabled", opts.Name)
+	}
+	return nil
+}
+
+func checkStaticAddress(address string, ipv4 bool, subnets []*net.IPNet) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	if (ip.To4() != nil) != ipv4 {
+		return fmt.Errorf("address %s is of the wrong family", address)
+	}
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("address %s is not in a configured subnet of the network", address)
+}
+
+func inspectNetwork(endpoint, name string) (*networkResource, error) {
+	log.Debugf("inspecting network %s", name)
+	var network networkResource
+	status, err := doJSON("GET", endpoint, "/networks/"+url.QueryEscape(name), nil, &network)
+	if status == 404 {
+		log.Errorf(" -> network %s not found", name)
+		return nil, ErrNetworkNotFound
+	} else if err != nil {
+		log.Errorf(" -> error inspecting network %s: %s", name, err)
+		return nil, err
+	}
+	log.Debugf(" -> network %s inspect success", name)
+	return &network, nil
+}
+
+func (n *NetworkOptions) networkingConfig() *networkingConfig {
+	settings := &endpointSettings{Aliases: n.Aliases}
+	if n.IPv4Address != "" || n.IPv6Address != "" {
+		settings.IPAMConfig = &endpointIPAMConfig{
+			IPv4Address: n.IPv4Address,
+			IPv6Address: n.IPv6Address,
+		}
+	}
+	return &networkingConfig{
+		EndpointsConfig: map[string]*endpointSettings{n.Name: settings},
+	}
+}
+
+// DefaultDNSDomain is a suggested NetworkOptions.DNSDomain.
+const DefaultDNSDomain = "libcmd.local"
+
+// RunDNSName returns the name under which the container of run runID is
+// reachable from other containers on its network when DNSDomain is set.
+// Characters of the run ID that are not allowed in a host name are replaced
+// with dashes.
+func RunDNSName(runID, domain string) string {
+	label := []byte("cmd-" + strings.ToLower(runID))
+	for i, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			label[i] = '-'
+		}
+	}
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return strings.TrimRight(string(label), "-") + "." + domain
+}
+
+// withRunDNSName returns opts with the DNS name of the run added to its
+// aliases.
+func withRunDNSName(opts *NetworkOptions, runID string) (*NetworkOptions, string, error) {
+	if opts.DNSDomain == "" {
+		return opts, "", nil
+	}
+	if opts.Name == "" {
+		return nil, "", ErrDNSNameNoNetwork
+	}
+	name := RunDNSName(runID, opts.DNSDomain)
+	withName := *opts
+	withName.Aliases = append(append([]string{}, opts.Aliases...), name)
+	return &withName, name, nil
+}
+
+// applyNetwork sets the network mode of hostConfig for opts.
+func applyNetwork(hostConfig *HostConfig, opts *NetworkOptions) {
+	if opts.Name != "" {
+		hostConfig.NetworkMode = opts.Name
+	} else if opts.Mode != "" {
+		hostConfig.NetworkMode = opts.Mode
+	}
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrNamedPipeUnsupported = errors.New("named pipe docker endpoints are only supported on windows")
+)
+
+// isNamedPipe reports whether endpoint is a windows named pipe, such as
+// npipe:////./pipe/docker_engine.
+func isNamedPipe(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "npipe://")
+}
+
+// pipePath converts the path of an npipe URL to a windows pipe path.
+func pipePath(u *url.URL) string {
+	return strings.Replace(u.Path, "/", `\`, -1)
+}
+
+// newPipeClient returns a docker client that sends its requests over the
+// named pipe. The vendored client does not know npipe endpoints, so it is
+// given a placeholder http endpoint and a transport that ignores it.
+// Requests that hijack the connection, exec and attach, cannot use the
+// transport and fail.
+func newPipeClient(endpoint string) (*docker.Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, docker.ErrInvalidEndpoint
+	}
+	client, err := docker.NewClient("http://docker")
+	if err != nil {
+		return nil, err
+	}
+	client.HTTPClient = &http.Client{Transport: pipeTransport(pipePath(u))}
+	return client, nil
+}
+
+// pipeTransport opens the pipe for each request and reads the response only
+// once the request is written, as the pipe is opened for synchronous I/O.
+type pipeTransport string
+
+func (t pipeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := dialPipe(string(t))
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = pipeBody{resp.Body, conn}
+	return resp, nil
+}
+
+type pipeBody struct {
+	body io.ReadCloser
+	conn io.Closer
+}
+
+func (b pipeBody) Read(p []byte) (int, error) {
+	return b.body.Read(p)
+}
+
+func (b pipeBody) Close() error {
+	b.body.Close()
+	return b.conn.Close()
+}
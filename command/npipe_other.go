@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"net"
+)
+
+func dialPipe(path string) (net.Conn, error) {
+	return nil, ErrNamedPipeUnsupported
+}
@@ -0,0 +1,33 @@
+package command
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// dialPipe opens the named pipe at path.
+func dialPipe(path string) (net.Conn, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeConn{f}, nil
+}
+
+// pipeConn adapts an open pipe to net.Conn for httputil.ClientConn.
+// Deadlines are not supported on pipes opened for synchronous I/O.
+type pipeConn struct {
+	*os.File
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "npipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+func (c *pipeConn) LocalAddr() net.Addr                { return pipeAddr(c.Name()) }
+func (c *pipeConn) RemoteAddr() net.Addr               { return pipeAddr(c.Name()) }
+func (c *pipeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
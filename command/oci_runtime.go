@@ -0,0 +1,58 @@
+package command
+
+import (
+	"errors"
+)
+
+const (
+	// RuntimeGVisor and RuntimeKata are the usual names of the gVisor and
+	// Kata Containers runtimes in the daemon configuration.
+	RuntimeGVisor = "runsc"
+	RuntimeKata   = "kata-runtime"
+)
+
+var (
+	ErrRuntimeConflict = errors.New("devices require a different container runtime")
+	ErrRuntimePinned   = errors.New("command is pinned to a different container runtime")
+)
+
+// ociRuntime picks the OCI runtime the command is pinned to, then that of
+// the run, then CmdConfig.OCIRuntime. A run cannot move a pinned command to
+// another runtime. Empty uses the daemon's default runtime, usually runc.
+func ociRuntime(config CmdConfig, def *CommandDef, opts RunOptions) (string, error) {
+	if def != nil && def.OCIRuntime != "" {
+		if opts.OCIRuntime != "" && opts.OCIRuntime != def.OCIRuntime {
+			return "", ErrRuntimePinned
+		}
+		return def.OCIRuntime, nil
+	}
+	if opts.OCIRuntime != "" {
+		return opts.OCIRuntime, nil
+	}
+	return config.OCIRuntime, nil
+}
+
+// applyOCIRuntime checks that the runtime of the run is registered on the
+// daemon and sets it on hostConfig. Device options that need another
+// runtime conflict with it.
+func applyOCIRuntime(hostConfig *HostConfig, config CmdConfig, def *CommandDef, opts RunOptions) error {
+	runtime, err := ociRuntime(config, def, opts)
+	if err != nil {
+		return err
+	}
+	if runtime == "" {
+		return nil
+	}
+	if devices := opts.Devices; devices != nil {
+		for _, required := range devices.requiredRuntimes() {
+			if required != runtime {
+				return ErrRuntimeConflict
+			}
+		}
+	}
+	if err := CheckRuntimes(config, []string{runtime}); err != nil {
+		return err
+	}
+	hostConfig.Runtime = runtime
+	return nil
+}
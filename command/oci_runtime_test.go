@@ -0,0 +1,49 @@
+package command
+
+import "testing"
+
+func TestOCIRuntime(t *testing.T) {
+	pinned := &CommandDef{Op: "untrusted", OCIRuntime: RuntimeGVisor}
+	tests := []struct {
+		config string
+		def    *CommandDef
+		run    string
+		want   string
+		err    error
+	}{
+		{"", nil, "", "", nil},
+		{RuntimeKata, nil, "", RuntimeKata, nil},
+		{RuntimeKata, nil, "runc", "runc", nil},
+		{"", &CommandDef{Op: "plain"}, RuntimeKata, RuntimeKata, nil},
+		{RuntimeKata, pinned, "", RuntimeGVisor, nil},
+		{"", pinned, RuntimeGVisor, RuntimeGVisor, nil},
+		{"", pinned, "runc", "", ErrRuntimePinned},
+	}
+	for i, test := range tests {
+		got, err := ociRuntime(CmdConfig{OCIRuntime: test.config}, test.def, RunOptions{OCIRuntime: test.run})
+		if got != test.want || err != test.err {
+			t.Errorf("%d: got %q, %v, want %q, %v", i, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestRunRefusesToMovePinnedCommand(t *testing.T) {
+	rt := newFakeRuntime()
+	if err := RegisterCommand(CommandDef{Op: "untrusted", OCIRuntime: RuntimeGVisor}); err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterCommand("untrusted")
+
+	if _, err := Run("untrusted", fakeConfig(), nil, RunOptions{OCIRuntime: "runc"}); err != ErrRuntimePinned {
+		t.Errorf("got error %v, want %v", err, ErrRuntimePinned)
+	}
+	if specs := rt.created(); len(specs) != 0 {
+		t.Errorf("refused run created %d containers", len(specs))
+	}
+}
+
+func unregisterCommand(op string) {
+	registryMu.Lock()
+	delete(registry, op)
+	registryMu.Unlock()
+}
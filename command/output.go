@@ -0,0 +1,275 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+const truncationMarker = "[libcmd: output truncated"
+
+// TruncateStrategy decides which part of an oversized output is kept.
+type TruncateStrategy int
+
+const (
+	// TruncateKeepHead keeps the start of the output.
+	TruncateKeepHead TruncateStrategy = iota
+	// TruncateKeepTail keeps the end of the output, which is usually where
+	// a failing script reports its error.
+	TruncateKeepTail
+)
+
+// OutputLimits caps how much of a command's stdout and stderr is held in
+// memory. A limit of zero or less is unlimited.
+type OutputLimits struct {
+	MaxStdout int
+	MaxStderr int
+	Strategy  TruncateStrategy
+}
+
+var (
+	// DefaultOutputLimits apply to runs that do not set
+	// RunOptions.OutputLimits.
+	DefaultOutputLimits = OutputLimits{
+		MaxStdout: 16 << 20,
+		MaxStderr: 16 << 20,
+		Strategy:  TruncateKeepHead,
+	}
+)
+
+func (opts RunOptions) outputLimits() OutputLimits {
+	if opts.OutputLimits != nil {
+		return *opts.OutputLimits
+	}
+	return DefaultOutputLimits
+}
+
+// OutputTruncated reports whether output was cut short by OutputLimits,
+// from the marker the limits leave in it. Output that prints the marker
+// itself is reported as truncated too, so RunContext.Truncated and
+// Result.Truncated, which count the dropped bytes, are exact where they
+// are available.
+func OutputTruncated(output []string) bool {
+	for _, line := range output {
+		if strings.Contains(line, truncationMarker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Output is the stdout and stderr of a command held within OutputLimits.
+// StdoutDropped and StderrDropped count the bytes the limits dropped, which
+// the marker left in the text only describes to people reading it.
+type Output struct {
+	Stdout        string
+	Stderr        string
+	StdoutDropped int64
+	StderrDropped int64
+}
+
+// Truncated reports whether the limits dropped any of the output.
+func (o Output) Truncated() bool {
+	return o.StdoutDropped > 0 || o.StderrDropped > 0
+}
+
+func limitedOutput(stdout, stderr *limitedBuffer) Output {
+	return Output{
+		Stdout:        stdout.String(),
+		Stderr:        stderr.String(),
+		StdoutDropped: stdout.dropped,
+		StderrDropped: stderr.dropped,
+	}
+}
+
+// limitedBuffer is an io.Writer that holds at most max bytes and counts the
+// bytes it drops. Keeping the tail, it is a ring that overwrites its oldest
+// bytes once full.
+type limitedBuffer struct {
+	max      int
+	keepTail bool
+	buf      []byte
+	// start is where the oldest byte is once the ring has wrapped.
+	start   int
+	dropped int64
+}
+
+func newLimitedBuffer(max int, strategy TruncateStrategy) *limitedBuffer {
+	return &limitedBuffer{max: max, keepTail: strategy == TruncateKeepTail}
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max <= 0 {
+		b.buf = append(b.buf, p...)
+		return n, nil
+	}
+	if !b.keepTail {
+		room := b.max - len(b.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		if room > 0 {
+			b.buf = append(b.buf, p[:room]...)
+		}
+		b.dropped += int64(len(p) - room)
+		return n, nil
+	}
+
+	if len(p) >= b.max {
+		b.dropped += int64(len(b.buf) + len(p) - b.max)
+		b.buf = append(b.buf[:0], p[len(p)-b.max:]...)
+		b.start = 0
+		return n, nil
+	}
+	if room := b.max - len(b.buf); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.buf = append(b.buf, p[:room]...)
+		p = p[room:]
+	}
+	for len(p) > 0 {
+		copied := copy(b.buf[b.start:], p)
+		b.dropped += int64(copied)
+		b.start = (b.start + copied) % b.max
+		p = p[copied:]
+	}
+	return n, nil
+}
+
+func (b *limitedBuffer) String() string {
+	kept := string(b.buf[b.start:]) + string(b.buf[:b.start])
+	if b.dropped == 0 {
+		return kept
+	}
+	marker := fmt.Sprintf("%s, %d bytes omitted]", truncationMarker, b.dropped)
+	if b.keepTail {
+		return marker + "\n" + kept
+	}
+	return kept + "\n" + marker
+}
+
+// OutputCapture holds the stdout and stderr of a command within
+// OutputLimits, for runtimes registered with RegisterRuntime that read the
+// output of their commands themselves, such as from a CLI. Each write is
+// passed to onChunk, in the order the writes happened.
+type OutputCapture struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	stdout *limitedBuffer
+	stderr *limitedBuffer
+}
+
+// NewOutputCapture returns a capture for limits. onChunk may be nil.
+func NewOutputCapture(limits OutputLimits, onChunk func(stdcopy.Chunk)) *OutputCapture {
+	c := &OutputCapture{
+		stdout: newLimitedBuffer(limits.MaxStdout, limits.Strategy),
+		stderr: newLimitedBuffer(limits.MaxStderr, limits.Strategy),
+	}
+	c.Stdout, c.Stderr = c.stdout, c.stderr
+	if onChunk != nil {
+		transcript := &transcriptRecorder{record: onChunk}
+		c.Stdout = io.MultiWriter(c.stdout, transcript.writer(stdcopy.Stdout))
+		c.Stderr = io.MultiWriter(c.stderr, transcript.writer(stdcopy.Stderr))
+	}
+	return c
+}
+
+// Output returns what was captured, as Runtime.Logs does.
+func (c *OutputCapture) Output() Output {
+	return limitedOutput(c.stdout, c.stderr)
+}
+
+// chunkRecorder returns the function that receives the output chunks of the
+// run, or nil when neither a transcript nor OnOutput was asked for. The
+// transcript stops growing once it holds as much as the output limits,
+// unless the run spills it to the OnTranscript hooks. OnOutput receives the
+// chunks as they are, and the transcript has the run's secrets masked.
+func chunkRecorder(rc *RunContext, opts RunOptions) func(stdcopy.Chunk) {
+	if !opts.RecordTranscript && opts.OnOutput == nil {
+		return nil
+	}
+	limits := opts.outputLimits()
+	max := limits.MaxStdout + limits.MaxStderr
+	recorded := 0
+	record := func(chunk stdcopy.Chunk) {
+		full := limits.MaxStdout > 0 && limits.MaxStderr > 0 && recorded+len(chunk.Data) > max
+		if full && rc.uncappedTranscript && spillTranscript(rc) {
+			recorded, full = 0, false
+		}
+		if full && !rc.uncappedTranscript {
+			rc.TranscriptTruncated = true
+		} else {
+			rc.Transcript = append(rc.Transcript, chunk)
+			recorded += len(chunk.Data)
+		}
+	}
+	// Secrets are masked before the transcript reaches the OnTranscript
+	// hooks, whose parts cannot be masked afterwards.
+	var redactor *chunkRedactor
+	if opts.RecordTranscript && len(longestFirst(opts.Secrets)) > 0 {
+		redactor = newChunkRedactor(opts.Secrets)
+		rc.flushTranscript = func() {
+			for _, masked := range redactor.flush() {
+				record(masked)
+			}
+		}
+	}
+	return func(chunk stdcopy.Chunk) {
+		if redactor != nil {
+			for _, masked := range redactor.redact(chunk) {
+				record(masked)
+			}
+		} else if opts.RecordTranscript {
+			record(chunk)
+		}
+		if opts.OnOutput != nil {
+			opts.OnOutput(chunk)
+		}
+	}
+}
+
+// maxDecodeErrorOutput is how much of the output a DecodeError message
+// quotes. Output holds all of it.
+const maxDecodeErrorOutput = 512
+
+// DecodeError is returned by DecodeJSON when the output of a command is not
+// the JSON the caller expected.
+type DecodeError struct {
+	Op     string
+	Output string
+	// Truncated is set when OutputLimits cut the output short, which is
+	// the likely reason it does not decode.
+	Truncated bool
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	output := e.Output
+	if len(output) > maxDecodeErrorOutput {
+		output = output[:maxDecodeErrorOutput] + "..."
+	}
+	if e.Truncated {
+		return fmt.Sprintf("decoding truncated output of %s: %s: %q", e.Op, e.Err, output)
+	}
+	return fmt.Sprintf("decoding output of %s: %s: %q", e.Op, e.Err, output)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeJSON unmarshals the output of a run of op into v, returning a
+// *DecodeError with the raw output if it does not decode.
+func DecodeJSON(op string, output []string, v interface{}) error {
+	raw := strings.Join(output, "\n")
+	if err := json.Unmarshal([]byte(raw), v); err != nil {
+		return &DecodeError{Op: op, Output: raw, Truncated: OutputTruncated(output), Err: err}
+	}
+	return nil
+}
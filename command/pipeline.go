@@ -0,0 +1,312 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type PipeStage struct {
+	Op   string
+	Args []string
+}
+
+type StageResult struct {
+	Stage    PipeStage
+	RunID    string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+// PipelineError reports the first stage of a pipeline that failed.
+type PipelineError struct {
+	Stage int
+	Op    string
+	Err   error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline stage %d (%s): %s", e.Stage, e.Op, e.Err)
+}
+
+// Pipeline runs container commands concurrently, streaming the stdout of
+// each stage into the stdin of the next one. Every stage is a run of its
+// own, with a run ID, hooks, records and metrics.
+type Pipeline struct {
+	stages       []PipeStage
+	config       CmdConfig
+	dockerClient *docker.Client
+	workspace    string
+	caller       string
+	limits       *OutputLimits
+}
+
+func NewPipeline(config CmdConfig, dockerClient *docker.Client, stages ...PipeStage) (*Pipeline, error) {
+	if err := CheckDockerBackend(config, dockerClient); err != nil {
+		return nil, err
+	}
+	for _, stage := range stages {
+		if !isContainerCommand(stage.Op) {
+			return nil, ErrCommandNotFound
+		}
+		if err := CheckArgs(stage.Op, stage.Args); err != nil {
+			return nil, err
+		}
+	}
+	return &Pipeline{stages: stages, config: config, dockerClient: dockerClient}, nil
+}
+
+// WithWorkspace mounts a workspace volume at target in every stage, so that
+// stages can share files as well as stdout. The volume is created when the
+// pipeline runs and removed when it ends.
+func (p *Pipeline) WithWorkspace(target string) *Pipeline {
+	p.workspace = target
+	return p
+}
+
+// WithCaller runs the pipeline for caller, whom the policy registered with
+// SetPolicy must permit to run every stage.
+func (p *Pipeline) WithCaller(caller string) *Pipeline {
+	p.caller = caller
+	return p
+}
+
+// WithOutputLimits caps the stderr of every stage and the stdout of the
+// last one. Defaults to DefaultOutputLimits. The stdout streamed between
+// stages is not held in memory.
+func (p *Pipeline) WithOutputLimits(limits OutputLimits) *Pipeline {
+	p.limits = &limits
+	return p
+}
+
+// Run returns the stdout of the last stage along with a result for every
+// stage. The error is a *PipelineError naming the first failing stage. No
+// stage starts unless the policy permits all of them. Canceling ctx, or a
+// stage failing to start, kills the stages that are running.
+func (p *Pipeline) Run(ctx context.Context) ([]string, []StageResult, error) {
+	results := make([]StageResult, len(p.stages))
+	for i, stage := range p.stages {
+		results[i].Stage = stage
+		if err := CheckPolicy(p.config, RunOptions{Caller: p.caller}, stage.Op, stage.Args); err != nil {
+			return nil, results, &PipelineError{i, stage.Op, err}
+		}
+	}
+	var workspaceBinds []string
+	if p.workspace != "" {
+		volume, err := CreateVolume(p.config, "", nil)
+		if err != nil {
+			return nil, results, err
+		}
+		// Deferred first, so that the volume is removed after the stage
+		// containers that use it.
+		defer RemoveVolume(p.config, volume.Name)
+		if workspaceBinds, err = volumeBinds(p.config, []VolumeMount{{Name: volume.Name, Target: p.workspace}}); err != nil {
+			return nil, results, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The stdout of stage i is written to stdouts[i] and read from
+	// stdins[i+1].
+	stdins := make([]*io.PipeReader, len(p.stages))
+	stdouts := make([]*io.PipeWriter, len(p.stages))
+	for i := 0; i < len(p.stages)-1; i++ {
+		stdins[i+1], stdouts[i] = io.Pipe()
+	}
+
+	var output []string
+	var wg sync.WaitGroup
+	for i, stage := range p.stages {
+		wg.Add(1)
+		go func(i int, stage PipeStage) {
+			defer wg.Done()
+			opts := RunOptions{Caller: p.caller, OutputLimits: p.limits, Context: ctx}
+			rc, stageOutput, err := runWith(stage.Op, p.config, opts, stage.Args, func(opts RunOptions, args []string) ([]string, error) {
+				return p.runStage(ctx, opts.runContext, opts, args, stdins[i], stdouts[i], workspaceBinds)
+			})
+			// Unblock the neighbouring stages if this one never ran.
+			if stdouts[i] != nil {
+				stdouts[i].Close()
+			}
+			if stdins[i] != nil {
+				stdins[i].CloseWithError(io.ErrClosedPipe)
+			}
+			if err != nil && err != ErrCommandResponse {
+				cancel()
+			}
+			results[i].Err = err
+			if rc != nil {
+				results[i].RunID, results[i].ExitCode = rc.RunID, rc.ExitCode
+				results[i].Stderr = strings.TrimSpace(rc.Stderr)
+			}
+			if i == len(p.stages)-1 {
+				output = stageOutput
+			}
+		}(i, stage)
+	}
+	wg.Wait()
+
+	return output, results, firstStageError(results)
+}
+
+// firstStageError returns the first stage that failed, passing over stages
+// that were only killed because another one failed.
+func firstStageError(results []StageResult) error {
+	var canceled error
+	for i, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		stageErr := &PipelineError{i, result.Stage.Op, result.Err}
+		if result.Err != ErrCommandCanceled {
+			return stageErr
+		}
+		if canceled == nil {
+			canceled = stageErr
+		}
+	}
+	return canceled
+}
+
+func (p *Pipeline) runStage(ctx context.Context, rc *RunContext, opts RunOptions, args []string, stdin *io.PipeReader, stdout *io.PipeWriter, workspaceBinds []string) ([]string, error) {
+	def, _ := lookupCommand(rc.Op)
+	rc.HostConfig.Binds = append(rc.HostConfig.Binds, workspaceBinds...)
+	if err := applySecurity(rc.HostConfig, rc.Op, securityProfile(def, opts)); err != nil {
+		return nil, err
+	}
+	if err := applyPrivileged(rc.HostConfig, rc.Op, privilegedOptions(def, opts)); err != nil {
+		return nil, err
+	}
+	if err := applyOCIRuntime(rc.HostConfig, p.config, def, opts); err != nil {
+		return nil, err
+	}
+	spec := ContainerSpec{
+		Op:    rc.Op,
+		RunID: rc.RunID,
+		Config: &docker.Config{
+			Image:     fmt.Sprintf("%s:%s", p.config.ContainerRepository, p.config.ContainerTag),
+			Cmd:       scriptCmdParts(p.config, rc.Op, args),
+			Env:       containerEnv(p.config, nil),
+			OpenStdin: stdin != nil,
+			StdinOnce: stdin != nil,
+			User:      p.config.User,
+		},
+		HostConfig: rc.HostConfig,
+		Network:    networkOptions(def, opts),
+	}
+	rc.Image = spec.Config.Image
+	container, err := createContainerFromOptions(p.config, spec)
+	if err != nil {
+		return nil, err
+	}
+	defer removeContainer(p.dockerClient, container.ID)
+	rc.ContainerID = container.ID
+	if err := runHooks(rc, containerCreatedHook); err != nil {
+		return nil, err
+	}
+
+	capture := NewOutputCapture(opts.outputLimits(), chunkRecorder(rc, opts))
+	var out io.Writer = capture.Stdout
+	if stdout != nil {
+		out = stdout
+	}
+	attachErrCh, err := attachContainer(p.dockerClient, container.ID, stdin, out, capture.Stderr, func() {
+		if stdout != nil {
+			stdout.Close()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := startContainer(p.dockerClient, container.ID); err != nil {
+		return nil, err
+	}
+	if err := runHooks(rc, startedHook); err != nil {
+		killContainer(p.dockerClient, container.ID)
+		return nil, err
+	}
+
+	waitCh := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := waitContainer(p.dockerClient, container.ID)
+		waitCh <- waitResult{exitCode, err}
+	}()
+	canceled := false
+	done := ctx.Done()
+	var result waitResult
+	for waiting := true; waiting; {
+		select {
+		case result = <-waitCh:
+			waiting = false
+		case <-done:
+			canceled = true
+			done = nil
+			killContainer(p.dockerClient, container.ID)
+		}
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	rc.ExitCode = result.exitCode
+	if err := <-attachErrCh; err != nil {
+		return nil, err
+	}
+	output := capture.Output()
+	rc.captureStreams(output)
+
+	if canceled {
+		return []string{strings.TrimSpace(output.Stderr)}, ErrCommandCanceled
+	}
+	if result.exitCode == 0 {
+		return []string{strings.TrimSpace(output.Stdout)}, nil
+	}
+	return []string{strings.TrimSpace(output.Stderr)}, ErrCommandResponse
+}
+
+// attachContainer attaches to the container streams before it is started.
+// done is called once the container's output streams are closed.
+func attachContainer(client *docker.Client, containerID string, stdin *io.PipeReader, stdout, stderr io.Writer, done func()) (chan error, error) {
+	log.Debugf("attaching to container %s", containerID)
+	success := make(chan struct{})
+	errCh := make(chan error, 1)
+	opts := docker.AttachToContainerOptions{
+		Container:    containerID,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Stdout:       true,
+		Stderr:       true,
+		Stream:       true,
+		Success:      success,
+	}
+	if stdin != nil {
+		opts.InputStream = stdin
+		opts.Stdin = true
+	}
+	go func() {
+		err := client.AttachToContainer(opts)
+		if stdin != nil {
+			// Unblock the previous stage if this one exited early.
+			stdin.CloseWithError(io.ErrClosedPipe)
+		}
+		done()
+		errCh <- err
+	}()
+
+	select {
+	case <-success:
+		success <- struct{}{}
+	case err := <-errCh:
+		log.Errorf(" -> error attaching to container %s: %s", containerID, err)
+		return nil, err
+	}
+	log.Debugf(" -> attached to container %s", containerID)
+	return errCh, nil
+}
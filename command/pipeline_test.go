@@ -0,0 +1,104 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func newTestPipeline(t *testing.T, stages ...PipeStage) *Pipeline {
+	client, err := docker.NewClient("tcp://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPipeline(scriptConfig(), client, stages...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPipelineRunsEachStageThroughRunLifecycle(t *testing.T) {
+	p := newTestPipeline(t, PipeStage{Op: "raw", Args: []string{"ls"}}, PipeStage{Op: "cert", Args: []string{"check"}})
+
+	var mu sync.Mutex
+	seen := map[string]*RunContext{}
+	withHooks(Hooks{OnBeforeRun: func(rc *RunContext) error {
+		mu.Lock()
+		seen[rc.Op] = rc
+		mu.Unlock()
+		return errAbortRun
+	}}, func() {
+		_, results, err := p.WithCaller("deploy").Run(context.Background())
+		var pipelineErr *PipelineError
+		if !errors.As(err, &pipelineErr) || pipelineErr.Stage != 0 || pipelineErr.Err != errAbortRun {
+			t.Errorf("got error %v", err)
+		}
+		for i, result := range results {
+			if result.RunID == "" || result.Err != errAbortRun {
+				t.Errorf("stage %d: got run %q, error %v", i, result.RunID, result.Err)
+			}
+		}
+		if results[0].RunID == results[1].RunID {
+			t.Errorf("stages share run ID %s", results[0].RunID)
+		}
+	})
+	for _, op := range []string{"raw", "cert"} {
+		if rc := seen[op]; rc == nil || rc.Options.Caller != "deploy" {
+			t.Errorf("stage %s did not run for the caller: %v", op, rc)
+		}
+	}
+}
+
+func TestPipelineRefusesWhenAnyStageIsNotPermitted(t *testing.T) {
+	p := newTestPipeline(t, PipeStage{Op: "raw"}, PipeStage{Op: "cert"})
+	SetPolicy(AllowlistPolicy(map[string][]string{"deploy": {"raw"}}))
+	defer SetPolicy(nil)
+
+	started := false
+	withHooks(Hooks{OnBeforeRun: func(rc *RunContext) error {
+		started = true
+		return errAbortRun
+	}}, func() {
+		_, _, err := p.WithCaller("deploy").Run(context.Background())
+		var pipelineErr *PipelineError
+		if !errors.As(err, &pipelineErr) || pipelineErr.Stage != 1 || pipelineErr.Err != ErrNotPermitted {
+			t.Errorf("got error %v", err)
+		}
+	})
+	if started {
+		t.Errorf("a stage started although another was not permitted")
+	}
+}
+
+func TestFirstStageError(t *testing.T) {
+	failed := errors.New("create failed")
+	tests := []struct {
+		errs  []error
+		stage int
+	}{
+		{[]error{nil, nil}, -1},
+		{[]error{nil, ErrCommandResponse, failed}, 1},
+		{[]error{ErrCommandCanceled, failed, ErrCommandCanceled}, 1},
+		{[]error{nil, ErrCommandCanceled, ErrCommandCanceled}, 1},
+	}
+	for i, test := range tests {
+		results := make([]StageResult, len(test.errs))
+		for j, err := range test.errs {
+			results[j].Err = err
+		}
+		err := firstStageError(results)
+		if test.stage < 0 {
+			if err != nil {
+				t.Errorf("%d: got error %v, want none", i, err)
+			}
+			continue
+		}
+		if pipelineErr, ok := err.(*PipelineError); !ok || pipelineErr.Stage != test.stage {
+			t.Errorf("%d: got error %v, want stage %d", i, err, test.stage)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ImageInfo describes a local image and the platform it was built for.
+type ImageInfo struct {
+	Image        string
+	ID           string
+	OS           string
+	Architecture string
+	Variant      string
+	// RepoDigests are the repository@digest references of the image in
+	// the registries it was pulled from or pushed to.
+	RepoDigests []string
+}
+
+// Platform returns the platform of the image as os/arch[/variant].
+func (i ImageInfo) Platform() string {
+	platform := i.OS + "/" + i.Architecture
+	if i.Variant != "" {
+		platform += "/" + i.Variant
+	}
+	return platform
+}
+
+// PullPlatformImage pulls the variant of repository:tag for platform, such
+// as "linux/arm64". An empty platform pulls the variant matching the daemon,
+// as PullImage does. Platforms require a daemon with API version 1.32 or
+// later.
+//
+// Concurrent pulls of the same image and platform from the same daemon are
+// collapsed into one, whose error every caller receives.
+func PullPlatformImage(config CmdConfig, client *docker.Client, repository, tag, platform string) error {
+	key := strings.Join([]string{config.DockerEndpoint, repository, tag, platform}, "|")
+	pullsMu.Lock()
+	if pull, exists := pulls[key]; exists {
+		pullsMu.Unlock()
+		log.Debugf("waiting for pull of %s:%s in progress", repository, tag)
+		<-pull.done
+		return pull.err
+	}
+	pull := &imagePull{done: make(chan bool)}
+	pulls[key] = pull
+	pullsMu.Unlock()
+
+	pull.err = pullPlatformImage(config, client, repository, tag, platform)
+
+	pullsMu.Lock()
+	delete(pulls, key)
+	pullsMu.Unlock()
+	close(pull.done)
+	return pull.err
+}
+
+var (
+	pullsMu sync.Mutex
+	pulls   = map[string]*imagePull{}
+)
+
+type imagePull struct {
+	done chan bool
+	err  error
+}
+
+func pullPlatformImage(config CmdConfig, client *docker.Client, repository, tag, platform string) error {
+	if platform == "" {
+		return PullImage(client, repository, tag)
+	}
+	image := fmt.Sprintf("%s:%s", repository, tag)
+	log.Debugf("pulling image %s for %s", image, platform)
+	start := time.Now()
+	err := pullPlatform(config.DockerEndpoint, repository, tag, platform)
+	currentMetrics().PullFinished(image, time.Since(start), err)
+	if err != nil {
+		log.Errorf(" -> error pulling image %s for %s: %s", image, platform, err)
+		return err
+	}
+	log.Debugf(" -> pulling image %s for %s complete", image, platform)
+	// Registries without the platform serve another variant rather than
+	// fail the pull.
+	if info, err := InspectImagePlatform(config, image); err == nil && !platformMatches(platform, info.Platform()) {
+		log.Infof("WARNING: image %s is for %s, not %s", image, info.Platform(), platform)
+	}
+	return nil
+}
+
+// pullPlatform pulls with a direct API request because the vendored client
+// cannot send a platform. A failed pull reports its error in the progress
+// stream.
+func pullPlatform(endpoint, repository, tag, platform string) error {
+	q := url.Values{"fromImage": {repository}, "tag": {tag}, "platform": {platform}}
+	resp, closeFn, err := sendRequest("POST", endpoint, "/images/create?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return &docker.Error{Status: resp.StatusCode, Message: string(message)}
+	}
+	return decodePullProgress(fmt.Sprintf("%s:%s", repository, tag), resp.Body)
+}
+
+// InspectImagePlatform returns the ID and platform of the local image.
+func InspectImagePlatform(config CmdConfig, image string) (*ImageInfo, error) {
+	var inspect struct {
+		Id           string
+		Os           string
+		Architecture string
+		Variant      string
+		RepoDigests  []string
+	}
+	if _, err := doJSON("GET", config.DockerEndpoint, "/images/"+image+"/json", nil, &inspect); err != nil {
+		return nil, err
+	}
+	return &ImageInfo{
+		Image:        image,
+		ID:           inspect.Id,
+		OS:           inspect.Os,
+		Architecture: inspect.Architecture,
+		Variant:      inspect.Variant,
+		RepoDigests:  inspect.RepoDigests,
+	}, nil
+}
+
+// createQuery returns the query of a container create request.
+func createQuery(config CmdConfig, name string) string {
+	q := url.Values{}
+	if name != "" {
+		q.Set("name", name)
+	}
+	if config.Platform != "" {
+		q.Set("platform", config.Platform)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// platformMatches reports whether an image built for actual can run as
+// wanted, which may leave out the variant.
+func platformMatches(wanted, actual string) bool {
+	return wanted == actual || strings.Count(wanted, "/") == 1 && strings.HasPrefix(actual, wanted+"/")
+}
@@ -0,0 +1,110 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// RootfulPodmanSocket is where a system-wide Podman service listens.
+const RootfulPodmanSocket = "/run/podman/podman.sock"
+
+var (
+	podmanMu      sync.RWMutex
+	podmanClients = map[*docker.Client]bool{}
+)
+
+// PodmanSocket returns the endpoint of the Podman compatibility socket of
+// this user, under $XDG_RUNTIME_DIR, or of the system-wide service. It
+// returns "" when neither exists.
+func PodmanSocket() string {
+	var candidates []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	candidates = append(candidates, RootfulPodmanSocket)
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return "unix://" + path
+		}
+	}
+	return ""
+}
+
+// NewDockerClient connects to the daemon at config.DockerEndpoint, which may
+// be a Docker daemon, including one on a windows named pipe or behind TLS
+// with config.TLSCertPath, or the Podman compatibility socket. Podman is
+// detected so that the operations it handles differently are adjusted for
+// it.
+func NewDockerClient(config CmdConfig) (*docker.Client, error) {
+	endpoint := config.DockerEndpoint
+	var client *docker.Client
+	var err error
+	if isNamedPipe(endpoint) {
+		client, err = newPipeClient(endpoint)
+	} else if config.TLSCertPath != "" {
+		client, err = newTLSClient(config)
+	} else {
+		client, err = docker.NewClient(endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+	podman, err := IsPodman(config)
+	if err != nil {
+		// The daemon may not be up yet. Detection is retried by the next
+		// client made for the endpoint, such as on reconnect.
+		log.Errorf("error detecting container engine at %s: %s", endpoint, err)
+	}
+	if podman {
+		podmanMu.Lock()
+		podmanClients[client] = true
+		podmanMu.Unlock()
+	}
+	return client, nil
+}
+
+// IsPodman reports whether config.DockerEndpoint is served by Podman rather
+// than Docker.
+func IsPodman(config CmdConfig) (bool, error) {
+	var version struct {
+		Components []struct {
+			Name string
+		}
+	}
+	if _, err := doJSON("GET", config.DockerEndpoint, "/version", nil, &version); err != nil {
+		return false, err
+	}
+	for _, component := range version.Components {
+		if strings.Contains(strings.ToLower(component.Name), "podman") {
+			log.Debugf("docker endpoint %s is podman", config.DockerEndpoint)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isPodmanClient(client *docker.Client) bool {
+	podmanMu.RLock()
+	defer podmanMu.RUnlock()
+	return podmanClients[client]
+}
+
+// prepareRemove kills the container if Podman still runs it, since Podman
+// does not force-remove a running container as Docker does.
+func prepareRemove(client *docker.Client, containerID string) {
+	if !isPodmanClient(client) {
+		return
+	}
+	container, err := client.InspectContainer(containerID)
+	if err != nil || !container.State.Running {
+		return
+	}
+	killContainer(client, containerID)
+	client.WaitContainer(containerID)
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrNotPermitted = errors.New("caller is not permitted to run this command")
+)
+
+// Policy decides whether caller may run op with args. Caller is
+// RunOptions.Caller, and is empty for runs that do not set it.
+type Policy func(caller, op string, args []string) bool
+
+var (
+	policyMu sync.RWMutex
+	policy   Policy
+)
+
+// SetPolicy registers the policy that gates every run: those of Run,
+// sessions, exec pools, pipelines, detached, interactive and break glass
+// runs, and ad-hoc scripts. Until one is set, or after it is reset with
+// nil, every run is permitted.
+func SetPolicy(p Policy) {
+	policyMu.Lock()
+	policy = p
+	policyMu.Unlock()
+}
+
+// AllowlistPolicy permits a caller to run the ops listed for it. The caller
+// "*" lists ops every caller may run, and the op "*" permits every op.
+func AllowlistPolicy(allow map[string][]string) Policy {
+	return func(caller, op string, args []string) bool {
+		for _, allowed := range append(append([]string{}, allow[caller]...), allow["*"]...) {
+			if allowed == op || allowed == "*" {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Authorize returns ErrNotPermitted if the registered policy refuses to let
+// caller run op with args.
+func Authorize(caller, op string, args []string) error {
+	policyMu.RLock()
+	p := policy
+	policyMu.RUnlock()
+	if p == nil || p(caller, op, args) {
+		return nil
+	}
+	log.Errorf("%s is not permitted to run %s", callerName(caller), op)
+	return ErrNotPermitted
+}
+
+// CheckPolicy is Authorize for runs refused before they enter the run
+// lifecycle, such as cached runs and pipelines checking every stage before
+// any starts. A refusal passes through the OnError and OnFinished hooks, so
+// that it is audited like the refusal of any other run.
+func CheckPolicy(config CmdConfig, opts RunOptions, op string, args []string) error {
+	err := Authorize(opts.Caller, op, args)
+	if err != nil {
+		if opts.RunID == "" {
+			opts.RunID, _ = NewID()
+		}
+		rc := newRunContext(op, args, config, opts)
+		rc.Err = err
+		runFinishedHooks(rc)
+	}
+	return err
+}
+
+func callerName(caller string) string {
+	if caller == "" {
+		return "anonymous caller"
+	}
+	return "caller " + caller
+}
@@ -0,0 +1,106 @@
+package command
+
+import (
+	"testing"
+)
+
+func TestAllowlistPolicy(t *testing.T) {
+	allow := AllowlistPolicy(map[string][]string{
+		"deploy": {"raw", "cert"},
+		"admin":  {"*"},
+		"*":      {"random"},
+	})
+	tests := []struct {
+		caller  string
+		op      string
+		allowed bool
+	}{
+		{"deploy", "raw", true},
+		{"deploy", "cert", true},
+		{"deploy", "aws_auth", false},
+		{"deploy", "random", true},
+		{"admin", "aws_auth", true},
+		{"", "random", true},
+		{"", "raw", false},
+		{"other", "raw", false},
+	}
+	for _, test := range tests {
+		if allowed := allow(test.caller, test.op, nil); allowed != test.allowed {
+			t.Errorf("%q running %s: got %t, want %t", test.caller, test.op, allowed, test.allowed)
+		}
+	}
+}
+
+func TestPolicyGatesRuns(t *testing.T) {
+	rt := newFakeRuntime()
+	SetPolicy(AllowlistPolicy(map[string][]string{"deploy": {"raw"}}))
+	defer SetPolicy(nil)
+
+	if _, err := Run("raw", fakeConfig(), nil, RunOptions{Caller: "other"}, "id"); err != ErrNotPermitted {
+		t.Errorf("got error %v for a refused caller, want %v", err, ErrNotPermitted)
+	}
+	if specs := rt.created(); len(specs) != 0 {
+		t.Fatalf("refused run created %d containers", len(specs))
+	}
+	if _, err := Run("raw", fakeConfig(), nil, RunOptions{Caller: "deploy"}, "id"); err != nil {
+		t.Errorf("permitted run failed: %s", err)
+	}
+	if specs := rt.created(); len(specs) != 1 {
+		t.Errorf("permitted run created %d containers, want 1", len(specs))
+	}
+}
+
+func TestPolicySeesRunArgs(t *testing.T) {
+	newFakeRuntime()
+	var seen []string
+	SetPolicy(func(caller, op string, args []string) bool {
+		seen = args
+		return true
+	})
+	defer SetPolicy(nil)
+
+	if _, err := Run("raw", fakeConfig(), nil, RunOptions{}, "echo", "hi"); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	if len(seen) != 2 || seen[0] != "echo" || seen[1] != "hi" {
+		t.Errorf("policy saw args %q", seen)
+	}
+}
+
+func TestCheckPolicyRecordsRefusals(t *testing.T) {
+	SetPolicy(AllowlistPolicy(map[string][]string{"deploy": {"raw"}}))
+	defer SetPolicy(nil)
+
+	var finished []*RunContext
+	withHooks(Hooks{OnFinished: func(rc *RunContext) {
+		finished = append(finished, rc)
+	}}, func() {
+		if err := CheckPolicy(fakeConfig(), RunOptions{Caller: "deploy"}, "raw", nil); err != nil {
+			t.Errorf("permitted run refused: %s", err)
+		}
+		if err := CheckPolicy(fakeConfig(), RunOptions{Caller: "other"}, "raw", []string{"id"}); err != ErrNotPermitted {
+			t.Errorf("got error %v, want %v", err, ErrNotPermitted)
+		}
+	})
+	if len(finished) != 1 {
+		t.Fatalf("finished hooks called %d times, want 1", len(finished))
+	}
+	if rc := finished[0]; rc.Err != ErrNotPermitted || rc.Op != "raw" || rc.Options.Caller != "other" || rc.RunID == "" {
+		t.Errorf("refusal recorded as %s run %q by %q: %v", rc.Op, rc.RunID, rc.Options.Caller, rc.Err)
+	}
+}
+
+func TestRunRecordsImage(t *testing.T) {
+	newFakeRuntime()
+	var image string
+	withHooks(Hooks{OnFinished: func(rc *RunContext) {
+		image = rc.Image
+	}}, func() {
+		if _, err := Run("raw", fakeConfig(), nil, RunOptions{ImageID: "sha256:abc"}, "id"); err != nil {
+			t.Fatalf("run failed: %s", err)
+		}
+	})
+	if image != "sha256:abc" {
+		t.Errorf("recorded image %q, want sha256:abc", image)
+	}
+}
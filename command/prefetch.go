@@ -0,0 +1,88 @@
+package command
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// PrefetchOptions are the settings of Prefetch.
+type PrefetchOptions struct {
+	// Warm creates and removes a container from each image once it is
+	// present, so that the daemon has prepared its filesystem before the
+	// first run.
+	Warm bool
+}
+
+// Prefetch makes images available according to config.PullPolicy, pulling
+// them concurrently, so that the first run of a command is not held up by
+// a pull. It is cheap once the images are present, and so can back a
+// readiness probe. When ctx is done Prefetch returns its error without
+// waiting for pulls in progress, which finish in the background.
+func Prefetch(ctx context.Context, config CmdConfig, client *docker.Client, opts PrefetchOptions, images ...string) error {
+	if err := CheckDockerBackend(config, client); err != nil {
+		return err
+	}
+	rt := &dockerRuntime{config: config, client: client}
+	errCh := make(chan error, 1)
+	go func() {
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			firstErr error
+		)
+		for _, image := range images {
+			wg.Add(1)
+			go func(image string) {
+				defer wg.Done()
+				if err := prefetchImage(ctx, config, rt, client, image, opts); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}(image)
+		}
+		wg.Wait()
+		errCh <- firstErr
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func prefetchImage(ctx context.Context, config CmdConfig, rt *dockerRuntime, client *docker.Client, image string, opts PrefetchOptions) error {
+	log.Debugf("prefetching image %s", image)
+	if err := EnsureImage(config, rt, client, image); err != nil {
+		return err
+	}
+	if opts.Warm && ctx.Err() == nil {
+		if err := warmImage(config, client, image); err != nil {
+			return err
+		}
+	}
+	log.Debugf(" -> image %s prefetched", image)
+	return nil
+}
+
+// warmImage creates and removes a container from image without starting
+// it, which has the daemon prepare the container filesystem.
+func warmImage(config CmdConfig, client *docker.Client, image string) error {
+	container, err := createContainerFromOptions(config, ContainerSpec{
+		Op:         "prefetch",
+		Config:     &docker.Config{Image: image, Cmd: []string{"true"}},
+		HostConfig: newHostConfig(config),
+	})
+	if err != nil {
+		return err
+	}
+	removeContainer(client, container.ID)
+	return nil
+}
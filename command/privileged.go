@@ -0,0 +1,120 @@
+package command
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrPrivilegedNotAllowed = errors.New("privileged commands are not allowed")
+)
+
+var (
+	allowPrivilegedMu sync.RWMutex
+	allowPrivileged   bool
+)
+
+// SetAllowPrivileged honors requests for privileged mode, host namespaces
+// including the host network and user namespace,
+// capabilities beyond docker's defaults, seccomp and AppArmor profiles
+// other than the daemon's and host devices. Until it is set, those runs
+// fail with ErrPrivilegedNotAllowed. It is deliberately not a CmdConfig
+// setting, so that config files and the environment cannot lift the
+// isolation of command containers.
+func SetAllowPrivileged(allow bool) {
+	allowPrivilegedMu.Lock()
+	allowPrivileged = allow
+	allowPrivilegedMu.Unlock()
+}
+
+// checkPrivileged returns ErrPrivilegedNotAllowed if anything is requested
+// while privileged runs are not allowed.
+func checkPrivileged(op string, requested []string) error {
+	if len(requested) == 0 {
+		return nil
+	}
+	allowPrivilegedMu.RLock()
+	allowed := allowPrivileged
+	allowPrivilegedMu.RUnlock()
+	if !allowed {
+		log.Errorf("refusing to run %s with %s: privileged commands are not allowed", op, strings.Join(requested, ", "))
+		return ErrPrivilegedNotAllowed
+	}
+	log.Infof("WARNING: running %s with %s", op, strings.Join(requested, ", "))
+	return nil
+}
+
+// PrivilegedOptions lifts the isolation of the command container. Every
+// option is off by default.
+type PrivilegedOptions struct {
+	Privileged bool
+	// HostPID and HostIPC share the host's PID and IPC namespaces.
+	HostPID bool
+	HostIPC bool
+}
+
+func (p *PrivilegedOptions) requested() []string {
+	var requested []string
+	if p.Privileged {
+		requested = append(requested, "privileged")
+	}
+	if p.HostPID {
+		requested = append(requested, "host pid")
+	}
+	if p.HostIPC {
+		requested = append(requested, "host ipc")
+	}
+	return requested
+}
+
+// privilegedOptions picks the options of the run, then of the command.
+func privilegedOptions(def *CommandDef, opts RunOptions) *PrivilegedOptions {
+	if opts.Privileged != nil {
+		return opts.Privileged
+	}
+	if def != nil {
+		return def.Privileged
+	}
+	return nil
+}
+
+// hostNamespaces lists the host namespaces hostConfig already shares, such
+// as the host network of NetworkOptions{Mode: "host"} and the host user
+// namespace of CmdConfig.UsernsMode.
+func hostNamespaces(hostConfig *HostConfig) []string {
+	var requested []string
+	if hostConfig.NetworkMode == "host" {
+		requested = append(requested, "host network")
+	}
+	if hostConfig.UsernsMode == "host" {
+		requested = append(requested, "host user namespace")
+	}
+	return requested
+}
+
+// applyPrivileged checks the host namespaces hostConfig shares and applies
+// privileged. Every path that starts a container calls it, with a nil
+// privileged if the path has no PrivilegedOptions.
+func applyPrivileged(hostConfig *HostConfig, op string, privileged *PrivilegedOptions) error {
+	requested := hostNamespaces(hostConfig)
+	if privileged != nil {
+		requested = append(privileged.requested(), requested...)
+	}
+	if err := checkPrivileged(op, requested); err != nil {
+		return err
+	}
+	if privileged == nil {
+		return nil
+	}
+	hostConfig.Privileged = privileged.Privileged
+	if privileged.HostPID {
+		hostConfig.PidMode = "host"
+	}
+	if privileged.HostIPC {
+		hostConfig.IpcMode = "host"
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package command
+
+import (
+	"testing"
+)
+
+func TestHostNetworkRequiresAllowPrivileged(t *testing.T) {
+	tests := []struct {
+		network *NetworkOptions
+		allow   bool
+		err     error
+	}{
+		{&NetworkOptions{Mode: "none"}, false, nil},
+		{&NetworkOptions{Mode: "bridge"}, false, nil},
+		{&NetworkOptions{Mode: "host"}, false, ErrPrivilegedNotAllowed},
+		{&NetworkOptions{Mode: "host"}, true, nil},
+	}
+	defer SetAllowPrivileged(false)
+	for _, test := range tests {
+		rt := newFakeRuntime()
+		SetAllowPrivileged(test.allow)
+		_, err := Run("raw", fakeConfig(), nil, RunOptions{Network: test.network}, "id")
+		if err != test.err {
+			t.Errorf("%s network allowed %t: got error %v, want %v", test.network.Mode, test.allow, err, test.err)
+		}
+		if created := len(rt.created()); test.err != nil && created != 0 {
+			t.Errorf("%s network allowed %t: created %d containers", test.network.Mode, test.allow, created)
+		}
+	}
+}
+
+func TestHostUsernsRequiresAllowPrivileged(t *testing.T) {
+	config := fakeConfig()
+	config.UsernsMode = "host"
+	defer SetAllowPrivileged(false)
+	for _, allow := range []bool{false, true} {
+		rt := newFakeRuntime()
+		SetAllowPrivileged(allow)
+		_, err := Run("raw", config, nil, RunOptions{}, "id")
+		if allow && err != nil {
+			t.Errorf("allowed host user namespace refused: %s", err)
+		} else if !allow && (err != ErrPrivilegedNotAllowed || len(rt.created()) != 0) {
+			t.Errorf("host user namespace not refused: %v", err)
+		}
+	}
+}
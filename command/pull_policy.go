@@ -0,0 +1,64 @@
+package command
+
+import (
+	"errors"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Pull policies of CmdConfig.PullPolicy.
+const (
+	// PullMissing pulls an image the daemon does not have.
+	PullMissing = "missing"
+	// PullAlways pulls the image before every run that names it, so that a
+	// moved tag is picked up.
+	PullAlways = "always"
+	// PullNever only runs images the daemon already has.
+	PullNever = "never"
+)
+
+var (
+	ErrImageNotPresent = errors.New("image is not present and the pull policy is never")
+)
+
+// EnsureImage makes image, as repository:tag or repository@digest,
+// available to rt according to config.PullPolicy. Runtimes other than
+// docker cannot be asked whether they have an image, so PullMissing pulls
+// on them every time. Only images docker did not have before are tracked
+// as pulled by libcmd, for PruneImages.
+func EnsureImage(config CmdConfig, rt Runtime, client *docker.Client, image string) error {
+	policy := config.PullPolicy
+	if policy == "" {
+		policy = PullMissing
+	}
+	onDocker := isDockerRuntime(rt)
+	present := false
+	if onDocker {
+		_, err := client.InspectImage(image)
+		if err == nil {
+			present = true
+		} else if err != docker.ErrNoSuchImage {
+			log.Errorf("error inspecting image %s: %s", image, err)
+			return err
+		}
+	}
+	if present && policy != PullAlways {
+		touchImage(image)
+		return nil
+	}
+	if !present && policy == PullNever {
+		log.Errorf("image %s is not present", image)
+		return ErrImageNotPresent
+	}
+	if err := rt.Pull(image); err != nil {
+		return err
+	}
+	if present {
+		touchImage(image)
+	} else if onDocker {
+		trackPulledImage(image)
+	}
+	return nil
+}
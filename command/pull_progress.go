@@ -0,0 +1,97 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+// PullProgress is one progress event of an image pull, as reported by the
+// daemon. Events with a Layer report on that layer, such as "Downloading"
+// or "Extracting" with Current of Total bytes done; those without report
+// on the pull as a whole.
+type PullProgress struct {
+	Image   string
+	Layer   string
+	Status  string
+	Current int64
+	Total   int64
+}
+
+// PullProgressFunc receives the progress events of every pull. It is called
+// from the goroutine decoding the pull and must not block.
+type PullProgressFunc func(PullProgress)
+
+var (
+	pullProgressMu sync.RWMutex
+	pullProgress   PullProgressFunc
+)
+
+// SetPullProgress registers the function that receives pull progress
+// events, such as to render progress bars. nil stops delivering them.
+func SetPullProgress(fn PullProgressFunc) {
+	pullProgressMu.Lock()
+	pullProgress = fn
+	pullProgressMu.Unlock()
+}
+
+// decodePullProgress reads the JSON progress stream of a pull of image to
+// its end, delivering each event to the registered PullProgressFunc and
+// logging those that are not byte counts. It returns the first error the
+// stream reports, since the daemon reports a failed pull there rather than
+// in the response status.
+func decodePullProgress(image string, r io.Reader) error {
+	pullProgressMu.RLock()
+	fn := pullProgress
+	pullProgressMu.RUnlock()
+
+	var pullErr error
+	decoder := json.NewDecoder(r)
+	for {
+		var event struct {
+			ID             string
+			Status         string
+			Error          string
+			ProgressDetail struct {
+				Current int64
+				Total   int64
+			}
+		}
+		if err := decoder.Decode(&event); err == io.EOF {
+			return pullErr
+		} else if err != nil {
+			// Drain the stream so that the writer of a pipe is not blocked.
+			io.Copy(ioutil.Discard, r)
+			if pullErr != nil {
+				return pullErr
+			}
+			return err
+		}
+		if event.Error != "" {
+			if pullErr == nil {
+				pullErr = errors.New(event.Error)
+			}
+			continue
+		}
+		if event.ProgressDetail.Total == 0 {
+			if event.ID != "" {
+				log.Debugf(" -> %s: %s", event.ID, event.Status)
+			} else {
+				log.Debugf(" -> %s", event.Status)
+			}
+		}
+		if fn != nil {
+			fn(PullProgress{
+				Image:   image,
+				Layer:   event.ID,
+				Status:  event.Status,
+				Current: event.ProgressDetail.Current,
+				Total:   event.ProgressDetail.Total,
+			})
+		}
+	}
+}
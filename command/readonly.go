@@ -0,0 +1,80 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReadOnlyOptions run the command with a read-only root filesystem so that
+// scripts cannot modify the command image's filesystem. /tmp and WorkDir
+// are writable in-memory scratch space that is discarded with the
+// container.
+type ReadOnlyOptions struct {
+	// TmpSize limits /tmp, for example "64m". Defaults to "64m".
+	TmpSize string
+	// WorkDir, if set, is mounted writable and used as the working
+	// directory of the command.
+	WorkDir     string
+	WorkDirSize string
+}
+
+func readOnlyOptions(def *CommandDef, opts RunOptions) *ReadOnlyOptions {
+	if opts.ReadOnly != nil {
+		return opts.ReadOnly
+	}
+	if def != nil {
+		return def.ReadOnly
+	}
+	return nil
+}
+
+// applyReadOnly makes the root filesystem of the container read-only and
+// mounts its scratch directories.
+func applyReadOnly(hostConfig *HostConfig, config CmdConfig, opts RunOptions, readOnly *ReadOnlyOptions) {
+	hostConfig.ReadonlyRootfs = true
+	user := containerUser(config, opts)
+	hostConfig.Tmpfs["/tmp"] = scratchTmpfsOptions(readOnly.TmpSize, user)
+	if readOnly.WorkDir != "" {
+		hostConfig.Tmpfs[readOnly.WorkDir] = scratchTmpfsOptions(readOnly.WorkDirSize, user)
+	}
+}
+
+func scratchTmpfsOptions(size, user string) string {
+	if size == "" {
+		size = "64m"
+	}
+	return "rw,nosuid,nodev,size=" + size + tmpfsOwnerOptions(user)
+}
+
+// tmpfsOwnerOptions makes a tmpfs owned by a numeric container user, who
+// could not otherwise write to a mount owned by root.
+func tmpfsOwnerOptions(user string) string {
+	uid, gid, ok := numericUser(user)
+	if !ok {
+		return ""
+	}
+	if gid < 0 {
+		return fmt.Sprintf(",uid=%d", uid)
+	}
+	return fmt.Sprintf(",uid=%d,gid=%d", uid, gid)
+}
+
+// numericUser parses a "uid[:gid]" user. gid is -1 when not given.
+func numericUser(user string) (int, int, bool) {
+	if user == "" {
+		return -1, -1, false
+	}
+	parts := strings.SplitN(user, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return -1, -1, false
+	}
+	gid := -1
+	if len(parts) == 2 {
+		if gid, err = strconv.Atoi(parts[1]); err != nil {
+			return -1, -1, false
+		}
+	}
+	return uid, gid, true
+}
@@ -0,0 +1,217 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	ownerLabel    = "libcmd.owner"
+	opLabel       = "libcmd.op"
+	detachedLabel = "libcmd.detached"
+	processLabel  = "libcmd.process"
+)
+
+var (
+	// ReapMinAge keeps Reap away from containers that were created so
+	// recently that this process may not have recorded them yet.
+	ReapMinAge = time.Minute
+)
+
+var (
+	// thisProcess labels the containers of this process with the host name
+	// and pid, so that Reap can tell whether their process is still alive.
+	thisProcess = processName(hostname(), os.Getpid())
+
+	liveContainersMu sync.Mutex
+	liveContainers   = map[string]bool{}
+)
+
+// trackContainer records the containers this process created and has not
+// yet removed, which Reap leaves alone.
+func trackContainer(containerID string, live bool) {
+	liveContainersMu.Lock()
+	defer liveContainersMu.Unlock()
+	if live {
+		liveContainers[containerID] = true
+	} else {
+		delete(liveContainers, containerID)
+	}
+}
+
+func liveContainerIDs() []string {
+	liveContainersMu.Lock()
+	defer liveContainersMu.Unlock()
+	ids := make([]string, 0, len(liveContainers))
+	for id := range liveContainers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func isLiveContainer(containerID string) bool {
+	liveContainersMu.Lock()
+	defer liveContainersMu.Unlock()
+	return liveContainers[containerID]
+}
+
+// ownerLabeled returns a copy of the container config of opts labeled with
+// the owner, op and run ID.
+func ownerLabeled(config CmdConfig, opts ContainerSpec) *docker.Config {
+	labeled := *opts.Config
+	labeled.Labels = map[string]string{}
+	for k, v := range opts.Config.Labels {
+		labeled.Labels[k] = v
+	}
+	labeled.Labels[ownerLabel] = config.Owner
+	labeled.Labels[processLabel] = thisProcess
+	if opts.Op != "" {
+		labeled.Labels[opLabel] = opts.Op
+	}
+	if opts.RunID != "" {
+		labeled.Labels[runIDLabel] = opts.RunID
+	}
+	return &labeled
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+func processName(host string, pid int) string {
+	return fmt.Sprintf("%s:%d", host, pid)
+}
+
+type processState int
+
+const (
+	processUnknown processState = iota
+	processSelf
+	processRunning
+	processExited
+)
+
+// containerProcess returns the state of the process that created a
+// container from its process label. Processes on other hosts, and
+// containers created before the label, are unknown.
+func containerProcess(label string) processState {
+	if label == thisProcess {
+		return processSelf
+	}
+	i := strings.LastIndex(label, ":")
+	if i < 0 || label[:i] != hostname() {
+		return processUnknown
+	}
+	pid, err := strconv.Atoi(label[i+1:])
+	if err != nil || pid <= 0 {
+		return processUnknown
+	}
+	if processAlive(pid) {
+		return processRunning
+	}
+	return processExited
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// Reap removes the containers labeled with config.Owner that this process
+// is not using, such as those left behind when a previous process crashed
+// between creating and removing a container. Detached commands are kept,
+// as are the containers of other processes on this host that are still
+// alive, and running containers whose process is not known to have exited,
+// so that processes sharing an Owner do not reap each other's runs.
+// Reap returns the IDs of the containers it removed.
+func Reap(config CmdConfig, client *docker.Client) ([]string, error) {
+	if err := CheckDockerBackend(config, client); err != nil {
+		return nil, err
+	}
+	log.Debugf("reaping containers of %s", config.Owner)
+	filters, err := json.Marshal(map[string][]string{
+		"label": {fmt.Sprintf("%s=%s", ownerLabel, config.Owner)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var containers []struct {
+		Id      string
+		Created int64
+		State   string
+		Labels  map[string]string
+	}
+	path := "/containers/json?" + url.Values{"all": {"1"}, "filters": {string(filters)}}.Encode()
+	if _, err := doJSON("GET", config.DockerEndpoint, path, nil, &containers); err != nil {
+		log.Errorf(" -> error listing containers: %s", err)
+		return nil, err
+	}
+
+	reaped := []string{}
+	for _, c := range containers {
+		if c.Labels[ownerLabel] != config.Owner || c.Labels[detachedLabel] == "true" || isLiveContainer(c.Id) {
+			continue
+		}
+		if time.Since(time.Unix(c.Created, 0)) < ReapMinAge {
+			continue
+		}
+		switch containerProcess(c.Labels[processLabel]) {
+		case processRunning:
+			continue
+		case processUnknown:
+			if c.State == "running" {
+				continue
+			}
+		}
+		log.Debugf(" -> reaping orphaned container %s (%s)", c.Id, c.Labels[opLabel])
+		if err := removeContainer(client, c.Id); err != nil {
+			continue
+		}
+		reaped = append(reaped, c.Id)
+	}
+	log.Debugf(" -> reaped %d containers", len(reaped))
+	return reaped, nil
+}
+
+// StartReaper runs Reap every interval until the returned function is
+// called.
+func StartReaper(config CmdConfig, client *docker.Client, interval time.Duration) func() {
+	stopCh := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				Reap(config, client)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stopCh) }) }
+}
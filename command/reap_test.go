@@ -0,0 +1,56 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReapRemovesOrphanedContainers(t *testing.T) {
+	d, config, client := newFakeDocker(t)
+	defer d.Close()
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("cannot run a short-lived process: %s", err)
+	}
+	old := time.Now().Add(-time.Hour).Unix()
+	owned := func(labels map[string]string) map[string]string {
+		labels[ownerLabel] = config.Owner
+		return labels
+	}
+	d.containers = []fakeContainer{
+		{Id: "exited-unknown", Created: old, State: "exited", Labels: owned(map[string]string{processLabel: "elsewhere:1"})},
+		{Id: "running-dead-process", Created: old, State: "running", Labels: owned(map[string]string{processLabel: processName(hostname(), exited.Process.Pid)})},
+		{Id: "running-unknown", Created: old, State: "running", Labels: owned(map[string]string{processLabel: "elsewhere:1"})},
+		{Id: "running-live-process", Created: old, State: "running", Labels: owned(map[string]string{processLabel: processName(hostname(), os.Getppid())})},
+		{Id: "detached", Created: old, State: "exited", Labels: owned(map[string]string{detachedLabel: "true"})},
+		{Id: "young", Created: time.Now().Unix(), State: "exited", Labels: owned(map[string]string{})},
+		{Id: "in-use", Created: old, State: "exited", Labels: owned(map[string]string{processLabel: thisProcess})},
+		{Id: "other-owner", Created: old, State: "exited", Labels: map[string]string{ownerLabel: "someone-else"}},
+	}
+	trackContainer("in-use", true)
+	defer trackContainer("in-use", false)
+
+	reaped, err := Reap(config, client)
+	if err != nil {
+		t.Fatalf("reap failed: %s", err)
+	}
+	sort.Strings(reaped)
+	want := []string{"exited-unknown", "running-dead-process"}
+	if len(reaped) != len(want) || reaped[0] != want[0] || reaped[1] != want[1] {
+		t.Errorf("reaped %q, want %q", reaped, want)
+	}
+	if len(d.containers) != 6 {
+		t.Errorf("%d containers left, want 6", len(d.containers))
+	}
+}
+
+func TestReapRefusesOtherBackends(t *testing.T) {
+	config := fakeConfig()
+	if _, err := Reap(config, nil); err != ErrNotSupportedByRuntime {
+		t.Errorf("got error %v, want %v", err, ErrNotSupportedByRuntime)
+	}
+}
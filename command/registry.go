@@ -0,0 +1,167 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var (
+	ErrInvalidMountParam = errors.New("invalid mount parameter")
+
+	registryMu sync.RWMutex
+	registry   = map[string]*CommandDef{
+		"cert":   {Op: "cert"},
+		"random": {Op: "random"},
+		"raw":    {Op: "raw", Network: &NetworkOptions{Mode: "bridge"}},
+	}
+)
+
+// CommandDef describes a container command, a script named <Op>.sh in the
+// commands directory of the command image unless Script or Binary is set.
+type CommandDef struct {
+	Op string
+	// Mounts are the host paths the command may have mounted. They are the
+	// only mounts a caller can request for the command.
+	Mounts []MountTemplate
+	// Network is the network the command needs. Defaults to
+	// DefaultNetwork.
+	Network *NetworkOptions
+	// Security relaxes or tightens DefaultSecurityProfile for the command.
+	Security *SecurityProfile
+	// ReadOnly runs the command with a read-only root filesystem.
+	ReadOnly *ReadOnlyOptions
+	// Privileged is for maintenance scripts that need privileged mode or
+	// host namespaces. See RunOptions.Privileged.
+	Privileged *PrivilegedOptions
+	// OCIRuntime pins the command to a container runtime registered on the
+	// daemon, such as RuntimeGVisor for untrusted scripts. Runs cannot
+	// move it to another runtime with RunOptions.OCIRuntime.
+	OCIRuntime string
+	// Script is the file of the command under CommandsDir. Defaults to
+	// <op>.sh.
+	Script string
+	// Interpreter runs Script, such as {"sh"} or {"python3", "-u"}.
+	// Defaults to the interpreter of its extension in Interpreters.
+	Interpreter []string
+	// Binary is an executable on the PATH of the command image that is run
+	// with the args in exec form, without a shell or interpreter, instead
+	// of Script. Detached runs and secret files still wrap it in bash.
+	Binary string
+	// ArgPattern is a regular expression every arg of a run must match as a
+	// whole, such as `[A-Za-z0-9._-]+`, for commands that are passed user
+	// input. See CheckArgs.
+	ArgPattern string
+	// Manifest describes the command and its args, which runs are
+	// validated against. See Describe.
+	Manifest *Manifest
+	// Requires lists what the script needs in the command image. Runs fail
+	// with ErrMissingDependency when any of it is missing.
+	Requires []Dependency
+}
+
+// MountTemplate is a bind mount whose host path is a text/template, such as
+// "{{.BackupDir}}", filled in from RunOptions.MountParams. Every parameter
+// used in Source must be listed in Params with a regular expression that
+// the whole value must match.
+type MountTemplate struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+	Params   map[string]string
+}
+
+// RegisterCommand adds a container command, or replaces the definition of
+// an existing one.
+func RegisterCommand(def CommandDef) error {
+	if def.Op == "" {
+		return errors.New("command op is required")
+	}
+	if def.ArgPattern != "" {
+		if _, err := regexp.Compile(def.ArgPattern); err != nil {
+			return fmt.Errorf("arg pattern: %s", err)
+		}
+	}
+	if def.Manifest != nil {
+		if err := def.Manifest.check(); err != nil {
+			return err
+		}
+	}
+	for _, mount := range def.Mounts {
+		if _, err := template.New(def.Op).Parse(mount.Source); err != nil {
+			return err
+		}
+		for name, pattern := range mount.Params {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("mount param %s: %s", name, err)
+			}
+		}
+	}
+	registryMu.Lock()
+	registry[def.Op] = &def
+	registryMu.Unlock()
+	return nil
+}
+
+// UsesCommandImage reports whether op runs in the command image. Go
+// commands run in this process, and ops that are not registered run
+// nowhere.
+func UsesCommandImage(op string) bool {
+	if _, exists := goCommands[op]; exists {
+		return false
+	}
+	_, exists := lookupCommand(op)
+	return exists
+}
+
+func lookupCommand(op string) (*CommandDef, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	def, exists := registry[op]
+	return def, exists
+}
+
+// binds renders the mount templates of the command with params.
+func (def *CommandDef) binds(params map[string]string) ([]string, error) {
+	binds := []string{}
+	for _, mount := range def.Mounts {
+		values := map[string]string{}
+		for name, pattern := range mount.Params {
+			value, exists := params[name]
+			if !exists {
+				return nil, fmt.Errorf("%s: %s is required", ErrInvalidMountParam, name)
+			}
+			if !regexp.MustCompile("^(?:" + pattern + ")$").MatchString(value) {
+				return nil, fmt.Errorf("%s: %s does not match %s", ErrInvalidMountParam, name, pattern)
+			}
+			values[name] = value
+		}
+
+		tmpl, err := template.New(def.Op).Parse(mount.Source)
+		if err != nil {
+			return nil, err
+		}
+		var source bytes.Buffer
+		if err := tmpl.Execute(&source, values); err != nil {
+			return nil, err
+		}
+		// Parameters missing from Params render as "<no value>", and
+		// relative or unclean paths could escape the intended directory.
+		path := source.String()
+		if strings.Contains(path, "<no value>") || !filepath.IsAbs(path) || filepath.Clean(path) != path {
+			return nil, fmt.Errorf("%s: mount source %q is not a clean absolute path", ErrInvalidMountParam, path)
+		}
+
+		bind := path + ":" + mount.Target
+		if mount.ReadOnly {
+			bind += ":ro"
+		}
+		binds = append(binds, bind)
+	}
+	return binds, nil
+}
@@ -0,0 +1,19 @@
+package command
+
+import "testing"
+
+func TestUsesCommandImage(t *testing.T) {
+	tests := []struct {
+		op   string
+		want bool
+	}{
+		{"raw", true},
+		{"random", false},
+		{"no-such-op", false},
+	}
+	for _, test := range tests {
+		if got := UsesCommandImage(test.op); got != test.want {
+			t.Errorf("UsesCommandImage(%q) = %t, want %t", test.op, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"strings"
+	"time"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Result holds both output streams of a run, whatever its exit code,
+// where Run returns only stdout on success and only stderr on failure.
+type Result struct {
+	RunID    string
+	Op       string
+	ExitCode int
+	// Stdout and Stderr are the streams as they were read, untrimmed and
+	// with secrets redacted. Go commands report their output as Stdout on
+	// success and as Stderr on failure.
+	Stdout    string
+	Stderr    string
+	Truncated bool
+	Duration  time.Duration
+	Err       error
+	// Stats is the resource usage of the run when RunOptions.CollectStats
+	// is set.
+	Stats *ResourceStats
+
+	transcript []stdcopy.Chunk
+	// combined is set when the transcript holds all of the output.
+	combined bool
+}
+
+// RunResult is Run returning a *Result. The run records its transcript, so
+// that CombinedOutput keeps stdout and stderr in the order they were
+// written.
+func RunResult(op string, config CmdConfig, dockerClient *docker.Client, opts RunOptions, args ...string) *Result {
+	opts.RecordTranscript = true
+	rc, output, err := run(op, config, dockerClient, opts, args...)
+	if rc == nil {
+		return &Result{RunID: opts.RunID, Op: op, ExitCode: -1, Err: err}
+	}
+	result := &Result{
+		RunID:      rc.RunID,
+		Op:         rc.Op,
+		ExitCode:   rc.ExitCode,
+		Stdout:     rc.Stdout,
+		Stderr:     rc.Stderr,
+		Truncated:  rc.Truncated,
+		Duration:   rc.Duration,
+		Stats:      rc.Stats,
+		Err:        err,
+		transcript: rc.Transcript,
+	}
+	// Exec does not report output as it is read, so runs in ExecContainer
+	// have no transcript.
+	result.combined = rc.captured && !rc.TranscriptTruncated && rc.TranscriptParts == 0 && (len(rc.Transcript) > 0 || rc.Stdout+rc.Stderr == "")
+	if !rc.captured {
+		if err == nil {
+			result.Stdout = strings.Join(output, "\n")
+		} else {
+			result.Stderr = strings.Join(output, "\n")
+		}
+	}
+	return result
+}
+
+// Output returns stdout and the error of the run, as exec.Cmd.Output does.
+func (r *Result) Output() (string, error) {
+	return r.Stdout, r.Err
+}
+
+// CombinedOutput returns stdout and stderr interleaved in the order they
+// were written, and the error of the run. Without a transcript, as for go
+// commands, runs in ExecContainer or when the transcript outgrew the output
+// limits, stderr follows stdout.
+func (r *Result) CombinedOutput() (string, error) {
+	if !r.combined {
+		return r.Stdout + r.Stderr, r.Err
+	}
+	var b strings.Builder
+	for _, chunk := range r.transcript {
+		b.Write(chunk.Data)
+	}
+	return b.String(), r.Err
+}
+
+// SeparateOutput returns stdout, stderr and the error of the run.
+func (r *Result) SeparateOutput() (string, string, error) {
+	return r.Stdout, r.Stderr, r.Err
+}
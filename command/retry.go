@@ -0,0 +1,174 @@
+package command
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// runIDLabel holds RunOptions.RunID. createIDLabel is unique to each
+	// create request, even when a run ID is reused.
+	runIDLabel    = "libcmd.run-id"
+	createIDLabel = "libcmd.create-id"
+	specHashLabel = "libcmd.spec-hash"
+)
+
+var (
+	// DaemonRetries is the number of times a container create or start is
+	// retried after the daemon responds with a 5xx error.
+	DaemonRetries = 3
+	// DaemonRetryBackoff is the wait before the first retry. It doubles
+	// with every further attempt.
+	DaemonRetryBackoff = 500 * time.Millisecond
+)
+
+func isTransientDaemonError(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	apiErr, ok := err.(*docker.Error)
+	return ok && apiErr.Status >= 500
+}
+
+func retryBackoff(attempt int) time.Duration {
+	return DaemonRetryBackoff << uint(attempt)
+}
+
+func newCreateID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// labelCreate labels the container to be created with a unique create ID and a
+// fingerprint of its spec, so that a container created by a request whose
+// response was lost can be found again rather than created twice.
+func labelCreate(body *containerCreateBody) (string, string, error) {
+	config := *body.Config
+	config.Labels = map[string]string{}
+	for k, v := range body.Config.Labels {
+		config.Labels[k] = v
+	}
+	createID := newCreateID()
+	config.Labels[createIDLabel] = createID
+	body.Config = &config
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(b)
+	specHash := hex.EncodeToString(sum[:])
+	config.Labels[specHashLabel] = specHash
+	return createID, specHash, nil
+}
+
+// findCreatedContainer returns the ID of the container created with
+// createID, or an empty string if there is none.
+func findCreatedContainer(endpoint, createID, specHash string) (string, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"label": {fmt.Sprintf("%s=%s", createIDLabel, createID)},
+	})
+	if err != nil {
+		return "", err
+	}
+	var containers []struct {
+		Id     string
+		Labels map[string]string
+	}
+	path := "/containers/json?" + url.Values{"all": {"1"}, "filters": {string(filters)}}.Encode()
+	if _, err := doJSON("GET", endpoint, path, nil, &containers); err != nil {
+		return "", err
+	}
+	for _, c := range containers {
+		if c.Labels[createIDLabel] != createID {
+			continue
+		}
+		if c.Labels[specHashLabel] != specHash {
+			return "", fmt.Errorf("container %s with create id %s does not match the requested spec", c.Id, createID)
+		}
+		return c.Id, nil
+	}
+	return "", nil
+}
+
+// postCreate sends the create request, retrying on transient daemon errors.
+// Before each retry the daemon is asked whether the failed attempt created
+// the container after all, in which case it is adopted.
+func postCreate(endpoint, path string, body containerCreateBody) (string, error) {
+	createID, specHash, err := labelCreate(&body)
+	if err != nil {
+		return "", err
+	}
+	for attempt := 0; ; attempt++ {
+		var created struct {
+			Id string
+		}
+		status, err := doJSON("POST", endpoint, path, body, &created)
+		if err == nil {
+			return created.Id, nil
+		}
+		if status == 404 {
+			return "", docker.ErrNoSuchImage
+		}
+		if !isTransientDaemonError(err) || attempt >= DaemonRetries {
+			return "", err
+		}
+		log.Errorf(" -> transient error creating container, retrying: %s", err)
+		time.Sleep(retryBackoff(attempt))
+		if isConnectionError(err) {
+			if err := waitForDaemon(func() error { return Ping(CmdConfig{DockerEndpoint: endpoint}) }); err != nil {
+				return "", err
+			}
+		}
+
+		id, findErr := findCreatedContainer(endpoint, createID, specHash)
+		if findErr != nil {
+			return "", findErr
+		}
+		if id != "" {
+			log.Debugf(" -> adopting container %s created by failed attempt", id)
+			return id, nil
+		}
+	}
+}
+
+// postStart starts the container, retrying on transient daemon errors unless
+// the container turns out to have started anyway.
+func postStart(client *docker.Client, containerID string) error {
+	for attempt := 0; ; attempt++ {
+		err := client.StartContainer(containerID, nil)
+		if _, ok := err.(*docker.ContainerAlreadyRunning); ok || err == nil {
+			return nil
+		}
+		if !isTransientDaemonError(err) || attempt >= DaemonRetries {
+			return err
+		}
+		log.Errorf(" -> transient error starting container %s, retrying: %s", containerID, err)
+		time.Sleep(retryBackoff(attempt))
+		if isConnectionError(err) {
+			if err := waitForDaemon(client.Ping); err != nil {
+				return err
+			}
+		}
+
+		cntr, inspectErr := client.InspectContainer(containerID)
+		if inspectErr != nil {
+			return inspectErr
+		}
+		if cntr.State.Running || !cntr.State.StartedAt.IsZero() {
+			return nil
+		}
+	}
+}
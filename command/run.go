@@ -0,0 +1,101 @@
+package command
+
+import (
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Run runs op as a go command if one exists and as a container command
+// otherwise, calling the registered hooks and reporting metrics.
+func Run(op string, config CmdConfig, dockerClient *docker.Client, opts RunOptions, args ...string) ([]string, error) {
+	_, output, err := run(op, config, dockerClient, opts, args...)
+	return output, err
+}
+
+// run is Run, also returning the context of the run once it has one.
+func run(op string, config CmdConfig, dockerClient *docker.Client, opts RunOptions, args ...string) (*RunContext, []string, error) {
+	return runWith(op, config, opts, args, func(opts RunOptions, args []string) ([]string, error) {
+		return dispatch(op, config, dockerClient, opts, args...)
+	})
+}
+
+// runWith is run with fn in place of dispatch, for runs that reach the
+// command another way, such as by exec in a session container. fn is given
+// the args as the hooks left them and opts with the run context.
+func runWith(op string, config CmdConfig, opts RunOptions, args []string, fn func(opts RunOptions, args []string) ([]string, error)) (*RunContext, []string, error) {
+	if !beginRun() {
+		return nil, nil, ErrShuttingDown
+	}
+	defer endRun()
+	var err error
+	if opts.RunID == "" {
+		if opts.RunID, err = NewID(); err != nil {
+			return nil, nil, err
+		}
+	} else if err := CheckID(opts.RunID); err != nil {
+		return nil, nil, err
+	}
+	// Templates are rendered before secret references are resolved, so
+	// that secret values are never parsed as templates.
+	if opts.ExpandTemplates {
+		if args, opts.Env, err = expandTemplates(op, opts, args, opts.Env); err != nil {
+			return nil, nil, err
+		}
+	}
+	opts, releaseSecrets, err := resolveSecretRefs(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer releaseSecrets()
+	if len(opts.Secrets) > 0 {
+		defer log.AddSecrets(opts.Secrets...)()
+	}
+	log.Debugf("run %s: %s", opts.RunID, op)
+	rc := newRunContext(op, args, config, opts)
+	startRunSpan(rc, opts.Context)
+	opts.runContext = rc
+	output, err := observeRun(op, func() ([]string, error) {
+		if err := runHooks(rc, beforeRunHook); err != nil {
+			return nil, err
+		}
+		if err := Authorize(opts.Caller, rc.Op, rc.Args); err != nil {
+			return nil, err
+		}
+		return fn(opts, rc.Args)
+	})
+	if err != nil {
+		// Failing scripts often echo the command line they were given.
+		output = redactOutput(output, opts.Secrets)
+	}
+	if rc.flushTranscript != nil {
+		rc.flushTranscript()
+	}
+	rc.Stdout = RedactSecrets(rc.Stdout, opts.Secrets)
+	rc.Stderr = RedactSecrets(rc.Stderr, opts.Secrets)
+	rc.Output = output
+	rc.Err = err
+	rc.Duration = time.Since(rc.Start)
+	endRunSpan(rc)
+	runFinishedHooks(rc)
+	return rc, output, err
+}
+
+func dispatch(op string, config CmdConfig, dockerClient *docker.Client, opts RunOptions, args ...string) ([]string, error) {
+	goCmd, err := NewGoCmd(op, config, dockerClient)
+	if err == nil {
+		defer rejectSignals(opts.signals)()
+		return goCmd.RunWithOptions(opts, args...)
+	}
+	if err != ErrCommandNotFound {
+		return nil, err
+	}
+
+	containerCmd, err := NewContainerCmd(op, config, dockerClient)
+	if err == nil {
+		return containerCmd.RunWithOptions(opts, args...)
+	}
+	return nil, err
+}
@@ -0,0 +1,172 @@
+package command
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrBackendNotFound       = errors.New("backend not registered")
+	ErrNotSupportedByRuntime = errors.New("not supported by the configured runtime")
+)
+
+// Runtime is the container engine that container commands run on. The
+// docker runtime, which also serves Podman through its compatibility
+// socket, is built in. Other engines, such as those of the kube, nerdctl
+// and sshcmd packages, and fakes in tests, are registered with
+// RegisterRuntime and selected by CmdConfig.Backend.
+//
+// Features that use the docker API beyond these operations, such as
+// dependency checks, warm-ups, secret files, sessions, pipelines and
+// detached runs, are only available with the docker runtime.
+type Runtime interface {
+	// Pull makes image, as repository:tag, available to Create.
+	Pull(image string) error
+	// Create creates a container for spec and returns its ID.
+	Create(spec ContainerSpec) (string, error)
+	Start(id string) error
+	// Wait returns the exit code of the container once it has exited.
+	Wait(id string) (int, error)
+	// Logs returns the stdout and stderr of an exited container, passing
+	// each chunk to onChunk if it is not nil.
+	Logs(id string, limits OutputLimits, onChunk func(stdcopy.Chunk)) (Output, error)
+	Kill(id string) error
+	Remove(id string) error
+	// Exec runs cmd in a running container and returns its output and
+	// exit code.
+	Exec(id string, cmd []string, limits OutputLimits) (Output, int, error)
+	// Capabilities reports the features runs on the runtime support. See
+	// BackendCapabilities.
+	Capabilities() Capabilities
+}
+
+var (
+	runtimesMu sync.RWMutex
+	runtimes   = map[string]Runtime{}
+)
+
+// RegisterRuntime makes rt available as the backend named name. The names
+// BackendDocker and BackendLocal are reserved.
+func RegisterRuntime(name string, rt Runtime) {
+	runtimesMu.Lock()
+	runtimes[name] = rt
+	runtimesMu.Unlock()
+}
+
+// BackendRegistered reports whether name is a built-in backend or one
+// registered with RegisterRuntime.
+func BackendRegistered(name string) bool {
+	if name == BackendDocker || name == BackendLocal {
+		return true
+	}
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	_, exists := runtimes[name]
+	return exists
+}
+
+// NewRuntime returns the runtime selected by config.Backend. The docker
+// runtime uses dockerClient. There is no runtime for BackendLocal, which
+// does not use containers.
+func NewRuntime(config CmdConfig, dockerClient *docker.Client) (Runtime, error) {
+	if config.Backend == "" || config.Backend == BackendDocker {
+		return &dockerRuntime{config: config, client: dockerClient}, nil
+	}
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	rt, exists := runtimes[config.Backend]
+	if !exists {
+		return nil, ErrBackendNotFound
+	}
+	return rt, nil
+}
+
+// CheckDockerBackend returns ErrNotSupportedByRuntime unless config selects
+// the docker runtime and client is connected to it. Features that use the
+// docker API beyond the operations of Runtime check it first, since no
+// docker client is made for other backends.
+func CheckDockerBackend(config CmdConfig, client *docker.Client) error {
+	if (config.Backend != "" && config.Backend != BackendDocker) || client == nil {
+		return ErrNotSupportedByRuntime
+	}
+	return nil
+}
+
+func isDockerRuntime(rt Runtime) bool {
+	_, ok := rt.(*dockerRuntime)
+	return ok
+}
+
+type dockerRuntime struct {
+	config CmdConfig
+	client *docker.Client
+}
+
+func (r *dockerRuntime) Pull(image string) error {
+	repository, tag := SplitImage(image)
+	return PullPlatformImage(r.config, r.client, repository, tag, r.config.Platform)
+}
+
+func (r *dockerRuntime) Create(spec ContainerSpec) (string, error) {
+	container, err := createContainerFromOptions(r.config, spec)
+	if err != nil {
+		return "", err
+	}
+	return container.ID, nil
+}
+
+func (r *dockerRuntime) Start(id string) error {
+	return startContainer(r.client, id)
+}
+
+func (r *dockerRuntime) Wait(id string) (int, error) {
+	return waitContainer(r.client, id)
+}
+
+func (r *dockerRuntime) Logs(id string, limits OutputLimits, onChunk func(stdcopy.Chunk)) (Output, error) {
+	return getContainerLogs(r.config.DockerEndpoint, id, limits, onChunk)
+}
+
+func (r *dockerRuntime) Kill(id string) error {
+	return killContainer(r.client, id)
+}
+
+func (r *dockerRuntime) Remove(id string) error {
+	return removeContainer(r.client, id)
+}
+
+func (r *dockerRuntime) Exec(id string, cmd []string, limits OutputLimits) (Output, int, error) {
+	exec, err := createExec(r.client, id, cmd)
+	if err != nil {
+		return Output{}, -1, err
+	}
+	output, err := startExec(r.client, exec.ID, limits)
+	if err != nil {
+		return Output{}, -1, err
+	}
+	exitCode, err := getExecExitCode(r.client, exec.ID)
+	if err != nil {
+		return Output{}, -1, err
+	}
+	return output, exitCode, nil
+}
+
+// SplitImage splits repository:tag, leaving a registry port in the
+// repository. The tag defaults to latest. The digest of
+// repository@digest is returned as the tag, which is how the pull API takes
+// it.
+func SplitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, "latest"
+	}
+	return image[:i], image[i+1:]
+}
@@ -0,0 +1,136 @@
+package command
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+const fakeBackend = "fake"
+
+// fakeRuntime is a Runtime whose containers run nothing. They exit with
+// exitCode and write chunks, in order, as their output.
+type fakeRuntime struct {
+	mu       sync.Mutex
+	exitCode int
+	chunks   []stdcopy.Chunk
+	specs    []ContainerSpec
+	removed  []string
+}
+
+func newFakeRuntime() *fakeRuntime {
+	rt := &fakeRuntime{}
+	RegisterRuntime(fakeBackend, rt)
+	return rt
+}
+
+func fakeConfig() CmdConfig {
+	return CmdConfig{
+		Backend:             fakeBackend,
+		CommandsDir:         "/root/commands",
+		ContainerRepository: "libcmd-test",
+		ContainerTag:        "latest",
+		Owner:               "libcmd-test",
+	}
+}
+
+func (r *fakeRuntime) Pull(image string) error {
+	return nil
+}
+
+func (r *fakeRuntime) Create(spec ContainerSpec) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.specs = append(r.specs, spec)
+	return fmt.Sprintf("fake-%d", len(r.specs)), nil
+}
+
+func (r *fakeRuntime) Start(id string) error {
+	return nil
+}
+
+func (r *fakeRuntime) Wait(id string) (int, error) {
+	return r.exitCode, nil
+}
+
+func (r *fakeRuntime) Logs(id string, limits OutputLimits, onChunk func(stdcopy.Chunk)) (Output, error) {
+	capture := NewOutputCapture(limits, onChunk)
+	for _, chunk := range r.chunks {
+		if chunk.Stream == stdcopy.Stderr {
+			capture.Stderr.Write(chunk.Data)
+		} else {
+			capture.Stdout.Write(chunk.Data)
+		}
+	}
+	return capture.Output(), nil
+}
+
+func (r *fakeRuntime) Kill(id string) error {
+	return nil
+}
+
+func (r *fakeRuntime) Remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removed = append(r.removed, id)
+	return nil
+}
+
+func (r *fakeRuntime) Exec(id string, cmd []string, limits OutputLimits) (Output, int, error) {
+	return Output{}, -1, ErrNotSupportedByRuntime
+}
+
+func (r *fakeRuntime) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func (r *fakeRuntime) created() []ContainerSpec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ContainerSpec{}, r.specs...)
+}
+
+func TestRunOnRegisteredRuntime(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.chunks = []stdcopy.Chunk{
+		{Stream: stdcopy.Stdout, Data: []byte("hello\n")},
+		{Stream: stdcopy.Stderr, Data: []byte("warning\n")},
+	}
+
+	result := RunResult("raw", fakeConfig(), nil, RunOptions{}, "echo", "hello")
+	if result.Err != nil {
+		t.Fatalf("run failed: %s", result.Err)
+	}
+	if result.Stdout != "hello\n" || result.Stderr != "warning\n" {
+		t.Errorf("got stdout %q and stderr %q", result.Stdout, result.Stderr)
+	}
+	specs := rt.created()
+	if len(specs) != 1 {
+		t.Fatalf("created %d containers, want 1", len(specs))
+	}
+	if specs[0].Op != "raw" || specs[0].RunID != result.RunID {
+		t.Errorf("created %s run %s, want raw run %s", specs[0].Op, specs[0].RunID, result.RunID)
+	}
+	if specs[0].Config.Image != "libcmd-test:latest" {
+		t.Errorf("created from %s, want libcmd-test:latest", specs[0].Config.Image)
+	}
+	if len(rt.removed) != 1 {
+		t.Errorf("removed %d containers, want 1", len(rt.removed))
+	}
+}
+
+func TestRunFailureReturnsStderr(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.exitCode = 3
+	rt.chunks = []stdcopy.Chunk{{Stream: stdcopy.Stderr, Data: []byte("no such file\n")}}
+
+	output, err := Run("raw", fakeConfig(), nil, RunOptions{}, "cat", "missing")
+	if err != ErrCommandResponse {
+		t.Fatalf("got error %v, want %v", err, ErrCommandResponse)
+	}
+	if len(output) != 1 || output[0] != "no such file" {
+		t.Errorf("got output %q", output)
+	}
+}
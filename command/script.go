@@ -0,0 +1,147 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ScriptOp is the op under which ad-hoc scripts pass through the hooks and
+// metrics. Their Args start with the script itself, so that the audit and
+// history stores record what ran.
+const ScriptOp = "script"
+
+// RunScript runs script with bash -s in a new command container, streaming
+// it over stdin, for one-off operations that do not justify a named command.
+// It returns stdout, or stderr along with ErrCommandResponse if the script
+// exits non-zero. Canceling ctx kills the container and returns
+// ErrCommandCanceled. Scripts go through the same run lifecycle as named
+// commands, and take their network, security profile, privileges and OCI
+// runtime from a CommandDef registered as ScriptOp, if there is one. They
+// need the docker backend.
+func RunScript(ctx context.Context, config CmdConfig, client *docker.Client, opts RunOptions, script string, args ...string) ([]string, error) {
+	if err := CheckDockerBackend(config, client); err != nil {
+		return nil, err
+	}
+	if config.ContainerOS == OSWindows {
+		return nil, ErrNotSupportedOnWindows
+	}
+	_, output, err := runWith(ScriptOp, config, opts, append([]string{script}, args...), func(opts RunOptions, args []string) ([]string, error) {
+		return runScript(ctx, opts.runContext, client, opts, args[0], args[1:])
+	})
+	return output, err
+}
+
+func runScript(ctx context.Context, rc *RunContext, client *docker.Client, opts RunOptions, script string, args []string) ([]string, error) {
+	def, _ := lookupCommand(ScriptOp)
+	if err := applySecurity(rc.HostConfig, rc.Op, securityProfile(def, opts)); err != nil {
+		return nil, err
+	}
+	applyRunLimits(rc.HostConfig, opts)
+	if err := applyPrivileged(rc.HostConfig, rc.Op, privilegedOptions(def, opts)); err != nil {
+		return nil, err
+	}
+	if err := applyOCIRuntime(rc.HostConfig, rc.Config, def, opts); err != nil {
+		return nil, err
+	}
+	if opts.Devices != nil {
+		if err := CheckDevices(rc.Config, *opts.Devices); err != nil {
+			return nil, err
+		}
+		if err := applyDevices(rc.HostConfig, rc.Op, opts.Devices); err != nil {
+			return nil, err
+		}
+	}
+	network := networkOptions(def, opts)
+	if network.Name != "" {
+		if err := CheckNetwork(rc.Config, *network); err != nil {
+			return nil, err
+		}
+	}
+	spec := ContainerSpec{
+		Op:    ScriptOp,
+		RunID: rc.RunID,
+		Config: &docker.Config{
+			Image:     fmt.Sprintf("%s:%s", rc.Config.ContainerRepository, rc.Config.ContainerTag),
+			Cmd:       append([]string{"bash", "-s", "--"}, args...),
+			Env:       containerEnv(rc.Config, opts.Env),
+			User:      containerUser(rc.Config, opts),
+			OpenStdin: true,
+			StdinOnce: true,
+		},
+		HostConfig: rc.HostConfig,
+		Network:    network,
+	}
+	rc.Image = spec.Config.Image
+	container, err := createContainerFromOptions(rc.Config, spec)
+	if err != nil {
+		return nil, err
+	}
+	defer removeContainer(client, container.ID)
+	rc.ContainerID = container.ID
+	if err := runHooks(rc, containerCreatedHook); err != nil {
+		return nil, err
+	}
+
+	limits := opts.outputLimits()
+	stdout := newLimitedBuffer(limits.MaxStdout, limits.Strategy)
+	stderr := newLimitedBuffer(limits.MaxStderr, limits.Strategy)
+	stdin, stdinWriter := io.Pipe()
+	go func() {
+		_, err := io.Copy(stdinWriter, strings.NewReader(script))
+		stdinWriter.CloseWithError(err)
+	}()
+	attachErrCh, err := attachContainer(client, container.ID, stdin, stdout, stderr, func() {})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := startContainer(client, container.ID); err != nil {
+		return nil, err
+	}
+	if err := runHooks(rc, startedHook); err != nil {
+		killContainer(client, container.ID)
+		return nil, err
+	}
+
+	waitCh := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := waitContainer(client, container.ID)
+		waitCh <- waitResult{exitCode, err}
+	}()
+	canceled := false
+	done := ctx.Done()
+	var result waitResult
+	for waiting := true; waiting; {
+		select {
+		case result = <-waitCh:
+			waiting = false
+		case <-done:
+			canceled = true
+			done = nil
+			killContainer(client, container.ID)
+		}
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	rc.ExitCode = result.exitCode
+	if err := <-attachErrCh; err != nil {
+		log.Errorf(" -> error reading output of container %s: %s", container.ID, err)
+		return nil, err
+	}
+	rc.captureStreams(limitedOutput(stdout, stderr))
+
+	if canceled {
+		return []string{strings.TrimSpace(stderr.String())}, ErrCommandCanceled
+	}
+	if result.exitCode == 0 {
+		return []string{strings.TrimSpace(stdout.String())}, nil
+	}
+	return []string{strings.TrimSpace(stderr.String())}, ErrCommandResponse
+}
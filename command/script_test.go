@@ -0,0 +1,76 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var errAbortRun = errors.New("run aborted by test hook")
+
+// scriptConfig is a docker backend config whose daemon is never reached by
+// runs that stop before creating a container.
+func scriptConfig() CmdConfig {
+	config := fakeConfig()
+	config.Backend = BackendDocker
+	return config
+}
+
+// withHooks runs fn with h as the only registered hooks.
+func withHooks(h Hooks, fn func()) {
+	saved := registeredHooks()
+	hooksMu.Lock()
+	hooks = []Hooks{h}
+	hooksMu.Unlock()
+	defer func() {
+		hooksMu.Lock()
+		hooks = saved
+		hooksMu.Unlock()
+	}()
+	fn()
+}
+
+func TestRunScriptGoesThroughRunLifecycle(t *testing.T) {
+	RegisterSecretResolver("scripttest", func(ref string) (string, error) {
+		return "resolved-token", nil
+	})
+	client, err := docker.NewClient("tcp://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen *RunContext
+	withHooks(Hooks{OnBeforeRun: func(rc *RunContext) error {
+		seen = rc
+		return errAbortRun
+	}}, func() {
+		opts := RunOptions{Env: []string{"TOKEN=scripttest://token"}}
+		if _, err := RunScript(context.Background(), scriptConfig(), client, opts, "echo $TOKEN", "a"); err != errAbortRun {
+			t.Errorf("got error %v, want %v", err, errAbortRun)
+		}
+	})
+	if seen == nil {
+		t.Fatal("before run hook was not called")
+	}
+	if seen.Op != ScriptOp || seen.RunID == "" || len(seen.Args) != 2 || seen.Args[0] != "echo $TOKEN" {
+		t.Errorf("got op %s, run %q, args %q", seen.Op, seen.RunID, seen.Args)
+	}
+	if len(seen.Options.Env) != 1 || seen.Options.Env[0] != "TOKEN=resolved-token" {
+		t.Errorf("secret reference not resolved: %q", seen.Options.Env)
+	}
+	if len(seen.Options.Secrets) != 1 || seen.Options.Secrets[0] != "resolved-token" {
+		t.Errorf("resolved value not treated as a secret: %q", seen.Options.Secrets)
+	}
+}
+
+func TestRunScriptChecksCallerRunID(t *testing.T) {
+	client, err := docker.NewClient("tcp://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunScript(context.Background(), scriptConfig(), client, RunOptions{RunID: "../bad"}, "true"); err != ErrInvalidID {
+		t.Errorf("got error %v, want %v", err, ErrInvalidID)
+	}
+}
@@ -0,0 +1,62 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// SecretsDir is where secret files are written inside the command
+	// container. It is an in-memory tmpfs, so secrets never reach the image
+	// or container filesystem on disk and disappear with the container.
+	SecretsDir = "/run/secrets"
+
+	secretsTmpfsOptions = "rw,noexec,nosuid,nodev,size=1m,mode=0700"
+	secretsReadyFile    = SecretsDir + "/.ready"
+
+	// tmpfs is only mounted once the container is running, so the command
+	// waits for the files to be written before it starts.
+	secretsWaitScript = `for i in $(seq 600); do
+	if [ -e ` + secretsReadyFile + ` ]; then exec "$@"; fi
+	sleep 0.1
+done
+echo "timed out waiting for secret files" >&2
+exit 1`
+)
+
+func validSecretName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\x00")
+}
+
+func checkSecretFiles(files map[string]string) error {
+	for name := range files {
+		if !validSecretName(name) {
+			return fmt.Errorf("invalid secret file name %q", name)
+		}
+	}
+	return nil
+}
+
+func secretFilesCmdParts(cmdParts []string) []string {
+	return append([]string{"bash", "-c", secretsWaitScript, "--"}, cmdParts...)
+}
+
+// writeSecretFiles streams each secret to a file in SecretsDir through the
+// stdin of an exec, so the values never appear in the container config or
+// process arguments.
+func writeSecretFiles(client *docker.Client, containerID string, files map[string]string) error {
+	for name, value := range files {
+		log.Debugf("writing secret file %s in container %s", name, containerID)
+		cmd := []string{"sh", "-c", `umask 077 && cat > "$1"`, "sh", SecretsDir + "/" + name}
+		if err := runExecWithInput(client, containerID, cmd, strings.NewReader(value)); err != nil {
+			log.Errorf(" -> error writing secret file %s in container %s: %s", name, containerID, err)
+			return err
+		}
+		log.Debugf(" -> secret file %s written", name)
+	}
+	return runExecWithInput(client, containerID, []string{"touch", secretsReadyFile}, nil)
+}
@@ -0,0 +1,126 @@
+package command
+
+import (
+	"strings"
+	"sync"
+)
+
+// SecretResolver returns the value referred to by ref, such as
+// "vault://secret/db#password".
+type SecretResolver func(ref string) (string, error)
+
+// LeasedSecretResolver is a SecretResolver for values that hold a lease,
+// such as dynamic database credentials. release, which may be nil, is
+// called once the run that resolved the value has finished.
+type LeasedSecretResolver func(ref string) (value string, release func(), err error)
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]LeasedSecretResolver{}
+)
+
+// RegisterSecretResolver resolves values in RunOptions.Env and
+// RunOptions.SecretFiles that start with scheme:// at run time. Resolved
+// values are treated as Secrets.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	RegisterLeasedSecretResolver(scheme, func(ref string) (string, func(), error) {
+		value, err := resolver(ref)
+		return value, nil, err
+	})
+}
+
+// RegisterLeasedSecretResolver is RegisterSecretResolver for a resolver
+// whose values are released when the run finishes.
+func RegisterLeasedSecretResolver(scheme string, resolver LeasedSecretResolver) {
+	secretResolversMu.Lock()
+	secretResolvers[scheme] = resolver
+	secretResolversMu.Unlock()
+}
+
+func resolveSecretRef(value string) (string, bool, func(), error) {
+	i := strings.Index(value, "://")
+	if i <= 0 {
+		return value, false, nil, nil
+	}
+	secretResolversMu.RLock()
+	resolver, exists := secretResolvers[value[:i]]
+	secretResolversMu.RUnlock()
+	if !exists {
+		return value, false, nil, nil
+	}
+	resolved, release, err := resolver(value)
+	if err != nil {
+		return "", false, nil, err
+	}
+	return resolved, true, release, nil
+}
+
+// secretReleases are the release functions of the values resolved for a
+// run.
+type secretReleases []func()
+
+func (r secretReleases) release() {
+	for _, release := range r {
+		release()
+	}
+}
+
+// resolveSecretRefs returns opts with the secret references in Env and
+// SecretFiles replaced by their values, and the function that releases
+// them, which the caller calls once the run has finished. The caller's maps
+// and slices are not modified. Values resolved before an error are
+// released.
+func resolveSecretRefs(opts RunOptions) (RunOptions, func(), error) {
+	var releases secretReleases
+	opts, err := resolveSecretRefsInto(opts, &releases)
+	if err != nil {
+		releases.release()
+		return opts, func() {}, err
+	}
+	return opts, releases.release, nil
+}
+
+func resolveSecretRefsInto(opts RunOptions, releases *secretReleases) (RunOptions, error) {
+	secrets := append([]string{}, opts.Secrets...)
+
+	if len(opts.Env) > 0 {
+		env := make([]string, len(opts.Env))
+		for i, kv := range opts.Env {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) == 2 {
+				value, resolved, release, err := resolveSecretRef(parts[1])
+				if err != nil {
+					return opts, err
+				}
+				if release != nil {
+					*releases = append(*releases, release)
+				}
+				if resolved {
+					secrets = append(secrets, value)
+					kv = parts[0] + "=" + value
+				}
+			}
+			env[i] = kv
+		}
+		opts.Env = env
+	}
+
+	if len(opts.SecretFiles) > 0 {
+		files := map[string]string{}
+		for name, v := range opts.SecretFiles {
+			value, _, release, err := resolveSecretRef(v)
+			if err != nil {
+				return opts, err
+			}
+			if release != nil {
+				*releases = append(*releases, release)
+			}
+			files[name] = value
+			secrets = append(secrets, value)
+		}
+		opts.SecretFiles = files
+	}
+
+	opts.Secrets = secrets
+	return opts, nil
+}
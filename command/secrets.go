@@ -0,0 +1,125 @@
+package command
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+const redacted = "[REDACTED]"
+
+// RedactSecrets masks every secret in s. Longer secrets are masked first, so
+// that a secret holding another is masked whole.
+func RedactSecrets(s string, secrets []string) string {
+	for _, secret := range longestFirst(secrets) {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	return s
+}
+
+// longestFirst returns the secrets that are not empty, longest first.
+func longestFirst(secrets []string) []string {
+	sorted := make(byLength, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			sorted = append(sorted, secret)
+		}
+	}
+	sort.Stable(sorted)
+	return sorted
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// chunkRedactor masks secrets in the chunks of a transcript as they are
+// recorded. A secret can be split across chunks, so the tail of each
+// stream, one byte shorter than the longest secret, is held back until the
+// next chunk of the stream or flush shows whether it starts a secret.
+type chunkRedactor struct {
+	secrets []string
+	window  int
+	held    []stdcopy.Chunk
+}
+
+func newChunkRedactor(secrets []string) *chunkRedactor {
+	r := &chunkRedactor{secrets: longestFirst(secrets)}
+	if len(r.secrets) > 0 {
+		r.window = len(r.secrets[0]) - 1
+	}
+	return r
+}
+
+// redact returns what can be recorded of chunk, masked, and holds back the
+// rest.
+func (r *chunkRedactor) redact(chunk stdcopy.Chunk) []stdcopy.Chunk {
+	data := chunk.Data
+	for i, held := range r.held {
+		if held.Stream == chunk.Stream {
+			data = append(held.Data, data...)
+			r.held = append(r.held[:i], r.held[i+1:]...)
+			break
+		}
+	}
+	masked, rest := r.mask(data, len(data)-r.window)
+	if len(rest) > 0 {
+		r.held = append(r.held, stdcopy.Chunk{Seq: chunk.Seq, Stream: chunk.Stream, Data: rest})
+	}
+	if len(masked) == 0 {
+		return nil
+	}
+	return []stdcopy.Chunk{{Seq: chunk.Seq, Stream: chunk.Stream, Data: masked}}
+}
+
+// flush returns the masked tails held back, once the streams have ended.
+func (r *chunkRedactor) flush() []stdcopy.Chunk {
+	chunks := []stdcopy.Chunk{}
+	for _, held := range r.held {
+		masked, _ := r.mask(held.Data, len(held.Data))
+		chunks = append(chunks, stdcopy.Chunk{Seq: held.Seq, Stream: held.Stream, Data: masked})
+	}
+	r.held = nil
+	return chunks
+}
+
+// mask masks the secrets starting before limit in data, and returns the
+// masked bytes and the bytes from where it stopped. Every secret starting
+// before limit fits in data when limit leaves room for the window.
+func (r *chunkRedactor) mask(data []byte, limit int) ([]byte, []byte) {
+	var masked bytes.Buffer
+	i := 0
+	for i < limit {
+		matched := false
+		for _, secret := range r.secrets {
+			if bytes.HasPrefix(data[i:], []byte(secret)) {
+				masked.WriteString(redacted)
+				i += len(secret)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			masked.WriteByte(data[i])
+			i++
+		}
+	}
+	rest := make([]byte, len(data)-i)
+	copy(rest, data[i:])
+	return masked.Bytes(), rest
+}
+
+func redactOutput(output []string, secrets []string) []string {
+	if len(secrets) == 0 || output == nil {
+		return output
+	}
+	redactedOutput := make([]string, len(output))
+	for i, line := range output {
+		redactedOutput[i] = RedactSecrets(line, secrets)
+	}
+	return redactedOutput
+}
@@ -0,0 +1,106 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+func TestRedactSecretsLongestFirst(t *testing.T) {
+	tests := []struct {
+		s       string
+		secrets []string
+		want    string
+	}{
+		{"token=abc123", []string{"abc", "abc123"}, "token=[REDACTED]"},
+		{"token=abc123", []string{"abc123", "abc"}, "token=[REDACTED]"},
+		{"abc and abc123", []string{"abc", "abc123"}, "[REDACTED] and [REDACTED]"},
+		{"nothing here", []string{"", "secret"}, "nothing here"},
+	}
+	for _, test := range tests {
+		if got := RedactSecrets(test.s, test.secrets); got != test.want {
+			t.Errorf("RedactSecrets(%q, %q) = %q, want %q", test.s, test.secrets, got, test.want)
+		}
+	}
+}
+
+// redactChunks passes chunks through a chunkRedactor and returns what it
+// records of each stream.
+func redactChunks(secrets []string, chunks []stdcopy.Chunk) map[stdcopy.Stream]string {
+	r := newChunkRedactor(secrets)
+	recorded := []stdcopy.Chunk{}
+	for _, chunk := range chunks {
+		recorded = append(recorded, r.redact(chunk)...)
+	}
+	recorded = append(recorded, r.flush()...)
+	streams := map[stdcopy.Stream]string{}
+	for _, chunk := range recorded {
+		streams[chunk.Stream] += string(chunk.Data)
+	}
+	return streams
+}
+
+func TestChunkRedactorMasksSecretsSplitAcrossChunks(t *testing.T) {
+	chunks := []stdcopy.Chunk{
+		{Seq: 1, Stream: stdcopy.Stdout, Data: []byte("password is hun")},
+		{Seq: 2, Stream: stdcopy.Stderr, Data: []byte("hun")},
+		{Seq: 3, Stream: stdcopy.Stdout, Data: []byte("ter2, ")},
+		{Seq: 4, Stream: stdcopy.Stdout, Data: []byte("h")},
+		{Seq: 5, Stream: stdcopy.Stdout, Data: []byte("unter")},
+		{Seq: 6, Stream: stdcopy.Stdout, Data: []byte("2")},
+		{Seq: 7, Stream: stdcopy.Stderr, Data: []byte("ter2\n")},
+	}
+	streams := redactChunks([]string{"hunter2"}, chunks)
+	if want := "password is [REDACTED], [REDACTED]"; streams[stdcopy.Stdout] != want {
+		t.Errorf("got stdout %q, want %q", streams[stdcopy.Stdout], want)
+	}
+	if want := "[REDACTED]\n"; streams[stdcopy.Stderr] != want {
+		t.Errorf("got stderr %q, want %q", streams[stdcopy.Stderr], want)
+	}
+}
+
+func TestChunkRedactorMasksLongestSecret(t *testing.T) {
+	chunks := []stdcopy.Chunk{
+		{Seq: 1, Stream: stdcopy.Stdout, Data: []byte("key=abc")},
+		{Seq: 2, Stream: stdcopy.Stdout, Data: []byte("def end abc")},
+	}
+	streams := redactChunks([]string{"abc", "abcdef"}, chunks)
+	if want := "key=[REDACTED] end [REDACTED]"; streams[stdcopy.Stdout] != want {
+		t.Errorf("got %q, want %q", streams[stdcopy.Stdout], want)
+	}
+}
+
+func TestChunkRedactorKeepsOutputWithoutSecrets(t *testing.T) {
+	chunks := []stdcopy.Chunk{
+		{Seq: 1, Stream: stdcopy.Stdout, Data: []byte("line one\n")},
+		{Seq: 2, Stream: stdcopy.Stdout, Data: []byte("line two\n")},
+	}
+	streams := redactChunks([]string{"not-in-output"}, chunks)
+	if want := "line one\nline two\n"; streams[stdcopy.Stdout] != want {
+		t.Errorf("got %q, want %q", streams[stdcopy.Stdout], want)
+	}
+}
+
+func TestRunRedactsTranscript(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.chunks = []stdcopy.Chunk{
+		{Stream: stdcopy.Stdout, Data: []byte("logging in with s3cr")},
+		{Stream: stdcopy.Stdout, Data: []byte("et-value\n")},
+	}
+
+	result := RunResult("raw", fakeConfig(), nil, RunOptions{Secrets: []string{"s3cret-value"}}, "login")
+	if result.Err != nil {
+		t.Fatalf("run failed: %s", result.Err)
+	}
+	transcript := ""
+	for _, chunk := range result.transcript {
+		transcript += string(chunk.Data)
+	}
+	if strings.Contains(transcript, "s3cr") || !strings.Contains(transcript, "[REDACTED]") {
+		t.Errorf("transcript not redacted: %q", transcript)
+	}
+	if strings.Contains(result.Stdout, "s3cret-value") {
+		t.Errorf("stdout not redacted: %q", result.Stdout)
+	}
+}
@@ -0,0 +1,124 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+// SecurityProfile restricts what a command container may do.
+type SecurityProfile struct {
+	CapAdd  []string
+	CapDrop []string
+	// SeccompProfile is the path to a seccomp profile on this host, or
+	// "unconfined". Empty uses the daemon's default profile.
+	SeccompProfile string
+	// AppArmorProfile names a profile loaded on the docker host. Empty
+	// uses the daemon's default profile.
+	AppArmorProfile string
+	NoNewPrivileges bool
+	// Ulimits and PidsLimit contain scripts that fork or open files
+	// without bound. A PidsLimit of zero or less is unlimited.
+	Ulimits   []Ulimit
+	PidsLimit int64
+}
+
+var (
+	// HardenedProfile drops every capability except those commonly needed
+	// to manage files and switch users, stops processes from gaining
+	// privileges through setuid binaries, and caps processes and open
+	// files. It leaves nproc alone because that limit counts every process
+	// of the user on the host, not just those of the container.
+	HardenedProfile = SecurityProfile{
+		CapDrop:         []string{"ALL"},
+		CapAdd:          []string{"CHOWN", "DAC_OVERRIDE", "FOWNER", "SETGID", "SETUID"},
+		NoNewPrivileges: true,
+		Ulimits:         []Ulimit{{Name: "nofile", Soft: 4096, Hard: 4096}},
+		PidsLimit:       512,
+	}
+
+	// DefaultSecurityProfile applies to commands that neither register a
+	// profile nor are given one in RunOptions.
+	DefaultSecurityProfile = HardenedProfile
+)
+
+// defaultCapabilities are those docker grants containers by default, which
+// profiles may add back after dropping them without being privileged.
+var defaultCapabilities = map[string]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true,
+	"FSETID": true, "KILL": true, "MKNOD": true, "NET_BIND_SERVICE": true,
+	"NET_RAW": true, "SETFCAP": true, "SETGID": true, "SETPCAP": true,
+	"SETUID": true, "SYS_CHROOT": true,
+}
+
+// escalations returns what the profile grants beyond docker's defaults:
+// added capabilities outside defaultCapabilities, and seccomp or AppArmor
+// profiles other than the daemon's, which may be looser than it.
+func (p SecurityProfile) escalations() []string {
+	var requested []string
+	for _, capability := range p.CapAdd {
+		name := strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+		if !defaultCapabilities[name] {
+			requested = append(requested, "capability "+name)
+		}
+	}
+	if p.SeccompProfile != "" {
+		requested = append(requested, "seccomp profile "+p.SeccompProfile)
+	}
+	if p.AppArmorProfile != "" && p.AppArmorProfile != "docker-default" {
+		requested = append(requested, "apparmor profile "+p.AppArmorProfile)
+	}
+	return requested
+}
+
+// securityProfile picks the profile of the run, then of the command, then
+// the default.
+func securityProfile(def *CommandDef, opts RunOptions) SecurityProfile {
+	if opts.Security != nil {
+		return *opts.Security
+	}
+	if def != nil && def.Security != nil {
+		return *def.Security
+	}
+	return DefaultSecurityProfile
+}
+
+// applySecurity adds profile to hostConfig, keeping anything hooks have
+// already set. Profiles with escalations fail with ErrPrivilegedNotAllowed
+// unless privileged runs are allowed.
+func applySecurity(hostConfig *HostConfig, op string, profile SecurityProfile) error {
+	if err := checkPrivileged(op, profile.escalations()); err != nil {
+		return err
+	}
+	hostConfig.CapAdd = append(append([]string{}, profile.CapAdd...), hostConfig.CapAdd...)
+	hostConfig.CapDrop = append(append([]string{}, profile.CapDrop...), hostConfig.CapDrop...)
+	hostConfig.Ulimits = mergeUlimits(profile.Ulimits, hostConfig.Ulimits)
+	if hostConfig.PidsLimit == 0 {
+		hostConfig.PidsLimit = profile.PidsLimit
+	}
+
+	securityOpts := []string{}
+	if profile.NoNewPrivileges {
+		securityOpts = append(securityOpts, "no-new-privileges")
+	}
+	if profile.AppArmorProfile != "" {
+		securityOpts = append(securityOpts, "apparmor="+profile.AppArmorProfile)
+	}
+	if profile.SeccompProfile == "unconfined" {
+		securityOpts = append(securityOpts, "seccomp=unconfined")
+	} else if profile.SeccompProfile != "" {
+		// The API takes the profile itself rather than a path.
+		b, err := ioutil.ReadFile(profile.SeccompProfile)
+		if err != nil {
+			return err
+		}
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, b); err != nil {
+			return err
+		}
+		securityOpts = append(securityOpts, "seccomp="+compacted.String())
+	}
+	hostConfig.SecurityOpt = append(securityOpts, hostConfig.SecurityOpt...)
+	return nil
+}
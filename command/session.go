@@ -0,0 +1,159 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrSessionClosed = errors.New("session closed")
+
+	sessionKeepAliveCmd = []string{"bash", "-c", "while true; do sleep 3600; done"}
+)
+
+// Session keeps a single command container running across several runs so
+// that related commands share the container filesystem. The container is
+// removed when the session is closed or has been idle for longer than the
+// idle timeout. The container has DefaultNetwork, DefaultSecurityProfile
+// and CmdConfig.OCIRuntime.
+type Session struct {
+	config       CmdConfig
+	dockerClient *docker.Client
+	containerID  string
+	idleTimeout  time.Duration
+
+	mu       sync.Mutex
+	closed   bool
+	inFlight int
+	timer    *time.Timer
+}
+
+func NewSession(config CmdConfig, dockerClient *docker.Client, idleTimeout time.Duration) (*Session, error) {
+	if err := CheckDockerBackend(config, dockerClient); err != nil {
+		return nil, err
+	}
+	container, err := createContainer(config, sessionKeepAliveCmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := startContainer(dockerClient, container.ID); err != nil {
+		removeContainer(dockerClient, container.ID)
+		return nil, err
+	}
+
+	s := &Session{
+		config:       config,
+		dockerClient: dockerClient,
+		containerID:  container.ID,
+		idleTimeout:  idleTimeout,
+	}
+	if idleTimeout > 0 {
+		s.timer = time.AfterFunc(idleTimeout, s.expire)
+	}
+	return s, nil
+}
+
+func (s *Session) Run(op string, args ...string) ([]string, error) {
+	return s.RunWithOptions(RunOptions{}, op, args...)
+}
+
+// RunWithOptions runs op with exec in the session container. The run passes
+// through the hooks, policy and manifest checks like any other, and the
+// scripts of SetScripts or CmdConfig.ScriptsDir are copied into the
+// container before it. Options that need a
+// container of their own, and commands that register network, security,
+// read-only, privileged, runtime or mount settings, which the shared
+// container was not created with, fail with ErrNotSupportedByRuntime.
+func (s *Session) RunWithOptions(opts RunOptions, op string, args ...string) ([]string, error) {
+	def, exists := lookupCommand(op)
+	if !exists {
+		return nil, ErrCommandNotFound
+	}
+	if def.Network != nil || def.Security != nil || def.ReadOnly != nil || def.Privileged != nil ||
+		def.OCIRuntime != "" || len(def.Mounts) > 0 || len(def.Requires) > 0 {
+		return nil, ErrNotSupportedByRuntime
+	}
+	if err := checkExecOptions(opts); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s.inFlight++
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		if s.timer != nil && !s.closed && s.inFlight == 0 {
+			s.timer.Reset(s.idleTimeout)
+		}
+		s.mu.Unlock()
+	}()
+
+	_, output, err := runWith(op, s.config, opts, args, func(opts RunOptions, args []string) ([]string, error) {
+		if err := CheckArgs(op, args); err != nil {
+			return nil, err
+		}
+		rc := opts.runContext
+		manifest, err := commandManifest(s.config, s.dockerClient, def)
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			if args, err = manifest.Validate(args); err != nil {
+				return nil, err
+			}
+			rc.Args = args
+		}
+		if scripts := injectedScripts(s.config); scripts != nil {
+			if err := injectScripts(s.config, s.containerID, scripts); err != nil {
+				return nil, err
+			}
+		}
+		// Hooks and records see the run as one in an exec container.
+		rc.ContainerID, rc.Config.ExecContainer = s.containerID, s.containerID
+		rc.Image = fmt.Sprintf("%s:%s", s.config.ContainerRepository, s.config.ContainerTag)
+		rt := &dockerRuntime{config: s.config, client: s.dockerClient}
+		return runExecContext(rc, rt, s.containerID, scriptCmdParts(s.config, op, args), opts.outputLimits())
+	})
+	return output, err
+}
+
+// Close removes the session container. It is safe to call more than once.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+
+	return removeContainer(s.dockerClient, s.containerID)
+}
+
+func (s *Session) expire() {
+	s.mu.Lock()
+	if s.closed || s.inFlight > 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	removeContainer(s.dockerClient, s.containerID)
+}
@@ -0,0 +1,57 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func registerSessionCommand(t *testing.T, op string) {
+	err := RegisterCommand(CommandDef{Op: op, Manifest: &Manifest{Args: []ArgSpec{
+		{Name: "bytes", Type: ArgInt, Required: true},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionValidatesManifest(t *testing.T) {
+	registerSessionCommand(t, "session-random")
+	defer unregisterCommand("session-random")
+	d, config, client := newFakeDocker(t)
+	defer d.Close()
+	s := &Session{config: config, dockerClient: client, containerID: "session-1"}
+
+	for _, args := range [][]string{{"many"}, {}} {
+		if _, err := s.Run("session-random", args...); err == nil {
+			t.Errorf("args %q passed the manifest", args)
+		}
+	}
+	if len(d.archives) != 0 {
+		t.Errorf("copied files into %v", d.archives)
+	}
+}
+
+func TestSessionInjectsScripts(t *testing.T) {
+	registerSessionCommand(t, "session-random")
+	defer unregisterCommand("session-random")
+	dir, err := ioutil.TempDir("", "libcmd-scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "session-random.sh"), []byte("head -c $1 /dev/urandom\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d, config, client := newFakeDocker(t)
+	defer d.Close()
+	config.ScriptsDir = dir
+	s := &Session{config: config, dockerClient: client, containerID: "session-1"}
+
+	// The fake daemon cannot exec, so only the copy succeeds.
+	s.Run("session-random", "16")
+	if len(d.archives) != 1 || d.archives[0] != "session-1" {
+		t.Errorf("copied scripts into %v, want the session container", d.archives)
+	}
+}
@@ -0,0 +1,87 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrShuttingDown = errors.New("shutting down")
+)
+
+var (
+	runsMu       sync.Mutex
+	runsInFlight int
+	shuttingDown bool
+	drained      chan struct{}
+)
+
+// beginRun counts a run as in flight, or returns false once Shutdown has
+// been called.
+func beginRun() bool {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+	if shuttingDown {
+		return false
+	}
+	runsInFlight++
+	return true
+}
+
+func endRun() {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+	runsInFlight--
+	if runsInFlight == 0 && drained != nil {
+		close(drained)
+		drained = nil
+	}
+}
+
+// Shutdown stops new runs, which fail with ErrShuttingDown, and waits for
+// the runs in flight to finish. If ctx is done first, their containers are
+// killed. Every container this process still holds, including those of
+// sessions, is then removed. Shutdown returns ctx.Err() when runs had to be
+// killed. Detached commands are left running.
+func Shutdown(ctx context.Context, client *docker.Client) error {
+	runsMu.Lock()
+	shuttingDown = true
+	done := drained
+	if done == nil {
+		done = make(chan struct{})
+		if runsInFlight == 0 {
+			close(done)
+		} else {
+			drained = done
+		}
+	}
+	inFlight := runsInFlight
+	runsMu.Unlock()
+
+	log.Infof("shutting down, waiting for %d runs", inFlight)
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Errorf("shutdown: runs still in flight: %s", err)
+	}
+
+	// Only the docker runtime tracks the containers it creates.
+	for _, containerID := range liveContainerIDs() {
+		if client == nil {
+			break
+		}
+		if err != nil {
+			killContainer(client, containerID)
+		}
+		removeContainer(client, containerID)
+	}
+	log.Infof("shutdown complete")
+	return err
+}
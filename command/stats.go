@@ -0,0 +1,137 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+// ResourceStats is the resource usage of a command container, collected
+// from the stats the daemon reports about once a second while it runs.
+// Commands that exit before the first report have none.
+type ResourceStats struct {
+	// PeakMemory is the highest memory usage reported, in bytes.
+	PeakMemory uint64
+	// CPUTime is the total CPU time used, in user and kernel mode.
+	CPUTime time.Duration
+	// NetworkRx and NetworkTx are the bytes received and sent on all
+	// interfaces.
+	NetworkRx uint64
+	NetworkTx uint64
+	// BlockRead and BlockWrite are the bytes read from and written to
+	// block devices.
+	BlockRead  uint64
+	BlockWrite uint64
+	// Samples is the number of reports the stats were collected from.
+	Samples int
+}
+
+type statsSample struct {
+	MemoryStats struct {
+		Usage    uint64 `json:"usage"`
+		MaxUsage uint64 `json:"max_usage"`
+	} `json:"memory_stats"`
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+	BlkioStats struct {
+		IOServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+func (s *ResourceStats) add(sample statsSample) {
+	s.Samples++
+	for _, usage := range []uint64{sample.MemoryStats.Usage, sample.MemoryStats.MaxUsage} {
+		if usage > s.PeakMemory {
+			s.PeakMemory = usage
+		}
+	}
+	// The counters are cumulative, so the latest nonzero report holds the
+	// totals.
+	if cpu := time.Duration(sample.CPUStats.CPUUsage.TotalUsage); cpu > 0 {
+		s.CPUTime = cpu
+	}
+	var rx, tx uint64
+	for _, network := range sample.Networks {
+		rx, tx = rx+network.RxBytes, tx+network.TxBytes
+	}
+	if rx > 0 || tx > 0 {
+		s.NetworkRx, s.NetworkTx = rx, tx
+	}
+	var read, write uint64
+	for _, entry := range sample.BlkioStats.IOServiceBytesRecursive {
+		switch entry.Op {
+		case "Read", "read":
+			read += entry.Value
+		case "Write", "write":
+			write += entry.Value
+		}
+	}
+	if read > 0 || write > 0 {
+		s.BlockRead, s.BlockWrite = read, write
+	}
+}
+
+// collectStats streams the stats of the container until the returned
+// function is called, which returns what was collected.
+func collectStats(endpoint, containerID string) func() *ResourceStats {
+	var (
+		mu      sync.Mutex
+		stats   ResourceStats
+		closeFn func()
+		stopped bool
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, closeResp, err := sendRequest("GET", endpoint, fmt.Sprintf("/containers/%s/stats?stream=1", containerID), nil)
+		if err != nil {
+			log.Errorf("error getting container %s stats: %s", containerID, err)
+			return
+		}
+		mu.Lock()
+		closeFn = closeResp
+		if stopped {
+			mu.Unlock()
+			closeResp()
+			return
+		}
+		mu.Unlock()
+		if resp.StatusCode != 200 {
+			log.Errorf("error getting container %s stats: docker returned status %d", containerID, resp.StatusCode)
+			return
+		}
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var sample statsSample
+			if err := decoder.Decode(&sample); err != nil {
+				return
+			}
+			mu.Lock()
+			stats.add(sample)
+			mu.Unlock()
+		}
+	}()
+	return func() *ResourceStats {
+		mu.Lock()
+		stopped = true
+		if closeFn != nil {
+			closeFn()
+		}
+		mu.Unlock()
+		<-done
+		return &stats
+	}
+}
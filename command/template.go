@@ -0,0 +1,73 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	ErrInvalidTemplate = errors.New("invalid arg template")
+)
+
+// TemplateData is what the templates in args and env of a run are rendered
+// with, such as {{.RunID}}, {{.Now.Format "2006-01-02"}} or
+// {{.Values.bucket}}.
+type TemplateData struct {
+	RunID string
+	Op    string
+	Now   time.Time
+	// Values are RunOptions.TemplateValues.
+	Values map[string]string
+}
+
+// expandTemplates renders args and the values of env for a run with
+// RunOptions.ExpandTemplates set. Missing values are an error rather than
+// "<no value>", and the rendered args are checked by CheckArgs like any
+// other.
+func expandTemplates(op string, opts RunOptions, args, env []string) ([]string, []string, error) {
+	values := opts.TemplateValues
+	if values == nil {
+		values = map[string]string{}
+	}
+	data := TemplateData{RunID: opts.RunID, Op: op, Now: time.Now(), Values: values}
+	expandedArgs := make([]string, len(args))
+	for i, arg := range args {
+		expanded, err := expandTemplate(fmt.Sprintf("arg %d", i), arg, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		expandedArgs[i] = expanded
+	}
+	var expandedEnv []string
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			value, err := expandTemplate("env "+parts[0], parts[1], data)
+			if err != nil {
+				return nil, nil, err
+			}
+			kv = parts[0] + "=" + value
+		}
+		expandedEnv = append(expandedEnv, kv)
+	}
+	return expandedArgs, expandedEnv, nil
+}
+
+func expandTemplate(name, text string, data TemplateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", ErrInvalidTemplate, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("%s: %s", ErrInvalidTemplate, err)
+	}
+	return b.String(), nil
+}
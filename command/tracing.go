@@ -0,0 +1,88 @@
+package command
+
+import (
+	"context"
+	"sync"
+)
+
+// Tracer starts the spans of runs: a root span named "libcmd.run" for each
+// run, and under it "libcmd.pull", "libcmd.create", "libcmd.start",
+// "libcmd.wait", "libcmd.logs" and "libcmd.remove" for the phases of a
+// container command. The root span is a child of the span in
+// RunOptions.Context, so that a run shows up in the trace of the request it
+// is for. An OpenTelemetry trace.Tracer is adapted to it with a Start that
+// calls its Start and a Span that records the error before ending.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. The root span of a run has the
+// attributes libcmd.op and libcmd.run_id, and once they are known
+// libcmd.image, libcmd.container_id and libcmd.exit_code.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// End ends the span, which failed if err is not nil.
+	End(err error)
+}
+
+var (
+	tracerMu sync.RWMutex
+	tracer   Tracer = noopTracer{}
+)
+
+// SetTracer traces every run with t. Nil stops tracing.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracerMu.Lock()
+	tracer = t
+	tracerMu.Unlock()
+}
+
+func currentTracer() Tracer {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return tracer
+}
+
+// startRunSpan starts the root span of the run of rc.
+func startRunSpan(rc *RunContext, parent context.Context) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	rc.traceCtx, rc.span = currentTracer().Start(parent, "libcmd.run")
+	rc.span.SetAttribute("libcmd.op", rc.Op)
+	rc.span.SetAttribute("libcmd.run_id", rc.RunID)
+}
+
+// endRunSpan ends the root span of the run of rc with what the run learned.
+func endRunSpan(rc *RunContext) {
+	if rc.Image != "" {
+		rc.span.SetAttribute("libcmd.image", rc.Image)
+	}
+	if rc.ContainerID != "" {
+		rc.span.SetAttribute("libcmd.container_id", rc.ContainerID)
+		rc.span.SetAttribute("libcmd.exit_code", rc.ExitCode)
+	}
+	rc.span.End(rc.Err)
+}
+
+// phase runs fn in a span named name under the root span of the run.
+func (rc *RunContext) phase(name string, fn func() error) error {
+	_, span := currentTracer().Start(rc.traceCtx, name)
+	err := fn()
+	span.End(err)
+	return err
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End(err error)                              {}
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type spanKey struct{}
+
+// recordingTracer records the spans it starts. The span a context is in is
+// carried as a value of the context.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	ended  bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attrs: map[string]interface{}{}}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *recordingTracer) named(name string) *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, span := range t.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.ended = true
+}
+
+func TestRunTracedUnderCallerSpan(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.exitCode = 3
+	tracer := &recordingTracer{}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	ctx, caller := tracer.Start(context.Background(), "request")
+	Run("raw", fakeConfig(), nil, RunOptions{Context: ctx}, "id")
+
+	root := tracer.named("libcmd.run")
+	if root == nil {
+		t.Fatal("run started no libcmd.run span")
+	}
+	if root.parent != caller {
+		t.Errorf("libcmd.run is not a child of the caller's span")
+	}
+	if !root.ended {
+		t.Errorf("libcmd.run was not ended")
+	}
+	for key, want := range map[string]interface{}{
+		"libcmd.op":           "raw",
+		"libcmd.container_id": "fake-1",
+		"libcmd.exit_code":    3,
+	} {
+		if got := root.attrs[key]; got != want {
+			t.Errorf("libcmd.run has %s %v, want %v", key, got, want)
+		}
+	}
+	for _, key := range []string{"libcmd.run_id", "libcmd.image"} {
+		if _, ok := root.attrs[key]; !ok {
+			t.Errorf("libcmd.run has no %s", key)
+		}
+	}
+	for _, name := range []string{"libcmd.create", "libcmd.start", "libcmd.wait", "libcmd.logs", "libcmd.remove"} {
+		span := tracer.named(name)
+		if span == nil {
+			t.Errorf("run started no %s span", name)
+			continue
+		}
+		if span.parent != root {
+			t.Errorf("%s is not a child of libcmd.run", name)
+		}
+		if !span.ended {
+			t.Errorf("%s was not ended", name)
+		}
+	}
+}
+
+func TestRunUntracedWithoutContext(t *testing.T) {
+	newFakeRuntime()
+	tracer := &recordingTracer{}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	if _, err := Run("raw", fakeConfig(), nil, RunOptions{}, "id"); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	root := tracer.named("libcmd.run")
+	if root == nil {
+		t.Fatal("run started no libcmd.run span")
+	}
+	if root.parent != nil {
+		t.Errorf("libcmd.run has a parent without a caller context")
+	}
+}
@@ -0,0 +1,137 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+const volumeLabel = "libcmd.volume"
+
+var (
+	ErrInvalidVolumeName = errors.New("invalid volume name")
+	ErrVolumeNotManaged  = errors.New("volume was not created by libcmd")
+
+	volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// Volume is a named docker volume created by libcmd. Volumes are labeled
+// with CmdConfig.Owner, and only those can be attached to runs or removed.
+type Volume struct {
+	Name       string
+	Driver     string
+	Mountpoint string
+	Labels     map[string]string
+	CreatedAt  string
+}
+
+// VolumeMount attaches a volume created with CreateVolume to a run.
+type VolumeMount struct {
+	Name     string
+	Target   string
+	ReadOnly bool
+}
+
+// CreateVolume creates a named volume with the local driver, labeled so
+// that ListVolumes finds it. An empty name creates "libcmd-<id>".
+func CreateVolume(config CmdConfig, name string, labels map[string]string) (*Volume, error) {
+	if name == "" {
+		id, err := NewID()
+		if err != nil {
+			return nil, err
+		}
+		name = "libcmd-" + id
+	}
+	if !volumeNamePattern.MatchString(name) {
+		return nil, ErrInvalidVolumeName
+	}
+	allLabels := map[string]string{}
+	for k, v := range labels {
+		allLabels[k] = v
+	}
+	allLabels[volumeLabel] = "true"
+	allLabels[ownerLabel] = config.Owner
+
+	log.Debugf("creating volume %s", name)
+	in := map[string]interface{}{"Name": name, "Driver": "local", "Labels": allLabels}
+	var volume Volume
+	if _, err := doJSON("POST", config.DockerEndpoint, "/volumes/create", in, &volume); err != nil {
+		log.Errorf(" -> error creating volume %s: %s", name, err)
+		return nil, err
+	}
+	log.Debugf(" -> volume %s created", name)
+	return &volume, nil
+}
+
+// ListVolumes returns the volumes created by libcmd for CmdConfig.Owner.
+func ListVolumes(config CmdConfig) ([]Volume, error) {
+	filters := fmt.Sprintf(`{"label":["%s=true","%s=%s"]}`, volumeLabel, ownerLabel, config.Owner)
+	var resp struct {
+		Volumes []Volume
+	}
+	if _, err := doJSON("GET", config.DockerEndpoint, "/volumes?"+url.Values{"filters": {filters}}.Encode(), nil, &resp); err != nil {
+		log.Errorf("error listing volumes: %s", err)
+		return nil, err
+	}
+	if resp.Volumes == nil {
+		return []Volume{}, nil
+	}
+	return resp.Volumes, nil
+}
+
+// InspectVolume returns the volume, or ErrVolumeNotManaged if it was not
+// created by libcmd for CmdConfig.Owner.
+func InspectVolume(config CmdConfig, name string) (*Volume, error) {
+	if !volumeNamePattern.MatchString(name) {
+		return nil, ErrInvalidVolumeName
+	}
+	var volume Volume
+	if _, err := doJSON("GET", config.DockerEndpoint, "/volumes/"+name, nil, &volume); err != nil {
+		return nil, err
+	}
+	if volume.Labels[volumeLabel] != "true" || volume.Labels[ownerLabel] != config.Owner {
+		return nil, ErrVolumeNotManaged
+	}
+	return &volume, nil
+}
+
+// RemoveVolume removes a volume created by libcmd. It fails while a
+// container uses the volume.
+func RemoveVolume(config CmdConfig, name string) error {
+	if _, err := InspectVolume(config, name); err != nil {
+		return err
+	}
+	log.Debugf("removing volume %s", name)
+	if _, err := doJSON("DELETE", config.DockerEndpoint, "/volumes/"+name, nil, nil); err != nil {
+		log.Errorf(" -> error removing volume %s: %s", name, err)
+		return err
+	}
+	log.Debugf(" -> volume %s removed", name)
+	return nil
+}
+
+// volumeBinds checks that the volumes were created by libcmd and returns
+// their binds. Names are checked first, since a host path in their place
+// would bypass the mount templates of the command.
+func volumeBinds(config CmdConfig, mounts []VolumeMount) ([]string, error) {
+	binds := []string{}
+	for _, mount := range mounts {
+		if !path.IsAbs(mount.Target) || strings.Contains(mount.Target, ":") {
+			return nil, fmt.Errorf("volume %s: invalid target %q", mount.Name, mount.Target)
+		}
+		if _, err := InspectVolume(config, mount.Name); err != nil {
+			return nil, err
+		}
+		bind := mount.Name + ":" + mount.Target
+		if mount.ReadOnly {
+			bind += ":ro"
+		}
+		binds = append(binds, bind)
+	}
+	return binds, nil
+}
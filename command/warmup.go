@@ -0,0 +1,215 @@
+package command
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// warmUpBackoff is how long a failed warm-up step waits before it is
+	// tried again, doubling with each failure up to maxWarmUpBackoff.
+	warmUpBackoff    = 30 * time.Second
+	maxWarmUpBackoff = 10 * time.Minute
+)
+
+var (
+	ErrWarmUpFailed = errors.New("warm-up step failed")
+)
+
+// WarmUp is a step run once per command image ID, before the first
+// container command that uses the image, such as priming a cache volume.
+// Commands run with exec, or on runtimes other than docker, do not run
+// warm-ups.
+type WarmUp struct {
+	Name string
+	// Script is run with bash -c in a container from the image.
+	Script string
+	// Binds mount volumes or host paths, as "source:target[:ro]", for
+	// example "libcmd-apt-cache:/var/cache/apt".
+	Binds []string
+	// Network defaults to DefaultNetwork.
+	Network *NetworkOptions
+	// Required fails the runs on an image while the step is failing on it.
+	// Otherwise runs go ahead without the step, which is logged.
+	Required bool
+}
+
+// WarmUpResult is the memoized outcome of a warm-up step on an image.
+// Attempts counts the failures in a row, and RetryAt is when a failed step
+// is tried again.
+type WarmUpResult struct {
+	Name     string
+	ImageID  string
+	Output   string
+	Err      error
+	Duration time.Duration
+	Attempts int
+	RetryAt  time.Time
+}
+
+var (
+	warmUpsMu     sync.Mutex
+	warmUps       []WarmUp
+	warmUpResults = map[string]*WarmUpResult{}
+	warmUpLocks   = map[string]*sync.Mutex{}
+)
+
+// RegisterWarmUp adds a warm-up step. Steps run in the order they were
+// registered.
+func RegisterWarmUp(w WarmUp) error {
+	if w.Name == "" || w.Script == "" {
+		return errors.New("warm-up name and script are required")
+	}
+	warmUpsMu.Lock()
+	defer warmUpsMu.Unlock()
+	for _, existing := range warmUps {
+		if existing.Name == w.Name {
+			return errors.New("warm-up " + w.Name + " already registered")
+		}
+	}
+	warmUps = append(warmUps, w)
+	return nil
+}
+
+// WarmUpResults returns the results of the warm-up steps run on imageID.
+func WarmUpResults(imageID string) []WarmUpResult {
+	warmUpsMu.Lock()
+	defer warmUpsMu.Unlock()
+	results := []WarmUpResult{}
+	for _, w := range warmUps {
+		if result, exists := warmUpResults[imageID+"\x00"+w.Name]; exists {
+			results = append(results, *result)
+		}
+	}
+	return results
+}
+
+// runWarmUps runs the warm-up steps that have not yet succeeded on image.
+// Runs that need the same image wait for its warm-ups to finish. A failed
+// step is tried again by the first run after its backoff. Until then, runs
+// skip it, or fail with its output when it is required.
+func runWarmUps(c *containerCmd, image string) ([]string, error) {
+	warmUpsMu.Lock()
+	steps := append([]WarmUp{}, warmUps...)
+	warmUpsMu.Unlock()
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	info, err := c.dockerClient.InspectImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	warmUpsMu.Lock()
+	lock, exists := warmUpLocks[info.ID]
+	if !exists {
+		lock = &sync.Mutex{}
+		warmUpLocks[info.ID] = lock
+	}
+	warmUpsMu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, w := range steps {
+		key := info.ID + "\x00" + w.Name
+		warmUpsMu.Lock()
+		result, done := warmUpResults[key]
+		warmUpsMu.Unlock()
+		if done && result.Err == nil {
+			continue
+		}
+		if done && time.Now().Before(result.RetryAt) {
+			if w.Required {
+				return []string{result.Output}, result.Err
+			}
+			continue
+		}
+
+		log.Debugf("running warm-up %s on image %s", w.Name, info.ID)
+		attempts := 0
+		if done {
+			attempts = result.Attempts
+		}
+		start := time.Now()
+		output, err := runWarmUp(c, info.ID, w)
+		result = &WarmUpResult{Name: w.Name, ImageID: info.ID, Output: output, Err: err, Duration: time.Since(start)}
+		if err != nil {
+			result.Attempts = attempts + 1
+			result.RetryAt = time.Now().Add(warmUpRetryDelay(result.Attempts))
+		}
+		warmUpsMu.Lock()
+		warmUpResults[key] = result
+		warmUpsMu.Unlock()
+		if err != nil {
+			log.Errorf(" -> warm-up %s failed, retrying after %s: %s", w.Name, result.RetryAt.Format(time.RFC3339), err)
+			if w.Required {
+				return []string{output}, err
+			}
+			continue
+		}
+		log.Debugf(" -> warm-up %s done", w.Name)
+	}
+	return nil, nil
+}
+
+// warmUpRetryDelay is the backoff after the given number of failures in a
+// row.
+func warmUpRetryDelay(attempts int) time.Duration {
+	delay := warmUpBackoff
+	for i := 1; i < attempts && delay < maxWarmUpBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxWarmUpBackoff {
+		delay = maxWarmUpBackoff
+	}
+	return delay
+}
+
+func runWarmUp(c *containerCmd, imageID string, w WarmUp) (string, error) {
+	opts := ContainerSpec{
+		Op: "warm-up",
+		Config: &docker.Config{
+			Image: imageID,
+			Cmd:   []string{"bash", "-c", w.Script},
+			Env:   containerEnv(c.config, nil),
+			User:  c.config.User,
+		},
+		HostConfig: newHostConfig(c.config),
+		Network:    networkOptions(nil, RunOptions{Network: w.Network}),
+	}
+	opts.HostConfig.Binds = append(opts.HostConfig.Binds, w.Binds...)
+	applyNetwork(opts.HostConfig, opts.Network)
+	if err := applySecurity(opts.HostConfig, "warm-up "+w.Name, DefaultSecurityProfile); err != nil {
+		return "", err
+	}
+	if err := applyPrivileged(opts.HostConfig, "warm-up "+w.Name, nil); err != nil {
+		return "", err
+	}
+
+	container, err := createContainerFromOptions(c.config, opts)
+	if err != nil {
+		return "", err
+	}
+	defer removeContainer(c.dockerClient, container.ID)
+	if err := startContainer(c.dockerClient, container.ID); err != nil {
+		return "", err
+	}
+	exitCode, err := waitContainer(c.dockerClient, container.ID)
+	if err != nil {
+		return "", err
+	}
+	logs, err := getContainerLogs(c.config.DockerEndpoint, container.ID, DefaultOutputLimits, nil)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return strings.TrimSpace(logs.Stderr), ErrWarmUpFailed
+	}
+	return strings.TrimSpace(logs.Stdout), nil
+}
@@ -0,0 +1,41 @@
+package command
+
+import (
+	"errors"
+	"strings"
+)
+
+// OSWindows is the CmdConfig.ContainerOS of windows command images.
+const OSWindows = "windows"
+
+var (
+	ErrNotSupportedOnWindows = errors.New("not supported with windows command images")
+)
+
+// windowsScriptCmdParts runs <CommandsDir>\<op>.ps1, or the script of the
+// command, with powershell unless the command sets its interpreter.
+func windowsScriptCmdParts(config CmdConfig, op string, args []string) []string {
+	def, _ := lookupCommand(op)
+	name := op + ".ps1"
+	if def != nil && def.Script != "" {
+		name = strings.Replace(def.Script, "/", `\`, -1)
+	}
+	script := strings.TrimRight(config.CommandsDir, `\/`) + `\` + name
+	interpreter := []string{"powershell", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"}
+	if def != nil && len(def.Interpreter) > 0 {
+		interpreter = def.Interpreter
+	}
+	cmdParts := append(append([]string{}, interpreter...), script)
+	return append(cmdParts, args...)
+}
+
+// checkWindows rejects the options of a run that use linux container
+// features windows containers do not have.
+func checkWindows(def *CommandDef, opts RunOptions) error {
+	if readOnlyOptions(def, opts) != nil || len(opts.SecretFiles) > 0 || len(def.Requires) > 0 ||
+		privilegedOptions(def, opts) != nil || len(opts.Ulimits) > 0 || opts.PidsLimit != 0 ||
+		opts.Security != nil || def.Security != nil {
+		return ErrNotSupportedOnWindows
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package libcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"unicode"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+// ConfigSource is the layer a setting was taken from. Each layer overrides
+// the one before it: defaults, then the config file, then LIBCMD_*
+// environment variables, then the opts passed to InitCmdContainer. The
+// settings in codeOnlySettings are only taken from the opts. Fields
+// of command.RunOptions, such as User or Network, override them again for a
+// single run.
+type ConfigSource string
+
+const (
+	SourceDefault ConfigSource = "default"
+	SourceFile    ConfigSource = "file"
+	SourceEnv     ConfigSource = "env"
+	SourceCode    ConfigSource = "code"
+)
+
+// codeOnlySettings are the settings that lift the isolation of commands:
+// running them on the host, in an existing container or in the host's user
+// namespace. Only the opts passed to InitCmdContainer may set them; the
+// config file and the environment cannot, for the same reason
+// command.SetAllowPrivileged is not a setting.
+var codeOnlySettings = map[string]func(value string) bool{
+	"Backend":       func(value string) bool { return value == command.BackendLocal },
+	"ExecContainer": func(value string) bool { return value != "" },
+	"UsernsMode":    func(value string) bool { return value != "" },
+}
+
+// ConfigValue is one effective setting and where it came from.
+type ConfigValue struct {
+	Key    string
+	Value  string
+	Source ConfigSource
+}
+
+var configSources = map[string]ConfigSource{}
+
+// Config returns the configuration set by InitCmdContainer, for packages
+// that run commands with their own docker clients.
+func Config() command.CmdConfig {
+	return config
+}
+
+// EffectiveConfig returns every setting in effect, sorted by key.
+func EffectiveConfig() []ConfigValue {
+	values := []ConfigValue{}
+	for key := range cmdConfigDefaults(command.DockerEnvironment{}) {
+		field := reflect.ValueOf(config).FieldByName(key)
+		values = append(values, ConfigValue{Key: key, Value: field.String(), Source: configSources[key]})
+	}
+	sort.Sort(byConfigKey(values))
+	return values
+}
+
+// loadConfig layers the settings. file is a JSON object of settings keyed
+// like opts and may be empty.
+func loadConfig(file string, opts map[string]string) (command.CmdConfig, map[string]ConfigSource, error) {
+	fileOpts := map[string]string{}
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return command.CmdConfig{}, nil, err
+		}
+		if err := json.Unmarshal(b, &fileOpts); err != nil {
+			return command.CmdConfig{}, nil, fmt.Errorf("config file %s: %s", file, err)
+		}
+	}
+	dockerEnv := command.ResolveDockerEnvironment()
+	defaults := cmdConfigDefaults(dockerEnv)
+	for key := range fileOpts {
+		if _, exists := defaults[key]; !exists {
+			log.Infof("WARNING: ignoring unknown setting %s in config file %s", key, file)
+		}
+	}
+	for key := range opts {
+		if _, exists := defaults[key]; !exists {
+			return command.CmdConfig{}, nil, fmt.Errorf("unknown config setting %s", key)
+		}
+	}
+
+	cfg := command.CmdConfig{}
+	sources := map[string]ConfigSource{}
+	for key, dflt := range defaults {
+		value, source := dflt, SourceDefault
+		if v, ok := fileOpts[key]; ok && allowedFrom(SourceFile, key, v) {
+			value, source = v, SourceFile
+		}
+		if v, ok := os.LookupEnv(ConfigEnvName(key)); ok && allowedFrom(SourceEnv, key, v) {
+			value, source = v, SourceEnv
+		}
+		if v, ok := opts[key]; ok {
+			value, source = v, SourceCode
+		}
+		reflect.ValueOf(&cfg).Elem().FieldByName(key).SetString(value)
+		sources[key] = source
+	}
+	// The TLS settings found with the default endpoint are not for one
+	// configured explicitly.
+	if sources["DockerEndpoint"] != SourceDefault && sources["TLSCertPath"] == SourceDefault {
+		cfg.TLSCertPath, cfg.TLSVerify = "", "false"
+	}
+	if sources["DockerEndpoint"] == SourceDefault {
+		log.Debugf("using docker endpoint %s from %s", cfg.DockerEndpoint, dockerEnv.Source)
+	}
+	if !command.BackendRegistered(cfg.Backend) {
+		return command.CmdConfig{}, nil, fmt.Errorf("unknown backend %s", cfg.Backend)
+	}
+	switch cfg.PullPolicy {
+	case command.PullMissing, command.PullAlways, command.PullNever:
+	default:
+		return command.CmdConfig{}, nil, fmt.Errorf("unknown pull policy %s", cfg.PullPolicy)
+	}
+	if cfg.ContainerOS != "linux" && cfg.ContainerOS != command.OSWindows {
+		return command.CmdConfig{}, nil, fmt.Errorf("unknown container OS %s", cfg.ContainerOS)
+	}
+	return cfg, sources, nil
+}
+
+// ConfigEnvName returns the environment variable that sets key, for example
+// LIBCMD_DOCKER_ENDPOINT for DockerEndpoint.
+func ConfigEnvName(key string) string {
+	runes := []rune(key)
+	name := "LIBCMD_"
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			name += "_"
+		}
+		name += string(unicode.ToUpper(r))
+	}
+	return name
+}
+
+// allowedFrom reports whether value may be taken for key from source, and
+// warns about code only settings that are not.
+func allowedFrom(source ConfigSource, key, value string) bool {
+	if codeOnly, exists := codeOnlySettings[key]; exists && codeOnly(value) {
+		log.Infof("WARNING: ignoring %s=%s from %s: it can only be set by InitCmdContainer", key, value, source)
+		return false
+	}
+	return true
+}
+
+type byConfigKey []ConfigValue
+
+func (b byConfigKey) Len() int           { return len(b) }
+func (b byConfigKey) Less(i, j int) bool { return b[i].Key < b[j].Key }
+func (b byConfigKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
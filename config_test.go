@@ -0,0 +1,101 @@
+package libcmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "libcmd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	file := writeConfigFile(t, `{"ContainerTag": "file", "User": "file"}`)
+	os.Setenv("LIBCMD_USER", "env")
+	defer os.Unsetenv("LIBCMD_USER")
+
+	cfg, sources, err := loadConfig(file, map[string]string{"DNS": "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key, value string
+		source     ConfigSource
+	}{
+		{"ContainerRepository", "freighterio/cmd", SourceDefault},
+		{"ContainerTag", "file", SourceFile},
+		{"User", "env", SourceEnv},
+		{"DNS", "10.0.0.2", SourceCode},
+	}
+	values := map[string]string{"ContainerRepository": cfg.ContainerRepository, "ContainerTag": cfg.ContainerTag, "User": cfg.User, "DNS": cfg.DNS}
+	for _, test := range tests {
+		if values[test.key] != test.value || sources[test.key] != test.source {
+			t.Errorf("%s = %q from %s, want %q from %s", test.key, values[test.key], sources[test.key], test.value, test.source)
+		}
+	}
+}
+
+func TestLoadConfigCodeOnlySettings(t *testing.T) {
+	file := writeConfigFile(t, `{"Backend": "local", "UsernsMode": "host"}`)
+	os.Setenv("LIBCMD_EXEC_CONTAINER", "host-agent")
+	defer os.Unsetenv("LIBCMD_EXEC_CONTAINER")
+
+	cfg, sources, err := loadConfig(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backend != command.BackendDocker || cfg.UsernsMode != "" || cfg.ExecContainer != "" {
+		t.Errorf("file and env lifted isolation: backend %q, userns %q, exec container %q", cfg.Backend, cfg.UsernsMode, cfg.ExecContainer)
+	}
+	if sources["Backend"] != SourceDefault {
+		t.Errorf("backend from %s, want %s", sources["Backend"], SourceDefault)
+	}
+
+	cfg, _, err = loadConfig(file, map[string]string{"Backend": command.BackendLocal, "ExecContainer": "host-agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backend != command.BackendLocal || cfg.ExecContainer != "host-agent" {
+		t.Errorf("code settings not honored: backend %q, exec container %q", cfg.Backend, cfg.ExecContainer)
+	}
+}
+
+func TestLoadConfigUnknownSettings(t *testing.T) {
+	file := writeConfigFile(t, `{"ContainerTag": "file", "RetiredSetting": "x"}`)
+	cfg, _, err := loadConfig(file, nil)
+	if err != nil {
+		t.Fatalf("unknown setting in config file failed the load: %s", err)
+	}
+	if cfg.ContainerTag != "file" {
+		t.Errorf("container tag %q, want file", cfg.ContainerTag)
+	}
+	if _, _, err := loadConfig("", map[string]string{"RetiredSetting": "x"}); err == nil {
+		t.Error("unknown setting in opts was accepted")
+	}
+}
+
+func TestLoadConfigResolvesDockerHostAtLoad(t *testing.T) {
+	os.Setenv("DOCKER_HOST", "tcp://10.0.0.5:2375")
+	defer os.Unsetenv("DOCKER_HOST")
+
+	cfg, sources, err := loadConfig("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DockerEndpoint != "tcp://10.0.0.5:2375" || sources["DockerEndpoint"] != SourceDefault {
+		t.Errorf("docker endpoint %q from %s, want DOCKER_HOST set before the load", cfg.DockerEndpoint, sources["DockerEndpoint"])
+	}
+}
@@ -0,0 +1,52 @@
+package libcmd
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/replicatedcom/libcmd/history"
+)
+
+var (
+	ErrHistoryDisabled = errors.New("history is not enabled")
+
+	historyStoreMu sync.RWMutex
+	historyStore   *history.Store
+)
+
+// EnableHistory records every run to a history store in dir.
+func EnableHistory(dir string) (*history.Store, error) {
+	store, err := history.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	store.Install()
+	historyStoreMu.Lock()
+	historyStore = store
+	historyStoreMu.Unlock()
+	return store, nil
+}
+
+// History lists past runs matching filter, most recent first.
+func History(filter history.Filter) ([]*history.Record, error) {
+	store := currentHistoryStore()
+	if store == nil {
+		return nil, ErrHistoryDisabled
+	}
+	return store.History(filter)
+}
+
+// HistoryRecord returns a single past run, including its output.
+func HistoryRecord(id string) (*history.Record, error) {
+	store := currentHistoryStore()
+	if store == nil {
+		return nil, ErrHistoryDisabled
+	}
+	return store.Get(id)
+}
+
+func currentHistoryStore() *history.Store {
+	historyStoreMu.RLock()
+	defer historyStoreMu.RUnlock()
+	return historyStore
+}
@@ -0,0 +1,352 @@
+// Package history keeps a record of every command run, including its output,
+// so that past runs can be listed and inspected after the fact.
+package history
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/audit"
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+// pruneInterval is how often recording a run prunes the store.
+const pruneInterval = time.Minute
+
+var (
+	ErrRecordNotFound = errors.New("history record not found")
+)
+
+type Record struct {
+	ID          string
+	RunID       string `json:",omitempty"`
+	Op          string
+	Args        []string
+	Output      []string
+	Error       string `json:",omitempty"`
+	ExitCode    int
+	ContainerID string            `json:",omitempty"`
+	Metadata    map[string]string `json:",omitempty"`
+	Transcript  []stdcopy.Chunk   `json:",omitempty"`
+	// TranscriptTruncated is set when the output limits cut Transcript
+	// short. TranscriptParts counts the earlier parts of the transcript of
+	// a session, which are in the audit records rather than Transcript.
+	TranscriptTruncated bool `json:",omitempty"`
+	TranscriptParts     int  `json:",omitempty"`
+	Start               time.Time
+	End                 time.Time
+	// Host is the docker endpoint the run was placed on, and Pool the exec
+	// container it ran in, if any.
+	Host string `json:",omitempty"`
+	Pool string `json:",omitempty"`
+}
+
+// Filter selects records in History. Zero values match everything.
+type Filter struct {
+	RunID      string
+	Op         string
+	Since      time.Time
+	Until      time.Time
+	FailedOnly bool
+	// Limit returns at most this many of the most recent matching records.
+	Limit int
+}
+
+func (f Filter) matches(record *Record) bool {
+	if f.RunID != "" && record.RunID != f.RunID {
+		return false
+	}
+	if f.Op != "" && record.Op != f.Op {
+		return false
+	}
+	if !f.inRange(record.Start) {
+		return false
+	}
+	if f.FailedOnly && record.Error == "" {
+		return false
+	}
+	return true
+}
+
+func (f Filter) inRange(start time.Time) bool {
+	if !f.Since.IsZero() && start.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !start.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Store keeps one JSON document per run in a directory. Records older than
+// Retention are removed as new runs are recorded, at most once a minute.
+type Store struct {
+	// Retention is how long records are kept. Zero keeps them forever.
+	Retention time.Duration
+	// SensitiveArgs lists argument positions that are never stored.
+	// Defaults to audit.DefaultSensitiveArgs.
+	SensitiveArgs map[string][]int
+
+	dir       string
+	mu        sync.Mutex
+	lastPrune time.Time
+	install   sync.Once
+}
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{SensitiveArgs: audit.DefaultSensitiveArgs, dir: dir}, nil
+}
+
+// Install registers the store so that every run is recorded. Calling it
+// again does nothing.
+func (s *Store) Install() {
+	s.install.Do(func() {
+		command.AddHooks(command.Hooks{
+			OnFinished: s.record,
+		})
+	})
+}
+
+func (s *Store) record(rc *command.RunContext) {
+	record := &Record{
+		ID:          newRecordID(rc.Start),
+		RunID:       rc.RunID,
+		Op:          rc.Op,
+		Args:        audit.RedactArgs(s.SensitiveArgs, rc.Op, rc.Args),
+		Output:      rc.Output,
+		ExitCode:    rc.ExitCode,
+		ContainerID: rc.ContainerID,
+		Host:        rc.Config.DockerEndpoint,
+		Pool:        rc.Config.ExecContainer,
+		Metadata:    rc.Options.Metadata,
+		Transcript:  rc.Transcript,
+		Start:       rc.Start,
+		End:         rc.Start.Add(rc.Duration),
+	}
+	record.TranscriptTruncated, record.TranscriptParts = rc.TranscriptTruncated, rc.TranscriptParts
+	for i, arg := range record.Args {
+		record.Args[i] = command.RedactSecrets(arg, rc.Options.Secrets)
+	}
+	if rc.Err != nil {
+		record.Error = command.RedactSecrets(rc.Err.Error(), rc.Options.Secrets)
+	}
+	if err := s.Put(record); err != nil {
+		log.Errorf("error writing history record for %s: %s", rc.Op, err)
+	}
+	if s.Retention > 0 && s.prunable() {
+		if err := s.Prune(time.Now().Add(-s.Retention)); err != nil {
+			log.Errorf("error pruning history: %s", err)
+		}
+	}
+}
+
+// prunable reports whether pruneInterval has passed since the last prune,
+// and if so starts the next interval.
+func (s *Store) prunable() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastPrune) < pruneInterval {
+		return false
+	}
+	s.lastPrune = time.Now()
+	return true
+}
+
+// Put stores record under its ID, which must pass command.CheckID.
+func (s *Store) Put(record *Record) error {
+	if err := command.CheckID(record.ID); err != nil {
+		return err
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := s.path(record.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(record.ID))
+}
+
+// Get returns the record stored under id. IDs that do not pass
+// command.CheckID, and so could name a file outside the store, return
+// command.ErrInvalidID.
+func (s *Store) Get(id string) (*Record, error) {
+	if err := command.CheckID(id); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrRecordNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var record Record
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// History returns the records matching filter, most recent first. The store
+// has no index other than the start of each run, which the IDs of recorded
+// runs carry: records started outside Since and Until are skipped without
+// being read, and the scan stops once Limit records match. Every other
+// record in the range is read to match RunID, Op and FailedOnly, so a
+// filter on those alone costs a read of the whole store.
+func (s *Store) History(filter Filter) ([]*Record, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	records := []*Record{}
+	for _, file := range files {
+		if file.known && !filter.inRange(file.start) {
+			continue
+		}
+		if file.known && filter.Limit > 0 && len(records) >= filter.Limit {
+			// The remaining files with known starts are older.
+			continue
+		}
+		record, err := s.Get(file.id)
+		if err == ErrRecordNotFound {
+			// Removed by a concurrent prune.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	sort.Sort(byStartDesc(records))
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+// Prune removes records of runs started before t. The start of a run is read
+// from the ID of its record, and only records stored under other IDs with
+// Put are read.
+func (s *Store) Prune(t time.Time) error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		start := file.start
+		if !file.known {
+			record, err := s.Get(file.id)
+			if err == ErrRecordNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			start = record.Start
+		}
+		if !start.Before(t) {
+			continue
+		}
+		s.mu.Lock()
+		err := os.Remove(s.path(file.id))
+		s.mu.Unlock()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedFile is a record file and the start of its run, if its ID carries
+// one.
+type storedFile struct {
+	id    string
+	start time.Time
+	known bool
+}
+
+// files lists the record files, those with known starts most recent first
+// and the others after them.
+func (s *Store) files() ([]storedFile, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []storedFile{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if command.CheckID(id) != nil {
+			// Not written by Put.
+			continue
+		}
+		start, known := recordStart(id)
+		files = append(files, storedFile{id: id, start: start, known: known})
+	}
+	sort.Stable(byFileStartDesc(files))
+	return files, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func newRecordID(start time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", start.UnixNano(), hex.EncodeToString(b))
+}
+
+// recordStart returns the start of the run in an ID from newRecordID.
+func recordStart(id string) (time.Time, bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 || len(parts[1]) != 8 {
+		return time.Time{}, false
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+type byFileStartDesc []storedFile
+
+func (f byFileStartDesc) Len() int      { return len(f) }
+func (f byFileStartDesc) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byFileStartDesc) Less(i, j int) bool {
+	if f[i].known != f[j].known {
+		return f[i].known
+	}
+	return f[i].start.After(f[j].start)
+}
+
+type byStartDesc []*Record
+
+func (r byStartDesc) Len() int           { return len(r) }
+func (r byStartDesc) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byStartDesc) Less(i, j int) bool { return r[i].Start.After(r[j].Start) }
@@ -0,0 +1,151 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+func openStore(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "libcmd-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func putRecord(t *testing.T, s *Store, op string, start time.Time, failed bool) *Record {
+	record := &Record{ID: newRecordID(start), Op: op, Start: start, End: start.Add(time.Second)}
+	if failed {
+		record.Error = "command failed"
+	}
+	if err := s.Put(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestGetRejectsPaths(t *testing.T) {
+	s := openStore(t)
+	outside := filepath.Join(filepath.Dir(s.dir), "outside.json")
+	if err := ioutil.WriteFile(outside, []byte(`{"Op":"leak"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outside)
+
+	for _, id := range []string{"../outside", "/etc/passwd", "", "a/b"} {
+		if _, err := s.Get(id); err != command.ErrInvalidID {
+			t.Errorf("Get(%q): got error %v, want %v", id, err, command.ErrInvalidID)
+		}
+		if err := s.Put(&Record{ID: id}); err != command.ErrInvalidID {
+			t.Errorf("Put(%q): got error %v, want %v", id, err, command.ErrInvalidID)
+		}
+	}
+	if _, err := s.Get("1-deadbeef"); err != ErrRecordNotFound {
+		t.Errorf("got error %v, want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestHistoryFilter(t *testing.T) {
+	s := openStore(t)
+	base := time.Unix(1700000000, 0)
+	deploy1 := putRecord(t, s, "deploy", base, false)
+	backup := putRecord(t, s, "backup", base.Add(time.Minute), true)
+	deploy2 := putRecord(t, s, "deploy", base.Add(2*time.Minute), true)
+	imported := &Record{ID: "imported", Op: "deploy", Start: base.Add(3 * time.Minute)}
+	if err := s.Put(imported); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []*Record
+	}{
+		{"all", Filter{}, []*Record{imported, deploy2, backup, deploy1}},
+		{"op", Filter{Op: "deploy"}, []*Record{imported, deploy2, deploy1}},
+		{"failed", Filter{FailedOnly: true}, []*Record{deploy2, backup}},
+		{"since", Filter{Since: base.Add(time.Minute)}, []*Record{imported, deploy2, backup}},
+		{"until", Filter{Until: base.Add(time.Minute)}, []*Record{deploy1}},
+		{"limit", Filter{Limit: 2}, []*Record{imported, deploy2}},
+		{"op and limit", Filter{Op: "backup", Limit: 1}, []*Record{backup}},
+	}
+	for _, test := range tests {
+		records, err := s.History(test.filter)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(records) != len(test.want) {
+			t.Errorf("%s: got %d records, want %d", test.name, len(records), len(test.want))
+			continue
+		}
+		for i := range records {
+			if records[i].ID != test.want[i].ID {
+				t.Errorf("%s: record %d is %s, want %s", test.name, i, records[i].ID, test.want[i].ID)
+			}
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s := openStore(t)
+	now := time.Now()
+	old := putRecord(t, s, "deploy", now.Add(-2*time.Hour), false)
+	recent := putRecord(t, s, "deploy", now, false)
+	if err := s.Put(&Record{ID: "imported", Op: "deploy", Start: now.Add(-3 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Prune(now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{old.ID, "imported"} {
+		if _, err := s.Get(id); err != ErrRecordNotFound {
+			t.Errorf("record %s not pruned: %v", id, err)
+		}
+	}
+	if _, err := s.Get(recent.ID); err != nil {
+		t.Errorf("recent record pruned: %s", err)
+	}
+}
+
+func TestInstallIsIdempotent(t *testing.T) {
+	s := openStore(t)
+	scripts, err := ioutil.TempDir("", "libcmd-history-scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(scripts)
+	if err := ioutil.WriteFile(filepath.Join(scripts, "history-test.sh"), []byte("echo recorded\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := command.RegisterCommand(command.CommandDef{Op: "history-test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Install()
+	s.Install()
+	config := command.CmdConfig{CommandsDir: scripts, Backend: command.BackendLocal}
+	if _, err := command.Run("history-test", config, nil, command.RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := s.History(Filter{Op: "history-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("run recorded %d times, want once", len(records))
+	}
+	if len(records[0].Output) != 1 || records[0].Output[0] != "recorded" {
+		t.Errorf("recorded output %q", records[0].Output)
+	}
+}
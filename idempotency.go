@@ -0,0 +1,102 @@
+package libcmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+var (
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused for a different run")
+)
+
+var (
+	// IdempotencyTTL is how long the result of a completed run is returned
+	// for repeated runs with the same idempotency key.
+	IdempotencyTTL = 10 * time.Minute
+
+	idempotentRunsMu sync.Mutex
+	idempotentRuns   = map[string]*idempotentRun{}
+)
+
+type idempotentRun struct {
+	params   string
+	done     chan bool
+	output   []string
+	err      error
+	finished time.Time
+}
+
+// idempotencyKey scopes the key of the caller to its Caller, so that keys
+// chosen by different tenants, such as webhook delivery IDs, never collide.
+func idempotencyKey(key string, opts command.RunOptions) string {
+	return opts.Caller + "\x00" + key
+}
+
+// idempotencyParams identifies what a run does, so that a key reused for
+// another op, args or image is refused instead of returning the result of
+// the first run.
+func idempotencyParams(op string, args []string, opts command.RunOptions) string {
+	b, _ := json.Marshal(struct {
+		Op         string
+		Args       []string
+		Image      string
+		ImageID    string
+		Backend    string
+		Repository string
+		Tag        string
+	}{op, args, opts.Image, opts.ImageID, config.Backend, config.ContainerRepository, config.ContainerTag})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func runIdempotent(op string, args []string, opts command.RunOptions, fn func() ([]string, error)) ([]string, error) {
+	key := idempotencyKey(opts.IdempotencyKey, opts)
+	params := idempotencyParams(op, args, opts)
+
+	idempotentRunsMu.Lock()
+	if run, exists := idempotentRuns[key]; exists {
+		if run.finished.IsZero() || time.Since(run.finished) <= IdempotencyTTL {
+			idempotentRunsMu.Unlock()
+			if run.params != params {
+				return nil, ErrIdempotencyKeyReused
+			}
+			<-run.done
+			return run.output, run.err
+		}
+		delete(idempotentRuns, key)
+	}
+	run := &idempotentRun{params: params, done: make(chan bool)}
+	idempotentRuns[key] = run
+	idempotentRunsMu.Unlock()
+
+	run.output, run.err = fn()
+
+	idempotentRunsMu.Lock()
+	// Only keep results produced by the command itself so that callers can
+	// retry after errors talking to the docker daemon.
+	if run.err == nil || run.err == command.ErrCommandResponse {
+		run.finished = time.Now()
+		time.AfterFunc(IdempotencyTTL, func() { expireIdempotentRun(key, run) })
+	} else {
+		delete(idempotentRuns, key)
+	}
+	idempotentRunsMu.Unlock()
+	close(run.done)
+
+	return run.output, run.err
+}
+
+// expireIdempotentRun removes run unless the key has been reused since.
+func expireIdempotentRun(key string, run *idempotentRun) {
+	idempotentRunsMu.Lock()
+	defer idempotentRunsMu.Unlock()
+	if idempotentRuns[key] == run {
+		delete(idempotentRuns, key)
+	}
+}
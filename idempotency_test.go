@@ -0,0 +1,60 @@
+package libcmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+func TestRunIdempotent(t *testing.T) {
+	calls := 0
+	fn := func() ([]string, error) {
+		calls++
+		return []string{"created"}, nil
+	}
+	opts := command.RunOptions{IdempotencyKey: "delivery-1", Caller: "tenant-a"}
+
+	for i := 0; i < 2; i++ {
+		output, err := runIdempotent("deploy", []string{"app"}, opts, fn)
+		if err != nil || len(output) != 1 || output[0] != "created" {
+			t.Fatalf("got %q, %v", output, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("ran %d times, want 1", calls)
+	}
+
+	other := opts
+	other.Caller = "tenant-b"
+	if _, err := runIdempotent("deploy", []string{"app"}, other, fn); err != nil {
+		t.Fatalf("run of another caller failed: %s", err)
+	}
+	if calls != 2 {
+		t.Errorf("another caller got the result of the first")
+	}
+
+	if _, err := runIdempotent("deploy", []string{"other-app"}, opts, fn); err != ErrIdempotencyKeyReused {
+		t.Errorf("reused key with other args: got error %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+	if _, err := runIdempotent("destroy", []string{"app"}, opts, fn); err != ErrIdempotencyKeyReused {
+		t.Errorf("reused key with another op: got error %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+	if calls != 2 {
+		t.Errorf("reused key ran the command")
+	}
+}
+
+func TestRunIdempotentRetriesDaemonErrors(t *testing.T) {
+	calls := 0
+	fn := func() ([]string, error) {
+		calls++
+		return nil, errors.New("daemon unreachable")
+	}
+	opts := command.RunOptions{IdempotencyKey: "delivery-2"}
+	runIdempotent("deploy", nil, opts, fn)
+	runIdempotent("deploy", nil, opts, fn)
+	if calls != 2 {
+		t.Errorf("ran %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,755 @@
+// Package kube runs commands as Kubernetes Jobs, for clusters where the
+// docker socket cannot be mounted. It talks to the API server directly and,
+// inside a pod, configures itself from the service account.
+//
+// The backend is a command.Runtime, so runs go through the hooks, policy and
+// checks of container commands, and libcmd can select it by name with
+// CmdConfig.Backend once New has registered it. Each "container" is a Job.
+//
+//	b, err := kube.New(kube.Config{Image: "freighterio/cmd:latest", CommandsDir: "/root/commands"})
+//	output, err := b.Run("random", "16")
+package kube
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// BackendName is the backend New registers the runtime as unless
+// Config.Name is set.
+const BackendName = "kube"
+
+// killedExitCode is reported for jobs deleted by Kill, as for a container
+// killed with SIGKILL.
+const killedExitCode = 137
+
+var (
+	ErrJobTimeout  = errors.New("kubernetes job did not finish in time")
+	ErrJobNotFound = errors.New("kubernetes job not found")
+)
+
+// Resources are the container resource requests and limits, such as
+// {"cpu": "500m", "memory": "256Mi"}.
+type Resources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+type Config struct {
+	// Name registers the runtime with command.RegisterRuntime. Defaults
+	// to BackendName.
+	Name string
+	// Host is the API server URL. Defaults to the in-cluster address.
+	Host string
+	// Token and CAFile default to those of the pod's service account.
+	Token  string
+	CAFile string
+	// Namespace defaults to the namespace of the pod's service account.
+	Namespace string
+	// ServiceAccount runs the job pods as this service account.
+	ServiceAccount string
+
+	// Image is the command image, as repository:tag.
+	Image       string
+	CommandsDir string
+	Resources   *Resources
+	// TTLSecondsAfterFinished lets the cluster delete finished jobs. Jobs
+	// are deleted after their logs are read either way.
+	TTLSecondsAfterFinished *int
+	// Timeout bounds a run. Defaults to one hour. It is also the
+	// activeDeadlineSeconds of each job, so that the cluster stops a job
+	// that outlives it even if this process is gone.
+	Timeout time.Duration
+	// PollInterval defaults to one second.
+	PollInterval time.Duration
+
+	Client *http.Client
+}
+
+type Backend struct {
+	config Config
+
+	mu   sync.Mutex
+	jobs map[string]*jobState
+}
+
+// jobState is a Job made by Create, which is only submitted by Start.
+type jobState struct {
+	manifest map[string]interface{}
+	started  bool
+	killed   bool
+	// succeeded is set by Wait, for Logs to tell which stream the logs
+	// of the pod are.
+	succeeded bool
+
+	// logs is what followLogs has read of the pod's logs so far. It is
+	// closed when the stream ends, which is when the pod's container
+	// exits, leaving logsErr set if it ended early.
+	logsMu   sync.Mutex
+	logs     bytes.Buffer
+	logsDone chan struct{}
+	logsErr  error
+}
+
+// Write appends to the logs of the job.
+func (j *jobState) Write(p []byte) (int, error) {
+	j.logsMu.Lock()
+	defer j.logsMu.Unlock()
+	return j.logs.Write(p)
+}
+
+// New returns the backend and registers it as Config.Name.
+func New(config Config) (*Backend, error) {
+	if config.Image == "" {
+		return nil, errors.New("command image is required")
+	}
+	if config.Name == "" {
+		config.Name = BackendName
+	}
+	if config.Host == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubernetes host is required outside a cluster")
+		}
+		config.Host = "https://" + host + ":" + port
+	}
+	if config.Token == "" {
+		if b, err := ioutil.ReadFile(serviceAccountDir + "/token"); err == nil {
+			config.Token = strings.TrimSpace(string(b))
+		}
+	}
+	if config.Namespace == "" {
+		b, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, errors.New("kubernetes namespace is required outside a cluster")
+		}
+		config.Namespace = strings.TrimSpace(string(b))
+	}
+	if config.CAFile == "" {
+		if _, err := os.Stat(serviceAccountDir + "/ca.crt"); err == nil {
+			config.CAFile = serviceAccountDir + "/ca.crt"
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Hour
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.Client == nil {
+		client, err := newHTTPClient(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Client = client
+	}
+	b := &Backend{config: config, jobs: map[string]*jobState{}}
+	command.RegisterRuntime(config.Name, b)
+	return b, nil
+}
+
+func newHTTPClient(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return http.DefaultClient, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}, nil
+}
+
+// CmdConfig returns the libcmd config that runs commands on this backend.
+func (b *Backend) CmdConfig() command.CmdConfig {
+	repository, tag := command.SplitImage(b.config.Image)
+	return command.CmdConfig{
+		Backend:             b.config.Name,
+		ContainerRepository: repository,
+		ContainerTag:        tag,
+		CommandsDir:         b.config.CommandsDir,
+	}
+}
+
+// Run runs op, a command registered with command.RegisterCommand, as a job
+// and returns its pod's logs. Like a container command, a failing job
+// returns command.ErrCommandResponse.
+func (b *Backend) Run(op string, args ...string) ([]string, error) {
+	return command.Run(op, b.CmdConfig(), nil, command.RunOptions{}, args...)
+}
+
+// RunResult is Run returning a *command.Result, with the exit code of the
+// job's pod. The pod's logs are its Stdout if the job succeeded and its
+// Stderr otherwise.
+func (b *Backend) RunResult(op string, opts command.RunOptions, args ...string) *command.Result {
+	return command.RunResult(op, b.CmdConfig(), nil, opts, args...)
+}
+
+// Pull does nothing, since the kubelet pulls the image of each job.
+func (b *Backend) Pull(image string) error {
+	return nil
+}
+
+// Create makes the Job for spec without submitting it. Binds, devices and
+// users that are not numeric cannot be honored and return
+// command.ErrNotSupportedByRuntime. Pods have no network mode, so that of
+// the spec does not apply.
+func (b *Backend) Create(spec command.ContainerSpec) (string, error) {
+	name, err := jobName(spec.Op)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := b.job(name, spec)
+	if err != nil {
+		return "", err
+	}
+	b.mu.Lock()
+	b.jobs[name] = &jobState{manifest: manifest, logsDone: make(chan struct{})}
+	b.mu.Unlock()
+	return name, nil
+}
+
+func (b *Backend) lookup(name string) (*jobState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	j, exists := b.jobs[name]
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+	return j, nil
+}
+
+// Start submits the job.
+func (b *Backend) Start(name string) error {
+	j, err := b.lookup(name)
+	if err != nil {
+		return err
+	}
+	log.Debugf("creating kubernetes job %s", name)
+	if err := b.do("POST", b.jobsPath(""), j.manifest, nil); err != nil {
+		log.Errorf(" -> error creating kubernetes job %s: %s", name, err)
+		return err
+	}
+	b.mu.Lock()
+	j.started = true
+	b.mu.Unlock()
+	log.Debugf(" -> kubernetes job %s created", name)
+	go b.followLogs(name, j)
+	return nil
+}
+
+// followLogs reads the logs of the job's pod as they are written, so that
+// they are not lost when the cluster deletes a finished pod before Logs is
+// called.
+func (b *Backend) followLogs(name string, j *jobState) {
+	defer close(j.logsDone)
+	p, err := b.startedPod(name, j)
+	if err != nil {
+		j.logsErr = err
+		return
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log?follow=true", b.config.Namespace, p.Metadata.Name)
+	body, err := b.stream("GET", path)
+	if err != nil {
+		j.logsErr = err
+		return
+	}
+	defer body.Close()
+	if _, err := io.Copy(j, body); err != nil {
+		j.logsErr = err
+	}
+}
+
+// startedPod waits for the job's pod to start its container, which is when
+// its logs can be followed.
+func (b *Backend) startedPod(name string, j *jobState) (*pod, error) {
+	deadline := time.Now().Add(b.config.Timeout)
+	for {
+		p, err := b.jobPod(name)
+		if err == nil && p.Status.Phase != "" && p.Status.Phase != "Pending" {
+			return p, nil
+		}
+		b.mu.Lock()
+		_, exists := b.jobs[name]
+		gone := j.killed || !exists
+		b.mu.Unlock()
+		if gone {
+			return nil, ErrJobNotFound
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrJobTimeout
+		}
+		time.Sleep(b.config.PollInterval)
+	}
+}
+
+// Wait returns the exit code of the job's pod once the job has finished.
+func (b *Backend) Wait(name string) (int, error) {
+	j, err := b.lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	succeeded, err := b.waitJob(name)
+	b.mu.Lock()
+	killed := j.killed
+	j.succeeded = succeeded
+	b.mu.Unlock()
+	if killed {
+		return killedExitCode, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	if succeeded {
+		return 0, nil
+	}
+	exitCode, err := b.podExitCode(name)
+	if err != nil {
+		return -1, err
+	}
+	return exitCode, nil
+}
+
+// Logs returns the logs of the job's pod, which hold stdout and stderr
+// together, as stdout if the job succeeded and as stderr otherwise. Jobs
+// deleted by Kill have no logs left.
+func (b *Backend) Logs(name string, limits command.OutputLimits, onChunk func(stdcopy.Chunk)) (command.Output, error) {
+	j, err := b.lookup(name)
+	if err != nil {
+		return command.Output{}, err
+	}
+	b.mu.Lock()
+	succeeded, killed := j.succeeded, j.killed
+	b.mu.Unlock()
+	if killed {
+		return command.Output{}, nil
+	}
+	<-j.logsDone
+	j.logsMu.Lock()
+	logs := j.logs.String()
+	j.logsMu.Unlock()
+	if j.logsErr != nil {
+		// The stream was cut short, so read the logs again in one go.
+		if logs, err = b.podLogs(name); err != nil {
+			return command.Output{}, err
+		}
+	}
+	capture := command.NewOutputCapture(limits, onChunk)
+	if succeeded {
+		capture.Stdout.Write([]byte(logs))
+	} else {
+		capture.Stderr.Write([]byte(logs))
+	}
+	return capture.Output(), nil
+}
+
+// Kill deletes the job and its pod.
+func (b *Backend) Kill(name string) error {
+	j, err := b.lookup(name)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	j.killed = true
+	b.mu.Unlock()
+	return b.deleteJob(name)
+}
+
+// Remove deletes the job if it was submitted.
+func (b *Backend) Remove(name string) error {
+	j, err := b.lookup(name)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	delete(b.jobs, name)
+	submitted := j.started && !j.killed
+	b.mu.Unlock()
+	if !submitted {
+		return nil
+	}
+	return b.deleteJob(name)
+}
+
+// Exec returns command.ErrNotSupportedByRuntime. Jobs run to completion
+// rather than staying up to exec into.
+func (b *Backend) Exec(name string, cmd []string, limits command.OutputLimits) (command.Output, int, error) {
+	return command.Output{}, -1, command.ErrNotSupportedByRuntime
+}
+
+// Capabilities are none of those of docker. Jobs run to completion without
+// stdin, and their resources are set by Config.Resources rather than per
+// run.
+func (b *Backend) Capabilities() command.Capabilities {
+	return command.Capabilities{}
+}
+
+// maxJobName is the longest name Kubernetes takes for a job, since the
+// name also labels its pods.
+const maxJobName = 63
+
+// maxJobNameID is the longest run ID kept as is in a job name. Longer IDs,
+// from command.SetIDGenerator, are hashed.
+const maxJobNameID = 32
+
+// jobName is libcmd-<op>-<id> made valid for Kubernetes. The op is cut
+// short to fit rather than the ID, which keeps names unique.
+func jobName(op string) (string, error) {
+	id, err := command.NewID()
+	if err != nil {
+		return "", err
+	}
+	id = dnsLabel(id)
+	if len(id) > maxJobNameID {
+		sum := sha256.Sum256([]byte(id))
+		id = hex.EncodeToString(sum[:])[:maxJobNameID]
+	}
+	prefix := dnsLabel("libcmd-" + op)
+	if room := maxJobName - len(id) - 1; len(prefix) > room {
+		prefix = strings.TrimRight(prefix[:room], "-")
+	}
+	return prefix + "-" + id, nil
+}
+
+// dnsLabel lowercases s and replaces what a DNS label may not hold with
+// '-'.
+func dnsLabel(s string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, s), "-")
+}
+
+func (b *Backend) job(name string, spec command.ContainerSpec) (map[string]interface{}, error) {
+	container := map[string]interface{}{
+		"name":  "command",
+		"image": spec.Config.Image,
+	}
+	if spec.Config.Entrypoint != nil {
+		container["command"] = spec.Config.Entrypoint
+		container["args"] = spec.Config.Cmd
+	} else {
+		container["command"] = spec.Config.Cmd
+	}
+	if spec.Config.WorkingDir != "" {
+		container["workingDir"] = spec.Config.WorkingDir
+	}
+	env := []map[string]string{}
+	for _, e := range spec.Config.Env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			env = append(env, map[string]string{"name": parts[0], "value": parts[1]})
+		}
+	}
+	if len(env) > 0 {
+		container["env"] = env
+	}
+	securityContext, err := securityContext(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(securityContext) > 0 {
+		container["securityContext"] = securityContext
+	}
+	if b.config.Resources != nil {
+		container["resources"] = b.config.Resources
+	}
+	podSpec := map[string]interface{}{
+		"restartPolicy": "Never",
+		"containers":    []interface{}{container},
+	}
+	if b.config.ServiceAccount != "" {
+		podSpec["serviceAccountName"] = b.config.ServiceAccount
+	}
+	// Label values are limited to 63 characters, which run IDs from
+	// command.SetIDGenerator may exceed, so the run ID is an annotation.
+	metadata := map[string]interface{}{
+		"labels":      map[string]string{"libcmd.op": spec.Op},
+		"annotations": map[string]string{"libcmd.run-id": spec.RunID},
+	}
+	// A failed pod is not retried, as a failed container is not: retries
+	// are for the caller, such as the queue package, to decide.
+	jobSpec := map[string]interface{}{
+		"backoffLimit":          0,
+		"activeDeadlineSeconds": activeDeadlineSeconds(b.config.Timeout),
+		"template": map[string]interface{}{
+			"metadata": metadata,
+			"spec":     podSpec,
+		},
+	}
+	if b.config.TTLSecondsAfterFinished != nil {
+		jobSpec["ttlSecondsAfterFinished"] = *b.config.TTLSecondsAfterFinished
+	}
+	return map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]interface{}{
+			"name":        name,
+			"labels":      map[string]string{"libcmd.op": spec.Op},
+			"annotations": map[string]string{"libcmd.run-id": spec.RunID},
+		},
+		"spec": jobSpec,
+	}, nil
+}
+
+// activeDeadlineSeconds rounds timeout up to whole seconds, of which a job
+// deadline must be at least one.
+func activeDeadlineSeconds(timeout time.Duration) int64 {
+	seconds := int64((timeout + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// securityContext translates the user and host config of spec into the
+// security context of the job's container.
+func securityContext(spec command.ContainerSpec) (map[string]interface{}, error) {
+	securityContext := map[string]interface{}{}
+	if user := spec.Config.User; user != "" {
+		parts := strings.SplitN(user, ":", 2)
+		uid, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, command.ErrNotSupportedByRuntime
+		}
+		securityContext["runAsUser"] = uid
+		if len(parts) == 2 {
+			gid, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, command.ErrNotSupportedByRuntime
+			}
+			securityContext["runAsGroup"] = gid
+		}
+	}
+	hc := spec.HostConfig
+	if hc == nil {
+		return securityContext, nil
+	}
+	if len(hc.Binds) > 0 || len(hc.Devices) > 0 || len(hc.DeviceRequests) > 0 {
+		return nil, command.ErrNotSupportedByRuntime
+	}
+	if len(hc.CapAdd) > 0 || len(hc.CapDrop) > 0 {
+		securityContext["capabilities"] = map[string][]string{"add": hc.CapAdd, "drop": hc.CapDrop}
+	}
+	for _, opt := range hc.SecurityOpt {
+		if opt == "no-new-privileges" {
+			securityContext["allowPrivilegeEscalation"] = false
+		}
+	}
+	if hc.Privileged {
+		securityContext["privileged"] = true
+	}
+	if hc.ReadonlyRootfs {
+		securityContext["readOnlyRootFilesystem"] = true
+	}
+	return securityContext, nil
+}
+
+func (b *Backend) waitJob(name string) (bool, error) {
+	deadline := time.Now().Add(b.config.Timeout)
+	for {
+		var job struct {
+			Status struct {
+				Succeeded  int
+				Failed     int
+				Conditions []struct {
+					Type   string
+					Reason string
+				}
+			}
+		}
+		if err := b.do("GET", b.jobsPath(name), nil, &job); err != nil {
+			log.Errorf(" -> error getting kubernetes job %s: %s", name, err)
+			return false, err
+		}
+		if job.Status.Succeeded > 0 {
+			log.Debugf(" -> kubernetes job %s succeeded", name)
+			return true, nil
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == "Failed" && condition.Reason == "DeadlineExceeded" {
+				log.Errorf(" -> kubernetes job %s exceeded its deadline", name)
+				return false, ErrJobTimeout
+			}
+		}
+		if job.Status.Failed > 0 {
+			log.Debugf(" -> kubernetes job %s failed", name)
+			return false, nil
+		}
+		if time.Now().After(deadline) {
+			log.Errorf(" -> kubernetes job %s timed out", name)
+			return false, ErrJobTimeout
+		}
+		time.Sleep(b.config.PollInterval)
+	}
+}
+
+type pod struct {
+	Metadata struct {
+		Name string
+	}
+	Status struct {
+		Phase             string
+		ContainerStatuses []struct {
+			State struct {
+				Terminated *struct {
+					ExitCode int
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) jobPod(jobName string) (*pod, error) {
+	var pods struct {
+		Items []pod
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods?%s", b.config.Namespace,
+		url.Values{"labelSelector": {"job-name=" + jobName}}.Encode())
+	if err := b.do("GET", path, nil, &pods); err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no pod found for kubernetes job %s", jobName)
+	}
+	return &pods.Items[0], nil
+}
+
+// podExitCode returns the exit code of the command container of the job's
+// pod, or 1 when the pod never ran it.
+func (b *Backend) podExitCode(jobName string) (int, error) {
+	p, err := b.jobPod(jobName)
+	if err != nil {
+		return -1, err
+	}
+	for _, status := range p.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			return status.State.Terminated.ExitCode, nil
+		}
+	}
+	return 1, nil
+}
+
+func (b *Backend) podLogs(jobName string) (string, error) {
+	p, err := b.jobPod(jobName)
+	if err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", b.config.Namespace, p.Metadata.Name)
+	logs, err := b.request("GET", path, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(logs), nil
+}
+
+func (b *Backend) deleteJob(name string) error {
+	// Background propagation deletes the job's pods as well.
+	path := b.jobsPath(name) + "?propagationPolicy=Background"
+	if err := b.do("DELETE", path, nil, nil); err != nil {
+		log.Errorf("error deleting kubernetes job %s: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) jobsPath(name string) string {
+	path := fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", b.config.Namespace)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+func (b *Backend) do(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	respBody, err := b.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	if out != nil && len(respBody) > 0 {
+		return json.Unmarshal(respBody, out)
+	}
+	return nil
+}
+
+func (b *Backend) request(method, path string, body []byte) ([]byte, error) {
+	resp, err := b.send(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	return ioutil.ReadAll(resp)
+}
+
+// stream is request returning the response body as it arrives.
+func (b *Backend) stream(method, path string) (io.ReadCloser, error) {
+	return b.send(method, path, nil)
+}
+
+func (b *Backend) send(method, path string, body []byte) (io.ReadCloser, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(b.config.Host, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if b.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.config.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		var status struct {
+			Message string
+		}
+		if json.Unmarshal(respBody, &status) == nil && status.Message != "" {
+			return nil, fmt.Errorf("kubernetes returned status %d: %s", resp.StatusCode, status.Message)
+		}
+		return nil, fmt.Errorf("kubernetes returned status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
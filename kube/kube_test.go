@@ -0,0 +1,287 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/replicatedcom/libcmd/command"
+)
+
+// fakeAPIServer serves the jobs and pods of one namespace. Jobs finish
+// with the exit code and logs it is given as soon as they are created.
+type fakeAPIServer struct {
+	t        *testing.T
+	exitCode int
+	logs     string
+	// deadlineExceeded fails jobs as the cluster does when they outlive
+	// their activeDeadlineSeconds.
+	deadlineExceeded bool
+
+	mu      sync.Mutex
+	created []map[string]interface{}
+	deleted []string
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	jobs := "/apis/batch/v1/namespaces/libcmd/jobs"
+	switch {
+	case r.Method == "POST" && r.URL.Path == jobs:
+		var job map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+			f.t.Errorf("decoding job: %s", err)
+		}
+		f.created = append(f.created, job)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, jobs+"/"):
+		status := map[string]interface{}{"Succeeded": 1}
+		if f.deadlineExceeded {
+			status = map[string]interface{}{"Failed": 1, "Conditions": []map[string]string{{"Type": "Failed", "Reason": "DeadlineExceeded"}}}
+		} else if f.exitCode != 0 {
+			status = map[string]interface{}{"Failed": 1}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Status": status})
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, jobs+"/"):
+		f.deleted = append(f.deleted, strings.TrimPrefix(r.URL.Path, jobs+"/"))
+	case r.Method == "GET" && r.URL.Path == "/api/v1/namespaces/libcmd/pods":
+		fmt.Fprintf(w, `{"Items": [{"Metadata": {"Name": "pod-1"}, "Status": {"Phase": "Succeeded",
+			"ContainerStatuses": [{"State": {"Terminated": {"ExitCode": %d}}}]}}]}`, f.exitCode)
+	case r.Method == "GET" && r.URL.Path == "/api/v1/namespaces/libcmd/pods/pod-1/log":
+		fmt.Fprint(w, f.logs)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestBackend(t *testing.T, api *fakeAPIServer, config Config) *Backend {
+	api.t = t
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	config.Name = "kube-" + t.Name()
+	config.Host = server.URL
+	config.Namespace = "libcmd"
+	config.Image = "freighterio/cmd:latest"
+	config.CommandsDir = "/root/commands"
+	config.PollInterval = time.Millisecond
+	config.Client = server.Client()
+	b, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func registerTestCommand(t *testing.T, op string) {
+	if err := command.RegisterCommand(command.CommandDef{Op: op}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	registerTestCommand(t, "kube-random")
+	api := &fakeAPIServer{logs: "4\n"}
+	b := newTestBackend(t, api, Config{})
+
+	output, err := b.Run("kube-random", "16")
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	if len(output) != 1 || output[0] != "4" {
+		t.Errorf("got output %q", output)
+	}
+	if len(api.created) != 1 {
+		t.Fatalf("created %d jobs, want 1", len(api.created))
+	}
+	name := api.created[0]["metadata"].(map[string]interface{})["name"].(string)
+	if !strings.HasPrefix(name, "libcmd-kube-random-") {
+		t.Errorf("created job %s", name)
+	}
+	if len(api.deleted) != 1 || api.deleted[0] != name {
+		t.Errorf("deleted jobs %v, want %s", api.deleted, name)
+	}
+}
+
+func TestRunFailedJob(t *testing.T) {
+	registerTestCommand(t, "kube-fail")
+	api := &fakeAPIServer{exitCode: 3, logs: "no such file\n"}
+	b := newTestBackend(t, api, Config{})
+
+	result := b.RunResult("kube-fail", command.RunOptions{})
+	if result.Err != command.ErrCommandResponse {
+		t.Fatalf("got error %v, want %v", result.Err, command.ErrCommandResponse)
+	}
+	if result.ExitCode != 3 || result.Stderr != "no such file\n" {
+		t.Errorf("got exit code %d and stderr %q", result.ExitCode, result.Stderr)
+	}
+}
+
+func TestRunJobPastDeadline(t *testing.T) {
+	registerTestCommand(t, "kube-slow")
+	api := &fakeAPIServer{exitCode: 137, deadlineExceeded: true}
+	b := newTestBackend(t, api, Config{Timeout: 90 * time.Second})
+
+	if _, err := b.Run("kube-slow"); err != ErrJobTimeout {
+		t.Errorf("got error %v, want %v", err, ErrJobTimeout)
+	}
+	spec := api.created[0]["spec"].(map[string]interface{})
+	if deadline := spec["activeDeadlineSeconds"]; deadline != float64(90) {
+		t.Errorf("job has deadline %v, want 90", deadline)
+	}
+}
+
+func TestActiveDeadlineSeconds(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int64
+	}{
+		{time.Hour, 3600},
+		{1500 * time.Millisecond, 2},
+		{time.Millisecond, 1},
+		{0, 1},
+	}
+	for _, test := range tests {
+		if got := activeDeadlineSeconds(test.timeout); got != test.want {
+			t.Errorf("%s: got %d seconds, want %d", test.timeout, got, test.want)
+		}
+	}
+}
+
+func TestJobName(t *testing.T) {
+	tests := []string{"random", "Backup_DB", strings.Repeat("long-op", 20)}
+	for _, op := range tests {
+		name, err := jobName(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(name) > maxJobName || dnsLabel(name) != name {
+			t.Errorf("%s: job name %q is not a valid label", op, name)
+		}
+		if !strings.HasPrefix(name, "libcmd-") {
+			t.Errorf("%s: job name %q", op, name)
+		}
+	}
+}
+
+func TestJobManifest(t *testing.T) {
+	ttl := 60
+	b := &Backend{config: Config{ServiceAccount: "runner", TTLSecondsAfterFinished: &ttl, Timeout: 10 * time.Minute,
+		Resources: &Resources{Limits: map[string]string{"cpu": "1"}}}}
+	hostConfig := &command.HostConfig{}
+	hostConfig.CapDrop = []string{"ALL"}
+	hostConfig.SecurityOpt = []string{"no-new-privileges"}
+	hostConfig.ReadonlyRootfs = true
+	spec := command.ContainerSpec{
+		Op:    "random",
+		RunID: "run-1",
+		Config: &docker.Config{
+			Image:      "freighterio/cmd:latest",
+			Entrypoint: []string{"bash"},
+			Cmd:        []string{"/root/commands/random.sh", "16"},
+			Env:        []string{"A=1", "B=x=y"},
+			User:       "1000:1000",
+			WorkingDir: "/work",
+		},
+		HostConfig: hostConfig,
+	}
+	manifest, err := b.job("libcmd-random-1", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job struct {
+		Spec struct {
+			BackoffLimit            int
+			ActiveDeadlineSeconds   int
+			TTLSecondsAfterFinished int
+			Template                struct {
+				Metadata struct {
+					Annotations map[string]string
+				}
+				Spec struct {
+					RestartPolicy      string
+					ServiceAccountName string
+					Containers         []struct {
+						Command         []string
+						Args            []string
+						WorkingDir      string
+						Env             []struct{ Name, Value string }
+						Resources       Resources
+						SecurityContext struct {
+							RunAsUser                int64
+							RunAsGroup               int64
+							AllowPrivilegeEscalation *bool
+							ReadOnlyRootFilesystem   bool
+							Capabilities             struct{ Drop []string }
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(raw, &job); err != nil {
+		t.Fatal(err)
+	}
+	pod := job.Spec.Template.Spec
+	if job.Spec.BackoffLimit != 0 || job.Spec.ActiveDeadlineSeconds != 600 || job.Spec.TTLSecondsAfterFinished != 60 || pod.RestartPolicy != "Never" || pod.ServiceAccountName != "runner" {
+		t.Errorf("got job spec %+v", job.Spec)
+	}
+	if job.Spec.Template.Metadata.Annotations["libcmd.run-id"] != "run-1" {
+		t.Errorf("got annotations %v", job.Spec.Template.Metadata.Annotations)
+	}
+	c := pod.Containers[0]
+	if strings.Join(c.Command, " ") != "bash" || strings.Join(c.Args, " ") != "/root/commands/random.sh 16" || c.WorkingDir != "/work" {
+		t.Errorf("got command %v, args %v in %s", c.Command, c.Args, c.WorkingDir)
+	}
+	if len(c.Env) != 2 || c.Env[1].Name != "B" || c.Env[1].Value != "x=y" {
+		t.Errorf("got env %+v", c.Env)
+	}
+	if c.Resources.Limits["cpu"] != "1" {
+		t.Errorf("got resources %+v", c.Resources)
+	}
+	sc := c.SecurityContext
+	if sc.RunAsUser != 1000 || sc.RunAsGroup != 1000 || sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation ||
+		!sc.ReadOnlyRootFilesystem || len(sc.Capabilities.Drop) != 1 {
+		t.Errorf("got security context %+v", sc)
+	}
+}
+
+func TestJobManifestUnsupported(t *testing.T) {
+	b := &Backend{}
+	binds := &command.HostConfig{}
+	binds.Binds = []string{"/data:/data"}
+	tests := []command.ContainerSpec{
+		{Config: &docker.Config{User: "nobody"}},
+		{Config: &docker.Config{User: "1000:staff"}},
+		{Config: &docker.Config{}, HostConfig: binds},
+	}
+	for i, spec := range tests {
+		if _, err := b.job("libcmd-test-1", spec); err != command.ErrNotSupportedByRuntime {
+			t.Errorf("%d: got error %v, want %v", i, err, command.ErrNotSupportedByRuntime)
+		}
+	}
+}
+
+func TestNewRequiresNamespaceOutsideCluster(t *testing.T) {
+	if _, err := ioutil.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+		t.Skip("running in a cluster")
+	}
+	if _, err := New(Config{Image: "freighterio/cmd:latest", Host: "https://127.0.0.1:6443"}); err == nil {
+		t.Error("created a backend without a namespace")
+	}
+	if _, err := New(Config{Host: "https://127.0.0.1:6443", Namespace: "libcmd"}); err == nil {
+		t.Error("created a backend without an image")
+	}
+}
@@ -1,59 +1,470 @@
 package libcmd
 
 import (
-	"reflect"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/fsouza/go-dockerclient"
 )
 
 var (
-	globalDockerClient *docker.Client
-	config             command.CmdConfig
+	globalDockerClientMu sync.RWMutex
+	globalDockerClient   *docker.Client
+	config               command.CmdConfig
+)
 
-	cmdConfigDefaultOpts = map[string]string{
+// cmdConfigDefaults returns the default of every setting InitCmdContainer
+// takes. The docker endpoint defaults to dockerEnv, which loadConfig resolves
+// when it runs rather than when the package is loaded, so that the
+// environment and sockets are those at InitCmdContainer.
+func cmdConfigDefaults(dockerEnv command.DockerEnvironment) map[string]string {
+	return map[string]string{
 		"CommandsDir":         "/root/commands",
-		"DockerEndpoint":      "unix:///var/run/docker.sock",
+		"DockerEndpoint":      dockerEnv.Endpoint,
+		"TLSCertPath":         dockerEnv.TLSCertPath,
+		"TLSVerify":           strconv.FormatBool(dockerEnv.TLSVerify),
 		"ContainerRepository": "freighterio/cmd",
 		"ContainerTag":        "latest",
+		"ExecContainer":       "",
+		"DetachDir":           "/var/lib/libcmd/detached",
+		"User":                "",
+		"UsernsMode":          "",
+		"DNS":                 "",
+		"DNSSearch":           "",
+		"ExtraHosts":          "",
+		"HTTPProxy":           proxyFromEnvironment("HTTP_PROXY"),
+		"HTTPSProxy":          proxyFromEnvironment("HTTPS_PROXY"),
+		"NoProxy":             proxyFromEnvironment("NO_PROXY"),
+		"Owner":               defaultOwner(),
+		"Backend":             command.BackendDocker,
+		"OCIRuntime":          "",
+		"ContainerOS":         "linux",
+		"Platform":            "",
+		"ScriptsDir":          "",
+		"Manifests":           "false",
+		"PullPolicy":          command.PullMissing,
 	}
-)
+}
 
+// InitCmdContainer configures libcmd from the defaults, LIBCMD_*
+// environment variables and opts, in increasing order of precedence. See
+// ConfigSource.
 func InitCmdContainer(opts map[string]string) {
-	config = command.CmdConfig{}
-	for key, dflt := range cmdConfigDefaultOpts {
-		field := reflect.ValueOf(&config).Elem().FieldByName(key)
-		if value, ok := opts[key]; ok {
-			field.SetString(value)
-		} else {
-			field.SetString(dflt)
+	InitCmdContainerFromFile("", opts)
+}
+
+// InitCmdContainerFromFile is InitCmdContainer with the settings in the JSON
+// config file at path layered between the defaults and the environment.
+func InitCmdContainerFromFile(path string, opts map[string]string) {
+	cfg, sources, err := loadConfig(path, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config = cfg
+	configSources = sources
+	if config.Backend == command.BackendLocal {
+		return
+	}
+	if config.Backend != command.BackendDocker {
+		rt, err := command.NewRuntime(config, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := rt.Pull(fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag)); err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
 
-	client, err := docker.NewClient(config.DockerEndpoint)
+	client, err := command.NewDockerClient(config)
 	if err != nil {
 		log.Fatal(err)
 	}
-	globalDockerClient = client
-	if err := command.PullImage(globalDockerClient, config.ContainerRepository, config.ContainerTag); err != nil {
+	setDockerClient(client)
+	if config.ExecContainer != "" {
+		if err := command.CheckExecContainer(dockerClient(), config.ExecContainer); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if build := currentImageBuild(); build != nil {
+		if err := command.BuildImage(config, *build, config.ContainerRepository, config.ContainerTag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := command.PullPlatformImage(config, dockerClient(), config.ContainerRepository, config.ContainerTag, config.Platform); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func RunCommand(op string, args ...string) ([]string, error) {
-	goCmd, err := command.NewGoCmd(op, config, globalDockerClient)
-	if err == nil {
-		return goCmd.Run(args...)
+	return RunCommandWithOptions(op, command.RunOptions{}, args...)
+}
+
+func RunCommandWithOptions(op string, opts command.RunOptions, args ...string) ([]string, error) {
+	// Cached and deduplicated results are returned without reaching
+	// command.Run, so the policy is checked here as well.
+	if err := command.CheckPolicy(config, opts, op, args); err != nil {
+		return nil, err
+	}
+	if opts.IdempotencyKey != "" {
+		return runIdempotent(op, args, opts, func() ([]string, error) {
+			return runCommand(op, opts, args...)
+		})
+	}
+	return runCommand(op, opts, args...)
+}
+
+func runCommand(op string, opts command.RunOptions, args ...string) ([]string, error) {
+	if opts.CacheTTL > 0 {
+		imageID := opts.ImageID
+		if imageID == "" && opts.Image != "" {
+			// Results from another image are not cached under the ID of
+			// the command image.
+			imageID = "image:" + opts.Image
+			if client := dockerClient(); client != nil {
+				if info, err := client.InspectImage(opts.Image); err == nil {
+					imageID = info.ID
+				}
+			}
+		}
+		return runCached(op, args, opts, imageID, func() ([]string, error) {
+			return command.Run(op, config, dockerClient(), opts, args...)
+		})
 	}
-	if err != command.ErrCommandNotFound {
+	return command.Run(op, config, dockerClient(), opts, args...)
+}
+
+// proxyFromEnvironment defaults the proxy settings of command containers to
+// those of this process.
+func proxyFromEnvironment(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// defaultOwner labels command containers with the host name. Reap tells the
+// processes on a host apart by the pid they are labeled with as well.
+func defaultOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "libcmd"
+	}
+	return hostname
+}
+
+// ResolveImage returns the configured command image tag and the ID of the
+// image it currently refers to. Other backends than docker have no image
+// IDs, and runs in ExecContainer do not use the command image, so both
+// return command.ErrNotSupportedByRuntime.
+func ResolveImage() (string, string, error) {
+	client := dockerClient()
+	if err := command.CheckDockerBackend(config, client); err != nil {
+		return "", "", err
+	}
+	if config.ExecContainer != "" {
+		return "", "", command.ErrNotSupportedByRuntime
+	}
+	image := fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag)
+	info, err := client.InspectImage(image)
+	if err != nil {
+		return "", "", err
+	}
+	return image, info.ID, nil
+}
+
+// PinnedImage is the command image an op resolved to, for running it later in
+// the same image even if the tag has moved by then. Digest is the repository
+// digest, as repository@sha256:..., which can be pulled again wherever the
+// run happens. Images that were never pushed have none and are pinned by
+// their local ID instead.
+type PinnedImage struct {
+	Image  string
+	Digest string
+	ID     string
+}
+
+// PinImage resolves the image op runs in. It returns nil, for runs that go
+// unpinned, for ops that do not run in the command image, such as go
+// commands, for other backends than docker, for runs in ExecContainer and
+// while the command image has not been pulled.
+func PinImage(op string) (*PinnedImage, error) {
+	if !command.UsesCommandImage(op) {
+		return nil, nil
+	}
+	client := dockerClient()
+	if command.CheckDockerBackend(config, client) != nil || config.ExecContainer != "" {
+		return nil, nil
+	}
+	image := fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag)
+	info, err := command.InspectImagePlatform(config, image)
+	if e, ok := err.(*docker.Error); ok && e.Status == 404 {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
+	pinned := &PinnedImage{Image: image, ID: info.ID}
+	for _, repoDigest := range info.RepoDigests {
+		if i := strings.LastIndex(repoDigest, "@"); i != -1 && repoDigest[:i] == config.ContainerRepository {
+			pinned.Digest = repoDigest
+			break
+		}
+	}
+	return pinned, nil
+}
+
+// ImageInfo returns the ID and platform of the configured command image, for
+// checking which variant was pulled.
+func ImageInfo() (*command.ImageInfo, error) {
+	return command.InspectImagePlatform(config, fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag))
+}
+
+// NewSession starts a command container that is kept alive across runs until
+// it is closed or has been idle for idleTimeout. Zero disables the timeout.
+func NewSession(idleTimeout time.Duration) (*command.Session, error) {
+	return command.NewSession(config, dockerClient(), idleTimeout)
+}
+
+// Pipe builds a pipeline where the stdout of each stage is streamed to the
+// stdin of the next.
+func Pipe(stages ...command.PipeStage) (*command.Pipeline, error) {
+	return command.NewPipeline(config, dockerClient(), stages...)
+}
+
+// StartDetached starts a command whose result survives restarts of this
+// process, the docker daemon or the host. See command.StartDetached.
+func StartDetached(runID, op string, args ...string) error {
+	return command.StartDetached(config, dockerClient(), runID, op, args...)
+}
+
+func RecoverDetached(runID string) ([]string, error) {
+	return command.RecoverDetached(config, dockerClient(), runID)
+}
+
+func ListDetached() ([]string, error) {
+	return command.ListDetached(config)
+}
+
+// EnsureNetwork creates a user-defined network for command containers, for
+// example a dual-stack or IPv6-only network, unless it already exists.
+func EnsureNetwork(spec command.NetworkSpec) error {
+	return command.EnsureNetwork(config, spec)
+}
+
+// BreakGlassShell starts an audited interactive shell. See
+// command.BreakGlassShell.
+func BreakGlassShell(req command.BreakGlassRequest, stdin io.Reader, stdout io.Writer) error {
+	return command.BreakGlassShell(config, dockerClient(), req, stdin, stdout)
+}
 
-	containerCmd, err := command.NewContainerCmd(op, config, globalDockerClient)
-	if err == nil {
-		return containerCmd.Run(args...)
+// Capabilities reports what the configured backend supports.
+func Capabilities() command.Capabilities {
+	return command.BackendCapabilities(config)
+}
+
+// Reap removes containers left behind by earlier processes with the same
+// Owner. See command.Reap.
+func Reap() ([]string, error) {
+	return command.Reap(config, dockerClient())
+}
+
+// StartReaper calls Reap every interval until the returned function is
+// called.
+func StartReaper(interval time.Duration) func() {
+	return command.StartReaper(config, dockerClient(), interval)
+}
+
+// PruneImages removes images pulled by libcmd according to opts. See
+// command.PruneImages.
+func PruneImages(opts command.ImageGCOptions) ([]string, error) {
+	return command.PruneImages(config, opts)
+}
+
+// StartImageGC calls PruneImages every interval until the returned function
+// is called.
+func StartImageGC(opts command.ImageGCOptions, interval time.Duration) func() {
+	return command.StartImageGC(config, opts, interval)
+}
+
+// Shutdown stops new runs and waits for those in flight until ctx is done.
+// See command.Shutdown.
+func Shutdown(ctx context.Context) error {
+	return command.Shutdown(ctx, dockerClient())
+}
+
+func dockerClient() *docker.Client {
+	globalDockerClientMu.RLock()
+	defer globalDockerClientMu.RUnlock()
+	return globalDockerClient
+}
+
+func setDockerClient(client *docker.Client) {
+	globalDockerClientMu.Lock()
+	globalDockerClient = client
+	globalDockerClientMu.Unlock()
+}
+
+// Ping checks that the docker daemon responds.
+func Ping() error {
+	return command.Ping(config)
+}
+
+// StartHealthMonitor pings the docker daemon every interval and replaces the
+// docker client when the daemon responds again after failing, so that no
+// connections from before a daemon restart are reused.
+func StartHealthMonitor(interval time.Duration) *command.HealthMonitor {
+	return command.StartHealthMonitor(config, interval, func() {
+		client, err := command.NewDockerClient(config)
+		if err != nil {
+			log.Errorf("error reconnecting to docker daemon: %s", err)
+			return
+		}
+		setDockerClient(client)
+	})
+}
+
+// NewExecPool starts size warm command containers that runs exec into. See
+// command.ExecPool.
+func NewExecPool(size int) (*command.ExecPool, error) {
+	return command.NewExecPool(config, dockerClient(), size)
+}
+
+// SetScripts injects the command scripts from fsys into command containers
+// instead of using those in the image. See command.SetScripts.
+func SetScripts(fsys fs.FS) {
+	command.SetScripts(fsys)
+}
+
+// ListCommands returns the ops that have a script in the command image. See
+// command.ListCommands.
+func ListCommands() ([]string, error) {
+	return command.ListCommands(config, dockerClient())
+}
+
+// Describe returns the manifest of op. See command.Describe.
+func Describe(op string) (*command.Manifest, error) {
+	return command.Describe(config, dockerClient(), op)
+}
+
+// RunJSON runs op and unmarshals its stdout into v. A command that fails
+// returns its error as RunCommand does, and output that does not decode
+// returns a *command.DecodeError holding it. Canceling ctx cancels the run.
+func RunJSON(ctx context.Context, v interface{}, op string, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return nil, err
+	cancel, stop := cancelOnDone(ctx)
+	defer stop()
+	output, err := RunCommandWithOptions(op, command.RunOptions{Cancel: cancel, Context: ctx}, args...)
+	if err != nil {
+		return err
+	}
+	return command.DecodeJSON(op, output, v)
+}
+
+// cancelOnDone returns a RunOptions.Cancel channel that is closed when ctx is
+// done, until stop is called.
+func cancelOnDone(ctx context.Context) (<-chan bool, func()) {
+	done := make(chan struct{})
+	cancel := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(cancel)
+		case <-done:
+		}
+	}()
+	return cancel, func() { close(done) }
+}
+
+// RunResult runs op and returns both of its output streams. Unlike
+// RunCommandWithOptions, it neither caches nor deduplicates runs. See
+// command.RunResult.
+func RunResult(op string, opts command.RunOptions, args ...string) *command.Result {
+	return command.RunResult(op, config, dockerClient(), opts, args...)
+}
+
+// RunInteractive runs op with a TTY attached to stdin and stdout. See
+// command.RunInteractive.
+func RunInteractive(op string, opts command.RunOptions, stdin io.Reader, stdout io.Writer, sizes <-chan command.TerminalSize, args ...string) error {
+	return command.RunInteractive(config, dockerClient(), opts, op, stdin, stdout, sizes, args...)
+}
+
+// Start runs op without waiting for it, returning an execution that can be
+// signaled. Unlike RunCommandWithOptions, it neither caches nor
+// deduplicates runs. See command.Start.
+func Start(op string, opts command.RunOptions, args ...string) *command.Execution {
+	return command.Start(op, config, dockerClient(), opts, args...)
+}
+
+// RunWithArtifacts runs op and copies the artifacts it writes out of the
+// command container before it is removed, to artifacts.HostDir or as a tar
+// stream to artifacts.Writer. Runs with artifacts are never cached or
+// deduplicated, since the files would not be copied again.
+func RunWithArtifacts(op string, opts command.RunOptions, artifacts command.ArtifactOptions, args ...string) ([]string, error) {
+	opts.Artifacts = &artifacts
+	opts.IdempotencyKey, opts.CacheTTL = "", 0
+	return RunCommandWithOptions(op, opts, args...)
+}
+
+// CreateVolume creates a named volume that runs can attach with
+// RunOptions.Volumes. See command.CreateVolume.
+func CreateVolume(name string, labels map[string]string) (*command.Volume, error) {
+	return command.CreateVolume(config, name, labels)
+}
+
+// ListVolumes returns the volumes created by libcmd.
+func ListVolumes() ([]command.Volume, error) {
+	return command.ListVolumes(config)
+}
+
+// RemoveVolume removes a volume created by libcmd.
+func RemoveVolume(name string) error {
+	return command.RemoveVolume(config, name)
+}
+
+// Prefetch pulls images, or the command image when none are given, so that
+// the first run does not wait for a pull. Warm also creates a throwaway
+// container from each. See command.Prefetch.
+func Prefetch(ctx context.Context, warm bool, images ...string) error {
+	if len(images) == 0 {
+		images = []string{fmt.Sprintf("%s:%s", config.ContainerRepository, config.ContainerTag)}
+	}
+	return command.Prefetch(ctx, config, dockerClient(), command.PrefetchOptions{Warm: warm}, images...)
+}
+
+// SetPolicy registers the policy that decides which callers may run which
+// commands. See command.SetPolicy.
+func SetPolicy(p command.Policy) {
+	command.SetPolicy(p)
+}
+
+// SetAllowPrivileged honors requests for privileged runs. See
+// command.SetAllowPrivileged.
+func SetAllowPrivileged(allow bool) {
+	command.SetAllowPrivileged(allow)
+}
+
+// SetPullProgress registers the function that receives the progress of
+// image pulls. See command.SetPullProgress.
+func SetPullProgress(fn command.PullProgressFunc) {
+	command.SetPullProgress(fn)
+}
+
+// RunScript runs an ad-hoc script in a command container. See
+// command.RunScript.
+func RunScript(ctx context.Context, script string, args ...string) ([]string, error) {
+	return command.RunScript(ctx, config, dockerClient(), command.RunOptions{Context: ctx}, script, args...)
 }
@@ -0,0 +1,181 @@
+// Package lite runs container commands using only the standard library and
+// the docker remote API. It has no dependency on logrus or go-dockerclient,
+// for programs that only need to run command scripts and care about binary
+// size. Go commands such as aws_auth are not available here.
+package lite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+var (
+	ErrCommandResponse = errors.New("error running command")
+)
+
+type Config struct {
+	CommandsDir         string
+	DockerEndpoint      string
+	ContainerRepository string
+	ContainerTag        string
+}
+
+type Client struct {
+	config     Config
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(config Config) (*Client, error) {
+	u, err := url.Parse(config.DockerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{config: config}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		c.baseURL = "http://docker"
+		c.httpClient = &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return net.Dial("unix", socket)
+				},
+			},
+		}
+	case "tcp", "http":
+		c.baseURL = "http://" + u.Host
+		c.httpClient = http.DefaultClient
+	default:
+		return nil, fmt.Errorf("unsupported docker endpoint %s", config.DockerEndpoint)
+	}
+	return c, nil
+}
+
+func (c *Client) PullImage() error {
+	q := url.Values{}
+	q.Set("fromImage", c.config.ContainerRepository)
+	q.Set("tag", c.config.ContainerTag)
+	resp, err := c.do("POST", "/images/create?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodePullProgress(resp.Body)
+}
+
+// decodePullProgress reads the progress stream of a pull to its end, which
+// is when the pull completes. It returns the first error the stream
+// reports, since the daemon reports a failed pull there rather than in the
+// response status.
+func decodePullProgress(r io.Reader) error {
+	var pullErr error
+	decoder := json.NewDecoder(r)
+	for {
+		var event struct {
+			Error string
+		}
+		if err := decoder.Decode(&event); err == io.EOF {
+			return pullErr
+		} else if err != nil {
+			io.Copy(ioutil.Discard, r)
+			if pullErr != nil {
+				return pullErr
+			}
+			return err
+		}
+		if event.Error != "" && pullErr == nil {
+			pullErr = errors.New(event.Error)
+		}
+	}
+}
+
+// Run runs <CommandsDir>/<op>.sh in a new container and returns its stdout,
+// or its stderr along with ErrCommandResponse if it exits non-zero.
+func (c *Client) Run(op string, args ...string) ([]string, error) {
+	cmdParts := []string{"bash", fmt.Sprintf("%s/%s.sh", c.config.CommandsDir, op)}
+	cmdParts = append(cmdParts, args...)
+	createBody := map[string]interface{}{
+		"Image": fmt.Sprintf("%s:%s", c.config.ContainerRepository, c.config.ContainerTag),
+		"Cmd":   cmdParts,
+	}
+	var created struct{ Id string }
+	if err := c.doJSON("POST", "/containers/create", createBody, &created); err != nil {
+		return nil, err
+	}
+	defer c.doJSON("DELETE", "/containers/"+created.Id+"?force=1", nil, nil)
+
+	if err := c.doJSON("POST", "/containers/"+created.Id+"/start", nil, nil); err != nil {
+		return nil, err
+	}
+	var waited struct{ StatusCode int }
+	if err := c.doJSON("POST", "/containers/"+created.Id+"/wait", nil, &waited); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do("GET", "/containers/"+created.Id+"/logs?stdout=1&stderr=1", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, resp.Body); err != nil {
+		return nil, err
+	}
+
+	if waited.StatusCode == 0 {
+		return []string{strings.TrimSpace(stdout.String())}, nil
+	}
+	return []string{strings.TrimSpace(stderr.String())}, ErrCommandResponse
+}
+
+func (c *Client) do(method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (c *Client) doJSON(method, path string, body, result interface{}) error {
+	resp, err := c.do(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+)
+
+// AsyncLogger hands messages to another Logger in the background, so that a
+// logger forwarding to a slow or unreachable service does not hold up the
+// caller. Messages are dropped, and counted, when the buffer is full.
+type AsyncLogger struct {
+	logger   Logger
+	messages chan message
+	done     chan bool
+
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu      sync.Mutex
+	dropped int64
+}
+
+type message struct {
+	level string
+	text  string
+}
+
+// NewAsyncLogger buffers up to size messages for l.
+func NewAsyncLogger(l Logger, size int) *AsyncLogger {
+	a := &AsyncLogger{
+		logger:   l,
+		messages: make(chan message, size),
+		done:     make(chan bool),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncLogger) Debugf(format string, args ...interface{}) {
+	a.send("debug", format, args)
+}
+
+func (a *AsyncLogger) Infof(format string, args ...interface{}) {
+	a.send("info", format, args)
+}
+
+func (a *AsyncLogger) Errorf(format string, args ...interface{}) {
+	a.send("error", format, args)
+}
+
+func (a *AsyncLogger) send(level, format string, args []interface{}) {
+	a.closeMu.RLock()
+	defer a.closeMu.RUnlock()
+	if a.closed {
+		a.mu.Lock()
+		a.dropped++
+		a.mu.Unlock()
+		return
+	}
+	select {
+	case a.messages <- message{level, fmt.Sprintf(format, args...)}:
+	default:
+		a.mu.Lock()
+		a.dropped++
+		a.mu.Unlock()
+	}
+}
+
+func (a *AsyncLogger) run() {
+	defer close(a.done)
+	for m := range a.messages {
+		switch m.level {
+		case "debug":
+			a.logger.Debugf("%s", m.text)
+		case "info":
+			a.logger.Infof("%s", m.text)
+		default:
+			a.logger.Errorf("%s", m.text)
+		}
+	}
+}
+
+// Dropped returns the number of messages dropped because the buffer was
+// full or the logger closed.
+func (a *AsyncLogger) Dropped() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.dropped
+}
+
+// Close writes out the buffered messages and stops. Set another logger
+// before calling it, as messages sent after Close are dropped.
+func (a *AsyncLogger) Close() {
+	a.closeMu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.messages)
+	}
+	a.closeMu.Unlock()
+	<-a.done
+}
@@ -0,0 +1,92 @@
+// Package logger is the logging facade used throughout libcmd. It logs
+// through the standard library by default; call SetLogger to route messages
+// elsewhere. *logrus.Logger and zap's *SugaredLogger satisfy Logger as is.
+package logger
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+var (
+	mu      sync.RWMutex
+	current Logger = NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), false)
+)
+
+// SetLogger replaces the logger used by libcmd. A nil logger discards all
+// messages.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	mu.Lock()
+	current = l
+	mu.Unlock()
+}
+
+func get() Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+func Debugf(format string, args ...interface{}) {
+	format, args = redactf(format, args)
+	get().Debugf(format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	format, args = redactf(format, args)
+	get().Infof(format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	format, args = redactf(format, args)
+	get().Errorf(format, args...)
+}
+
+// Fatal logs args as an error and exits the process.
+func Fatal(args ...interface{}) {
+	get().Errorf("%s", Redact(fmt.Sprint(args...)))
+	os.Exit(1)
+}
+
+// StdLogger adapts a standard library *log.Logger.
+type StdLogger struct {
+	logger *log.Logger
+	debug  bool
+}
+
+// NewStdLogger returns a Logger writing to l. Debug messages are dropped
+// unless debug is set.
+func NewStdLogger(l *log.Logger, debug bool) *StdLogger {
+	return &StdLogger{l, debug}
+}
+
+func (l *StdLogger) Debugf(format string, args ...interface{}) {
+	if l.debug {
+		l.logger.Printf("DEBUG "+format, args...)
+	}
+}
+
+func (l *StdLogger) Infof(format string, args ...interface{}) {
+	l.logger.Printf("INFO "+format, args...)
+}
+
+func (l *StdLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Printf("ERROR "+format, args...)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
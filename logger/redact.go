@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   = map[string]int{}
+)
+
+// AddSecrets masks values in every message logged until the returned
+// function is called.
+func AddSecrets(values ...string) func() {
+	secretsMu.Lock()
+	for _, v := range values {
+		if v != "" {
+			secrets[v]++
+		}
+	}
+	secretsMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			secretsMu.Lock()
+			for _, v := range values {
+				if v == "" {
+					continue
+				}
+				if secrets[v]--; secrets[v] <= 0 {
+					delete(secrets, v)
+				}
+			}
+			secretsMu.Unlock()
+		})
+	}
+}
+
+// Redact masks the registered secrets in s. Longer secrets are masked first,
+// so that a secret holding another is masked whole.
+func Redact(s string) string {
+	secretsMu.RLock()
+	values := make(byLength, 0, len(secrets))
+	for v := range secrets {
+		values = append(values, v)
+	}
+	secretsMu.RUnlock()
+	sort.Sort(values)
+	for _, v := range values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	return s
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func hasSecrets() bool {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	return len(secrets) > 0
+}
+
+// redactf formats the message itself when secrets are registered so that
+// they can be masked before reaching the logger.
+func redactf(format string, args []interface{}) (string, []interface{}) {
+	if !hasSecrets() {
+		return format, args
+	}
+	return "%s", []interface{}{Redact(fmt.Sprintf(format, args...))}
+}
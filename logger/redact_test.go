@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRedactLongestFirst(t *testing.T) {
+	remove := AddSecrets("abc", "abc123")
+	defer remove()
+
+	if got, want := Redact("token=abc123 key=abc"), "token=[REDACTED] key=[REDACTED]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAddSecretsCountsRegistrations(t *testing.T) {
+	first := AddSecrets("hunter2")
+	second := AddSecrets("hunter2")
+
+	first()
+	first()
+	if got := Redact("hunter2"); got != redacted {
+		t.Errorf("secret unmasked while still registered: %q", got)
+	}
+	second()
+	if got := Redact("hunter2"); got != "hunter2" {
+		t.Errorf("secret masked after removal: %q", got)
+	}
+}
+
+func TestLoggedMessagesAreRedacted(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer SetLogger(get())
+	SetLogger(NewStdLogger(log.New(buf, "", 0), true))
+	remove := AddSecrets("s3cret")
+	defer remove()
+
+	Debugf("connecting with %s", "s3cret")
+	Errorf("login failed for %s", "s3cret")
+	if strings.Contains(buf.String(), "s3cret") || strings.Count(buf.String(), redacted) != 2 {
+		t.Errorf("got log %q", buf.String())
+	}
+}
@@ -0,0 +1,31 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SlogLogger adapts a *slog.Logger.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	return &SlogLogger{l}
+}
+
+func (l *SlogLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *SlogLogger) Infof(format string, args ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *SlogLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelError, fmt.Sprintf(format, args...))
+}
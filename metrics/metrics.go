@@ -0,0 +1,243 @@
+// Package metrics collects command execution metrics and serves them in the
+// Prometheus text exposition format.
+//
+//	m := metrics.New()
+//	command.SetMetrics(m)
+//	http.Handle("/metrics", m)
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultBuckets are the histogram upper bounds in seconds.
+	DefaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []int64
+	sum     float64
+	count   int64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]int64, len(buckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, braces(labels), h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+// Metrics implements command.Metrics.
+type Metrics struct {
+	mu             sync.Mutex
+	runsStarted    map[string]int64
+	runsSucceeded  map[string]int64
+	runsFailed     map[string]int64
+	runDuration    map[string]*histogram
+	runSeconds     map[string]float64
+	pullDuration   *histogram
+	pullFailures   int64
+	createLatency  *histogram
+	createFailures int64
+	startLatency   *histogram
+	startFailures  int64
+	inFlight       int64
+	leaked         map[string]int
+}
+
+func New() *Metrics {
+	return &Metrics{
+		runsStarted:   map[string]int64{},
+		runsSucceeded: map[string]int64{},
+		runsFailed:    map[string]int64{},
+		runDuration:   map[string]*histogram{},
+		runSeconds:    map[string]float64{},
+		pullDuration:  newHistogram(DefaultBuckets),
+		createLatency: newHistogram(DefaultBuckets),
+		startLatency:  newHistogram(DefaultBuckets),
+		leaked:        map[string]int{},
+	}
+}
+
+func (m *Metrics) RunStarted(op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runsStarted[op]++
+	m.inFlight++
+}
+
+func (m *Metrics) RunFinished(op string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	if err == nil {
+		m.runsSucceeded[op]++
+	} else {
+		m.runsFailed[op]++
+	}
+	h, exists := m.runDuration[op]
+	if !exists {
+		h = newHistogram(DefaultBuckets)
+		m.runDuration[op] = h
+	}
+	h.observe(duration)
+	m.runSeconds[op] += duration.Seconds()
+}
+
+func (m *Metrics) PullFinished(image string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pullDuration.observe(duration)
+	if err != nil {
+		m.pullFailures++
+	}
+}
+
+func (m *Metrics) ContainerCreated(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.createLatency.observe(duration)
+	if err != nil {
+		m.createFailures++
+	}
+}
+
+func (m *Metrics) ContainerStarted(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.startLatency.observe(duration)
+	if err != nil {
+		m.startFailures++
+	}
+}
+
+// LeakedResources implements command.LeakMetrics.
+func (m *Metrics) LeakedResources(kind string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leaked[kind] = count
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteText(w)
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func (m *Metrics) WriteText(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeCounterVec(w, "libcmd_runs_started_total", "Command runs started.", m.runsStarted)
+	writeCounterVec(w, "libcmd_runs_succeeded_total", "Command runs that succeeded.", m.runsSucceeded)
+	writeCounterVec(w, "libcmd_runs_failed_total", "Command runs that failed.", m.runsFailed)
+
+	writeHeader(w, "libcmd_run_duration_seconds", "Command run duration.", "histogram")
+	for _, op := range sortedKeys(m.runDuration) {
+		m.runDuration[op].write(w, "libcmd_run_duration_seconds", fmt.Sprintf("op=%q", op))
+	}
+
+	writeHeader(w, "libcmd_run_seconds_total", "Total time spent running commands.", "counter")
+	for _, op := range sortedFloatKeys(m.runSeconds) {
+		fmt.Fprintf(w, "libcmd_run_seconds_total{op=%q} %g\n", op, m.runSeconds[op])
+	}
+
+	writeHeader(w, "libcmd_runs_in_flight", "Command runs currently in progress.", "gauge")
+	fmt.Fprintf(w, "libcmd_runs_in_flight %d\n", m.inFlight)
+
+	writeHeader(w, "libcmd_image_pull_duration_seconds", "Image pull duration.", "histogram")
+	m.pullDuration.write(w, "libcmd_image_pull_duration_seconds", "")
+	writeCounter(w, "libcmd_image_pull_failures_total", "Image pulls that failed.", m.pullFailures)
+
+	writeHeader(w, "libcmd_container_create_duration_seconds", "Container create latency.", "histogram")
+	m.createLatency.write(w, "libcmd_container_create_duration_seconds", "")
+	writeCounter(w, "libcmd_container_create_failures_total", "Container creates that failed.", m.createFailures)
+
+	writeHeader(w, "libcmd_container_start_duration_seconds", "Container start latency.", "histogram")
+	m.startLatency.write(w, "libcmd_container_start_duration_seconds", "")
+	writeCounter(w, "libcmd_container_start_failures_total", "Container starts that failed.", m.startFailures)
+
+	writeHeader(w, "libcmd_leaked_resources", "Resources left behind by runs that the reaper has not removed.", "gauge")
+	kinds := make([]string, 0, len(m.leaked))
+	for kind := range m.leaked {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "libcmd_leaked_resources{kind=%q} %d\n", kind, m.leaked[kind])
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounter(w io.Writer, name, help string, value int64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func writeCounterVec(w io.Writer, name, help string, values map[string]int64) {
+	writeHeader(w, name, help, "counter")
+	ops := make([]string, 0, len(values))
+	for op := range values {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fmt.Fprintf(w, "%s{op=%q} %d\n", name, op, values[op])
+	}
+}
+
+func sortedKeys(m map[string]*histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFloatKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
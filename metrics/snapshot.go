@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+// Snapshot holds the cumulative counters of a Metrics so that they can
+// outlive the process. Histograms and gauges are not included.
+type Snapshot struct {
+	Ops            map[string]OpTotals
+	PullFailures   int64
+	CreateFailures int64
+	StartFailures  int64
+	TakenAt        time.Time
+}
+
+type OpTotals struct {
+	Started   int64
+	Succeeded int64
+	Failed    int64
+	Seconds   float64
+}
+
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := Snapshot{
+		Ops:            map[string]OpTotals{},
+		PullFailures:   m.pullFailures,
+		CreateFailures: m.createFailures,
+		StartFailures:  m.startFailures,
+		TakenAt:        time.Now(),
+	}
+	for _, counts := range []map[string]int64{m.runsStarted, m.runsSucceeded, m.runsFailed} {
+		for op := range counts {
+			s.Ops[op] = OpTotals{
+				Started:   m.runsStarted[op],
+				Succeeded: m.runsSucceeded[op],
+				Failed:    m.runsFailed[op],
+				Seconds:   m.runSeconds[op],
+			}
+		}
+	}
+	return s
+}
+
+// Restore adds the counters in s to the current ones. It is meant to be
+// called once at startup, before commands run.
+func (m *Metrics) Restore(s Snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for op, totals := range s.Ops {
+		m.runsStarted[op] += totals.Started
+		m.runsSucceeded[op] += totals.Succeeded
+		m.runsFailed[op] += totals.Failed
+		m.runSeconds[op] += totals.Seconds
+	}
+	m.pullFailures += s.PullFailures
+	m.createFailures += s.CreateFailures
+	m.startFailures += s.StartFailures
+}
+
+// Reset zeroes every metric.
+func (m *Metrics) Reset() {
+	fresh := New()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inFlight := m.inFlight
+	m.runsStarted = fresh.runsStarted
+	m.runsSucceeded = fresh.runsSucceeded
+	m.runsFailed = fresh.runsFailed
+	m.runDuration = fresh.runDuration
+	m.runSeconds = fresh.runSeconds
+	m.pullDuration = fresh.pullDuration
+	m.pullFailures = 0
+	m.createLatency = fresh.createLatency
+	m.createFailures = 0
+	m.startLatency = fresh.startLatency
+	m.startFailures = 0
+	// Runs still in progress will report finishing.
+	m.inFlight = inFlight
+}
+
+// Save writes a snapshot to path.
+func (m *Metrics) Save(path string) error {
+	b, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load restores the snapshot saved at path. A missing file is not an error.
+func (m *Metrics) Load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	m.Restore(s)
+	return nil
+}
+
+// Persist loads the snapshot at path and then saves to it every interval
+// until the returned function is called, which saves one last time.
+func (m *Metrics) Persist(path string, interval time.Duration) (func(), error) {
+	if err := m.Load(path); err != nil {
+		return nil, err
+	}
+	stopCh := make(chan bool)
+	doneCh := make(chan bool)
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Save(path); err != nil {
+					log.Errorf("error saving metrics snapshot: %s", err)
+				}
+			case <-stopCh:
+				if err := m.Save(path); err != nil {
+					log.Errorf("error saving metrics snapshot: %s", err)
+				}
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+		<-doneCh
+	}, nil
+}
@@ -0,0 +1,364 @@
+// Package nerdctl runs commands on containerd through the nerdctl CLI, for
+// hosts that have containerd but no docker daemon, such as Kubernetes nodes
+// after the removal of dockershim.
+//
+// The backend is a command.Runtime, so runs go through the hooks, policy and
+// checks of container commands, and libcmd can select it by name with
+// CmdConfig.Backend once New has registered it.
+//
+//	b, err := nerdctl.New(nerdctl.Config{Namespace: "k8s.io", Image: "freighterio/cmd:latest", CommandsDir: "/root/commands"})
+//	output, err := b.Run("random", "16")
+package nerdctl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+// BackendName is the backend New registers the runtime as unless
+// Config.Name is set.
+const BackendName = "nerdctl"
+
+type Config struct {
+	// Name registers the runtime with command.RegisterRuntime. Defaults
+	// to BackendName.
+	Name string
+	// Nerdctl is the path to the nerdctl binary. Defaults to "nerdctl" on
+	// the PATH.
+	Nerdctl string
+	// Address is the containerd socket. Empty uses nerdctl's default.
+	Address string
+	// Namespace is the containerd namespace. Empty uses nerdctl's
+	// default.
+	Namespace string
+
+	// Image is the command image, as repository:tag.
+	Image       string
+	CommandsDir string
+	// Env is passed to the command containers as "NAME=value", ahead of
+	// the env of each run.
+	Env []string
+}
+
+type Backend struct {
+	config Config
+}
+
+// New returns the backend and registers it as Config.Name.
+func New(config Config) (*Backend, error) {
+	if config.Image == "" {
+		return nil, errors.New("command image is required")
+	}
+	if config.Name == "" {
+		config.Name = BackendName
+	}
+	if config.Nerdctl == "" {
+		config.Nerdctl = "nerdctl"
+	}
+	path, err := exec.LookPath(config.Nerdctl)
+	if err != nil {
+		return nil, err
+	}
+	config.Nerdctl = path
+	b := &Backend{config: config}
+	command.RegisterRuntime(config.Name, b)
+	return b, nil
+}
+
+// CmdConfig returns the libcmd config that runs commands on this backend.
+func (b *Backend) CmdConfig() command.CmdConfig {
+	repository, tag := command.SplitImage(b.config.Image)
+	return command.CmdConfig{
+		Backend:             b.config.Name,
+		ContainerRepository: repository,
+		ContainerTag:        tag,
+		CommandsDir:         b.config.CommandsDir,
+	}
+}
+
+// Run runs op, a command registered with command.RegisterCommand, in a new
+// container and returns its stdout, or its stderr along with
+// command.ErrCommandResponse if it exits non-zero.
+func (b *Backend) Run(op string, args ...string) ([]string, error) {
+	return command.Run(op, b.CmdConfig(), nil, command.RunOptions{}, args...)
+}
+
+// Pull pulls image, such as Config.Image, into the containerd image store.
+func (b *Backend) Pull(image string) error {
+	log.Debugf("pulling image %s with nerdctl", image)
+	if _, _, err := b.nerdctl("pull", "--quiet", image); err != nil {
+		log.Errorf(" -> error pulling image %s: %s", image, err)
+		return err
+	}
+	log.Debugf(" -> pulling image %s complete", image)
+	return nil
+}
+
+func (b *Backend) Create(spec command.ContainerSpec) (string, error) {
+	createArgs := []string{"create", "--label", "libcmd.op=" + spec.Op, "--label", "libcmd.run-id=" + spec.RunID}
+	labels := []string{}
+	for k, v := range spec.Config.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		createArgs = append(createArgs, "--label", label)
+	}
+	for _, env := range append(append([]string{}, b.config.Env...), spec.Config.Env...) {
+		createArgs = append(createArgs, "--env", env)
+	}
+	if spec.Config.User != "" {
+		createArgs = append(createArgs, "--user", spec.Config.User)
+	}
+	if spec.Config.WorkingDir != "" {
+		createArgs = append(createArgs, "--workdir", spec.Config.WorkingDir)
+	}
+	cmd := spec.Config.Cmd
+	if spec.Config.Entrypoint != nil {
+		// nerdctl takes a single entrypoint, so the rest of it goes
+		// ahead of the command.
+		entrypoint := ""
+		if len(spec.Config.Entrypoint) > 0 {
+			entrypoint = spec.Config.Entrypoint[0]
+			cmd = append(append([]string{}, spec.Config.Entrypoint[1:]...), cmd...)
+		}
+		createArgs = append(createArgs, "--entrypoint", entrypoint)
+	}
+	hostArgs, err := hostConfigArgs(spec)
+	if err != nil {
+		return "", err
+	}
+	createArgs = append(createArgs, hostArgs...)
+	createArgs = append(createArgs, spec.Config.Image)
+	createArgs = append(createArgs, cmd...)
+
+	log.Debugf("creating container for %s with nerdctl", spec.Op)
+	stdout, _, err := b.nerdctl(createArgs...)
+	if err != nil {
+		log.Errorf(" -> error creating container for %s: %s", spec.Op, err)
+		return "", err
+	}
+	id := strings.TrimSpace(stdout)
+	log.Debugf(" -> container %s created", id)
+	return id, nil
+}
+
+// hostConfigArgs translates the host config of spec into nerdctl flags. It
+// returns command.ErrNotSupportedByRuntime for settings nerdctl has no flag
+// for, rather than running the command without them.
+func hostConfigArgs(spec command.ContainerSpec) ([]string, error) {
+	hc := spec.HostConfig
+	if hc == nil {
+		return nil, nil
+	}
+	if len(hc.DeviceRequests) > 0 || hc.UsernsMode != "" || (spec.Network != nil && len(spec.Network.Aliases) > 0) {
+		return nil, command.ErrNotSupportedByRuntime
+	}
+	var args []string
+	if hc.NetworkMode != "" {
+		args = append(args, "--network", hc.NetworkMode)
+	}
+	if spec.Network != nil && spec.Network.IPv4Address != "" {
+		args = append(args, "--ip", spec.Network.IPv4Address)
+	}
+	if spec.Network != nil && spec.Network.IPv6Address != "" {
+		args = append(args, "--ip6", spec.Network.IPv6Address)
+	}
+	for _, dns := range hc.DNS {
+		args = append(args, "--dns", dns)
+	}
+	for _, search := range hc.DNSSearch {
+		args = append(args, "--dns-search", search)
+	}
+	for _, host := range hc.ExtraHosts {
+		args = append(args, "--add-host", host)
+	}
+	for _, bind := range hc.Binds {
+		args = append(args, "--volume", bind)
+	}
+	paths := []string{}
+	for path := range hc.Tmpfs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		tmpfs := path
+		if hc.Tmpfs[path] != "" {
+			tmpfs += ":" + hc.Tmpfs[path]
+		}
+		args = append(args, "--tmpfs", tmpfs)
+	}
+	if hc.ReadonlyRootfs {
+		args = append(args, "--read-only")
+	}
+	for _, capability := range hc.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	for _, capability := range hc.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	for _, opt := range hc.SecurityOpt {
+		// The docker API takes seccomp profiles inline, nerdctl only as a
+		// file.
+		if strings.HasPrefix(opt, "seccomp=") && opt != "seccomp=unconfined" {
+			return nil, command.ErrNotSupportedByRuntime
+		}
+		args = append(args, "--security-opt", opt)
+	}
+	for _, ulimit := range hc.Ulimits {
+		args = append(args, "--ulimit", fmt.Sprintf("%s=%d:%d", ulimit.Name, ulimit.Soft, ulimit.Hard))
+	}
+	if hc.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(hc.PidsLimit, 10))
+	}
+	if hc.Privileged {
+		args = append(args, "--privileged")
+	}
+	if hc.PidMode != "" {
+		args = append(args, "--pid", hc.PidMode)
+	}
+	if hc.IpcMode != "" {
+		args = append(args, "--ipc", hc.IpcMode)
+	}
+	for _, device := range hc.Devices {
+		args = append(args, "--device", device.PathOnHost+":"+device.PathInContainer+":"+device.CgroupPermissions)
+	}
+	if hc.Runtime != "" {
+		args = append(args, "--runtime", hc.Runtime)
+	}
+	return args, nil
+}
+
+func (b *Backend) Start(id string) error {
+	log.Debugf("starting container %s", id)
+	if _, _, err := b.nerdctl("start", id); err != nil {
+		log.Errorf(" -> error starting container %s: %s", id, err)
+		return err
+	}
+	log.Debugf(" -> container %s started", id)
+	return nil
+}
+
+func (b *Backend) Wait(id string) (int, error) {
+	log.Debugf("waiting for container %s", id)
+	stdout, _, err := b.nerdctl("wait", id)
+	if err != nil {
+		log.Errorf(" -> error waiting for container %s: %s", id, err)
+		return -1, err
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(stdout))
+	if err != nil {
+		log.Errorf(" -> error reading exit code of container %s: %s", id, err)
+		return -1, err
+	}
+	log.Debugf(" -> container %s exited with code %d", id, exitCode)
+	return exitCode, nil
+}
+
+func (b *Backend) Logs(id string, limits command.OutputLimits, onChunk func(stdcopy.Chunk)) (command.Output, error) {
+	capture := command.NewOutputCapture(limits, onChunk)
+	exitCode, err := b.run(capture.Stdout, capture.Stderr, "logs", id)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("nerdctl logs exited with code %d", exitCode)
+	}
+	if err != nil {
+		log.Errorf(" -> error getting logs of container %s: %s", id, err)
+		return command.Output{}, err
+	}
+	return capture.Output(), nil
+}
+
+func (b *Backend) Kill(id string) error {
+	log.Debugf("killing container %s", id)
+	if _, _, err := b.nerdctl("kill", id); err != nil {
+		log.Errorf(" -> error killing container %s: %s", id, err)
+		return err
+	}
+	log.Debugf(" -> container %s killed", id)
+	return nil
+}
+
+func (b *Backend) Remove(id string) error {
+	log.Debugf("removing container %s", id)
+	if _, _, err := b.nerdctl("rm", "--force", id); err != nil {
+		log.Errorf(" -> error removing container %s: %s", id, err)
+		return err
+	}
+	log.Debugf(" -> container %s removed", id)
+	return nil
+}
+
+// Exec runs cmd in the running container id. nerdctl exits with the exit
+// code of cmd, which cannot be told apart from nerdctl itself failing.
+func (b *Backend) Exec(id string, cmd []string, limits command.OutputLimits) (command.Output, int, error) {
+	capture := command.NewOutputCapture(limits, nil)
+	exitCode, err := b.run(capture.Stdout, capture.Stderr, append([]string{"exec", id}, cmd...)...)
+	if err != nil {
+		return command.Output{}, -1, err
+	}
+	return capture.Output(), exitCode, nil
+}
+
+// Capabilities are those of the host config nerdctl takes flags for.
+// Streaming, artifacts and exec pools use the docker API.
+func (b *Backend) Capabilities() command.Capabilities {
+	return command.Capabilities{
+		ResourceLimits: true,
+		Devices:        true,
+	}
+}
+
+// nerdctl runs nerdctl with the global flags of the config and returns its
+// stdout and stderr. A failing nerdctl returns its stderr as the error.
+func (b *Backend) nerdctl(args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := b.command(&stdout, &stderr, args...).Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.String(), stderr.String(), errors.New(msg)
+		}
+		return stdout.String(), stderr.String(), err
+	}
+	return stdout.String(), stderr.String(), nil
+}
+
+// run runs nerdctl with its output going to stdout and stderr, and returns
+// its exit code. Only failing to run nerdctl at all is an error.
+func (b *Backend) run(stdout, stderr io.Writer, args ...string) (int, error) {
+	err := b.command(stdout, stderr, args...).Run()
+	if err == nil {
+		return 0, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return -1, err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus(), nil
+	}
+	return -1, err
+}
+
+func (b *Backend) command(stdout, stderr io.Writer, args ...string) *exec.Cmd {
+	var global []string
+	if b.config.Address != "" {
+		global = append(global, "--address", b.config.Address)
+	}
+	if b.config.Namespace != "" {
+		global = append(global, "--namespace", b.config.Namespace)
+	}
+	cmd := exec.Command(b.config.Nerdctl, append(global, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd
+}
@@ -0,0 +1,363 @@
+// Package queue runs commands in the background in priority order, retrying
+// failed jobs with exponential backoff and dead-lettering those that keep
+// failing.
+//
+// Jobs are only as durable as their Store. FileStore writes each job to its
+// own file with a rename, so a crash never leaves a partial job behind, but
+// it does not fsync, has no transactions across jobs and reads the whole
+// directory at start. It is not the durability of a database such as BoltDB
+// or SQLite; queues that must not lose jobs on power loss, or that hold many
+// thousands of them, should implement Store on one.
+package queue
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd"
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+var (
+	ErrQueueStopped = errors.New("queue stopped")
+	ErrJobExists    = errors.New("job already exists")
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	// JobDead marks a job that failed MaxAttempts times and was moved to
+	// the dead-letter list.
+	JobDead JobStatus = "dead"
+)
+
+type Job struct {
+	ID       string
+	Op       string
+	Args     []string
+	Priority int
+	// Image is the command image tag when the job was enqueued, and
+	// ImageDigest and ImageID the image it resolved to. The job runs
+	// ImageDigest, or ImageID for images without one. Jobs of ops that do
+	// not run in the command image are not pinned. See libcmd.PinImage.
+	Image       string
+	ImageDigest string
+	ImageID     string
+	Status      JobStatus
+	Attempts    int
+	// NotBefore is when a failed job is retried. It is zero for jobs that
+	// have not failed.
+	NotBefore  time.Time
+	Output     []string
+	Error      string
+	EnqueuedAt time.Time
+	UpdatedAt  time.Time
+}
+
+type Options struct {
+	// Workers is the number of jobs processed concurrently. Defaults to 1.
+	Workers int
+	// MaxAttempts is the number of times a failing job is run before it is
+	// dead-lettered. Defaults to 3.
+	MaxAttempts int
+	// RetryBackoff is how long a job waits to be retried after its first
+	// failure, doubling after each further failure up to MaxRetryBackoff.
+	// They default to a second and five minutes.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Run executes a job. Defaults to libcmd.RunCommandWithOptions.
+	Run func(op string, opts command.RunOptions, args ...string) ([]string, error)
+	// PinImage resolves the image the jobs of op run in, returning nil
+	// for jobs that run unpinned. Defaults to libcmd.PinImage.
+	PinImage func(op string) (*libcmd.PinnedImage, error)
+}
+
+// Queue processes enqueued commands in priority order, highest first, with
+// jobs of equal priority processed in the order they were enqueued.
+type Queue struct {
+	store Store
+	opts  Options
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending jobHeap
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewQueue loads the jobs in store. Jobs that were running when the process
+// last exited are queued again, and failed jobs wait out their NotBefore.
+func NewQueue(store Store, opts Options) (*Queue, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Second
+	}
+	if opts.MaxRetryBackoff <= 0 {
+		opts.MaxRetryBackoff = 5 * time.Minute
+	}
+	if opts.Run == nil {
+		opts.Run = libcmd.RunCommandWithOptions
+	}
+	if opts.PinImage == nil {
+		opts.PinImage = libcmd.PinImage
+	}
+	q := &Queue{store: store, opts: opts}
+	q.cond = sync.NewCond(&q.mu)
+
+	jobs, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		switch job.Status {
+		case JobRunning:
+			job.Status = JobPending
+			if err := store.Put(job); err != nil {
+				return nil, err
+			}
+			fallthrough
+		case JobPending:
+			q.schedule(job)
+		}
+	}
+	return q, nil
+}
+
+// Start launches the queue workers.
+func (q *Queue) Start() {
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop waits for running jobs to finish and stops the workers. Pending jobs
+// stay in the store and are picked up again by the next queue.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+func (q *Queue) Enqueue(op string, priority int, args ...string) (*Job, error) {
+	id, err := command.NewID()
+	if err != nil {
+		return nil, err
+	}
+	return q.EnqueueWithID(id, op, priority, args...)
+}
+
+// EnqueueWithID enqueues a job under an ID chosen by the caller, such as an
+// upstream request ID. The ID is also the run ID of every attempt.
+func (q *Queue) EnqueueWithID(id, op string, priority int, args ...string) (*Job, error) {
+	if err := command.CheckID(id); err != nil {
+		return nil, err
+	}
+	pinned, err := q.opts.PinImage(op)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job := &Job{
+		ID:         id,
+		Op:         op,
+		Args:       args,
+		Priority:   priority,
+		Status:     JobPending,
+		EnqueuedAt: now,
+		UpdatedAt:  now,
+	}
+	if pinned != nil {
+		job.Image, job.ImageDigest, job.ImageID = pinned.Image, pinned.Digest, pinned.ID
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return nil, ErrQueueStopped
+	}
+	if _, err := q.store.Get(id); err == nil {
+		return nil, ErrJobExists
+	} else if err != ErrJobNotFound {
+		return nil, err
+	}
+	if err := q.store.Put(job); err != nil {
+		return nil, err
+	}
+	heap.Push(&q.pending, job)
+	q.cond.Signal()
+	copied := *job
+	return &copied, nil
+}
+
+func (q *Queue) Status(id string) (*Job, error) {
+	return q.store.Get(id)
+}
+
+// DeadLetters returns the jobs that exhausted their attempts.
+func (q *Queue) DeadLetters() ([]*Job, error) {
+	jobs, err := q.store.List()
+	if err != nil {
+		return nil, err
+	}
+	dead := []*Job{}
+	for _, job := range jobs {
+		if job.Status == JobDead {
+			dead = append(dead, job)
+		}
+	}
+	return dead, nil
+}
+
+// Requeue moves a dead-lettered job back to the queue with its attempts
+// reset.
+func (q *Queue) Requeue(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if job.Status != JobDead {
+		return errors.New("only dead jobs can be requeued")
+	}
+	job.Status = JobPending
+	job.Attempts = 0
+	job.NotBefore = time.Time{}
+	job.UpdatedAt = time.Now()
+	if err := q.store.Put(job); err != nil {
+		return err
+	}
+	heap.Push(&q.pending, job)
+	q.cond.Signal()
+	return nil
+}
+
+// schedule queues job once its NotBefore has passed. It must be called with
+// q.mu held. A queue stopped before then leaves the job pending in the
+// store.
+func (q *Queue) schedule(job *Job) {
+	if delay := time.Until(job.NotBefore); delay > 0 {
+		time.AfterFunc(delay, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if !q.stopped {
+				heap.Push(&q.pending, job)
+				q.cond.Signal()
+			}
+		})
+		return
+	}
+	heap.Push(&q.pending, job)
+	q.cond.Signal()
+}
+
+// retryBackoff is how long a job waits to be retried after failing attempts
+// times.
+func (q *Queue) retryBackoff(attempts int) time.Duration {
+	backoff := q.opts.RetryBackoff
+	for i := 1; i < attempts && backoff < q.opts.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.opts.MaxRetryBackoff {
+		backoff = q.opts.MaxRetryBackoff
+	}
+	return backoff
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for !q.stopped && q.pending.Len() == 0 {
+			q.cond.Wait()
+		}
+		if q.stopped {
+			q.mu.Unlock()
+			return
+		}
+		job := heap.Pop(&q.pending).(*Job)
+		job.Status = JobRunning
+		job.Attempts++
+		job.UpdatedAt = time.Now()
+		err := q.store.Put(job)
+		q.mu.Unlock()
+		if err != nil {
+			log.Errorf("error storing job %s: %s", job.ID, err)
+		}
+
+		q.run(job)
+	}
+}
+
+func (q *Queue) run(job *Job) {
+	log.Debugf("running job %s (%s), attempt %d", job.ID, job.Op, job.Attempts)
+	opts := command.RunOptions{RunID: job.ID}
+	if job.ImageDigest != "" {
+		opts.Image = job.ImageDigest
+	} else {
+		opts.ImageID = job.ImageID
+	}
+	output, err := q.opts.Run(job.Op, opts, job.Args...)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Output = output
+	job.UpdatedAt = time.Now()
+	if err == nil {
+		log.Debugf(" -> job %s succeeded", job.ID)
+		job.Status = JobSucceeded
+		job.Error = ""
+		job.NotBefore = time.Time{}
+	} else if job.Attempts >= q.opts.MaxAttempts {
+		log.Errorf(" -> job %s failed %d times, moving to dead letters: %s", job.ID, job.Attempts, err)
+		job.Status = JobDead
+		job.Error = err.Error()
+	} else {
+		backoff := q.retryBackoff(job.Attempts)
+		log.Debugf(" -> job %s failed, will retry in %s: %s", job.ID, backoff, err)
+		job.Status = JobPending
+		job.Error = err.Error()
+		job.NotBefore = job.UpdatedAt.Add(backoff)
+		q.schedule(job)
+	}
+	if err := q.store.Put(job); err != nil {
+		log.Errorf("error storing job %s: %s", job.ID, err)
+	}
+}
+
+type jobHeap []*Job
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].EnqueuedAt.Before(h[j].EnqueuedAt)
+}
+
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(*Job)) }
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	*h = old[:n-1]
+	return job
+}
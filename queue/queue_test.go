@@ -0,0 +1,257 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/replicatedcom/libcmd"
+	"github.com/replicatedcom/libcmd/command"
+)
+
+type ranJob struct {
+	op   string
+	opts command.RunOptions
+}
+
+// recordingRun returns a Run that sends the options of every job it runs.
+func recordingRun(ran chan<- ranJob) func(string, command.RunOptions, ...string) ([]string, error) {
+	return func(op string, opts command.RunOptions, args ...string) ([]string, error) {
+		ran <- ranJob{op, opts}
+		return []string{"done"}, nil
+	}
+}
+
+// pins resolves "pinned" to a digest, "unpinned" to an image without one,
+// and any other op to nothing.
+func pins(op string) (*libcmd.PinnedImage, error) {
+	switch op {
+	case "pinned":
+		return &libcmd.PinnedImage{Image: "libcmd:latest", Digest: "libcmd@sha256:aaa", ID: "sha256:bbb"}, nil
+	case "unpinned":
+		return &libcmd.PinnedImage{Image: "libcmd:latest", ID: "sha256:ccc"}, nil
+	}
+	return nil, nil
+}
+
+func waitForRuns(t *testing.T, ran <-chan ranJob, n int) map[string]command.RunOptions {
+	runs := map[string]command.RunOptions{}
+	for i := 0; i < n; i++ {
+		select {
+		case job := <-ran:
+			runs[job.op] = job.opts
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ran %d jobs, want %d", i, n)
+		}
+	}
+	return runs
+}
+
+func TestQueueRunsPinnedImage(t *testing.T) {
+	ran := make(chan ranJob, 3)
+	store := NewMemoryStore(0, 0)
+	q, err := NewQueue(store, Options{Run: recordingRun(ran), PinImage: pins})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]string{}
+	for _, op := range []string{"pinned", "unpinned", "go-command"} {
+		job, err := q.Enqueue(op, 0)
+		if err != nil {
+			t.Fatalf("enqueueing %s: %s", op, err)
+		}
+		ids[op] = job.ID
+	}
+	if job, _ := q.Status(ids["pinned"]); job.ImageDigest != "libcmd@sha256:aaa" || job.ImageID != "sha256:bbb" {
+		t.Errorf("stored pin %s %s", job.ImageDigest, job.ImageID)
+	}
+
+	q.Start()
+	runs := waitForRuns(t, ran, 3)
+	q.Stop()
+
+	if opts := runs["pinned"]; opts.Image != "libcmd@sha256:aaa" || opts.ImageID != "" {
+		t.Errorf("pinned job ran image %q, id %q", opts.Image, opts.ImageID)
+	}
+	if opts := runs["unpinned"]; opts.Image != "" || opts.ImageID != "sha256:ccc" {
+		t.Errorf("job without digest ran image %q, id %q", opts.Image, opts.ImageID)
+	}
+	if opts := runs["go-command"]; opts.Image != "" || opts.ImageID != "" {
+		t.Errorf("unpinned job ran image %q, id %q", opts.Image, opts.ImageID)
+	}
+	for op, id := range ids {
+		if job, _ := q.Status(id); job.Status != JobSucceeded || runs[op].RunID != id {
+			t.Errorf("%s: got status %s, run ID %s", op, job.Status, runs[op].RunID)
+		}
+	}
+}
+
+func TestQueueKeepsPinAcrossRestart(t *testing.T) {
+	store := NewMemoryStore(0, 0)
+	first, err := NewQueue(store, Options{Run: recordingRun(nil), PinImage: pins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Enqueue("pinned", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan ranJob, 1)
+	repinned := func(op string) (*libcmd.PinnedImage, error) {
+		return &libcmd.PinnedImage{Digest: "libcmd@sha256:moved"}, nil
+	}
+	second, err := NewQueue(store, Options{Run: recordingRun(ran), PinImage: repinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Start()
+	runs := waitForRuns(t, ran, 1)
+	second.Stop()
+	if image := runs["pinned"].Image; image != "libcmd@sha256:aaa" {
+		t.Errorf("reloaded job ran %q, want the image pinned at enqueue", image)
+	}
+}
+
+func TestEnqueueFailsWhenImageCannotBePinned(t *testing.T) {
+	errInspect := errors.New("inspect failed")
+	q, err := NewQueue(NewMemoryStore(0, 0), Options{
+		Run:      recordingRun(nil),
+		PinImage: func(op string) (*libcmd.PinnedImage, error) { return nil, errInspect },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue("pinned", 0); err != errInspect {
+		t.Errorf("got error %v, want %v", err, errInspect)
+	}
+	if jobs, _ := q.store.List(); len(jobs) != 0 {
+		t.Errorf("stored %d jobs, want none", len(jobs))
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	q, err := NewQueue(NewMemoryStore(0, 0), Options{RetryBackoff: time.Second, MaxRetryBackoff: 10 * time.Second, PinImage: pins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{60, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if got := q.retryBackoff(test.attempts); got != test.want {
+			t.Errorf("after %d attempts: got backoff %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestQueueRetriesAfterBackoff(t *testing.T) {
+	var runs []time.Time
+	done := make(chan bool)
+	run := func(op string, opts command.RunOptions, args ...string) ([]string, error) {
+		runs = append(runs, time.Now())
+		if len(runs) < 3 {
+			return nil, errors.New("temporary failure")
+		}
+		close(done)
+		return []string{"done"}, nil
+	}
+	backoff := 20 * time.Millisecond
+	q, err := NewQueue(NewMemoryStore(0, 0), Options{Run: run, PinImage: pins, RetryBackoff: backoff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Enqueue("flaky", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not retried")
+	}
+	q.Stop()
+
+	if d := runs[1].Sub(runs[0]); d < backoff {
+		t.Errorf("first retry after %s, want at least %s", d, backoff)
+	}
+	if d := runs[2].Sub(runs[1]); d < 2*backoff {
+		t.Errorf("second retry after %s, want at least %s", d, 2*backoff)
+	}
+	if stored, _ := q.Status(job.ID); stored.Status != JobSucceeded || stored.Attempts != 3 || !stored.NotBefore.IsZero() {
+		t.Errorf("got status %s after %d attempts, not before %s", stored.Status, stored.Attempts, stored.NotBefore)
+	}
+}
+
+func TestQueueWaitsOutBackoffAcrossRestart(t *testing.T) {
+	store := NewMemoryStore(0, 0)
+	notBefore := time.Now().Add(50 * time.Millisecond)
+	store.Put(&Job{ID: "retry", Op: "flaky", Status: JobPending, Attempts: 1, NotBefore: notBefore})
+
+	ran := make(chan ranJob, 1)
+	q, err := NewQueue(store, Options{Run: recordingRun(ran), PinImage: pins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	defer q.Stop()
+	waitForRuns(t, ran, 1)
+	if now := time.Now(); now.Before(notBefore) {
+		t.Errorf("job retried %s before its backoff ended", notBefore.Sub(now))
+	}
+}
+
+func TestFileStoreVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libcmd-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(&Job{ID: "current", Op: "deploy", Status: JobPending}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "current.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored struct{ Version int }
+	if err := json.Unmarshal(b, &stored); err != nil || stored.Version != fileStoreVersion {
+		t.Errorf("stored version %d, want %d: %v", stored.Version, fileStoreVersion, err)
+	}
+
+	files := map[string]string{
+		"legacy.json": `{"ID": "legacy", "Op": "deploy", "Status": "pending"}`,
+		"newer.json":  `{"Version": 99, "ID": "newer", "Op": "deploy", "Status": "pending"}`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"current", "legacy"} {
+		if job, err := store.Get(id); err != nil || job.ID != id || job.Op != "deploy" {
+			t.Errorf("got job %+v for %s: %v", job, id, err)
+		}
+	}
+	if _, err := store.Get("newer"); err == nil {
+		t.Error("loaded a job of a newer version")
+	}
+}
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// Store persists jobs so that a queue survives process restarts.
+type Store interface {
+	Put(job *Job) error
+	Get(id string) (*Job, error)
+	List() ([]*Job, error)
+}
+
+// fileStoreVersion is the layout of the job files FileStore writes. Files
+// without a version predate it and have the layout of version 1.
+const fileStoreVersion = 1
+
+// FileStore keeps one JSON document per job in a directory. Each document
+// records the version of its layout, and jobs written by a newer version of
+// the package fail to load rather than lose the fields this one does not
+// know.
+type FileStore struct {
+	dir string
+}
+
+// fileJob is the document of a job in a FileStore.
+type fileJob struct {
+	Version int
+	*Job
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir}, nil
+}
+
+func (s *FileStore) Put(job *Job) error {
+	b, err := json.Marshal(fileJob{Version: fileStoreVersion, Job: job})
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a partially
+	// written job behind.
+	tmp := s.path(job.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(job.ID))
+}
+
+func (s *FileStore) Get(id string) (*Job, error) {
+	b, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrJobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	stored := fileJob{Job: &Job{}}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Version > fileStoreVersion {
+		return nil, fmt.Errorf("job %s has version %d, newer than the supported %d", id, stored.Version, fileStoreVersion)
+	}
+	return stored.Job, nil
+}
+
+func (s *FileStore) List() ([]*Job, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []*Job{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		job, err := s.Get(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// MemoryStore keeps jobs in memory, for queues that need not survive a
+// restart. Finished jobs are evicted once there are more than MaxEntries
+// jobs, oldest first, or once they have not been updated for TTL. Pending
+// and running jobs are never evicted. Zero values disable either limit.
+type MemoryStore struct {
+	MaxEntries int
+	TTL        time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewMemoryStore(maxEntries int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{MaxEntries: maxEntries, TTL: ttl, jobs: map[string]*Job{}}
+}
+
+func (s *MemoryStore) Put(job *Job) error {
+	copied := *job
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = &copied
+	s.evict()
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (s *MemoryStore) List() ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	jobs := []*Job{}
+	for _, job := range s.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	return jobs, nil
+}
+
+// evict must be called with s.mu held.
+func (s *MemoryStore) evict() {
+	finished := []*Job{}
+	for _, job := range s.jobs {
+		if job.Status == JobSucceeded || job.Status == JobDead {
+			finished = append(finished, job)
+		}
+	}
+	sort.Sort(byUpdated(finished))
+
+	now := time.Now()
+	for _, job := range finished {
+		expired := s.TTL > 0 && now.Sub(job.UpdatedAt) > s.TTL
+		full := s.MaxEntries > 0 && len(s.jobs) > s.MaxEntries
+		if !expired && !full {
+			break
+		}
+		delete(s.jobs, job.ID)
+	}
+}
+
+type byUpdated []*Job
+
+func (b byUpdated) Len() int           { return len(b) }
+func (b byUpdated) Less(i, j int) bool { return b[i].UpdatedAt.Before(b[j].UpdatedAt) }
+func (b byUpdated) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
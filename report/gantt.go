@@ -0,0 +1,149 @@
+package report
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/replicatedcom/libcmd"
+	"github.com/replicatedcom/libcmd/history"
+)
+
+// GanttBar is one run on a timeline. Runs in the same Lane shared a host,
+// or an exec container on it, so overlapping bars in a lane contended for
+// it.
+type GanttBar struct {
+	ID     string
+	RunID  string `json:",omitempty"`
+	Op     string
+	Lane   string
+	Host   string `json:",omitempty"`
+	Pool   string `json:",omitempty"`
+	Start  time.Time
+	End    time.Time
+	Failed bool
+}
+
+type byStart []GanttBar
+
+func (b byStart) Len() int           { return len(b) }
+func (b byStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byStart) Less(i, j int) bool { return b[i].Start.Before(b[j].Start) }
+
+// Gantt converts history records into timeline bars, earliest first.
+func Gantt(records []*history.Record) []GanttBar {
+	bars := make([]GanttBar, 0, len(records))
+	for _, record := range records {
+		lane := record.Host
+		if record.Pool != "" {
+			lane += " " + record.Pool
+		}
+		if lane == "" {
+			lane = "local"
+		}
+		bars = append(bars, GanttBar{
+			ID:     record.ID,
+			RunID:  record.RunID,
+			Op:     record.Op,
+			Lane:   lane,
+			Host:   record.Host,
+			Pool:   record.Pool,
+			Start:  record.Start,
+			End:    record.End,
+			Failed: record.Error != "",
+		})
+	}
+	sort.Stable(byStart(bars))
+	return bars
+}
+
+// RecentGantt returns the bars of the runs in the history store started
+// since t. History must be enabled.
+func RecentGantt(since time.Time) ([]GanttBar, error) {
+	records, err := libcmd.History(history.Filter{Since: since})
+	if err != nil {
+		return nil, err
+	}
+	return Gantt(records), nil
+}
+
+// WriteGanttJSON writes bars as a JSON array.
+func WriteGanttJSON(w io.Writer, bars []GanttBar) error {
+	return json.NewEncoder(w).Encode(bars)
+}
+
+type ganttLane struct {
+	Name string
+	Bars []ganttHTMLBar
+}
+
+type ganttHTMLBar struct {
+	GanttBar
+	Left, Width float64
+}
+
+var ganttTemplate = template.Must(template.New("gantt").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 12px; }
+.lane { display: flex; align-items: center; margin: 2px 0; }
+.name { width: 240px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+.track { position: relative; flex: 1; height: 18px; background: #f4f4f4; }
+.bar { position: absolute; top: 2px; height: 14px; min-width: 1px; background: #4a90d9; opacity: 0.8; overflow: hidden; color: #fff; }
+.bar.failed { background: #d9534f; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.From.Format "2006-01-02 15:04:05"}} to {{.To.Format "2006-01-02 15:04:05"}}</p>
+{{range .Lanes}}<div class="lane"><div class="name" title="{{.Name}}">{{.Name}}</div><div class="track">
+{{range .Bars}}<div class="bar{{if .Failed}} failed{{end}}" style="left: {{printf "%.3f" .Left}}%; width: {{printf "%.3f" .Width}}%" title="{{.Op}} {{.Start.Format "15:04:05"}} - {{.End.Format "15:04:05"}}">{{.Op}}</div>
+{{end}}</div></div>
+{{end}}</body>
+</html>
+`))
+
+// WriteGanttHTML writes bars as a standalone HTML page with one row per
+// lane.
+func WriteGanttHTML(w io.Writer, title string, bars []GanttBar) error {
+	var from, to time.Time
+	for i, bar := range bars {
+		if i == 0 || bar.Start.Before(from) {
+			from = bar.Start
+		}
+		if i == 0 || bar.End.After(to) {
+			to = bar.End
+		}
+	}
+	span := to.Sub(from).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+
+	var lanes []*ganttLane
+	byName := map[string]*ganttLane{}
+	for _, bar := range bars {
+		lane, exists := byName[bar.Lane]
+		if !exists {
+			lane = &ganttLane{Name: bar.Lane}
+			byName[bar.Lane] = lane
+			lanes = append(lanes, lane)
+		}
+		lane.Bars = append(lane.Bars, ganttHTMLBar{
+			GanttBar: bar,
+			Left:     bar.Start.Sub(from).Seconds() / span * 100,
+			Width:    bar.End.Sub(bar.Start).Seconds() / span * 100,
+		})
+	}
+	return ganttTemplate.Execute(w, map[string]interface{}{
+		"Title": title,
+		"From":  from,
+		"To":    to,
+		"Lanes": lanes,
+	})
+}
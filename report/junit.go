@@ -0,0 +1,103 @@
+// Package report converts command results into formats understood by other
+// tools.
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/replicatedcom/libcmd"
+	"github.com/replicatedcom/libcmd/audit"
+	"github.com/replicatedcom/libcmd/command"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as a JUnit XML report with a single test suite.
+// Each result is a test case named after its op and args; failed commands
+// carry their stderr as the failure message. The args in
+// audit.DefaultSensitiveArgs and secrets are masked throughout, as reports
+// are usually published with the build.
+func WriteJUnit(w io.Writer, suite string, results []libcmd.Result, secrets []string) error {
+	s := junitTestSuite{
+		Name:      suite,
+		Tests:     len(results),
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"),
+	}
+	var total time.Duration
+	for _, result := range results {
+		total += result.Duration
+		args := audit.RedactArgs(audit.DefaultSensitiveArgs, result.Spec.Op, result.Spec.Args)
+		c := junitTestCase{
+			Name:      command.RedactSecrets(strings.TrimSpace(result.Spec.Op+" "+strings.Join(args, " ")), secrets),
+			ClassName: suite,
+			Time:      seconds(result.Duration),
+		}
+		output := command.RedactSecrets(strings.Join(result.Output, "\n"), secrets)
+		switch {
+		case result.Err == libcmd.ErrCommandSkipped:
+			s.Skipped++
+			c.Skipped = &struct{}{}
+		case result.Err != nil:
+			s.Failures++
+			c.Failure = &junitFailure{Message: command.RedactSecrets(failureMessage(result.Err, output), secrets), Body: output}
+		default:
+			c.SystemOut = output
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{s}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// failureMessage is the first line of stderr, falling back to the error.
+func failureMessage(err error, stderr string) string {
+	if line := strings.TrimSpace(strings.SplitN(stderr, "\n", 2)[0]); line != "" {
+		return line
+	}
+	return err.Error()
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
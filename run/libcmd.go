@@ -5,6 +5,7 @@ import (
 
 	"github.com/replicatedcom/libcmd"
 	"github.com/replicatedcom/libcmd/command"
+	"github.com/replicatedcom/libcmd/logger"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -20,6 +21,7 @@ func init() {
 
 func main() {
 	log.SetLevel(log.DebugLevel)
+	logger.SetLogger(log.StandardLogger())
 
 	opts := map[string]string{
 		"ContainerRepository": "freighter/cmd",
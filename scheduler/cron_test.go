@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"3", 0, 59, []int{3}},
+		{"1-5", 0, 59, []int{1, 2, 3, 4, 5}},
+		{"1-10/3", 0, 59, []int{1, 4, 7, 10}},
+		{"50/5", 0, 59, []int{50, 55}},
+		{"1,3,5", 0, 59, []int{1, 3, 5}},
+		{"1-2,20-21", 0, 23, []int{1, 2, 20, 21}},
+	}
+	for _, test := range tests {
+		values, err := parseCronField(test.field, test.min, test.max)
+		if err != nil {
+			t.Errorf("%s: %s", test.field, err)
+			continue
+		}
+		got := []int{}
+		for v := range values {
+			got = append(got, v)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.field, got, test.want)
+		}
+	}
+
+	for _, field := range []string{"60", "*/0", "*/x", "5-1", "a", "1-x", "-1"} {
+		if _, err := parseCronField(field, 0, 59); err == nil {
+			t.Errorf("%s: parsed", field)
+		}
+	}
+}
+
+func TestParseScheduleFields(t *testing.T) {
+	for _, expr := range []string{"* * * *", "* * * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("%q: parsed", expr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// 2024-01-02 is a Tuesday.
+	from := time.Date(2024, 1, 2, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 2, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 2, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 1, 2, 13, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// Sunday is 0 or 7.
+		{"0 0 * * 7", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted.
+		{"0 0 13 * 5", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 4 * 1", time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)},
+		// A day field starting with "*" is unrestricted, so only the other
+		// one decides, as it does for a plain "*".
+		{"0 0 */1 * 1", time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * *", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 10 * */1", time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		s, err := ParseSchedule(test.expr)
+		if err != nil {
+			t.Errorf("%q: %s", test.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(test.want) {
+			t.Errorf("%q: next run %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
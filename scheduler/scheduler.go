@@ -0,0 +1,275 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd"
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+// OverlapPolicy decides what happens when a command is due while its
+// previous run is still in progress.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip drops the new run.
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueue runs the command again as soon as the previous run
+	// finishes. At most one run is queued.
+	OverlapQueue
+	// OverlapKillPrevious cancels the previous run and starts the new one
+	// once it has exited.
+	OverlapKillPrevious
+)
+
+type RunRecord struct {
+	Image       string
+	ImageDigest string
+	ImageID     string
+	Start       time.Time
+	End         time.Time
+	Output      []string
+	Err         error
+	Skipped     bool
+}
+
+type entry struct {
+	name     string
+	schedule *Schedule
+	op       string
+	args     []string
+	overlap  OverlapPolicy
+	pinned   *libcmd.PinnedImage
+
+	running  bool
+	pending  bool
+	cancelCh chan bool
+	history  []RunRecord
+}
+
+// Scheduler runs registered commands on cron schedules.
+type Scheduler struct {
+	// HistoryLimit is the number of runs kept per command. Defaults to 100.
+	HistoryLimit int
+	// PinImages resolves the command image when a command is added and
+	// runs that image on every run, even if the tag moves later. Commands
+	// that do not run in the command image are not pinned. See
+	// libcmd.PinImage.
+	PinImages bool
+	// ExpandTemplates renders the args of every run, for example to name
+	// a backup after {{.Now.Format "20060102"}}, with TemplateValues. See
+	// command.RunOptions.ExpandTemplates.
+	ExpandTemplates bool
+	TemplateValues  map[string]string
+
+	run      func(op string, opts command.RunOptions, args ...string) ([]string, error)
+	pinImage func(op string) (*libcmd.PinnedImage, error)
+	now      func() time.Time
+	newTimer func(d time.Duration) (<-chan time.Time, func() bool)
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	started bool
+	stopped bool
+	stopCh  chan bool
+	wg      sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{
+		HistoryLimit: 100,
+		run:          libcmd.RunCommandWithOptions,
+		pinImage:     libcmd.PinImage,
+		now:          time.Now,
+		newTimer:     newTimer,
+		entries:      map[string]*entry{},
+		stopCh:       make(chan bool),
+	}
+}
+
+// Add registers op to run on the cron expression expr under a unique name.
+func (s *Scheduler) Add(name, expr, op string, overlap OverlapPolicy, args ...string) error {
+	schedule, err := ParseSchedule(expr)
+	if err != nil {
+		return err
+	}
+	var pinned *libcmd.PinnedImage
+	if s.PinImages {
+		if pinned, err = s.pinImage(op); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.entries[name]; exists {
+		return fmt.Errorf("scheduled command %s already exists", name)
+	}
+	e := &entry{
+		name:     name,
+		schedule: schedule,
+		op:       op,
+		args:     args,
+		overlap:  overlap,
+		pinned:   pinned,
+	}
+	s.entries[name] = e
+	if s.started && !s.stopped {
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+	return nil
+}
+
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+}
+
+// Stop stops scheduling new runs and waits for running commands to exit.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.stopCh)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// LastRun returns the most recent run of the named command.
+func (s *Scheduler) LastRun(name string) (RunRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exists := s.entries[name]
+	if !exists || len(e.history) == 0 {
+		return RunRecord{}, false
+	}
+	return e.history[len(e.history)-1], true
+}
+
+// History returns the recorded runs of the named command, oldest first.
+func (s *Scheduler) History(name string) []RunRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exists := s.entries[name]
+	if !exists {
+		return nil
+	}
+	history := make([]RunRecord, len(e.history))
+	copy(history, e.history)
+	return history
+}
+
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+	for {
+		next := e.schedule.Next(s.now())
+		if next.IsZero() {
+			log.Errorf("scheduled command %s will never run", e.name)
+			return
+		}
+		timerCh, stopTimer := s.newTimer(next.Sub(s.now()))
+		select {
+		case <-timerCh:
+			s.trigger(e)
+		case <-s.stopCh:
+			stopTimer()
+			return
+		}
+	}
+}
+
+func (s *Scheduler) trigger(e *entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if !e.running {
+		s.launch(e)
+		return
+	}
+
+	switch e.overlap {
+	case OverlapSkip:
+		log.Debugf("scheduled command %s still running, skipping", e.name)
+		now := s.now()
+		s.record(e, RunRecord{Start: now, End: now, Skipped: true})
+	case OverlapQueue:
+		log.Debugf("scheduled command %s still running, queueing", e.name)
+		e.pending = true
+	case OverlapKillPrevious:
+		log.Debugf("scheduled command %s still running, canceling previous run", e.name)
+		e.pending = true
+		if e.cancelCh != nil {
+			close(e.cancelCh)
+			e.cancelCh = nil
+		}
+	}
+}
+
+// launch must be called with s.mu held.
+func (s *Scheduler) launch(e *entry) {
+	e.running = true
+	e.cancelCh = make(chan bool)
+	cancelCh := e.cancelCh
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		log.Debugf("running scheduled command %s", e.name)
+		record := RunRecord{Start: s.now()}
+		opts := command.RunOptions{
+			Cancel:          cancelCh,
+			ExpandTemplates: s.ExpandTemplates,
+			TemplateValues:  s.TemplateValues,
+		}
+		if e.pinned != nil {
+			record.Image, record.ImageDigest, record.ImageID = e.pinned.Image, e.pinned.Digest, e.pinned.ID
+			if e.pinned.Digest != "" {
+				opts.Image = e.pinned.Digest
+			} else {
+				opts.ImageID = e.pinned.ID
+			}
+		}
+		record.Output, record.Err = s.run(e.op, opts, e.args...)
+		record.End = s.now()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.record(e, record)
+		e.cancelCh = nil
+		if e.pending && !s.stopped {
+			e.pending = false
+			s.launch(e)
+			return
+		}
+		e.pending = false
+		e.running = false
+	}()
+}
+
+func newTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
+
+func (s *Scheduler) record(e *entry, record RunRecord) {
+	e.history = append(e.history, record)
+	if s.HistoryLimit > 0 && len(e.history) > s.HistoryLimit {
+		e.history = e.history[len(e.history)-s.HistoryLimit:]
+	}
+}
@@ -0,0 +1,201 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+// fakeClock fires the timers of a Scheduler when advanced past them.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) newTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	return timer.ch, func() bool { return c.remove(timer) }
+}
+
+func (c *fakeClock) remove(timer *fakeTimer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t := range c.timers {
+		if t == timer {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// advance waits for a timer to be set, then moves the clock to it and
+// fires it.
+func (c *fakeClock) advance(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		if len(c.timers) > 0 {
+			timer := c.timers[0]
+			c.timers = c.timers[1:]
+			c.now = timer.at
+			c.mu.Unlock()
+			timer.ch <- timer.at
+			return
+		}
+		c.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("scheduler set no timer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeRuns blocks every run until it is released or canceled.
+type fakeRuns struct {
+	started  chan command.RunOptions
+	release  chan bool
+	canceled chan bool
+}
+
+func newFakeRuns() *fakeRuns {
+	return &fakeRuns{started: make(chan command.RunOptions, 10), release: make(chan bool), canceled: make(chan bool, 10)}
+}
+
+func (r *fakeRuns) run(op string, opts command.RunOptions, args ...string) ([]string, error) {
+	r.started <- opts
+	select {
+	case <-r.release:
+		return []string{op + " done"}, nil
+	case <-opts.Cancel:
+		r.canceled <- true
+		return nil, command.ErrCommandCanceled
+	}
+}
+
+func (r *fakeRuns) waitStarted(t *testing.T) {
+	select {
+	case <-r.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not start")
+	}
+}
+
+func (r *fakeRuns) assertNotStarted(t *testing.T) {
+	select {
+	case <-r.started:
+		t.Fatal("run started while the previous run was in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func newTestScheduler(t *testing.T, overlap OverlapPolicy) (*Scheduler, *fakeClock, *fakeRuns) {
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 10, 0, 30, 0, time.UTC)}
+	runs := newFakeRuns()
+	s := New()
+	s.run, s.now, s.newTimer = runs.run, clock.Now, clock.newTimer
+	if err := s.Add("sync", "* * * * *", "sync", overlap); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	return s, clock, runs
+}
+
+// waitHistory waits for the named command to have n recorded runs.
+func waitHistory(t *testing.T, s *Scheduler, n int) []RunRecord {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if history := s.History("sync"); len(history) >= n {
+			return history
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d runs, want %d", len(s.History("sync")), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOverlapSkip(t *testing.T) {
+	s, clock, runs := newTestScheduler(t, OverlapSkip)
+	defer s.Stop()
+
+	clock.advance(t)
+	runs.waitStarted(t)
+	clock.advance(t)
+	history := waitHistory(t, s, 1)
+	if !history[0].Skipped || !history[0].Start.Equal(time.Date(2024, 1, 2, 10, 2, 0, 0, time.UTC)) {
+		t.Errorf("overlapping run recorded as %+v, want skipped at 10:02", history[0])
+	}
+	runs.assertNotStarted(t)
+
+	runs.release <- true
+	history = waitHistory(t, s, 2)
+	if history[1].Skipped || history[1].Err != nil || history[1].Output[0] != "sync done" {
+		t.Errorf("run recorded as %+v", history[1])
+	}
+}
+
+func TestOverlapQueue(t *testing.T) {
+	s, clock, runs := newTestScheduler(t, OverlapQueue)
+	defer s.Stop()
+
+	clock.advance(t)
+	runs.waitStarted(t)
+	clock.advance(t)
+	clock.advance(t)
+	runs.assertNotStarted(t)
+
+	// The two overlapping triggers queue a single run.
+	runs.release <- true
+	runs.waitStarted(t)
+	runs.release <- true
+	history := waitHistory(t, s, 2)
+	runs.assertNotStarted(t)
+	for i, record := range history {
+		if record.Skipped || record.Err != nil {
+			t.Errorf("run %d recorded as %+v", i, record)
+		}
+	}
+}
+
+func TestOverlapKillPrevious(t *testing.T) {
+	s, clock, runs := newTestScheduler(t, OverlapKillPrevious)
+	defer s.Stop()
+
+	clock.advance(t)
+	runs.waitStarted(t)
+	clock.advance(t)
+	select {
+	case <-runs.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("previous run was not canceled")
+	}
+	runs.waitStarted(t)
+	history := waitHistory(t, s, 1)
+	if history[0].Err != command.ErrCommandCanceled {
+		t.Errorf("canceled run recorded with error %v", history[0].Err)
+	}
+
+	runs.release <- true
+	history = waitHistory(t, s, 2)
+	if history[1].Err != nil {
+		t.Errorf("replacing run recorded with error %v", history[1].Err)
+	}
+}
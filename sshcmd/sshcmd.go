@@ -0,0 +1,421 @@
+// Package sshcmd runs command scripts on remote hosts over SSH, for
+// machines that do not run docker. It uses the ssh client of this host, so
+// keys, known hosts and ssh_config apply as they do for ssh itself.
+//
+// The backend is a command.Runtime, so runs go through the hooks, policy and
+// checks of container commands, and libcmd can select it by name with
+// CmdConfig.Backend once New has registered it. Each run is a "container"
+// made of a directory on the remote host holding the script.
+//
+//	b, err := sshcmd.New(sshcmd.Config{Host: "db1.example.com", User: "ops", CommandsDir: "/root/commands"})
+//	output, err := b.Run("random", "16")
+package sshcmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+// BackendName is the backend New registers the runtime as unless
+// Config.Name is set.
+const BackendName = "ssh"
+
+// sshFailed is the exit status of ssh itself failing, as opposed to the
+// remote command.
+const sshFailed = 255
+
+var (
+	ErrSSHFailed     = errors.New("ssh connection failed")
+	ErrRunNotFound   = errors.New("ssh run not found")
+	ErrRunNotStarted = errors.New("ssh run not started")
+)
+
+type Config struct {
+	// Name registers the runtime with command.RegisterRuntime. Defaults
+	// to BackendName.
+	Name string
+	// SSH is the path to the ssh binary. Defaults to "ssh" on the PATH.
+	SSH  string
+	Host string
+	User string
+	// Port defaults to that of ssh_config, usually 22.
+	Port         int
+	IdentityFile string
+	// Options are passed to ssh as -o options, such as
+	// "StrictHostKeyChecking=yes".
+	Options []string
+
+	// CommandsDir is the local directory the op scripts are copied from.
+	CommandsDir string
+	// RemoteDir is where a directory for each run is made on the remote
+	// host. Defaults to /tmp.
+	RemoteDir string
+	// Env is set for the script as "NAME=value", ahead of the env of each
+	// run.
+	Env []string
+}
+
+type Backend struct {
+	config Config
+
+	mu   sync.Mutex
+	runs map[string]*remoteRun
+}
+
+// remoteRun is a run copied to the remote host by Create.
+type remoteRun struct {
+	dir string
+	cmd string
+	ssh *exec.Cmd
+
+	mu     sync.Mutex
+	seq    uint64
+	chunks []stdcopy.Chunk
+	done   chan struct{}
+	exit   int
+	err    error
+}
+
+// New returns the backend and registers it as Config.Name.
+func New(config Config) (*Backend, error) {
+	if config.Host == "" {
+		return nil, errors.New("ssh host is required")
+	}
+	if config.Name == "" {
+		config.Name = BackendName
+	}
+	if config.SSH == "" {
+		config.SSH = "ssh"
+	}
+	path, err := exec.LookPath(config.SSH)
+	if err != nil {
+		return nil, err
+	}
+	config.SSH = path
+	if config.RemoteDir == "" {
+		config.RemoteDir = "/tmp"
+	}
+	b := &Backend{config: config, runs: map[string]*remoteRun{}}
+	command.RegisterRuntime(config.Name, b)
+	return b, nil
+}
+
+// CmdConfig returns the libcmd config that runs commands on this backend.
+func (b *Backend) CmdConfig() command.CmdConfig {
+	return command.CmdConfig{
+		Backend:     b.config.Name,
+		CommandsDir: b.config.CommandsDir,
+	}
+}
+
+// Run runs op, a command registered with command.RegisterCommand, on the
+// remote host and returns the script's stdout, or its stderr along with
+// command.ErrCommandResponse if it exits non-zero.
+func (b *Backend) Run(op string, args ...string) ([]string, error) {
+	return command.Run(op, b.CmdConfig(), nil, command.RunOptions{}, args...)
+}
+
+// Pull does nothing, since scripts run on the remote host rather than in an
+// image.
+func (b *Backend) Pull(image string) error {
+	return nil
+}
+
+// Create copies the script of spec from CommandsDir to a new directory on
+// the remote host. The image, network and security profile of the spec do
+// not apply to a remote host. Users, entrypoints, binds and devices cannot
+// be honored and return command.ErrNotSupportedByRuntime.
+func (b *Backend) Create(spec command.ContainerSpec) (string, error) {
+	if spec.Config.User != "" || spec.Config.Entrypoint != nil {
+		return "", command.ErrNotSupportedByRuntime
+	}
+	if hc := spec.HostConfig; hc != nil && (len(hc.Binds) > 0 || len(hc.Devices) > 0 || len(hc.DeviceRequests) > 0 || hc.Privileged) {
+		return "", command.ErrNotSupportedByRuntime
+	}
+	id, err := command.NewID()
+	if err != nil {
+		return "", err
+	}
+	dir := b.config.RemoteDir + "/libcmd-" + id
+
+	// The script is the arg under CommandsDir. Commands that run a binary
+	// have none.
+	runCmd := []string{}
+	var script *os.File
+	for _, part := range spec.Config.Cmd {
+		if name, ok := b.scriptName(part); ok && script == nil {
+			if script, err = os.Open(filepath.Join(b.config.CommandsDir, name)); os.IsNotExist(err) {
+				return "", command.ErrCommandNotFound
+			} else if err != nil {
+				return "", err
+			}
+			defer script.Close()
+			part = dir + "/" + filepath.Base(name)
+		}
+		runCmd = append(runCmd, quote(part))
+	}
+	if len(runCmd) == 0 {
+		return "", errors.New("no command to run")
+	}
+
+	log.Debugf("copying %s to %s", spec.Op, b.config.Host)
+	copyCmd := "mkdir -m 0700 " + quote(dir)
+	if script != nil {
+		copyCmd += " && cat > " + quote(dir+"/"+filepath.Base(script.Name()))
+	}
+	if err := b.sshOK(script, copyCmd); err != nil {
+		log.Errorf(" -> error copying %s to %s: %s", spec.Op, b.config.Host, err)
+		return "", err
+	}
+
+	env := append(append([]string{}, b.config.Env...), spec.Config.Env...)
+	if len(env) > 0 {
+		envCmd := []string{"env"}
+		for _, e := range env {
+			envCmd = append(envCmd, quote(e))
+		}
+		runCmd = append(envCmd, runCmd...)
+	}
+	// The pid lets Kill stop the script, which outlives the ssh client
+	// without a tty.
+	cmd := fmt.Sprintf("echo $$ > %s && exec %s", quote(dir+"/pid"), strings.Join(runCmd, " "))
+	if spec.Config.WorkingDir != "" {
+		cmd = "cd " + quote(spec.Config.WorkingDir) + " && " + cmd
+	}
+
+	b.mu.Lock()
+	b.runs[id] = &remoteRun{dir: dir, cmd: cmd, done: make(chan struct{})}
+	b.mu.Unlock()
+	return id, nil
+}
+
+// scriptName returns the name of part under CommandsDir, if it is a path
+// there that does not leave it.
+func (b *Backend) scriptName(part string) (string, bool) {
+	prefix := strings.TrimRight(b.config.CommandsDir, "/") + "/"
+	if b.config.CommandsDir == "" || !strings.HasPrefix(part, prefix) {
+		return "", false
+	}
+	name := filepath.Clean(part[len(prefix):])
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || filepath.IsAbs(name) {
+		return "", false
+	}
+	return name, true
+}
+
+func (b *Backend) lookup(id string) (*remoteRun, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	run, exists := b.runs[id]
+	if !exists {
+		return nil, ErrRunNotFound
+	}
+	return run, nil
+}
+
+// Start runs the script on the remote host in the background of this
+// process.
+func (b *Backend) Start(id string) error {
+	run, err := b.lookup(id)
+	if err != nil {
+		return err
+	}
+	log.Debugf("running %s on %s", id, b.config.Host)
+	run.ssh = b.sshCommand(cmdWriter{run, stdcopy.Stdout}, cmdWriter{run, stdcopy.Stderr}, nil, run.cmd)
+	if err := run.ssh.Start(); err != nil {
+		log.Errorf(" -> error running %s on %s: %s", id, b.config.Host, err)
+		return err
+	}
+	go func() {
+		run.exit, run.err = b.exitCode(run.ssh.Wait())
+		close(run.done)
+	}()
+	return nil
+}
+
+func (b *Backend) Wait(id string) (int, error) {
+	run, err := b.lookup(id)
+	if err != nil {
+		return -1, err
+	}
+	if run.ssh == nil {
+		return -1, ErrRunNotStarted
+	}
+	<-run.done
+	if run.err != nil {
+		log.Errorf(" -> error running %s on %s: %s", id, b.config.Host, run.err)
+		return -1, run.err
+	}
+	log.Debugf(" -> %s on %s exited with code %d", id, b.config.Host, run.exit)
+	return run.exit, nil
+}
+
+// Logs replays the output of the script, in the order it was written.
+func (b *Backend) Logs(id string, limits command.OutputLimits, onChunk func(stdcopy.Chunk)) (command.Output, error) {
+	run, err := b.lookup(id)
+	if err != nil {
+		return command.Output{}, err
+	}
+	capture := command.NewOutputCapture(limits, onChunk)
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	for _, chunk := range run.chunks {
+		if chunk.Stream == stdcopy.Stderr {
+			capture.Stderr.Write(chunk.Data)
+		} else {
+			capture.Stdout.Write(chunk.Data)
+		}
+	}
+	return capture.Output(), nil
+}
+
+func (b *Backend) Kill(id string) error {
+	run, err := b.lookup(id)
+	if err != nil {
+		return err
+	}
+	log.Debugf("killing %s on %s", id, b.config.Host)
+	pidFile := quote(run.dir + "/pid")
+	if err := b.sshOK(nil, fmt.Sprintf("test ! -f %s || kill -KILL $(cat %s)", pidFile, pidFile)); err != nil {
+		log.Errorf(" -> error killing %s on %s: %s", id, b.config.Host, err)
+		return err
+	}
+	log.Debugf(" -> %s on %s killed", id, b.config.Host)
+	return nil
+}
+
+// Remove removes the directory of the run from the remote host.
+func (b *Backend) Remove(id string) error {
+	run, err := b.lookup(id)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	delete(b.runs, id)
+	b.mu.Unlock()
+	if err := b.sshOK(nil, "rm -rf "+quote(run.dir)); err != nil {
+		log.Errorf("error removing %s on %s: %s", run.dir, b.config.Host, err)
+		return err
+	}
+	return nil
+}
+
+// Exec returns command.ErrNotSupportedByRuntime. Each run has a host to
+// itself rather than a container that could be shared.
+func (b *Backend) Exec(id string, cmd []string, limits command.OutputLimits) (command.Output, int, error) {
+	return command.Output{}, -1, command.ErrNotSupportedByRuntime
+}
+
+// Capabilities are none of those of containers, which a remote host
+// running scripts directly does not have.
+func (b *Backend) Capabilities() command.Capabilities {
+	return command.Capabilities{}
+}
+
+// cmdWriter records the output of a run as chunks.
+type cmdWriter struct {
+	run    *remoteRun
+	stream stdcopy.Stream
+}
+
+func (w cmdWriter) Write(p []byte) (int, error) {
+	w.run.mu.Lock()
+	defer w.run.mu.Unlock()
+	w.run.seq++
+	data := make([]byte, len(p))
+	copy(data, p)
+	w.run.chunks = append(w.run.chunks, stdcopy.Chunk{Seq: w.run.seq, Stream: w.stream, Data: data})
+	return len(p), nil
+}
+
+// ssh runs cmd on the remote host and returns its stdout, stderr and exit
+// code. Failures of ssh itself are returned as ErrSSHFailed, and a remote
+// command that exits non-zero is not an error.
+func (b *Backend) ssh(stdin io.Reader, cmd string) (string, string, int, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := b.exitCode(b.sshCommand(&stdout, &stderr, stdin, cmd).Run())
+	if err != nil {
+		if err == ErrSSHFailed {
+			log.Errorf("ssh to %s failed: %s", b.config.Host, strings.TrimSpace(stderr.String()))
+		}
+		return "", "", -1, err
+	}
+	return stdout.String(), stderr.String(), exitCode, nil
+}
+
+func (b *Backend) sshCommand(stdout, stderr io.Writer, stdin io.Reader, cmd string) *exec.Cmd {
+	args := []string{"-T", "-o", "BatchMode=yes"}
+	if b.config.User != "" {
+		args = append(args, "-l", b.config.User)
+	}
+	if b.config.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(b.config.Port))
+	}
+	if b.config.IdentityFile != "" {
+		args = append(args, "-i", b.config.IdentityFile)
+	}
+	for _, option := range b.config.Options {
+		args = append(args, "-o", option)
+	}
+	args = append(args, "--", b.config.Host, cmd)
+
+	c := exec.Command(b.config.SSH, args...)
+	if stdin == nil {
+		stdin = bytes.NewReader(nil)
+	}
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	return c
+}
+
+// exitCode returns the exit code of a finished ssh. ssh itself failing is
+// ErrSSHFailed, and a remote command that exits non-zero is not an error.
+func (b *Backend) exitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return -1, err
+	}
+	exitCode := -1
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		exitCode = status.ExitStatus()
+	}
+	if exitCode == sshFailed {
+		return -1, ErrSSHFailed
+	}
+	return exitCode, nil
+}
+
+// sshOK runs cmd on the remote host and returns its stderr as the error if
+// it exits non-zero.
+func (b *Backend) sshOK(stdin io.Reader, cmd string) error {
+	_, stderr, exitCode, err := b.ssh(stdin, cmd)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exit code %d: %s", exitCode, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// quote quotes s for the remote shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -1,4 +1,6 @@
-package command
+// Package stdcopy demultiplexes the stdout and stderr streams returned by the
+// docker remote API for containers without a TTY.
+package stdcopy
 
 import (
 	"encoding/binary"
@@ -14,8 +16,35 @@ const (
 
 var errInvalidStdHeader = errors.New("Unrecognized input header")
 
-func stdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error) {
+type Stream int
+
+const (
+	// Stdin marks input recorded from an interactive session. It never
+	// appears in a multiplexed stream.
+	Stdin  Stream = 0
+	Stdout Stream = 1
+	Stderr Stream = 2
+)
+
+// Chunk is one frame of output. Seq increases by one with every chunk of
+// either stream, so chunks kept apart by stream can be merged back into the
+// order they were written.
+type Chunk struct {
+	Seq    uint64
+	Stream Stream
+	Data   []byte
+}
+
+func StdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error) {
+	return StdCopySequenced(dstout, dsterr, src, nil)
+}
+
+// StdCopySequenced is StdCopy that also passes every chunk, numbered in the
+// order it was read, to onChunk if it is not nil.
+func StdCopySequenced(dstout, dsterr io.Writer, src io.Reader, onChunk func(Chunk)) (written int64, err error) {
 	var (
+		seq       uint64
+		stream    Stream
 		buf       = make([]byte, 32*1024+stdWriterPrefixLen+1)
 		bufLen    = len(buf)
 		nr, nw    int
@@ -43,8 +72,10 @@ func stdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error)
 			fallthrough
 		case 1:
 			out = dstout
+			stream = Stdout
 		case 2:
 			out = dsterr
+			stream = Stderr
 		default:
 			return 0, errInvalidStdHeader
 		}
@@ -81,6 +112,12 @@ func stdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error)
 		if nw != frameSize {
 			return written, io.ErrShortWrite
 		}
+		if onChunk != nil {
+			seq++
+			data := make([]byte, bound-stdWriterPrefixLen)
+			copy(data, buf[stdWriterPrefixLen:bound])
+			onChunk(Chunk{Seq: seq, Stream: stream, Data: data})
+		}
 		copy(buf, buf[frameSize+stdWriterPrefixLen:])
 		nr -= frameSize + stdWriterPrefixLen
 	}
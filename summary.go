@@ -0,0 +1,73 @@
+package libcmd
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/replicatedcom/libcmd/stdcopy"
+)
+
+// LogSummary collects the output lines that follow the convention of being
+// prefixed with ERROR:, WARN: or INFO:, with the prefix removed.
+type LogSummary struct {
+	Errors   []string
+	Warnings []string
+	Infos    []string
+}
+
+// SummarizeOutput summarizes the lines of output. The output of a run holds
+// only one of its streams, stdout when it succeeds and stderr when it fails;
+// pass command.Result.Stdout and Stderr to summarize both.
+func SummarizeOutput(output []string) LogSummary {
+	var summary LogSummary
+	for _, block := range output {
+		for _, line := range strings.Split(block, "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "ERROR:"):
+				summary.Errors = append(summary.Errors, strings.TrimSpace(line[len("ERROR:"):]))
+			case strings.HasPrefix(line, "WARN:"):
+				summary.Warnings = append(summary.Warnings, strings.TrimSpace(line[len("WARN:"):]))
+			case strings.HasPrefix(line, "INFO:"):
+				summary.Infos = append(summary.Infos, strings.TrimSpace(line[len("INFO:"):]))
+			}
+		}
+	}
+	return summary
+}
+
+// outputStreams collects both streams of a run from RunOptions.OnOutput.
+type outputStreams struct {
+	mu             sync.Mutex
+	stdout, stderr bytes.Buffer
+	written        bool
+}
+
+func (s *outputStreams) write(chunk stdcopy.Chunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = true
+	if chunk.Stream == stdcopy.Stderr {
+		s.stderr.Write(chunk.Data)
+	} else {
+		s.stdout.Write(chunk.Data)
+	}
+}
+
+// summary summarizes both streams, or output for runs that did not report
+// them, such as cached results and commands run with exec.
+func (s *outputStreams) summary(output []string) LogSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.written {
+		return SummarizeOutput(output)
+	}
+	return SummarizeOutput([]string{s.stdout.String(), s.stderr.String()})
+}
+
+// SucceededWithWarnings reports whether the command succeeded but printed
+// warnings or errors.
+func (r Result) SucceededWithWarnings() bool {
+	return r.Err == nil && (len(r.Summary.Warnings) > 0 || len(r.Summary.Errors) > 0)
+}
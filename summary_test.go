@@ -0,0 +1,50 @@
+package libcmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/replicatedcom/libcmd/command"
+)
+
+func TestSummarizeOutput(t *testing.T) {
+	summary := SummarizeOutput([]string{"INFO: starting\nplain line\n  WARN:  disk almost full\n", "ERROR: failed"})
+	want := LogSummary{Errors: []string{"failed"}, Warnings: []string{"disk almost full"}, Infos: []string{"starting"}}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("got summary %+v, want %+v", summary, want)
+	}
+}
+
+func TestRunAllSummarizesStderr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libcmd-summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := "echo 'INFO: done'\necho 'WARN: certificate expires soon' >&2\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "renew.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := command.RegisterCommand(command.CommandDef{Op: "renew"}); err != nil {
+		t.Fatal(err)
+	}
+	saved := config
+	config = command.CmdConfig{CommandsDir: dir, Backend: command.BackendLocal}
+	defer func() { config = saved }()
+
+	results := RunAll(context.Background(), []CommandSpec{{Op: "renew"}}, RunAllOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("run failed: %s", results[0].Err)
+	}
+	if !reflect.DeepEqual(results[0].Summary.Warnings, []string{"certificate expires soon"}) ||
+		!reflect.DeepEqual(results[0].Summary.Infos, []string{"done"}) {
+		t.Errorf("got summary %+v", results[0].Summary)
+	}
+	if !results[0].SucceededWithWarnings() {
+		t.Error("successful run with a warning on stderr did not report it")
+	}
+}
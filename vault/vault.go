@@ -0,0 +1,355 @@
+// Package vault resolves "vault://path#key" secret references from
+// HashiCorp Vault for command runs.
+//
+//	p, err := vault.New(vault.Config{Address: "https://vault:8200", RoleID: id, SecretID: secret})
+//	p.Install()
+//	p.Start()
+//
+// Env values and secret files such as "vault://secret/data/db#password" are
+// then replaced with the value of the password key at secret/data/db. Both
+// KV version 1 and version 2 responses are understood.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicatedcom/libcmd/command"
+	log "github.com/replicatedcom/libcmd/logger"
+)
+
+const scheme = "vault"
+
+// defaultTimeout bounds each request to vault when Config.Client is not
+// set.
+const defaultTimeout = 30 * time.Second
+
+var (
+	ErrInvalidRef  = errors.New("invalid vault reference")
+	ErrKeyNotFound = errors.New("key not found in vault secret")
+)
+
+// Config selects how the provider authenticates. Set Token to use a token
+// directly, or RoleID and SecretID to log in with AppRole.
+type Config struct {
+	Address string
+	Token   string
+
+	RoleID   string
+	SecretID string
+	// AppRolePath is the mount path of the AppRole auth method. Defaults
+	// to "approle".
+	AppRolePath string
+
+	// Client defaults to a client with a timeout of 30 seconds.
+	Client *http.Client
+}
+
+type Provider struct {
+	config Config
+
+	mu          sync.Mutex
+	token       string
+	tokenTTL    time.Duration
+	tokenExpiry time.Time
+	renewable   bool
+	leases      map[string]lease
+
+	stopCh   chan bool
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type lease struct {
+	duration  time.Duration
+	renewAt   time.Time
+	renewable bool
+}
+
+type secretResponse struct {
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+	Data          map[string]interface{}
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	}
+	Errors []string
+}
+
+func New(config Config) (*Provider, error) {
+	if config.Address == "" {
+		return nil, errors.New("vault address is required")
+	}
+	if config.Token == "" && (config.RoleID == "" || config.SecretID == "") {
+		return nil, errors.New("vault token or approle credentials are required")
+	}
+	if config.AppRolePath == "" {
+		config.AppRolePath = "approle"
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: defaultTimeout}
+	}
+	p := &Provider{
+		config: config,
+		token:  config.Token,
+		leases: map[string]lease{},
+		stopCh: make(chan bool),
+	}
+	if p.token == "" {
+		if err := p.login(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Install registers the provider to resolve vault:// references. The
+// leases of the secrets resolved for a run are revoked once it finishes.
+func (p *Provider) Install() {
+	command.RegisterLeasedSecretResolver(scheme, p.resolveLeased)
+}
+
+// Start renews the auth token and the leases of resolved secrets in the
+// background until Stop is called.
+func (p *Provider) Start() {
+	p.wg.Add(1)
+	go p.renewLoop()
+}
+
+// Stop stops the renewals of Start. It may be called more than once.
+func (p *Provider) Stop() {
+	p.stopOnce.Do(func() { close(p.stopCh) })
+	p.wg.Wait()
+}
+
+// Resolve returns the value of a "vault://path#key" reference. The lease of
+// the secret, if it has one, is renewed until it expires.
+func (p *Provider) Resolve(ref string) (string, error) {
+	value, _, err := p.resolve(ref)
+	return value, err
+}
+
+// resolveLeased is Resolve also returning the function that revokes the
+// lease of the secret.
+func (p *Provider) resolveLeased(ref string) (string, func(), error) {
+	value, leaseID, err := p.resolve(ref)
+	if err != nil || leaseID == "" {
+		return value, nil, err
+	}
+	return value, func() { p.revoke(leaseID) }, nil
+}
+
+func (p *Provider) resolve(ref string) (string, string, error) {
+	path, key, err := parseRef(ref)
+	if err != nil {
+		return "", "", err
+	}
+	var resp secretResponse
+	if err := p.do("GET", "/v1/"+path, nil, &resp); err != nil {
+		return "", "", err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret under data.data.
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	value, exists := data[key]
+	if !exists {
+		if resp.LeaseID != "" {
+			p.revoke(resp.LeaseID)
+		}
+		return "", "", fmt.Errorf("%s: %s", ErrKeyNotFound, key)
+	}
+	if resp.LeaseID != "" {
+		d := time.Duration(resp.LeaseDuration) * time.Second
+		p.mu.Lock()
+		p.leases[resp.LeaseID] = lease{duration: d, renewAt: time.Now().Add(d / 2), renewable: resp.Renewable}
+		p.mu.Unlock()
+	}
+	if s, ok := value.(string); ok {
+		return s, resp.LeaseID, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), resp.LeaseID, err
+}
+
+// revoke revokes a lease and stops renewing it.
+func (p *Provider) revoke(leaseID string) {
+	p.mu.Lock()
+	delete(p.leases, leaseID)
+	p.mu.Unlock()
+	log.Debugf("revoking vault lease %s", leaseID)
+	if err := p.do("PUT", "/v1/sys/leases/revoke", map[string]string{"lease_id": leaseID}, nil); err != nil {
+		log.Errorf(" -> error revoking vault lease %s: %s", leaseID, err)
+		return
+	}
+	log.Debugf(" -> vault lease %s revoked", leaseID)
+}
+
+func parseRef(ref string) (string, string, error) {
+	prefix := scheme + "://"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", "", ErrInvalidRef
+	}
+	parts := strings.SplitN(strings.TrimPrefix(ref, prefix), "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrInvalidRef
+	}
+	return strings.Trim(parts[0], "/"), parts[1], nil
+}
+
+func (p *Provider) login() error {
+	log.Debugf("logging in to vault with approle")
+	body := map[string]string{"role_id": p.config.RoleID, "secret_id": p.config.SecretID}
+	var resp secretResponse
+	if err := p.doWithToken("POST", "/v1/auth/"+p.config.AppRolePath+"/login", "", body, &resp); err != nil {
+		log.Errorf(" -> error logging in to vault: %s", err)
+		return err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("vault login returned no token")
+	}
+	p.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	log.Debugf(" -> logged in to vault")
+	return nil
+}
+
+func (p *Provider) setToken(token string, leaseDuration int, renewable bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = token
+	p.renewable = renewable
+	p.tokenTTL = time.Duration(leaseDuration) * time.Second
+	p.tokenExpiry = time.Time{}
+	if p.tokenTTL > 0 {
+		p.tokenExpiry = time.Now().Add(p.tokenTTL)
+	}
+}
+
+func (p *Provider) renewLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.renewToken()
+			p.renewLeases()
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+// renewToken renews the token once less than a third of its lease remains,
+// logging in again when it cannot be renewed.
+func (p *Provider) renewToken() {
+	p.mu.Lock()
+	ttl, expiry, renewable := p.tokenTTL, p.tokenExpiry, p.renewable
+	p.mu.Unlock()
+	if expiry.IsZero() || expiry.Sub(time.Now()) > ttl/3 {
+		return
+	}
+	if renewable {
+		var resp secretResponse
+		err := p.do("POST", "/v1/auth/token/renew-self", map[string]string{}, &resp)
+		if err == nil && resp.Auth != nil {
+			p.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+			return
+		}
+		log.Errorf("error renewing vault token: %v", err)
+	}
+	if p.config.RoleID != "" {
+		if err := p.login(); err != nil {
+			log.Errorf("error logging in to vault: %s", err)
+		}
+	}
+}
+
+func (p *Provider) renewLeases() {
+	now := time.Now()
+	p.mu.Lock()
+	due := []string{}
+	for id, l := range p.leases {
+		if l.renewable && !now.Before(l.renewAt) {
+			due = append(due, id)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, id := range due {
+		var resp secretResponse
+		err := p.do("PUT", "/v1/sys/leases/renew", map[string]string{"lease_id": id}, &resp)
+		p.mu.Lock()
+		// Leases revoked while they were renewed are not added back.
+		_, exists := p.leases[id]
+		if err != nil || !resp.Renewable || resp.LeaseDuration <= 0 {
+			// The lease has reached its max TTL or was revoked.
+			delete(p.leases, id)
+		} else if exists {
+			d := time.Duration(resp.LeaseDuration) * time.Second
+			p.leases[id] = lease{duration: d, renewAt: now.Add(d / 2), renewable: true}
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *Provider) do(method, path string, in, out interface{}) error {
+	p.mu.Lock()
+	token := p.token
+	p.mu.Unlock()
+	return p.doWithToken(method, path, token, in, out)
+}
+
+func (p *Provider) doWithToken(method, path, token string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(p.config.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var errResp secretResponse
+		if json.Unmarshal(b, &errResp) == nil && len(errResp.Errors) > 0 {
+			return fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.Join(errResp.Errors, "; "))
+		}
+		return fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+	if out != nil && len(b) > 0 {
+		return json.Unmarshal(b, out)
+	}
+	return nil
+}
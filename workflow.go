@@ -0,0 +1,188 @@
+package libcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrWorkflowCycle = errors.New("workflow has a dependency cycle")
+)
+
+type FailurePolicy int
+
+const (
+	// SkipDescendants skips every node depending on a failed node while
+	// independent branches keep running.
+	SkipDescendants FailurePolicy = iota
+	// StopAll stops launching new nodes after the first failure.
+	StopAll
+)
+
+type WorkflowNode struct {
+	Op   string
+	Args []string
+	// BuildArgs, when set, computes the node args from the outputs of its
+	// dependencies, keyed by node name. It replaces Args.
+	BuildArgs func(outputs map[string][]string) []string
+	// Undo is run to compensate for this node when another node fails.
+	Undo *CommandSpec
+}
+
+// WorkflowError reports the first node of a workflow that failed.
+type WorkflowError struct {
+	Node string
+	Err  error
+}
+
+func (e *WorkflowError) Error() string {
+	return fmt.Sprintf("workflow node %s: %s", e.Node, e.Err)
+}
+
+// Workflow runs commands as a DAG, starting every node as soon as all of
+// its dependencies have succeeded.
+type Workflow struct {
+	Policy FailurePolicy
+
+	nodes map[string]WorkflowNode
+	deps  map[string][]string
+	names []string
+}
+
+func NewWorkflow() *Workflow {
+	return &Workflow{
+		nodes: map[string]WorkflowNode{},
+		deps:  map[string][]string{},
+	}
+}
+
+func (w *Workflow) Add(name string, node WorkflowNode, deps ...string) error {
+	if _, exists := w.nodes[name]; exists {
+		return fmt.Errorf("workflow node %s already exists", name)
+	}
+	w.nodes[name] = node
+	w.deps[name] = deps
+	w.names = append(w.names, name)
+	return nil
+}
+
+// Run executes the workflow and returns a Result for every node keyed by
+// node name. The error is a *WorkflowError naming the first failed node, or
+// a validation error if the graph is invalid, in which case nothing runs.
+// When a node fails, the Undo commands of the nodes that succeeded are run
+// in reverse order of completion.
+func (w *Workflow) Run() (map[string]Result, error) {
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		firstErr  error
+		results   = map[string]Result{}
+		completed []string
+		done      = map[string]chan struct{}{}
+	)
+	for _, name := range w.names {
+		done[name] = make(chan struct{})
+	}
+
+	for _, name := range w.names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer close(done[name])
+			for _, dep := range w.deps[name] {
+				<-done[dep]
+			}
+
+			node := w.nodes[name]
+			mu.Lock()
+			skip := w.Policy == StopAll && firstErr != nil
+			outputs := map[string][]string{}
+			for _, dep := range w.deps[name] {
+				if results[dep].Err != nil {
+					skip = true
+				}
+				outputs[dep] = results[dep].Output
+			}
+			mu.Unlock()
+
+			args := node.Args
+			if node.BuildArgs != nil && !skip {
+				args = node.BuildArgs(outputs)
+			}
+			spec := CommandSpec{Op: node.Op, Args: args, Undo: node.Undo}
+			if skip {
+				mu.Lock()
+				results[name] = Result{Spec: spec, Err: ErrCommandSkipped}
+				mu.Unlock()
+				return
+			}
+
+			result := runSpec(context.Background(), spec, nil)
+			mu.Lock()
+			results[name] = result
+			if result.Err != nil && firstErr == nil {
+				firstErr = &WorkflowError{name, result.Err}
+			} else if result.Err == nil {
+				completed = append(completed, name)
+			}
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		undo := make([]*Result, len(completed))
+		for i, name := range completed {
+			result := results[name]
+			undo[i] = &result
+		}
+		rollback(undo)
+		for i, name := range completed {
+			results[name] = *undo[i]
+		}
+	}
+	return results, firstErr
+}
+
+func (w *Workflow) validate() error {
+	indegree := map[string]int{}
+	children := map[string][]string{}
+	for _, name := range w.names {
+		for _, dep := range w.deps[name] {
+			if _, exists := w.nodes[dep]; !exists {
+				return fmt.Errorf("workflow node %s depends on unknown node %s", name, dep)
+			}
+			indegree[name]++
+			children[dep] = append(children[dep], name)
+		}
+	}
+
+	queue := []string{}
+	for _, name := range w.names {
+		if indegree[name] == 0 {
+			queue = append(queue, name)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, child := range children[name] {
+			indegree[child]--
+			if indegree[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+	if visited != len(w.names) {
+		return ErrWorkflowCycle
+	}
+	return nil
+}